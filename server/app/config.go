@@ -0,0 +1,667 @@
+package app
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	gormzap "github.com/killi1812/cloudflared-web-gui/util/gormZap"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+// _REDACTED replaces values of secret options in Config.Redacted
+const _REDACTED = "[REDACTED]"
+
+// Config is the typed program configuration, every option can be set from a config file key,
+// an env variable, the env variable with a _FILE suffix naming a file holding the value, or a flag.
+//
+// Sources override each other in order: default < config file < env < flag.
+// Option tags are key (config file key, the flag is the dotted path with - instead of _),
+// env, default (lists are comma separated, maps comma separated key=value pairs) and secret
+type Config struct {
+	Port int `key:"port" env:"PORT" default:"8090"`
+	// TrustedProxies are ips and cidrs of proxies whose X-Forwarded-For is used as the client ip,
+	// when empty the ip of the connection is used so clients can't spoof it
+	TrustedProxies []string `key:"trusted_proxies" env:"TRUSTED_PROXIES"`
+
+	Database       DatabaseConfig       `key:"database"`
+	Backup         BackupConfig         `key:"backup"`
+	Secrets        SecretsConfig        `key:"secrets"`
+	Jwt            JwtConfig            `key:"jwt"`
+	Cloudflare     CloudflareConfig     `key:"cloudflare"`
+	Auth           AuthConfig           `key:"auth"`
+	Invite         InviteConfig         `key:"invite"`
+	Login          LoginConfig          `key:"login"`
+	SecurityEvents SecurityEventsConfig `key:"security_events"`
+	Log            LogConfig            `key:"log"`
+	Metrics        MetricsConfig        `key:"metrics"`
+	Tracing        TracingConfig        `key:"tracing"`
+	Health         HealthConfig         `key:"health"`
+	Password       PasswordConfig       `key:"password"`
+	Oidc           OidcConfig           `key:"oidc"`
+	Ldap           LdapConfig           `key:"ldap"`
+}
+
+type DatabaseConfig struct {
+	Driver          string        `key:"driver" env:"DB_DRIVER" default:"sqlite"`
+	Dsn             string        `key:"dsn" env:"DB_DSN" secret:"true"` // Dsn is the connection string of postgres and mysql
+	Path            string        `key:"path" env:"DB_PATH" default:"db.sqlite"`
+	SqliteWal       bool          `key:"sqlite_wal" env:"DB_SQLITE_WAL" default:"true"`
+	MaxOpenConns    int           `key:"max_open_conns" env:"DB_MAX_OPEN_CONNS" default:"100"`
+	MaxIdleConns    int           `key:"max_idle_conns" env:"DB_MAX_IDLE_CONNS" default:"10"`
+	ConnMaxLifetime time.Duration `key:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME" default:"1h"`
+	AutoMigrate     bool          `key:"auto_migrate" env:"DB_AUTO_MIGRATE" default:"true"` // AutoMigrate applies pending migrations on startup
+}
+
+type BackupConfig struct {
+	Dir        string        `key:"dir" env:"BACKUP_DIR" default:"backups"`
+	Interval   time.Duration `key:"interval" env:"BACKUP_INTERVAL"` // Interval of scheduled backups, zero disables them
+	Keep       int           `key:"keep" env:"BACKUP_KEEP" default:"7"`
+	Passphrase string        `key:"passphrase" env:"BACKUP_PASSPHRASE" secret:"true"` // Passphrase encrypts archives when set
+}
+
+type SecretsConfig struct {
+	AccessKey           string        `key:"access_key" env:"ACCESS_KEY" secret:"true"`
+	RefreshKey          string        `key:"refresh_key" env:"REFRESH_KEY" secret:"true"`
+	AccessKeysPrevious  []string      `key:"access_keys_previous" env:"ACCESS_KEYS_PREVIOUS" secret:"true"`
+	RefreshKeysPrevious []string      `key:"refresh_keys_previous" env:"REFRESH_KEYS_PREVIOUS" secret:"true"`
+	KeyRetiredTtl       time.Duration `key:"key_retired_ttl" env:"KEY_RETIRED_TTL" default:"168h"`
+	SuperadminPassword  string        `key:"superadmin_password" env:"SUPERADMIN_PASSWORD" secret:"true"` // SuperadminPassword is only needed on the first start
+}
+
+type JwtConfig struct {
+	Algorithm      string   `key:"algorithm" env:"JWT_ALGORITHM" default:"HS256"`
+	PrivateKeyFile string   `key:"private_key_file" env:"JWT_PRIVATE_KEY_FILE"`
+	Issuer         string   `key:"issuer" env:"JWT_ISSUER" default:"cloudflared-web-gui"`
+	Audience       []string `key:"audience" env:"JWT_AUDIENCE" default:"cloudflared-web-gui"`
+	RequireClaims  bool     `key:"require_claims" env:"JWT_REQUIRE_CLAIMS"` // RequireClaims rejects tokens without iss and aud, issued by versions before they were added
+}
+
+type CloudflareConfig struct {
+	ApiKey    string `key:"api_key" env:"CLOUDFLARED_API_KEY" secret:"true"`
+	ZoneId    string `key:"zone_id" env:"ZONE_ID"`
+	ConfigDir string `key:"config_dir" env:"CLOUDFLARED_CONFIG_DIR" default:"/config"` // ConfigDir holds tunnel configs and credentials
+}
+
+type AuthConfig struct {
+	Mode           string         `key:"mode" env:"AUTH_MODE" default:"local"`
+	ReloadInterval time.Duration  `key:"reload_interval" env:"AUTH_RELOAD_INTERVAL" default:"1m"` // ReloadInterval is how often signing keys, roles and groups are reloaded from the database, zero disables it
+	CfAccess       CfAccessConfig `key:"cf_access"`
+}
+
+type CfAccessConfig struct {
+	TeamDomain  string `key:"team_domain" env:"CF_ACCESS_TEAM_DOMAIN"`
+	JwksUrl     string `key:"jwks_url" env:"CF_ACCESS_JWKS_URL"` // JwksUrl defaults to TeamDomain/cdn-cgi/access/certs
+	Audience    string `key:"audience" env:"CF_ACCESS_AUD"`
+	DefaultRole string `key:"default_role" env:"CF_ACCESS_DEFAULT_ROLE" default:"user"`
+}
+
+type InviteConfig struct {
+	Url string        `key:"url" env:"INVITE_URL"`
+	Ttl time.Duration `key:"ttl" env:"INVITE_TTL" default:"72h"`
+}
+
+type LoginConfig struct {
+	RateWindow       time.Duration `key:"rate_window" env:"LOGIN_RATE_WINDOW" default:"1m"`
+	RateIp           int           `key:"rate_ip" env:"LOGIN_RATE_IP" default:"20"`
+	RateUsername     int           `key:"rate_username" env:"LOGIN_RATE_USERNAME" default:"10"`
+	DelayBase        time.Duration `key:"delay_base" env:"LOGIN_DELAY_BASE" default:"1s"`
+	DelayMax         time.Duration `key:"delay_max" env:"LOGIN_DELAY_MAX" default:"30s"`
+	LockoutThreshold int           `key:"lockout_threshold" env:"LOGIN_LOCKOUT_THRESHOLD" default:"10"`
+	LockoutDuration  time.Duration `key:"lockout_duration" env:"LOGIN_LOCKOUT_DURATION" default:"15m"`
+}
+
+type SecurityEventsConfig struct {
+	Retention time.Duration `key:"retention" env:"SECURITY_EVENT_RETENTION" default:"2160h"`
+}
+
+type LogConfig struct {
+	Level          string `key:"level" env:"LOG_LEVEL"`                             // Level is debug, info, warn or error, debug in dev builds and info otherwise when empty
+	Format         string `key:"format" env:"LOG_FORMAT" default:"text"`            // Format of console logs, text or json
+	File           string `key:"file" env:"LOG_FILE"`                               // File logs are also written to, rotated by size, disabled when empty
+	FileFormat     string `key:"file_format" env:"LOG_FILE_FORMAT" default:"text"`  // FileFormat is text or json
+	FileMaxSize    int    `key:"file_max_size" env:"LOG_FILE_MAX_SIZE" default:"2"` // FileMaxSize is the size in MB the file is rotated at
+	FileMaxAge     int    `key:"file_max_age" env:"LOG_FILE_MAX_AGE" default:"30"`  // FileMaxAge is how many days rotated files are kept, 0 keeps them
+	FileMaxBackups int    `key:"file_max_backups" env:"LOG_FILE_MAX_BACKUPS"`       // FileMaxBackups is how many rotated files are kept, 0 keeps all
+	GormLevel      string `key:"gorm_level" env:"LOG_GORM_LEVEL" default:"warn"`    // GormLevel is silent, error, warn or info, info logs every query
+}
+
+type MetricsConfig struct {
+	Token string `key:"token" env:"METRICS_TOKEN" secret:"true"` // Token enables /metrics, scrapers send it as a bearer token
+}
+
+type TracingConfig struct {
+	Exporter    string `key:"exporter" env:"TRACING_EXPORTER"` // Exporter is otlp, stdout or empty to not export spans
+	Endpoint    string `key:"endpoint" env:"TRACING_ENDPOINT"` // Endpoint is the OTLP/HTTP collector url, OTEL_EXPORTER_OTLP_ENDPOINT or localhost when empty
+	ServiceName string `key:"service_name" env:"TRACING_SERVICE_NAME" default:"cloudflared-web-gui"`
+}
+
+type HealthConfig struct {
+	CacheTtl           time.Duration `key:"cache_ttl" env:"HEALTH_CACHE_TTL" default:"5s"`                       // CacheTtl is how long the readiness report is reused
+	CloudflareCacheTtl time.Duration `key:"cloudflare_cache_ttl" env:"HEALTH_CLOUDFLARE_CACHE_TTL" default:"1m"` // CloudflareCacheTtl is how long the Cloudflare API check result is reused
+}
+
+type PasswordConfig struct {
+	MinLength     int    `key:"min_length" env:"PASSWORD_MIN_LENGTH" default:"8"`
+	RequireUpper  bool   `key:"require_upper" env:"PASSWORD_REQUIRE_UPPER"`
+	RequireLower  bool   `key:"require_lower" env:"PASSWORD_REQUIRE_LOWER"`
+	RequireDigit  bool   `key:"require_digit" env:"PASSWORD_REQUIRE_DIGIT"`
+	RequireSymbol bool   `key:"require_symbol" env:"PASSWORD_REQUIRE_SYMBOL"`
+	BreachedList  string `key:"breached_list" env:"PASSWORD_BREACHED_LIST"`
+	Argon2Memory  int    `key:"argon2_memory" env:"PASSWORD_ARGON2_MEMORY" default:"65536"`
+	Argon2Time    int    `key:"argon2_time" env:"PASSWORD_ARGON2_TIME" default:"3"`
+	Argon2Threads int    `key:"argon2_threads" env:"PASSWORD_ARGON2_THREADS" default:"2"`
+}
+
+type OidcConfig struct {
+	Issuer         string            `key:"issuer" env:"OIDC_ISSUER"`
+	ClientId       string            `key:"client_id" env:"OIDC_CLIENT_ID"`
+	ClientSecret   string            `key:"client_secret" env:"OIDC_CLIENT_SECRET" secret:"true"`
+	RedirectUrl    string            `key:"redirect_url" env:"OIDC_REDIRECT_URL"`
+	Scopes         []string          `key:"scopes" env:"OIDC_SCOPES" default:"profile,email"`
+	DefaultRole    string            `key:"default_role" env:"OIDC_DEFAULT_ROLE" default:"user"`
+	GroupsClaim    string            `key:"groups_claim" env:"OIDC_GROUPS_CLAIM" default:"groups"`
+	RoleMapping    map[string]string `key:"role_mapping" env:"OIDC_ROLE_MAPPING"`
+	ClientRedirect string            `key:"client_redirect" env:"OIDC_CLIENT_REDIRECT"`
+}
+
+type LdapConfig struct {
+	Url         string            `key:"url" env:"LDAP_URL"`
+	StartTls    bool              `key:"start_tls" env:"LDAP_START_TLS"`
+	Insecure    bool              `key:"insecure" env:"LDAP_INSECURE"`
+	CaFile      string            `key:"ca_file" env:"LDAP_CA_FILE"`
+	BindDn      string            `key:"bind_dn" env:"LDAP_BIND_DN"`
+	UserBaseDn  string            `key:"user_base_dn" env:"LDAP_USER_BASE_DN"`
+	UserFilter  string            `key:"user_filter" env:"LDAP_USER_FILTER" default:"(uid={username})"`
+	EmailAttr   string            `key:"email_attr" env:"LDAP_EMAIL_ATTR" default:"mail"`
+	GroupBaseDn string            `key:"group_base_dn" env:"LDAP_GROUP_BASE_DN"`
+	GroupFilter string            `key:"group_filter" env:"LDAP_GROUP_FILTER" default:"(member={dn})"`
+	DefaultRole string            `key:"default_role" env:"LDAP_DEFAULT_ROLE" default:"user"`
+	RoleMapping map[string]string `key:"role_mapping" env:"LDAP_ROLE_MAPPING"`
+}
+
+// option is one leaf field of Config
+type option struct {
+	key    string // key is the dotted config file path, ldap.start_tls
+	env    string
+	def    string
+	secret bool
+	value  reflect.Value
+}
+
+// flagName is the command line flag of the option, --ldap.start-tls
+func (o option) flagName() string {
+	return strings.ReplaceAll(o.key, "_", "-")
+}
+
+// options returns all options of cfg in declaration order
+func options(cfg *Config) []option {
+	return collectOptions(reflect.ValueOf(cfg).Elem(), "")
+}
+
+func collectOptions(value reflect.Value, prefix string) []option {
+	var rez []option
+	for i := range value.NumField() {
+		field := value.Type().Field(i)
+		key := prefix + field.Tag.Get("key")
+
+		if field.Type.Kind() == reflect.Struct {
+			rez = append(rez, collectOptions(value.Field(i), key+".")...)
+			continue
+		}
+		rez = append(rez, option{
+			key:    key,
+			env:    field.Tag.Get("env"),
+			def:    field.Tag.Get("default"),
+			secret: field.Tag.Get("secret") == "true",
+			value:  value.Field(i),
+		})
+	}
+	return rez
+}
+
+// Load builds the configuration from defaults, the config file, env variables and args,
+// it also returns the args left after the flags.
+//
+// The config file is set with --config or CONFIG_FILE and can be YAML or TOML.
+// An env file set with --env-file or ENV_FILE (default ../.env) is read when it exists,
+// variables already in the environment take precedence over it
+func Load(args []string, lookupEnv func(string) (string, bool)) (Config, []string, error) {
+	return LoadFlags(flag.NewFlagSet("cloudflared-web-gui", flag.ContinueOnError), args, lookupEnv)
+}
+
+// LoadFlags is Load parsing args with flags, flags of a command defined on it are parsed along the options
+func LoadFlags(flags *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (Config, []string, error) {
+	var cfg Config
+	opts := options(&cfg)
+
+	configFile := flags.String("config", "", "path to a YAML or TOML config file, overrides CONFIG_FILE")
+	envFile := flags.String("env-file", "", "path to an env file, overrides ENV_FILE")
+	flagValues := make(map[string]string)
+	for _, opt := range opts {
+		usage := "overrides config key " + opt.key
+		if opt.env != "" {
+			usage += " and env " + opt.env
+		}
+		flags.Var(&rawFlag{name: opt.key, values: flagValues, isBool: opt.value.Kind() == reflect.Bool}, opt.flagName(), usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	env, err := envLookup(lookupEnv, *envFile)
+	if err != nil {
+		return cfg, nil, err
+	}
+
+	// 1. defaults
+	var errs []error
+	for _, opt := range opts {
+		if err := setString(opt.value, opt.def); err != nil {
+			errs = append(errs, fmt.Errorf("default of %s: %w", opt.key, err))
+		}
+	}
+
+	// 2. config file
+	if *configFile == "" {
+		*configFile, _ = env("CONFIG_FILE")
+	}
+	if *configFile != "" {
+		if err := loadFile(opts, *configFile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// 3. env variables and their _FILE variants
+	for _, opt := range opts {
+		if opt.env == "" {
+			continue
+		}
+		raw, ok, err := envValue(env, opt.env)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if err := setString(opt.value, raw); err != nil {
+			errs = append(errs, fmt.Errorf("env %s: %w", opt.env, err))
+		}
+	}
+
+	// 4. flags
+	for _, opt := range opts {
+		raw, ok := flagValues[opt.key]
+		if !ok {
+			continue
+		}
+		if err := setString(opt.value, raw); err != nil {
+			errs = append(errs, fmt.Errorf("flag --%s: %w", opt.flagName(), err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return cfg, nil, errors.Join(errs...)
+	}
+
+	cfg.Auth.CfAccess.TeamDomain = strings.TrimSuffix(cfg.Auth.CfAccess.TeamDomain, "/")
+	if cfg.Auth.CfAccess.JwksUrl == "" && cfg.Auth.CfAccess.TeamDomain != "" {
+		cfg.Auth.CfAccess.JwksUrl = cfg.Auth.CfAccess.TeamDomain + "/cdn-cgi/access/certs"
+	}
+
+	return cfg, flags.Args(), cfg.Validate()
+}
+
+// envLookup returns a lookup of the environment falling back to the env file
+func envLookup(lookupEnv func(string) (string, bool), envFile string) (func(string) (string, bool), error) {
+	explicit := envFile != ""
+	if !explicit {
+		envFile, explicit = lookupEnv("ENV_FILE")
+	}
+	if envFile == "" {
+		envFile = "../.env"
+	}
+
+	fileEnv, err := godotenv.Read(envFile)
+	if err != nil {
+		if explicit || !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("env file %s: %w", envFile, err)
+		}
+		fileEnv = nil
+	}
+
+	return func(name string) (string, bool) {
+		if value, ok := lookupEnv(name); ok {
+			return value, true
+		}
+		value, ok := fileEnv[name]
+		return value, ok
+	}, nil
+}
+
+// envValue returns the value of name, or the content of the file named by name_FILE
+func envValue(env func(string) (string, bool), name string) (string, bool, error) {
+	value, ok := env(name)
+	path, fromFile := env(name + "_FILE")
+	if ok && fromFile {
+		return "", false, fmt.Errorf("env %s and %s_FILE are both set", name, name)
+	}
+	if !fromFile {
+		return value, ok && strings.TrimSpace(value) != "", nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("env %s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), true, nil
+}
+
+// loadFile sets options from a YAML or TOML file, unknown keys are an error so typos don't go unnoticed
+func loadFile(opts []option, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	values := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &values)
+	case ".toml":
+		err = toml.Unmarshal(content, &values)
+	default:
+		return fmt.Errorf("config file %s: unknown format, use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	byKey := make(map[string]option, len(opts))
+	for _, opt := range opts {
+		byKey[opt.key] = opt
+	}
+
+	var errs []error
+	var walk func(prefix string, values map[string]any)
+	walk = func(prefix string, values map[string]any) {
+		for name, value := range values {
+			key := prefix + name
+			if opt, ok := byKey[key]; ok {
+				if err := setAny(opt.value, value); err != nil {
+					errs = append(errs, fmt.Errorf("config file key %s: %w", key, err))
+				}
+				continue
+			}
+			if nested, ok := value.(map[string]any); ok {
+				walk(key+".", nested)
+				continue
+			}
+			errs = append(errs, fmt.Errorf("config file key %s: unknown option", key))
+		}
+	}
+	walk("", values)
+
+	return errors.Join(errs...)
+}
+
+// setAny sets a value decoded from a config file
+func setAny(value reflect.Value, raw any) error {
+	switch raw := raw.(type) {
+	case nil:
+		return nil
+	case []any:
+		if value.Kind() != reflect.Slice {
+			return fmt.Errorf("expected a single value, got a list")
+		}
+		list := make([]string, 0, len(raw))
+		for _, item := range raw {
+			list = append(list, fmt.Sprint(item))
+		}
+		value.Set(reflect.ValueOf(list))
+		return nil
+	case map[string]any:
+		if value.Kind() != reflect.Map {
+			return fmt.Errorf("expected a single value, got a table")
+		}
+		pairs := make(map[string]string, len(raw))
+		for key, item := range raw {
+			pairs[key] = fmt.Sprint(item)
+		}
+		value.Set(reflect.ValueOf(pairs))
+		return nil
+	default:
+		return setString(value, fmt.Sprint(raw))
+	}
+}
+
+// setString parses raw into value, empty raw leaves value untouched
+func setString(value reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil
+	}
+
+	if value.Type() == reflect.TypeFor[time.Duration]() {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(duration))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Int:
+		num, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(num))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	case reflect.Slice:
+		var list []string
+		for item := range strings.SplitSeq(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		value.Set(reflect.ValueOf(list))
+	case reflect.Map:
+		pairs := make(map[string]string)
+		for item := range strings.SplitSeq(raw, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			key, val, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("invalid pair %s, expected key=value", item)
+			}
+			pairs[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		}
+		value.Set(reflect.ValueOf(pairs))
+	default:
+		return fmt.Errorf("unsupported option type %s", value.Type())
+	}
+	return nil
+}
+
+// rawFlag collects flag values as strings, they are parsed after the lower precedence sources
+type rawFlag struct {
+	name   string
+	values map[string]string
+	isBool bool
+}
+
+func (f *rawFlag) String() string { return "" }
+
+func (f *rawFlag) Set(value string) error {
+	f.values[f.name] = value
+	return nil
+}
+
+func (f *rawFlag) IsBoolFlag() bool { return f.isBool }
+
+// Validate returns all problems of the configuration joined
+func (cfg Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(cfg.Port > 0 && cfg.Port < 65536, "port must be between 1 and 65535, got %d", cfg.Port)
+	for _, proxy := range cfg.TrustedProxies {
+		_, _, cidrErr := net.ParseCIDR(proxy)
+		check(cidrErr == nil || net.ParseIP(proxy) != nil, "trusted_proxies must be ips or cidrs, got %s", proxy)
+	}
+
+	switch cfg.Database.Driver {
+	case DbDriverSqlite:
+		check(cfg.Database.Path != "", "database.path (DB_PATH) is required with the sqlite driver")
+	case DbDriverPostgres, DbDriverMysql:
+		check(cfg.Database.Dsn != "", "database.dsn (DB_DSN) is required with the %s driver", cfg.Database.Driver)
+	default:
+		errs = append(errs, fmt.Errorf("database.driver must be %s, %s or %s, got %s", DbDriverSqlite, DbDriverPostgres, DbDriverMysql, cfg.Database.Driver))
+	}
+	check(cfg.Database.MaxOpenConns >= 0 && cfg.Database.MaxIdleConns >= 0, "database connection limits must not be negative")
+	check(cfg.Database.ConnMaxLifetime >= 0, "database.conn_max_lifetime must not be negative")
+
+	check(cfg.Secrets.AccessKey != "", "secrets.access_key (ACCESS_KEY) is required")
+	check(cfg.Secrets.RefreshKey != "", "secrets.refresh_key (REFRESH_KEY) is required")
+	check(cfg.Secrets.KeyRetiredTtl > 0, "secrets.key_retired_ttl must be positive")
+	check(cfg.Cloudflare.ApiKey != "", "cloudflare.api_key (CLOUDFLARED_API_KEY) is required")
+	check(cfg.Cloudflare.ZoneId != "", "cloudflare.zone_id (ZONE_ID) is required")
+	check(cfg.Cloudflare.ConfigDir != "", "cloudflare.config_dir (CLOUDFLARED_CONFIG_DIR) is required")
+	check(cfg.Backup.Interval == 0 || cfg.Backup.Dir != "", "backup.dir (BACKUP_DIR) is required with scheduled backups")
+	check(cfg.Backup.Interval == 0 || cfg.Database.Driver == DbDriverSqlite, "scheduled backups are only supported with the sqlite driver")
+
+	if cfg.Log.Level != "" {
+		_, err := zapcore.ParseLevel(cfg.Log.Level)
+		check(err == nil, "log.level must be debug, info, warn or error, got %s", cfg.Log.Level)
+	}
+	for name, format := range map[string]string{"log.format": cfg.Log.Format, "log.file_format": cfg.Log.FileFormat} {
+		check(slices.Contains([]string{LogFormatText, LogFormatJson}, format), "%s must be %s or %s, got %s", name, LogFormatText, LogFormatJson, format)
+	}
+	_, err := gormzap.ParseLevel(cfg.Log.GormLevel)
+	check(err == nil, "log.gorm_level must be silent, error, warn or info, got %s", cfg.Log.GormLevel)
+
+	check(slices.Contains([]string{TracingExporterNone, TracingExporterOtlp, TracingExporterStdout}, cfg.Tracing.Exporter),
+		"tracing.exporter must be empty, %s or %s, got %s", TracingExporterOtlp, TracingExporterStdout, cfg.Tracing.Exporter)
+	check(cfg.Tracing.ServiceName != "", "tracing.service_name (TRACING_SERVICE_NAME) is required")
+
+	check(slices.Contains([]string{JwtAlgHS256, JwtAlgEdDSA}, cfg.Jwt.Algorithm),
+		"jwt.algorithm must be %s or %s, got %s", JwtAlgHS256, JwtAlgEdDSA, cfg.Jwt.Algorithm)
+
+	switch cfg.Auth.Mode {
+	case AuthModeLocal:
+	case AuthModeCfAccess:
+		check(cfg.Auth.CfAccess.TeamDomain != "", "auth.cf_access.team_domain (CF_ACCESS_TEAM_DOMAIN) is required in cf-access mode")
+		check(cfg.Auth.CfAccess.Audience != "", "auth.cf_access.audience (CF_ACCESS_AUD) is required in cf-access mode")
+	default:
+		errs = append(errs, fmt.Errorf("auth.mode must be %s or %s, got %s", AuthModeLocal, AuthModeCfAccess, cfg.Auth.Mode))
+	}
+
+	check(cfg.Invite.Ttl > 0, "invite.ttl must be positive")
+
+	for name, duration := range map[string]time.Duration{
+		"login.rate_window":           cfg.Login.RateWindow,
+		"login.delay_base":            cfg.Login.DelayBase,
+		"login.delay_max":             cfg.Login.DelayMax,
+		"login.lockout_duration":      cfg.Login.LockoutDuration,
+		"security_events.retention":   cfg.SecurityEvents.Retention,
+		"backup.interval":             cfg.Backup.Interval,
+		"health.cache_ttl":            cfg.Health.CacheTtl,
+		"health.cloudflare_cache_ttl": cfg.Health.CloudflareCacheTtl,
+		"auth.reload_interval":        cfg.Auth.ReloadInterval,
+	} {
+		check(duration >= 0, "%s must not be negative", name)
+	}
+	for name, num := range map[string]int{
+		"login.rate_ip":           cfg.Login.RateIp,
+		"login.rate_username":     cfg.Login.RateUsername,
+		"login.lockout_threshold": cfg.Login.LockoutThreshold,
+		"backup.keep":             cfg.Backup.Keep,
+		"log.file_max_size":       cfg.Log.FileMaxSize,
+		"log.file_max_age":        cfg.Log.FileMaxAge,
+		"log.file_max_backups":    cfg.Log.FileMaxBackups,
+	} {
+		check(num >= 0, "%s must not be negative", name)
+	}
+
+	check(cfg.Password.MinLength > 0, "password.min_length must be positive")
+	check(cfg.Password.Argon2Time > 0, "password.argon2_time must be positive")
+	check(cfg.Password.Argon2Threads > 0 && cfg.Password.Argon2Threads < 256, "password.argon2_threads must be between 1 and 255")
+	check(cfg.Password.Argon2Memory >= 8*cfg.Password.Argon2Threads, "password.argon2_memory must be at least 8 KiB per thread")
+
+	if cfg.Oidc.Issuer != "" {
+		check(cfg.Oidc.ClientId != "", "oidc.client_id (OIDC_CLIENT_ID) is required when oidc.issuer is set")
+		check(cfg.Oidc.ClientSecret != "", "oidc.client_secret (OIDC_CLIENT_SECRET) is required when oidc.issuer is set")
+		check(cfg.Oidc.RedirectUrl != "", "oidc.redirect_url (OIDC_REDIRECT_URL) is required when oidc.issuer is set")
+	}
+
+	if cfg.Tracing.Endpoint != "" {
+		parsed, err := url.Parse(cfg.Tracing.Endpoint)
+		check(err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https"), "tracing.endpoint must be an http:// or https:// url, got %s", cfg.Tracing.Endpoint)
+	}
+
+	if cfg.Ldap.Url != "" {
+		parsed, err := url.Parse(cfg.Ldap.Url)
+		check(err == nil && (parsed.Scheme == "ldap" || parsed.Scheme == "ldaps"), "ldap.url must be an ldap:// or ldaps:// url, got %s", cfg.Ldap.Url)
+		check(err != nil || parsed.Scheme != "ldaps" || !cfg.Ldap.StartTls, "ldap.start_tls can't be used with an ldaps:// url")
+		check(err != nil || parsed.Scheme != "ldap" || cfg.Ldap.StartTls || cfg.Ldap.Insecure,
+			"ldap.url %s sends passwords in cleartext, set ldap.start_tls (LDAP_START_TLS), use ldaps:// or allow it with ldap.insecure (LDAP_INSECURE)", cfg.Ldap.Url)
+		check(strings.Contains(cfg.Ldap.BindDn, "{username}"), "ldap.bind_dn (LDAP_BIND_DN) must contain {username} when ldap.url is set")
+	}
+
+	return errors.Join(errs...)
+}
+
+// Redacted returns the configuration keyed like the config file with secret values replaced
+func (cfg Config) Redacted() map[string]any {
+	rez := make(map[string]any)
+	for _, opt := range options(&cfg) {
+		var value any = opt.value.Interface()
+		if duration, ok := value.(time.Duration); ok {
+			value = duration.String()
+		}
+		if opt.secret && !opt.value.IsZero() {
+			value = _REDACTED
+		}
+
+		section := rez
+		parts := strings.Split(opt.key, ".")
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := section[part].(map[string]any)
+			if !ok {
+				next = make(map[string]any)
+				section[part] = next
+			}
+			section = next
+		}
+		section[parts[len(parts)-1]] = value
+	}
+	return rez
+}
@@ -0,0 +1,231 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// requiredEnv holds every option without a default that validation requires
+func requiredEnv() map[string]string {
+	return map[string]string{
+		"ACCESS_KEY":          "access-secret",
+		"REFRESH_KEY":         "refresh-secret",
+		"CLOUDFLARED_API_KEY": "api-secret",
+		"ZONE_ID":             "zone",
+	}
+}
+
+// load runs Load with env as the whole environment and an empty env file
+func load(t *testing.T, env map[string]string, args ...string) (Config, error) {
+	t.Helper()
+	if _, ok := env["ENV_FILE"]; !ok {
+		env["ENV_FILE"] = writeFile(t, "empty.env", "")
+	}
+	cfg, _, err := Load(args, func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	})
+	return cfg, err
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := load(t, requiredEnv())
+	require.NoError(t, err)
+
+	assert.Equal(t, 8090, cfg.Port)
+	assert.Equal(t, JwtAlgHS256, cfg.Jwt.Algorithm)
+	assert.Equal(t, []string{"cloudflared-web-gui"}, cfg.Jwt.Audience)
+	assert.Equal(t, 7*24*time.Hour, cfg.Secrets.KeyRetiredTtl)
+	assert.Equal(t, AuthModeLocal, cfg.Auth.Mode)
+	assert.Equal(t, []string{"profile", "email"}, cfg.Oidc.Scopes)
+	assert.Equal(t, 64*1024, cfg.Password.Argon2Memory)
+	assert.Equal(t, "access-secret", cfg.Secrets.AccessKey)
+}
+
+func TestLoad_MissingSecretsFail(t *testing.T) {
+	env := requiredEnv()
+	delete(env, "ACCESS_KEY")
+	env["REFRESH_KEY"] = "   "
+
+	_, err := load(t, env)
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "secrets.access_key (ACCESS_KEY) is required")
+	assert.ErrorContains(t, err, "secrets.refresh_key (REFRESH_KEY) is required")
+}
+
+func TestLoad_InvalidValuesFail(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "Unparsable int", env: map[string]string{"PORT": "eighty"}, wantErr: "env PORT"},
+		{name: "Port out of range", env: map[string]string{"PORT": "70000"}, wantErr: "port must be between"},
+		{name: "Unparsable bool", env: map[string]string{"LDAP_START_TLS": "yes please"}, wantErr: "env LDAP_START_TLS"},
+		{name: "Unknown auth mode", env: map[string]string{"AUTH_MODE": "magic"}, wantErr: "auth.mode must be"},
+		{name: "Negative duration", env: map[string]string{"LOGIN_DELAY_MAX": "-1s"}, wantErr: "login.delay_max must not be negative"},
+		{name: "Cf access without audience", env: map[string]string{"AUTH_MODE": AuthModeCfAccess, "CF_ACCESS_TEAM_DOMAIN": "https://team.cloudflareaccess.com"}, wantErr: "auth.cf_access.audience"},
+		{name: "Ldap bind dn without username", env: map[string]string{"LDAP_URL": "ldaps://localhost", "LDAP_BIND_DN": "cn=admin"}, wantErr: "ldap.bind_dn"},
+		{name: "Cleartext ldap", env: map[string]string{"LDAP_URL": "ldap://localhost", "LDAP_BIND_DN": "uid={username}"}, wantErr: "sends passwords in cleartext"},
+		{name: "Unknown database driver", env: map[string]string{"DB_DRIVER": "oracle"}, wantErr: "database.driver must be"},
+		{name: "Postgres without dsn", env: map[string]string{"DB_DRIVER": DbDriverPostgres}, wantErr: "database.dsn (DB_DSN) is required with the postgres driver"},
+		{name: "Invalid role mapping", env: map[string]string{"OIDC_ROLE_MAPPING": "admins"}, wantErr: "invalid pair admins"},
+		{name: "Invalid trusted proxy", env: map[string]string{"TRUSTED_PROXIES": "10.0.0.0/8,proxy.local"}, wantErr: "trusted_proxies must be ips or cidrs, got proxy.local"},
+		{name: "Unknown log level", env: map[string]string{"LOG_LEVEL": "verbose"}, wantErr: "log.level must be"},
+		{name: "Unknown log file format", env: map[string]string{"LOG_FILE_FORMAT": "xml"}, wantErr: "log.file_format must be"},
+		{name: "Unknown gorm log level", env: map[string]string{"LOG_GORM_LEVEL": "debug"}, wantErr: "log.gorm_level must be"},
+		{name: "Negative log file size", env: map[string]string{"LOG_FILE_MAX_SIZE": "-1"}, wantErr: "log.file_max_size must not be negative"},
+		{name: "Unknown tracing exporter", env: map[string]string{"TRACING_EXPORTER": "jaeger"}, wantErr: "tracing.exporter must be"},
+		{name: "Tracing endpoint without scheme", env: map[string]string{"TRACING_ENDPOINT": "collector:4318"}, wantErr: "tracing.endpoint must be"},
+		{name: "Scheduled backups with postgres", env: map[string]string{"DB_DRIVER": DbDriverPostgres, "DB_DSN": "postgres://localhost", "BACKUP_INTERVAL": "24h"}, wantErr: "scheduled backups are only supported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := requiredEnv()
+			for name, value := range tt.env {
+				env[name] = value
+			}
+
+			_, err := load(t, env)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestLoad_LdapTransport(t *testing.T) {
+	for _, ldapEnv := range []map[string]string{
+		{"LDAP_URL": "ldaps://localhost"},
+		{"LDAP_URL": "ldap://localhost", "LDAP_START_TLS": "true"},
+		{"LDAP_URL": "ldap://localhost", "LDAP_INSECURE": "true"},
+	} {
+		env := requiredEnv()
+		env["LDAP_BIND_DN"] = "uid={username}"
+		for name, value := range ldapEnv {
+			env[name] = value
+		}
+
+		_, err := load(t, env)
+		assert.NoError(t, err, ldapEnv)
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	configFile := writeFile(t, "config.yaml", `
+port: 1000
+invite:
+  url: https://gui.example.com/invite
+  ttl: 24h
+oidc:
+  scopes: [profile, groups]
+  role_mapping:
+    gui-admins: superadmin
+`)
+	env := requiredEnv()
+	env["CONFIG_FILE"] = configFile
+	env["INVITE_TTL"] = "48h"
+
+	cfg, err := load(t, env)
+	require.NoError(t, err)
+	assert.Equal(t, 1000, cfg.Port, "file overrides default")
+	assert.Equal(t, "https://gui.example.com/invite", cfg.Invite.Url)
+	assert.Equal(t, 48*time.Hour, cfg.Invite.Ttl, "env overrides file")
+	assert.Equal(t, []string{"profile", "groups"}, cfg.Oidc.Scopes)
+	assert.Equal(t, map[string]string{"gui-admins": "superadmin"}, cfg.Oidc.RoleMapping)
+
+	env["PORT"] = "2000"
+	cfg, err = load(t, env, "--port", "3000", "--ldap.start-tls")
+	require.NoError(t, err)
+	assert.Equal(t, 3000, cfg.Port, "flag overrides env")
+	assert.True(t, cfg.Ldap.StartTls)
+}
+
+func TestLoad_Args(t *testing.T) {
+	env := requiredEnv()
+	env["ENV_FILE"] = writeFile(t, "empty.env", "")
+
+	cfg, args, err := Load([]string{"--port", "3000", "migrate", "down", "2"}, func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3000, cfg.Port)
+	assert.Equal(t, []string{"migrate", "down", "2"}, args, "flags end at the command")
+}
+
+func TestLoad_TomlFileAndUnknownKeys(t *testing.T) {
+	env := requiredEnv()
+	configFile := writeFile(t, "config.toml", `
+port = 9000
+
+[login]
+rate_ip = 5
+`)
+
+	cfg, err := load(t, env, "--config", configFile)
+	require.NoError(t, err)
+	assert.Equal(t, 9000, cfg.Port)
+	assert.Equal(t, 5, cfg.Login.RateIp)
+
+	configFile = writeFile(t, "typo.toml", "[login]\nrate_ipp = 5\n")
+	_, err = load(t, env, "--config", configFile)
+	assert.ErrorContains(t, err, "config file key login.rate_ipp: unknown option")
+}
+
+func TestLoad_FileVariants(t *testing.T) {
+	env := requiredEnv()
+	delete(env, "ACCESS_KEY")
+	env["ACCESS_KEY_FILE"] = writeFile(t, "access_key", "docker-secret\n")
+
+	cfg, err := load(t, env)
+	require.NoError(t, err)
+	assert.Equal(t, "docker-secret", cfg.Secrets.AccessKey)
+
+	env["ACCESS_KEY"] = "also-set"
+	_, err = load(t, env)
+	assert.ErrorContains(t, err, "ACCESS_KEY and ACCESS_KEY_FILE are both set")
+}
+
+func TestLoad_EnvFile(t *testing.T) {
+	env := requiredEnv()
+	delete(env, "ZONE_ID")
+	env["ENV_FILE"] = writeFile(t, ".env", "ZONE_ID = \"from-file\"\nACCESS_KEY = \"ignored\"\n")
+
+	cfg, err := load(t, env)
+	require.NoError(t, err)
+	assert.Equal(t, "from-file", cfg.Cloudflare.ZoneId)
+	assert.Equal(t, "access-secret", cfg.Secrets.AccessKey, "the environment overrides the env file")
+
+	env["ENV_FILE"] = filepath.Join(t.TempDir(), "missing.env")
+	_, err = load(t, env)
+	assert.Error(t, err, "an explicitly set env file must exist")
+}
+
+func TestConfig_Redacted(t *testing.T) {
+	env := requiredEnv()
+	env["OIDC_ROLE_MAPPING"] = "admins=admin"
+	cfg, err := load(t, env)
+	require.NoError(t, err)
+
+	redacted := cfg.Redacted()
+	secrets := redacted["secrets"].(map[string]any)
+	assert.Equal(t, _REDACTED, secrets["access_key"])
+	assert.Equal(t, _REDACTED, secrets["refresh_key"])
+	assert.Equal(t, "168h0m0s", secrets["key_retired_ttl"])
+	assert.Equal(t, _REDACTED, redacted["cloudflare"].(map[string]any)["api_key"])
+	assert.Equal(t, "zone", redacted["cloudflare"].(map[string]any)["zone_id"])
+	assert.Equal(t, map[string]string{"admins": "admin"}, redacted["oidc"].(map[string]any)["role_mapping"])
+	assert.Equal(t, 8090, redacted["port"])
+}
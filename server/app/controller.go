@@ -8,6 +8,11 @@ type Controller interface {
 	RegisterEndpoints(router *gin.RouterGroup)
 }
 
+// RootController is implemented by controllers that also serve endpoints outside of /api
+type RootController interface {
+	RegisterRootEndpoints(router *gin.RouterGroup)
+}
+
 var controllers []Controller
 
 // RegisterController registers a controller to a router
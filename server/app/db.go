@@ -1,25 +1,104 @@
 package app
 
 import (
+	"fmt"
+	"net/url"
+
+	"github.com/killi1812/cloudflared-web-gui/migration"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+	gormotel "github.com/killi1812/cloudflared-web-gui/util/gormOtel"
 	gormzap "github.com/killi1812/cloudflared-web-gui/util/gormZap"
 
+	gomysql "github.com/go-sql-driver/mysql"
 	"go.uber.org/zap"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
+// _SQLITE_BUSY_TIMEOUT_MS is how long sqlite waits for a lock instead of failing with database is locked
+const _SQLITE_BUSY_TIMEOUT_MS = 5000
+
 func newDbConn() *gorm.DB {
-	db, err := gorm.Open(sqlite.Open("file:db.sqlite"), &gorm.Config{
-		// NOTE: change LogMode if needed when debugging
-		Logger: gormzap.NewGormZapLogger().LogMode(logger.Warn),
+	dialector, err := newDialector(DbDriver)
+	if err != nil {
+		zap.S().Panicf("failed to configure database err = %+v", err)
+	}
+
+	level, err := gormzap.ParseLevel(LogGormLevel)
+	if err != nil {
+		zap.S().Panicf("failed to configure database logger err = %+v", err)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger: gormzap.NewGormZapLogger().LogMode(level),
 	})
 	if err != nil {
 		zap.S().Panicf("failed to connect database err = %+v", err)
 	}
+
+	err = db.Use(gormotel.NewGormOtelPlugin())
+	if err != nil {
+		zap.S().Panicf("failed to setup database tracing err = %+v", err)
+	}
 	return db
 }
 
+// newDialector returns the dialector of driver configured from the Db* variables
+func newDialector(driver string) (gorm.Dialector, error) {
+	switch driver {
+	case DbDriverSqlite:
+		return sqlite.Open(sqliteDsn(DbPath, DbSqliteWal)), nil
+	case DbDriverPostgres:
+		return postgres.Open(DbDsn), nil
+	case DbDriverMysql:
+		// time.Time columns can only be scanned with parseTime
+		config, err := gomysql.ParseDSN(DbDsn)
+		if err != nil {
+			return nil, err
+		}
+		config.ParseTime = true
+		return mysql.New(mysql.Config{DSNConfig: config}), nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %s", driver)
+	}
+}
+
+// sqliteDsn returns the dsn of the sqlite database file at path
+func sqliteDsn(path string, wal bool) string {
+	params := url.Values{}
+	params.Set("_busy_timeout", fmt.Sprint(_SQLITE_BUSY_TIMEOUT_MS))
+	if wal {
+		params.Set("_journal_mode", "WAL")
+	}
+	return "file:" + path + "?" + params.Encode()
+}
+
+// migrateOnStartup refuses a schema newer than this version and applies pending migrations,
+// with auto migrate off it fails while any are pending instead
+func migrateOnStartup(db *gorm.DB) error {
+	migrator := migration.New(db, zap.S())
+	if err := migrator.Check(); err != nil {
+		return err
+	}
+
+	pending, err := migrator.Pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if !DbAutoMigrate {
+		return fmt.Errorf("%w: %d, run the migrate command", cerror.ErrPendingMigrations, len(pending))
+	}
+
+	_, err = migrator.Up(0)
+	return err
+}
+
 func testDbConn() *gorm.DB {
 	db, err := gorm.Open(sqlite.Open("file:db?mode=memory&cache=shared"), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
@@ -27,5 +106,10 @@ func testDbConn() *gorm.DB {
 	if err != nil {
 		zap.S().Panicf("failed to connect database err = %+v", err)
 	}
+
+	err = db.Use(gormotel.NewGormOtelPlugin())
+	if err != nil {
+		zap.S().Panicf("failed to setup database tracing err = %+v", err)
+	}
 	return db
 }
@@ -0,0 +1,39 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/killi1812/cloudflared-web-gui/migration"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestSqliteDsn(t *testing.T) {
+	assert.Equal(t, "file:/config/db.sqlite?_busy_timeout=5000&_journal_mode=WAL", sqliteDsn("/config/db.sqlite", true))
+	assert.Equal(t, "file:db.sqlite?_busy_timeout=5000", sqliteDsn("db.sqlite", false))
+}
+
+func TestMigrateOnStartup(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:startup_migrate_test.db?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+
+	DbAutoMigrate = false
+	assert.ErrorIs(t, migrateOnStartup(db), cerror.ErrPendingMigrations)
+
+	DbAutoMigrate = true
+	require.NoError(t, migrateOnStartup(db))
+	pending, err := migration.New(db, zap.S()).Pending()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	DbAutoMigrate = false
+	assert.NoError(t, migrateOnStartup(db), "nothing is pending")
+}
@@ -11,8 +11,11 @@ import (
 	"time"
 
 	"github.com/killi1812/cloudflared-web-gui/docs"
+	"github.com/killi1812/cloudflared-web-gui/util/logging"
+	"github.com/killi1812/cloudflared-web-gui/util/metrics"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.uber.org/zap"
 
 	swaggerFiles "github.com/swaggo/files"
@@ -42,6 +45,15 @@ func Start() {
 	go run(schedulerCtx, &schedulerWg)
 	zap.S().Debugf("Started HTTP server")
 
+	for _, job := range jobs {
+		schedulerWg.Add(1)
+		go func() {
+			defer schedulerWg.Done()
+			job(schedulerCtx)
+		}()
+	}
+	zap.S().Debugf("Started %d jobs", len(jobs))
+
 	schedulerWg.Wait()
 
 	zap.S().Debugf("Terminated program")
@@ -64,6 +76,16 @@ func checkInterrupt(ctx context.Context, wg *sync.WaitGroup, schedulerCancel con
 	}
 }
 
+// newRouter returns a router that takes the client ip from X-Forwarded-For only when the request
+// comes from one of TrustedProxies, login rate limits and security events are keyed by it
+func newRouter() (*gin.Engine, error) {
+	router := gin.New()
+	if err := router.SetTrustedProxies(TrustedProxies); err != nil {
+		return nil, err
+	}
+	return router, nil
+}
+
 func run(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 
@@ -71,7 +93,18 @@ func run(ctx context.Context, wg *sync.WaitGroup) {
 	if Build == BuildProd {
 		gin.SetMode(gin.ReleaseMode)
 	}
-	router := gin.Default()
+	router, err := newRouter()
+	if err != nil {
+		zap.S().Panicf("Failed to setup router, err = %+v", err)
+	}
+	// probes run every few seconds, their spans and access logs would drown out those of requests
+	notProbe := func(r *http.Request) bool { return r.URL.Path != HealthPath && r.URL.Path != ReadyPath }
+	router.Use(
+		otelgin.Middleware(TracingServiceName, otelgin.WithFilter(notProbe)),
+		logging.Middleware(HealthPath, ReadyPath),
+		logging.Recovery(),
+		metrics.Middleware(),
+	)
 
 	// setup swagger
 	if Build == BuildDev {
@@ -86,10 +119,19 @@ func run(ctx context.Context, wg *sync.WaitGroup) {
 		router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 	}
 
+	// setup metrics, disabled without a token
+	if MetricsToken != "" {
+		router.GET("/metrics", metrics.Handler(MetricsToken))
+	}
+
 	// setup controllers
 	basePath := router.Group("/api")
+	rootPath := router.Group("")
 	for _, c := range controllers {
 		c.RegisterEndpoints(basePath)
+		if rc, ok := c.(RootController); ok {
+			rc.RegisterRootEndpoints(rootPath)
+		}
 	}
 	// cleanup
 	controllers = nil
@@ -115,7 +157,7 @@ func run(ctx context.Context, wg *sync.WaitGroup) {
 
 	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer timeoutCancel()
-	err := srv.Shutdown(timeoutCtx)
+	err = srv.Shutdown(timeoutCtx)
 	if err != nil {
 		zap.S().Errorf("Cannot shut down HTTP server, err = %v", err)
 	}
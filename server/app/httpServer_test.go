@@ -0,0 +1,42 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clientIp returns the client ip the router resolves for a request from remote claiming forwardedFor
+func clientIp(t *testing.T, remote, forwardedFor string) string {
+	gin.SetMode(gin.TestMode)
+	router, err := newRouter()
+	require.NoError(t, err)
+	router.GET("/ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+	req.RemoteAddr = remote
+	req.Header.Set("X-Forwarded-For", forwardedFor)
+	router.ServeHTTP(w, req)
+	return w.Body.String()
+}
+
+func TestNewRouter_ForgedForwardedFor(t *testing.T) {
+	TrustedProxies = nil
+
+	assert.Equal(t, "203.0.113.7", clientIp(t, "203.0.113.7:4242", "198.51.100.1"),
+		"login rate limits must not be keyed by a header the client chooses")
+	assert.Equal(t, "203.0.113.7", clientIp(t, "203.0.113.7:4242", "198.51.100.2"))
+}
+
+func TestNewRouter_TrustedProxy(t *testing.T) {
+	TrustedProxies = []string{"10.0.0.0/8"}
+	t.Cleanup(func() { TrustedProxies = nil })
+
+	assert.Equal(t, "198.51.100.1", clientIp(t, "10.0.0.2:4242", "198.51.100.1"))
+	assert.Equal(t, "203.0.113.7", clientIp(t, "203.0.113.7:4242", "198.51.100.1"), "only trusted proxies may forward")
+}
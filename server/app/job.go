@@ -0,0 +1,14 @@
+package app
+
+import "context"
+
+// Job is a background task run next to the web server, it has to return once ctx is done
+type Job func(ctx context.Context)
+
+var jobs []Job
+
+// RegisterJob registers a job that Start runs in its own goroutine,
+// Start returns only after every job returned
+func RegisterJob(job Job) {
+	jobs = append(jobs, job)
+}
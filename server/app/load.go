@@ -1,66 +1,156 @@
 package app
 
 import (
+	"errors"
+	"flag"
 	"os"
-	"strconv"
-	"strings"
 
-	"github.com/joho/godotenv"
 	"go.uber.org/zap"
 )
 
-// LoadConfig loads in program configuration should be a first thing called in the program
-func LoadConfig() {
-	zap.S().Debugf("Loading env variables")
+// Cfg is the configuration loaded by LoadConfig, package variables below are set from it
+var Cfg Config
 
-	if err := godotenv.Load("../.env"); err != nil {
-		zap.S().DPanicf("Env load err = %+v\n", err)
-		zap.S().Infof("Can't load config using real env")
+// LoadConfig loads in program configuration from args parsed with flags of the command,
+// it returns the positional args. The program exits when the configuration is invalid
+func LoadConfig(flags *flag.FlagSet, args []string) []string {
+	zap.S().Debugf("Loading config")
+
+	cfg, args, err := LoadFlags(flags, args, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		zap.S().Fatalf("Invalid configuration:\n%v", err)
 	}
 
+	applyConfig(cfg)
+	zap.S().Debugf("Finished loading config")
+	return args
+}
+
+// applyConfig sets Cfg and the package variables read by the rest of the program
+func applyConfig(cfg Config) {
+	Cfg = cfg
+
 	// App config
-	Port = loadInt("PORT")
+	Port = cfg.Port
+	TrustedProxies = cfg.TrustedProxies
+
+	// Database
+	DbDriver = cfg.Database.Driver
+	DbDsn = cfg.Database.Dsn
+	DbPath = cfg.Database.Path
+	DbSqliteWal = cfg.Database.SqliteWal
+	DbMaxOpenConns = cfg.Database.MaxOpenConns
+	DbMaxIdleConns = cfg.Database.MaxIdleConns
+	DbConnMaxLifetime = cfg.Database.ConnMaxLifetime
+	DbAutoMigrate = cfg.Database.AutoMigrate
+
+	// Backups
+	BackupDir = cfg.Backup.Dir
+	BackupInterval = cfg.Backup.Interval
+	BackupKeep = cfg.Backup.Keep
+	BackupPassphrase = cfg.Backup.Passphrase
 
 	// Secrets
-	AccessKey = loadString("ACCESS_KEY")
-	RefreshKey = loadString("REFRESH_KEY")
+	AccessKey = cfg.Secrets.AccessKey
+	RefreshKey = cfg.Secrets.RefreshKey
+	AccessKeysPrevious = cfg.Secrets.AccessKeysPrevious
+	RefreshKeysPrevious = cfg.Secrets.RefreshKeysPrevious
+	KeyRetiredTtl = cfg.Secrets.KeyRetiredTtl
+	SuperadminPassword = cfg.Secrets.SuperadminPassword
 
-	CloudflaredApiKey = loadString("CLOUDFLARED_API_KEY")
-	ZoneId = loadString("ZONE_ID")
+	// Token signing
+	JwtAlgorithm = cfg.Jwt.Algorithm
+	JwtPrivateKeyFile = cfg.Jwt.PrivateKeyFile
+	JwtIssuer = cfg.Jwt.Issuer
+	JwtAudience = cfg.Jwt.Audience
+	JwtRequireClaims = cfg.Jwt.RequireClaims
 
-	zap.S().Debugf("Finished loading env variables")
-}
+	CloudflaredApiKey = cfg.Cloudflare.ApiKey
+	ZoneId = cfg.Cloudflare.ZoneId
+	CloudflaredConfigDir = cfg.Cloudflare.ConfigDir
 
-func loadInt(name string) int {
-	rez := os.Getenv(name)
-	if rez == "" {
-		zap.S().Errorf("Env variable %s is empty\n", name)
-	}
+	// Authentication mode
+	AuthMode = cfg.Auth.Mode
+	AuthReloadInterval = cfg.Auth.ReloadInterval
+	CfAccessTeamDomain = cfg.Auth.CfAccess.TeamDomain
+	CfAccessJwksUrl = cfg.Auth.CfAccess.JwksUrl
+	CfAccessAudience = cfg.Auth.CfAccess.Audience
+	CfAccessDefaultRole = cfg.Auth.CfAccess.DefaultRole
 
-	num, err := strconv.Atoi(rez)
-	if err != nil {
-		zap.S().Errorf("Failed to parse int %s, will use default (0)\n", rez)
-		return 0
-	}
+	// Invitations
+	InviteUrl = cfg.Invite.Url
+	InviteTtl = cfg.Invite.Ttl
 
-	zap.S().Debugf("Loaded %s = %d", name, num)
-	return num
-}
+	// Login brute-force protection
+	LoginRateWindow = cfg.Login.RateWindow
+	LoginRateIp = cfg.Login.RateIp
+	LoginRateUsername = cfg.Login.RateUsername
+	LoginDelayBase = cfg.Login.DelayBase
+	LoginDelayMax = cfg.Login.DelayMax
+	LoginLockoutThreshold = cfg.Login.LockoutThreshold
+	LoginLockoutDuration = cfg.Login.LockoutDuration
 
-func loadString(name string) string {
-	rez := strings.TrimSpace(os.Getenv(name))
-	if rez == "" {
-		zap.S().Errorf("Env variable %s is empty", name)
-		return rez
-	}
-	zap.S().Debugf("Loaded %s = %s", name, rez)
-	return rez
-}
+	// Security events
+	SecurityEventRetention = cfg.SecurityEvents.Retention
 
-func loadBool(name string) bool {
-	rez := os.Getenv(name)
-	if rez == "" {
-		zap.S().Errorf("Env variable %s is empty", name)
-	}
-	return rez == "true"
+	// Metrics
+	MetricsToken = cfg.Metrics.Token
+
+	// Logging
+	LogLevelName = cfg.Log.Level
+	LogFormat = cfg.Log.Format
+	LogFile = cfg.Log.File
+	LogFileFormat = cfg.Log.FileFormat
+	LogFileMaxSize = cfg.Log.FileMaxSize
+	LogFileMaxAge = cfg.Log.FileMaxAge
+	LogFileMaxBackups = cfg.Log.FileMaxBackups
+	LogGormLevel = cfg.Log.GormLevel
+
+	// Tracing
+	TracingExporter = cfg.Tracing.Exporter
+	TracingEndpoint = cfg.Tracing.Endpoint
+	TracingServiceName = cfg.Tracing.ServiceName
+
+	// Health checks
+	HealthCacheTtl = cfg.Health.CacheTtl
+	HealthCloudflareCacheTtl = cfg.Health.CloudflareCacheTtl
+
+	// Password policy
+	PasswordMinLength = cfg.Password.MinLength
+	PasswordRequireUpper = cfg.Password.RequireUpper
+	PasswordRequireLower = cfg.Password.RequireLower
+	PasswordRequireDigit = cfg.Password.RequireDigit
+	PasswordRequireSymbol = cfg.Password.RequireSymbol
+	PasswordBreachedList = cfg.Password.BreachedList
+	PasswordArgon2Memory = cfg.Password.Argon2Memory
+	PasswordArgon2Time = cfg.Password.Argon2Time
+	PasswordArgon2Threads = cfg.Password.Argon2Threads
+
+	// OpenID Connect
+	OidcIssuer = cfg.Oidc.Issuer
+	OidcClientId = cfg.Oidc.ClientId
+	OidcClientSecret = cfg.Oidc.ClientSecret
+	OidcRedirectUrl = cfg.Oidc.RedirectUrl
+	OidcScopes = cfg.Oidc.Scopes
+	OidcDefaultRole = cfg.Oidc.DefaultRole
+	OidcGroupsClaim = cfg.Oidc.GroupsClaim
+	OidcRoleMapping = cfg.Oidc.RoleMapping
+	OidcClientRedirect = cfg.Oidc.ClientRedirect
+
+	// LDAP
+	LdapUrl = cfg.Ldap.Url
+	LdapStartTls = cfg.Ldap.StartTls
+	LdapInsecure = cfg.Ldap.Insecure
+	LdapCaFile = cfg.Ldap.CaFile
+	LdapBindDn = cfg.Ldap.BindDn
+	LdapUserBaseDn = cfg.Ldap.UserBaseDn
+	LdapUserFilter = cfg.Ldap.UserFilter
+	LdapEmailAttr = cfg.Ldap.EmailAttr
+	LdapGroupBaseDn = cfg.Ldap.GroupBaseDn
+	LdapGroupFilter = cfg.Ldap.GroupFilter
+	LdapDefaultRole = cfg.Ldap.DefaultRole
+	LdapRoleMapping = cfg.Ldap.RoleMapping
 }
@@ -3,33 +3,22 @@ package app
 
 import (
 	"fmt"
-	"time"
-
-	"github.com/killi1812/cloudflared-web-gui/model"
 
 	"go.uber.org/dig"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
-// Setup will preform app setup or panic of it fails
-// Can only be called once
-func Setup() {
+// Commands run only the setup steps they need, in order: SetupLogger or SetupCliLogger,
+// LoadConfig and SetupDb. Each step panics if it fails and can only be called once
+
+// SetupLogger sets up the server logger from the configuration and prints build time variables
+func SetupLogger() {
 	// Logger setup
 	{
-		var err error
-
-		if Build == BuildDev {
-			err = devLoggerSetup()
-			if err != nil {
-				fmt.Printf("err: %v\n", err)
-				panic("failed to setup logger")
-			}
-		} else {
-			err = prodLoggerSetup()
-			if err != nil {
-				fmt.Printf("err: %v\n", err)
-				panic("failed to setup logger")
-			}
+		if err := loggerSetup(); err != nil {
+			fmt.Printf("err: %v\n", err)
+			panic("failed to setup logger")
 		}
 	}
 
@@ -41,9 +30,20 @@ func Setup() {
 		zap.S().Infof("Build Time Stamp:\t %s", BuildTimestamp)
 		zap.S().Sync()
 	}
+}
 
-	LoadConfig()
+// SetupCliLogger sets up a logger for commands, it only writes warnings and errors to stderr
+// so they don't mix with the command output
+func SetupCliLogger() {
+	if err := cliLoggerSetup(); err != nil {
+		fmt.Printf("err: %v\n", err)
+		panic("failed to setup logger")
+	}
+}
 
+// SetupDb connects to the database and provides it, with migrate the schema is checked
+// and pending migrations are applied as configured
+func SetupDb(migrate bool) {
 	// Dig setup
 	{
 		digContainer = dig.New()
@@ -57,14 +57,17 @@ func Setup() {
 			zap.S().Panicf("failed to get database connection: %+v", err)
 		}
 
-		sqlDB.SetMaxIdleConns(10)
-		sqlDB.SetMaxOpenConns(100)
-		sqlDB.SetConnMaxLifetime(time.Hour)
+		sqlDB.SetMaxIdleConns(DbMaxIdleConns)
+		sqlDB.SetMaxOpenConns(DbMaxOpenConns)
+		sqlDB.SetConnMaxLifetime(DbConnMaxLifetime)
 
-		if err = db.AutoMigrate(model.GetAllModels()...); err != nil {
-			zap.S().Panicf("Can't run AutoMigrate err = %+v", err)
+		if migrate {
+			if err = migrateOnStartup(db); err != nil {
+				zap.S().Panicf("Can't migrate database err = %+v", err)
+			}
 		}
 
-		Provide(newDbConn)
+		// every service shares the connection pool configured above
+		Provide(func() *gorm.DB { return db })
 	}
 }
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.uber.org/zap"
+)
+
+// _TRACING_SHUTDOWN_TIMEOUT is how long pending spans are flushed for on shutdown
+const _TRACING_SHUTDOWN_TIMEOUT = 5 * time.Second
+
+// SetupTracing installs the global tracer provider exporting spans with TracingExporter, without an
+// exporter spans are only used to correlate log lines. The returned function flushes pending spans
+//
+// The sampler and OTLP options not in the config are read from the standard OTEL_* env variables
+func SetupTracing() func() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	exporter, err := newSpanExporter(TracingExporter)
+	if err != nil {
+		zap.S().Panicf("Failed to setup tracing exporter, err = %+v", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(TracingServiceName),
+		semconv.ServiceVersion(Version),
+	))
+	if err != nil {
+		zap.S().Panicf("Failed to setup tracing resource, err = %+v", err)
+	}
+
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	if exporter != nil {
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+		zap.S().Infof("Exporting traces with %s", TracingExporter)
+	}
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), _TRACING_SHUTDOWN_TIMEOUT)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			zap.S().Errorf("Failed to flush traces, err = %+v", err)
+		}
+	}
+}
+
+// newSpanExporter returns the exporter named by exporter, nil when exporter is TracingExporterNone
+func newSpanExporter(exporter string) (sdktrace.SpanExporter, error) {
+	switch exporter {
+	case TracingExporterNone:
+		return nil, nil
+	case TracingExporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case TracingExporterOtlp:
+		var opts []otlptracehttp.Option
+		if TracingEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(TracingEndpoint))
+		}
+		return otlptracehttp.New(context.Background(), opts...)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %s", exporter)
+	}
+}
@@ -1,10 +1,44 @@
 package app
 
+import "time"
+
 const (
 	BuildDev  = "dev"
 	BuildProd = "prod"
 )
 
+const (
+	JwtAlgHS256 = "HS256" // JwtAlgHS256 signs access tokens with a shared secret
+	JwtAlgEdDSA = "EdDSA" // JwtAlgEdDSA signs access tokens with an Ed25519 key published at /.well-known/jwks.json
+)
+
+const (
+	DbDriverSqlite   = "sqlite"
+	DbDriverPostgres = "postgres"
+	DbDriverMysql    = "mysql"
+)
+
+const (
+	HealthPath = "/healthz" // HealthPath is the liveness probe
+	ReadyPath  = "/readyz"  // ReadyPath is the readiness probe
+)
+
+const (
+	LogFormatText = "text" // LogFormatText logs human readable lines
+	LogFormatJson = "json" // LogFormatJson logs a json object per line
+)
+
+const (
+	TracingExporterNone   = ""       // TracingExporterNone doesn't export spans
+	TracingExporterOtlp   = "otlp"   // TracingExporterOtlp exports spans to an OTLP/HTTP collector
+	TracingExporterStdout = "stdout" // TracingExporterStdout prints spans, for local use
+)
+
+const (
+	AuthModeLocal    = "local"     // AuthModeLocal authenticates users with tokens issued by the app
+	AuthModeCfAccess = "cf-access" // AuthModeCfAccess trusts Cloudflare Access JWT assertions
+)
+
 var (
 	// Build describes app build type
 	//
@@ -18,10 +52,166 @@ var (
 // Envirment variables
 
 var (
-	Port       int    // Port is app port
-	AccessKey  string // AccessKey is secrete for jwt access key
-	RefreshKey string // RefreshKey is secrete for jwt refresh key
+	Port           int      // Port is app port
+	TrustedProxies []string // TrustedProxies are proxies whose X-Forwarded-For is trusted, none when empty
+	AccessKey      string   // AccessKey is secrete for jwt access key
+	RefreshKey     string   // RefreshKey is secrete for jwt refresh key
+
+	AccessKeysPrevious  []string      // AccessKeysPrevious are old access secrets still accepted for verification
+	RefreshKeysPrevious []string      // RefreshKeysPrevious are old refresh secrets still accepted for verification
+	KeyRetiredTtl       time.Duration // KeyRetiredTtl is how long a rotated key keeps verifying tokens
+	SuperadminPassword  string        // SuperadminPassword is the password of the superadmin created on the first start
+
+	CloudflaredApiKey    string
+	ZoneId               string
+	CloudflaredConfigDir string // CloudflaredConfigDir holds tunnel configs, credentials and the origin certificate
+)
+
+// Database
+
+var (
+	DbDriver          string        // DbDriver is one of DbDriverSqlite, DbDriverPostgres, DbDriverMysql
+	DbDsn             string        // DbDsn is the connection string of postgres and mysql
+	DbPath            string        // DbPath is the sqlite database file
+	DbSqliteWal       bool          // DbSqliteWal enables the sqlite write-ahead log so reads don't block on writes
+	DbMaxOpenConns    int           // DbMaxOpenConns limits open connections, zero is unlimited
+	DbMaxIdleConns    int           // DbMaxIdleConns is the number of kept idle connections
+	DbConnMaxLifetime time.Duration // DbConnMaxLifetime closes connections older than it, zero keeps them forever
+	DbAutoMigrate     bool          // DbAutoMigrate applies pending migrations on startup, otherwise startup fails while any are pending
+)
+
+// Backups
+
+var (
+	BackupDir        string        // BackupDir is where scheduled backups are written
+	BackupInterval   time.Duration // BackupInterval of scheduled backups, zero disables them
+	BackupKeep       int           // BackupKeep is the number of scheduled backups kept, zero keeps all
+	BackupPassphrase string        // BackupPassphrase encrypts backup archives, they aren't encrypted when empty
+)
+
+// Token signing
+
+var (
+	JwtAlgorithm      string   // JwtAlgorithm signs access tokens, one of JwtAlgHS256, JwtAlgEdDSA
+	JwtPrivateKeyFile string   // JwtPrivateKeyFile is a PEM encoded PKCS8 Ed25519 key, generated and stored in the database when empty
+	JwtIssuer         string   // JwtIssuer is the iss claim of issued tokens
+	JwtAudience       []string // JwtAudience is the aud claim of issued tokens
+	JwtRequireClaims  bool     // JwtRequireClaims rejects tokens without iss and aud instead of accepting them until they expire
+)
+
+// OpenID Connect single sign-on, disabled when OidcIssuer is empty
+
+var (
+	OidcIssuer         string            // OidcIssuer is the issuer url of the identity provider
+	OidcClientId       string            // OidcClientId is the client id registered at the identity provider
+	OidcClientSecret   string            // OidcClientSecret is the client secret registered at the identity provider
+	OidcRedirectUrl    string            // OidcRedirectUrl is the callback url registered at the identity provider
+	OidcScopes         []string          // OidcScopes are requested scopes, openid is always added
+	OidcDefaultRole    string            // OidcDefaultRole is assigned to auto provisioned users
+	OidcGroupsClaim    string            // OidcGroupsClaim is the id token claim holding users groups
+	OidcRoleMapping    map[string]string // OidcRoleMapping maps identity provider groups to user roles
+	OidcClientRedirect string            // OidcClientRedirect is where the browser is sent after login, empty returns json
+)
+
+// LDAP / Active Directory login, disabled when LdapUrl is empty
+
+var (
+	LdapUrl         string            // LdapUrl is the directory server, ldap://host:389 or ldaps://host:636
+	LdapStartTls    bool              // LdapStartTls upgrades an ldap:// connection with StartTLS
+	LdapInsecure    bool              // LdapInsecure allows an ldap:// url without StartTLS, passwords are sent in cleartext
+	LdapCaFile      string            // LdapCaFile is a PEM file with the CA of the directory certificate, system roots when empty
+	LdapBindDn      string            // LdapBindDn is the DN users bind as, {username} is replaced with the login username
+	LdapUserBaseDn  string            // LdapUserBaseDn is where the user entry is searched for its email and memberOf
+	LdapUserFilter  string            // LdapUserFilter finds the user entry, {username} is replaced with the login username
+	LdapEmailAttr   string            // LdapEmailAttr is the user entry attribute holding the email
+	LdapGroupBaseDn string            // LdapGroupBaseDn is where groups are searched, memberOf of the user entry is used when empty
+	LdapGroupFilter string            // LdapGroupFilter finds groups of the user, {dn} is replaced with the user DN
+	LdapDefaultRole string            // LdapDefaultRole is assigned to users without a mapped group
+	LdapRoleMapping map[string]string // LdapRoleMapping maps directory group names to user roles
+)
+
+// Authentication mode
+
+var (
+	AuthMode           string        // AuthMode is one of AuthModeLocal, AuthModeCfAccess
+	AuthReloadInterval time.Duration // AuthReloadInterval is how often signing keys, roles and groups are reloaded so instances sharing the database pick up changes
+
+	CfAccessTeamDomain  string // CfAccessTeamDomain is the Access team domain, https://<team>.cloudflareaccess.com
+	CfAccessJwksUrl     string // CfAccessJwksUrl is where the team signing keys are fetched from
+	CfAccessAudience    string // CfAccessAudience is the Access application audience (AUD) tag
+	CfAccessDefaultRole string // CfAccessDefaultRole is assigned to users created on first login
+)
+
+// Invitations
+
+var (
+	InviteUrl string        // InviteUrl is the page invite links point to, the token is appended as the token query parameter
+	InviteTtl time.Duration // InviteTtl is how long an invitation is valid when the admin doesn't set an expiry
+)
+
+// Login brute-force protection, zero values disable the given protection
+
+var (
+	LoginRateWindow       time.Duration // LoginRateWindow is the window of LoginRateIp and LoginRateUsername
+	LoginRateIp           int           // LoginRateIp is the number of login attempts allowed per ip in the window
+	LoginRateUsername     int           // LoginRateUsername is the number of login attempts allowed per username in the window
+	LoginDelayBase        time.Duration // LoginDelayBase is the wait after the first failed login, doubled on every next failure
+	LoginDelayMax         time.Duration // LoginDelayMax caps the progressive delay
+	LoginLockoutThreshold int           // LoginLockoutThreshold is the number of failures after which the account is locked
+	LoginLockoutDuration  time.Duration // LoginLockoutDuration is how long the account stays locked
+)
+
+// Security events
+
+var (
+	SecurityEventRetention time.Duration // SecurityEventRetention is how long security events are kept, zero keeps them forever
+)
+
+// Logging
+
+var (
+	LogLevelName      string // LogLevelName is the configured level LogLevel starts at, empty for the build default
+	LogFormat         string // LogFormat of console logs, LogFormatText or LogFormatJson
+	LogFile           string // LogFile is the rotated log file, disabled when empty
+	LogFileFormat     string // LogFileFormat is LogFormatText or LogFormatJson
+	LogFileMaxSize    int    // LogFileMaxSize is the size in MB LogFile is rotated at
+	LogFileMaxAge     int    // LogFileMaxAge is how many days rotated files are kept
+	LogFileMaxBackups int    // LogFileMaxBackups is how many rotated files are kept
+	LogGormLevel      string // LogGormLevel is the level of the gorm logger, silent, error, warn or info
+)
+
+// Metrics
+
+var (
+	MetricsToken string // MetricsToken is the bearer token of /metrics, the endpoint is disabled when empty
+)
+
+// Tracing
+
+var (
+	TracingExporter    string // TracingExporter is one of TracingExporterNone, TracingExporterOtlp, TracingExporterStdout
+	TracingEndpoint    string // TracingEndpoint is the OTLP/HTTP collector url
+	TracingServiceName string // TracingServiceName is the service.name of exported spans
+)
+
+// Health checks
+
+var (
+	HealthCacheTtl           time.Duration // HealthCacheTtl is how long the readiness report is reused
+	HealthCloudflareCacheTtl time.Duration // HealthCloudflareCacheTtl is how long the Cloudflare API readiness check result is reused
+)
+
+// Password policy
+
+var (
+	PasswordMinLength     int    // PasswordMinLength is the minimal number of characters
+	PasswordRequireUpper  bool   // PasswordRequireUpper requires an upper case letter
+	PasswordRequireLower  bool   // PasswordRequireLower requires a lower case letter
+	PasswordRequireDigit  bool   // PasswordRequireDigit requires a digit
+	PasswordRequireSymbol bool   // PasswordRequireSymbol requires a symbol or punctuation
+	PasswordBreachedList  string // PasswordBreachedList is a path to a file with one breached password per line
 
-	CloudflaredApiKey string
-	ZoneId            string
+	PasswordArgon2Memory  int // PasswordArgon2Memory is the Argon2id memory cost in KiB
+	PasswordArgon2Time    int // PasswordArgon2Time is the number of Argon2id passes
+	PasswordArgon2Threads int // PasswordArgon2Threads is the Argon2id parallelism
 )
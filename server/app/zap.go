@@ -1,75 +1,58 @@
 package app
 
 import (
+	"fmt"
 	"os"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-const (
-	_LOG_FILE             = "cloudflared-web-gui.log"
-	_LOG_FILE_MAX_SIZE    = 2
-	_LOG_FILE_MAX_AGE     = 30
-	_LOG_FILE_MAX_BACKUPS = 0
-	_LOG_FOLDER           = "./log"
-	_TMP_FOLDER           = "./tmp"
-)
+// LogLevel is the level of the server logger, it can be changed while the server runs
+var LogLevel = zap.NewAtomicLevel()
+
+// loggerSetup sets up the server logger writing to the console and, when LogFile is set, to a rotating file
+func loggerSetup() error {
+	level, err := logLevel()
+	if err != nil {
+		return err
+	}
+	LogLevel.SetLevel(level)
 
-func prodLoggerSetup() error {
-	consoleLogLevel := zap.LevelEnablerFunc(func(level zapcore.Level) bool {
-		return level >= zapcore.InfoLevel
-	})
+	consoleEncoder, err := newLogEncoder(LogFormat, consoleEncoderConfig(LogFormat))
+	if err != nil {
+		return err
+	}
+	// dev builds log to stderr like zap.NewDevelopment
+	console := zapcore.Lock(os.Stdout)
+	if Build == BuildDev {
+		console = zapcore.Lock(os.Stderr)
+	}
+	cores := []zapcore.Core{zapcore.NewCore(consoleEncoder, console, LogLevel)}
 
-	// log output
-	consoleLogFile := zapcore.Lock(os.Stdout)
+	if LogFile != "" {
+		fileEncoder, err := newLogEncoder(LogFileFormat, fileEncoderConfig())
+		if err != nil {
+			return err
+		}
 
-	// log configuration no date time and location, just level
-	consoleLogConfig := zap.NewProductionEncoderConfig()
-	consoleLogConfig.EncodeTime = nil
-	consoleLogConfig.EncodeCaller = nil
-	// consoleLogConfig.EncodeLevel = nil
-	consoleLogConfig.LevelKey = ""
-
-	consoleLogEncoder := zapcore.NewConsoleEncoder(consoleLogConfig)
-	/*
-		// file log, text
-		// log level
-		fileLogLevel := zap.LevelEnablerFunc(func(level zapcore.Level) bool {
-			return level >= zapcore.InfoLevel
-		})
-
-		logPath := filepath.Join(_LOG_FOLDER, _LOG_FILE)
-		lumberjackLogger := lumberjack.Logger{
-			Filename:   logPath,
-			MaxSize:    _LOG_FILE_MAX_SIZE,    // size in MB
-			MaxAge:     _LOG_FILE_MAX_AGE,     // maximum number of days to retain old log files
-			MaxBackups: _LOG_FILE_MAX_BACKUPS, // maximum number of old log files to retain
-			LocalTime:  true,                  // time used for formatting the timestamps
-			Compress:   false,
+		file := &lumberjack.Logger{
+			Filename:   LogFile,
+			MaxSize:    LogFileMaxSize,    // size in MB
+			MaxAge:     LogFileMaxAge,     // maximum number of days to retain old log files
+			MaxBackups: LogFileMaxBackups, // maximum number of old log files to retain
+			LocalTime:  true,              // time used for formatting the timestamps
 		}
-		fileLogFile := zapcore.Lock(zapcore.AddSync(&lumberjackLogger))
-		// log configuration
-		fileLogConfig := zap.NewProductionEncoderConfig()
-		// configure keys
-		fileLogConfig.TimeKey = "timestamp"
-		fileLogConfig.MessageKey = "message"
-		// configure types
-		fileLogConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-		fileLogConfig.EncodeLevel = zapcore.CapitalLevelEncoder
-		// create encoder
-		fileLogEncoder := zapcore.NewConsoleEncoder(fileLogConfig)
-		// setup zap
-		// duplicate log entries into multiple cores
-	*/
-	core := zapcore.NewTee(
-		zapcore.NewCore(consoleLogEncoder, consoleLogFile, consoleLogLevel),
-		// zapcore.NewCore(fileLogEncoder, fileLogFile, fileLogLevel),
-	)
-
-	// create logger from core
+		cores = append(cores, zapcore.NewCore(fileEncoder, zapcore.Lock(zapcore.AddSync(file)), LogLevel))
+	}
+
 	// options = annotate message with the filename, line number, and function name
-	logger := zap.New(core, zap.AddCaller())
+	options := []zap.Option{zap.AddCaller()}
+	if Build == BuildDev {
+		options = append(options, zap.Development(), zap.AddStacktrace(zap.PanicLevel))
+	}
+	logger := zap.New(zapcore.NewTee(cores...), options...)
 	defer logger.Sync()
 
 	// replace global logger
@@ -78,12 +61,66 @@ func prodLoggerSetup() error {
 	return nil
 }
 
-func devLoggerSetup() error {
-	logger, err := zap.NewDevelopment(zap.AddStacktrace(zap.PanicLevel))
-	if err != nil {
-		return err
+// logLevel returns LogLevel from the configuration, debug in dev builds and info otherwise when unset
+func logLevel() (zapcore.Level, error) {
+	if LogLevelName != "" {
+		return zapcore.ParseLevel(LogLevelName)
 	}
-	_ = zap.ReplaceGlobals(logger)
+	if Build == BuildDev {
+		return zapcore.DebugLevel, nil
+	}
+	return zapcore.InfoLevel, nil
+}
+
+// consoleEncoderConfig returns the console configuration of format, text logs of dev builds have time and caller,
+// of other builds just the message because the container runtime adds the time
+func consoleEncoderConfig(format string) zapcore.EncoderConfig {
+	if format == LogFormatJson {
+		return fileEncoderConfig()
+	}
+	if Build == BuildDev {
+		return zap.NewDevelopmentEncoderConfig()
+	}
+
+	// log configuration no date time and location, just level
+	config := zap.NewProductionEncoderConfig()
+	config.EncodeTime = nil
+	config.EncodeCaller = nil
+	config.LevelKey = ""
+	return config
+}
+
+// fileEncoderConfig returns the configuration of file logs and json console logs
+func fileEncoderConfig() zapcore.EncoderConfig {
+	config := zap.NewProductionEncoderConfig()
+	// configure keys
+	config.TimeKey = "timestamp"
+	config.MessageKey = "message"
+	// configure types
+	config.EncodeTime = zapcore.ISO8601TimeEncoder
+	config.EncodeLevel = zapcore.CapitalLevelEncoder
+	return config
+}
+
+func newLogEncoder(format string, config zapcore.EncoderConfig) (zapcore.Encoder, error) {
+	switch format {
+	case LogFormatText:
+		return zapcore.NewConsoleEncoder(config), nil
+	case LogFormatJson:
+		return zapcore.NewJSONEncoder(config), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %s", format)
+	}
+}
+
+func cliLoggerSetup() error {
+	config := zap.NewProductionEncoderConfig()
+	config.EncodeTime = nil
+	config.EncodeCaller = nil
+	config.EncodeLevel = zapcore.CapitalLevelEncoder
+
+	core := zapcore.NewCore(zapcore.NewConsoleEncoder(config), zapcore.Lock(os.Stderr), zapcore.WarnLevel)
+	_ = zap.ReplaceGlobals(zap.New(core))
 
 	return nil
 }
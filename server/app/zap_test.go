@@ -0,0 +1,46 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLoggerSetup_File(t *testing.T) {
+	t.Cleanup(zap.ReplaceGlobals(zap.NewNop()))
+	LogLevelName, LogFormat, LogFileFormat = "info", LogFormatText, LogFormatJson
+	LogFile = filepath.Join(t.TempDir(), "server.log")
+	t.Cleanup(func() { LogFile = "" })
+	require.NoError(t, loggerSetup())
+
+	zap.S().Debug("hidden")
+	zap.S().Infow("written", "request_id", "1")
+	LogLevel.SetLevel(zapcore.DebugLevel)
+	zap.S().Debug("written after the level changed")
+	_ = zap.L().Sync() // syncing the console fails on some terminals, the file is written unbuffered
+
+	data, err := os.ReadFile(LogFile)
+	require.NoError(t, err)
+
+	var messages []string
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for decoder.More() {
+		var line map[string]any
+		require.NoError(t, decoder.Decode(&line))
+		messages = append(messages, line["message"].(string))
+	}
+	assert.Equal(t, []string{"written", "written after the level changed"}, messages)
+}
+
+func TestLoggerSetup_Invalid(t *testing.T) {
+	LogLevelName, LogFormat = "", "xml"
+	t.Cleanup(func() { LogFormat = LogFormatText })
+	assert.Error(t, loggerSetup())
+}
@@ -0,0 +1,90 @@
+package command
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/service"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+)
+
+func backup(name string, args []string) error {
+	flags := newFlagSet(name, "[path]", "Writes a backup archive of the sqlite database, tunnel configs and credentials\n"+
+		"while the server runs. Without path the archive is written to the backup directory,\n"+
+		"otherwise path must not exist. The archive is encrypted when a backup passphrase is set")
+	args = setup(flags, args, true)
+	if len(args) > 1 {
+		flags.Usage()
+		return errUsage
+	}
+	provideServices()
+
+	var err error
+	app.Invoke(func(backups service.IBackupSrv) {
+		var path string
+		var manifest *model.BackupManifest
+		if len(args) == 0 {
+			path, manifest, err = backups.CreateFile(app.BackupDir, app.BackupPassphrase)
+		} else {
+			path = args[0]
+			manifest, err = createBackup(backups, path)
+		}
+		if err == nil {
+			fmt.Printf("Backup of %d files, schema version %d, written to %s\n", len(manifest.Files), manifest.SchemaVersion, path)
+		}
+	})
+	return err
+}
+
+// createBackup writes a backup archive to path, the file is removed when the backup fails
+func createBackup(backups service.IBackupSrv, path string) (*model.BackupManifest, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := backups.Create(file, app.BackupPassphrase)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func restore(name string, args []string) error {
+	flags := newFlagSet(name, "<archive>", "Verifies the backup archive, then replaces the sqlite database and restores\n"+
+		"tunnel configs and credentials into the config directory. Stop the server first,\n"+
+		"the replaced database is kept next to it with the .pre-restore suffix")
+	verify := flags.Bool("verify", false, "only verify the archive, nothing is changed")
+	args = loadConfig(flags, args)
+	if err := exactArgs(flags, args, 1); err != nil {
+		return err
+	}
+
+	var manifest *model.BackupManifest
+	var err error
+	if *verify {
+		manifest, err = service.VerifyBackup(args[0], app.BackupPassphrase)
+	} else {
+		if app.DbDriver != app.DbDriverSqlite {
+			return cerror.ErrBackupUnsupported
+		}
+		manifest, err = service.RestoreBackup(args[0], app.BackupPassphrase, app.DbPath, app.CloudflaredConfigDir)
+	}
+	if err != nil {
+		return err
+	}
+
+	action := "Restored"
+	if *verify {
+		action = "Verified"
+	}
+	fmt.Printf("%s backup of %d files, created %s by version %s, schema version %d\n",
+		action, len(manifest.Files), manifest.CreatedAt.Format("2006-01-02 15:04:05 MST"), manifest.AppVersion, manifest.SchemaVersion)
+	return nil
+}
@@ -0,0 +1,145 @@
+// Package command implements the subcommands of the binary, each runs only the app setup it needs
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+)
+
+const _NAME = "cloudflared-web-gui"
+
+// errUsage is returned when a command is called with wrong arguments, its usage was already printed
+var errUsage = errors.New("invalid usage")
+
+// command is a subcommand, a group of subcommands has subcommands instead of run
+type command struct {
+	name        string
+	description string
+	run         func(name string, args []string) error
+	subcommands []command
+}
+
+func commands() []command {
+	return []command{
+		{name: "serve", description: "start the web server, the default command", run: serve},
+		{name: "user", description: "manage users", subcommands: userCommands()},
+		{name: "tunnel", description: "list, start and stop tunnels", subcommands: tunnelCommands()},
+		{name: "migrate", description: "apply, roll back or list schema migrations", run: migrate},
+		{name: "backup", description: "write a backup archive of the database, tunnel configs and credentials", run: backup},
+		{name: "restore", description: "verify or restore a backup archive", run: restore},
+		{name: "healthcheck", description: "check the running server is ready, for container healthchecks", run: healthcheck},
+		{name: "version", description: "print the version", run: version},
+	}
+}
+
+// Run runs the command selected by args and returns the exit code, without a command the server is started
+func Run(args []string) int {
+	err := dispatch(_NAME, commands(), args, "serve")
+	if errors.Is(err, errUsage) {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// dispatch runs the command of cmds named by the first arg, def is run when args start with a flag or are empty
+func dispatch(prefix string, cmds []command, args []string, def string) error {
+	name := def
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" || (name == "" && len(args) > 0 && isHelp(args[0])) {
+		printCommands(os.Stdout, prefix, cmds)
+		return nil
+	}
+
+	for _, cmd := range cmds {
+		if cmd.name != name {
+			continue
+		}
+		if cmd.subcommands != nil {
+			return dispatch(prefix+" "+cmd.name, cmd.subcommands, args, "")
+		}
+		return cmd.run(prefix+" "+cmd.name, args)
+	}
+
+	if name != "" {
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", name)
+	}
+	printCommands(os.Stderr, prefix, cmds)
+	return errUsage
+}
+
+func isHelp(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+func printCommands(w io.Writer, prefix string, cmds []command) {
+	fmt.Fprintf(w, "usage: %s <command> [flags] [args]\n\ncommands:\n", prefix)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range cmds {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.description)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nEvery command accepts the configuration flags, see %s serve --help\n", _NAME)
+}
+
+// newFlagSet returns the flag set of command name, its usage lists flags defined before setup
+// and not the configuration flags
+func newFlagSet(name, args, description string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s [flags] %s\n\n%s\n", name, args, description)
+	}
+	return flags
+}
+
+// setup loads the configuration with the flags of the command and connects to the database,
+// it returns the positional args and exits on invalid flags
+func setup(flags *flag.FlagSet, args []string, migrate bool) []string {
+	args = loadConfig(flags, args)
+	app.SetupDb(migrate)
+	return args
+}
+
+// loadConfig is setup for commands that don't use the database
+func loadConfig(flags *flag.FlagSet, args []string) []string {
+	// flags defined so far belong to the command, the rest are configuration options
+	var own []*flag.Flag
+	flags.VisitAll(func(f *flag.Flag) { own = append(own, f) })
+	usage := flags.Usage
+	flags.Usage = func() {
+		usage()
+		if len(own) > 0 {
+			fmt.Fprintln(flags.Output(), "\nflags:")
+			tw := tabwriter.NewWriter(flags.Output(), 0, 0, 2, ' ', 0)
+			for _, f := range own {
+				fmt.Fprintf(tw, "  --%s\t%s\n", f.Name, f.Usage)
+			}
+			tw.Flush()
+		}
+		fmt.Fprintf(flags.Output(), "\nThe configuration flags are listed by %s serve --help\n", _NAME)
+	}
+
+	app.SetupCliLogger()
+	return app.LoadConfig(flags, args)
+}
+
+// exactArgs prints the usage of flags and returns errUsage unless args has n args
+func exactArgs(flags *flag.FlagSet, args []string, n int) error {
+	if len(args) != n {
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
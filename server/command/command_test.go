@@ -0,0 +1,56 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatch(t *testing.T) {
+	var ran string
+	var ranArgs []string
+	record := func(name string, args []string) error {
+		ran, ranArgs = name, args
+		return nil
+	}
+	cmds := []command{
+		{name: "serve", run: record},
+		{name: "user", subcommands: []command{{name: "create", run: record}}},
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantRan  string
+		wantArgs []string
+		wantErr  error
+	}{
+		{name: "Default command", args: nil, wantRan: "app serve"},
+		{name: "Flags go to the default command", args: []string{"--port", "1"}, wantRan: "app serve", wantArgs: []string{"--port", "1"}},
+		{name: "Subcommand", args: []string{"user", "create", "--role", "admin", "alice"}, wantRan: "app user create", wantArgs: []string{"--role", "admin", "alice"}},
+		{name: "Group without subcommand", args: []string{"user"}, wantErr: errUsage},
+		{name: "Unknown command", args: []string{"bogus"}, wantErr: errUsage},
+		{name: "Help", args: []string{"help"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran, ranArgs = "", nil
+
+			err := dispatch("app", cmds, tt.args, "serve")
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantRan, ran)
+			assert.Equal(t, tt.wantArgs, ranArgs)
+		})
+	}
+}
+
+func TestTemporaryPassword(t *testing.T) {
+	policy := auth.PasswordPolicy{MinLength: 32, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true}
+
+	password := temporaryPassword()
+	assert.NoError(t, policy.Validate(password))
+	assert.NotEqual(t, password, temporaryPassword())
+}
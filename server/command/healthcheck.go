@@ -0,0 +1,48 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+)
+
+// _HEALTHCHECK_TIMEOUT is longer than the timeout of a readiness check, so failed checks are reported
+const _HEALTHCHECK_TIMEOUT = 10 * time.Second
+
+// healthcheck requests a probe of the running server and fails unless it answers 200, it lets
+// container healthchecks run without curl in the image
+func healthcheck(name string, args []string) error {
+	flags := newFlagSet(name, "", "Requests the readiness probe of the server listening on the configured port\n"+
+		"and prints the response, it exits with 1 when the server isn't ready")
+	live := flags.Bool("live", false, "request the liveness probe, dependencies aren't checked")
+	args = loadConfig(flags, args)
+	if err := exactArgs(flags, args, 0); err != nil {
+		return err
+	}
+
+	path := app.ReadyPath
+	if *live {
+		path = app.HealthPath
+	}
+
+	client := http.Client{Timeout: _HEALTHCHECK_TIMEOUT}
+	res, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d%s", app.Port, path))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(body))
+	if res.StatusCode != http.StatusOK {
+		return errors.New(res.Status)
+	}
+	return nil
+}
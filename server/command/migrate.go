@@ -0,0 +1,106 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/migration"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// migrate applies, rolls back or lists migrations, it doesn't migrate on startup like the other commands
+func migrate(name string, args []string) error {
+	flags := newFlagSet(name, "up [version] | down [steps] | status",
+		"up applies pending migrations, up to version when set\n"+
+			"down rolls back the last steps applied migrations, default 1\n"+
+			"status lists migrations and when they were applied")
+	args = setup(flags, args, false)
+	if len(args) == 0 || len(args) > 2 {
+		flags.Usage()
+		return errUsage
+	}
+
+	var err error
+	app.Invoke(func(db *gorm.DB) {
+		migrator := migration.New(db, zap.S())
+		switch args[0] {
+		case "up":
+			err = migrateUp(migrator, args[1:])
+		case "down":
+			err = migrateDown(migrator, args[1:])
+		case "status":
+			err = migrateStatus(migrator)
+		default:
+			flags.Usage()
+			err = errUsage
+		}
+	})
+	return err
+}
+
+func migrateUp(migrator *migration.Migrator, args []string) error {
+	var target uint64
+	if len(args) == 1 {
+		var err error
+		if target, err = strconv.ParseUint(args[0], 10, 32); err != nil {
+			return fmt.Errorf("invalid version %s", args[0])
+		}
+	}
+
+	applied, err := migrator.Up(uint(target))
+	for _, migration := range applied {
+		fmt.Printf("Applied %d %s\n", migration.Version, migration.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Println("No pending migrations")
+	}
+	return nil
+}
+
+func migrateDown(migrator *migration.Migrator, args []string) error {
+	steps := 1
+	if len(args) == 1 {
+		var err error
+		if steps, err = strconv.Atoi(args[0]); err != nil || steps < 1 {
+			return fmt.Errorf("invalid steps %s", args[0])
+		}
+	}
+
+	rolledBack, err := migrator.Down(steps)
+	for _, migration := range rolledBack {
+		fmt.Printf("Rolled back %d %s\n", migration.Version, migration.Name)
+	}
+	return err
+}
+
+func migrateStatus(migrator *migration.Migrator) error {
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, status := range statuses {
+		applied := "pending"
+		if status.AppliedAt != nil {
+			applied = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, applied)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	// applied migrations this version doesn't know about aren't listed
+	return migrator.Check()
+}
@@ -0,0 +1,110 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/controller"
+	"github.com/killi1812/cloudflared-web-gui/service"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/seed"
+
+	"go.uber.org/zap"
+)
+
+// serve starts the web server
+func serve(name string, args []string) error {
+	// the configured logger needs the configuration, until then only problems are logged
+	app.SetupCliLogger()
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	if args = app.LoadConfig(flags, args); len(args) > 0 {
+		return fmt.Errorf("unexpected argument %s", args[0])
+	}
+	app.SetupLogger()
+	shutdownTracing := app.SetupTracing()
+	defer shutdownTracing()
+	app.SetupDb(true)
+
+	provideServices()
+
+	app.Invoke(func(keys service.IKeySrv, roles service.IRoleSrv, groups service.IGroupSrv) {
+		if err := keys.Load(); err != nil {
+			zap.S().Panicf("Failed to load signing keys, err = %+v", err)
+		}
+		if err := roles.Load(); err != nil {
+			zap.S().Panicf("Failed to load roles, err = %+v", err)
+		}
+		if err := groups.Load(); err != nil {
+			zap.S().Panicf("Failed to load groups, err = %+v", err)
+		}
+	})
+
+	app.Invoke(func(events service.ISecurityEventSrv) {
+		app.RegisterJob(func(ctx context.Context) {
+			service.KeepSecurityEvents(ctx, events, app.SecurityEventRetention)
+		})
+	})
+	app.Invoke(func(backups service.IBackupSrv) {
+		app.RegisterJob(func(ctx context.Context) {
+			service.KeepBackups(ctx, backups, app.BackupDir, app.BackupInterval, app.BackupKeep, app.BackupPassphrase)
+		})
+	})
+
+	app.Invoke(func(keys service.IKeySrv, roles service.IRoleSrv, groups service.IGroupSrv) {
+		app.RegisterJob(func(ctx context.Context) {
+			service.KeepReloading(ctx, app.AuthReloadInterval, keys.Reload)
+		})
+		app.RegisterJob(func(ctx context.Context) {
+			service.KeepReloading(ctx, app.AuthReloadInterval, roles.Reload)
+		})
+		app.RegisterJob(func(ctx context.Context) {
+			service.KeepReloading(ctx, app.AuthReloadInterval, groups.Reload)
+		})
+	})
+
+	if app.AuthMode == app.AuthModeCfAccess {
+		app.Invoke(service.SetupCfAccess)
+	}
+
+	app.RegisterController(controller.NewInfoCnt)
+	app.RegisterController(controller.NewHealthCtn)
+	app.RegisterController(controller.NewLogCtn)
+	app.RegisterController(controller.NewUserCtn)
+	app.RegisterController(controller.NewInvitationCtn)
+	app.RegisterController(controller.NewAuthCtn)
+	app.RegisterController(controller.NewRoleCtn)
+	app.RegisterController(controller.NewGroupCtn)
+	app.RegisterController(controller.NewTunnelCtn)
+
+	seed.Insert()
+
+	app.Start()
+	return nil
+}
+
+// provideServices loads the password policy and provides the logger and every service,
+// services are only created when a command invokes them
+func provideServices() {
+	if err := auth.LoadPasswordPolicy(); err != nil {
+		zap.S().Panicf("Failed to load password policy, err = %+v", err)
+	}
+
+	// Provide logger
+	app.Provide(zap.S)
+
+	app.Provide(service.NewSecurityEventSrv)
+	app.Provide(service.NewKeySrv)
+	app.Provide(service.NewRoleSrv)
+	app.Provide(service.NewGroupSrv)
+	app.Provide(service.NewUserCrudService)
+	app.Provide(service.NewAuthService)
+	app.Provide(service.NewInvitationSrv)
+	app.Provide(service.NewOidcSrv)
+	app.Provide(service.NewDnsSrv)
+	app.Provide(service.NewTunelSrv)
+	app.Provide(service.NewTunnelAccessSrv)
+	app.Provide(service.NewBackupSrv)
+	app.Provide(service.NewHealthSrv)
+}
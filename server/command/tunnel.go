@@ -0,0 +1,78 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/service"
+
+	"github.com/google/uuid"
+)
+
+func tunnelCommands() []command {
+	return []command{
+		{name: "list", description: "list tunnels and whether they are running", run: tunnelList},
+		{name: "start", description: "start a tunnel in the background", run: tunnelStart},
+		{name: "stop", description: "stop a running tunnel", run: tunnelStop},
+	}
+}
+
+func tunnelList(name string, args []string) error {
+	flags := newFlagSet(name, "", "Lists tunnels of the cloudflared account")
+	args = setup(flags, args, true)
+	if err := exactArgs(flags, args, 0); err != nil {
+		return err
+	}
+	provideServices()
+
+	var err error
+	app.Invoke(func(tunnels service.ITunnelSrv) {
+		var list []model.Tunnel
+		if list, err = tunnels.List(context.Background()); err != nil {
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tCONNECTIONS\tRUNNING")
+		for _, tunnel := range list {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%t\n", tunnel.Id, tunnel.Name, len(tunnel.Connections), tunnel.IsRunning)
+		}
+		err = w.Flush()
+	})
+	return err
+}
+
+func tunnelStart(name string, args []string) error {
+	return tunnelAction(name, args, "Starts cloudflared for a tunnel, it keeps running after the command exits",
+		"Started", service.ITunnelSrv.Start)
+}
+
+func tunnelStop(name string, args []string) error {
+	return tunnelAction(name, args, "Stops a tunnel started by the server or the tunnel start command",
+		"Stopped", service.ITunnelSrv.Stop)
+}
+
+// tunnelAction runs action on the tunnel with the id in args
+func tunnelAction(name string, args []string, description, done string, action func(service.ITunnelSrv, context.Context, uuid.UUID) error) error {
+	flags := newFlagSet(name, "<tunnel id>", description)
+	args = setup(flags, args, true)
+	if err := exactArgs(flags, args, 1); err != nil {
+		return err
+	}
+	id, err := uuid.Parse(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid tunnel id %s", args[0])
+	}
+	provideServices()
+
+	app.Invoke(func(tunnels service.ITunnelSrv) {
+		if err = action(tunnels, context.Background(), id); err == nil {
+			fmt.Printf("%s tunnel %s\n", done, id)
+		}
+	})
+	return err
+}
@@ -0,0 +1,213 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/service"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func userCommands() []command {
+	return []command{
+		{name: "create", description: "create a local user", run: userCreate},
+		{name: "list", description: "list users", run: userList},
+		{name: "reset-password", description: "set a new password and unlock the account", run: userResetPassword},
+		{name: "set-role", description: "change the role of a user", run: userSetRole},
+	}
+}
+
+// setupUsers sets up the command and loads roles, so custom roles are known
+func setupUsers(flags *flag.FlagSet, args []string) []string {
+	args = setup(flags, args, true)
+	provideServices()
+
+	app.Invoke(func(roles service.IRoleSrv) {
+		if err := roles.Load(); err != nil {
+			zap.S().Panicf("Failed to load roles, err = %+v", err)
+		}
+	})
+	return args
+}
+
+func userCreate(name string, args []string) error {
+	flags := newFlagSet(name, "<username>", "Creates a local user. Without --password-stdin a temporary password\n"+
+		"is generated and printed, the user has to change it after logging in")
+	role := flags.String("role", string(model.ROLE_USER), "role of the user")
+	email := flags.String("email", "", "email of the user")
+	passwordStdin := flags.Bool("password-stdin", false, "read the password from the first line of stdin")
+	args = setupUsers(flags, args)
+	if err := exactArgs(flags, args, 1); err != nil {
+		return err
+	}
+
+	userRole, err := auth.ParseRole(*role)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, *role)
+	}
+	password, generated, err := readPassword(*passwordStdin)
+	if err != nil {
+		return err
+	}
+
+	app.Invoke(func(users service.IUserCrudService) {
+		var user *model.User
+		user, err = users.Create(context.Background(), &model.User{
+			Uuid:               uuid.New(),
+			Username:           args[0],
+			Email:              *email,
+			Role:               userRole,
+			MustChangePassword: generated,
+		}, password)
+		if err != nil {
+			return
+		}
+
+		fmt.Printf("Created user %s, uuid = %s, role = %s\n", user.Username, user.Uuid, user.Role)
+		if generated {
+			fmt.Printf("Temporary password: %s\n", password)
+		}
+	})
+	return err
+}
+
+func userList(name string, args []string) error {
+	flags := newFlagSet(name, "", "Lists users ordered by username")
+	role := flags.String("role", "", "only list users with role")
+	search := flags.String("search", "", "only list users with username or email containing search")
+	deleted := flags.Bool("deleted", false, "list deleted users instead")
+	args = setupUsers(flags, args)
+	if err := exactArgs(flags, args, 0); err != nil {
+		return err
+	}
+
+	var err error
+	app.Invoke(func(users service.IUserCrudService) {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "UUID\tUSERNAME\tROLE\tEMAIL\tLOCKED")
+
+		filter := service.UserFilter{
+			Page:     1,
+			PageSize: 100,
+			Role:     model.UserRole(*role),
+			Search:   *search,
+			Deleted:  *deleted,
+		}
+		for {
+			var page []model.User
+			var total int64
+			page, total, err = users.List(context.Background(), filter)
+			if err != nil {
+				return
+			}
+			for _, user := range page {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\n", user.Uuid, user.Username, user.Role, user.Email, user.IsLocked(time.Now()))
+			}
+			if int64(filter.Page*filter.PageSize) >= total {
+				break
+			}
+			filter.Page++
+		}
+		err = w.Flush()
+	})
+	return err
+}
+
+func userResetPassword(name string, args []string) error {
+	flags := newFlagSet(name, "<username>", "Sets a new password, unlocks the account and ends the users session.\n"+
+		"Without --password-stdin a temporary password is generated and printed")
+	passwordStdin := flags.Bool("password-stdin", false, "read the password from the first line of stdin")
+	keep := flags.Bool("no-force-change", false, "don't make the user change the password after logging in")
+	args = setupUsers(flags, args)
+	if err := exactArgs(flags, args, 1); err != nil {
+		return err
+	}
+
+	password, generated, err := readPassword(*passwordStdin)
+	if err != nil {
+		return err
+	}
+
+	app.Invoke(func(users service.IUserCrudService) {
+		var user *model.User
+		if user, err = findUser(users, args[0]); err != nil {
+			return
+		}
+		if err = users.ResetPassword(context.Background(), user.Uuid, password, !*keep); err != nil {
+			return
+		}
+
+		fmt.Printf("Password of %s reset\n", user.Username)
+		if generated {
+			fmt.Printf("Temporary password: %s\n", password)
+		}
+	})
+	return err
+}
+
+func userSetRole(name string, args []string) error {
+	flags := newFlagSet(name, "<username> <role>", "Changes the role of a user")
+	args = setupUsers(flags, args)
+	if err := exactArgs(flags, args, 2); err != nil {
+		return err
+	}
+
+	role, err := auth.ParseRole(args[1])
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, args[1])
+	}
+
+	app.Invoke(func(users service.IUserCrudService) {
+		var user *model.User
+		if user, err = findUser(users, args[0]); err != nil {
+			return
+		}
+		if user, err = users.Update(context.Background(), user.Uuid, &model.User{Role: role}); err != nil {
+			return
+		}
+
+		fmt.Printf("Role of %s set to %s\n", user.Username, user.Role)
+	})
+	return err
+}
+
+// findUser returns the active user with username
+func findUser(users service.IUserCrudService, username string) (*model.User, error) {
+	user, err := users.ReadByUsername(context.Background(), username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("user %s not found", username)
+	}
+	return user, err
+}
+
+// readPassword reads the password from stdin, or generates one when fromStdin is false
+func readPassword(fromStdin bool) (string, bool, error) {
+	if !fromStdin {
+		return temporaryPassword(), true, nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", false, fmt.Errorf("reading password from stdin: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), false, nil
+}
+
+// temporaryPassword returns a random password, the suffix satisfies any character class policy
+func temporaryPassword() string {
+	return rand.Text() + strings.ToLower(rand.Text()) + "-1"
+}
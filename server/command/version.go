@@ -0,0 +1,21 @@
+package command
+
+import (
+	"fmt"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+)
+
+// version prints build time variables, it needs no configuration
+func version(name string, args []string) error {
+	if len(args) > 0 {
+		fmt.Printf("usage: %s\n", name)
+		return errUsage
+	}
+
+	fmt.Printf("%s %s\n", _NAME, app.Version)
+	fmt.Printf("commit:     %s\n", app.CommitHash)
+	fmt.Printf("built:      %s\n", app.BuildTimestamp)
+	fmt.Printf("build type: %s\n", app.Build)
+	return nil
+}
@@ -1,19 +1,31 @@
 package controller
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/killi1812/cloudflared-web-gui/app"
 	"github.com/killi1812/cloudflared-web-gui/dto"
+	"github.com/killi1812/cloudflared-web-gui/model"
 	"github.com/killi1812/cloudflared-web-gui/service"
 	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
+// _OIDC_LOGIN_COOKIE binds an oidc login to the browser that started it
+const _OIDC_LOGIN_COOKIE = "oidc_login"
+
 type AuthCtn struct {
 	auth   service.IAuthService
+	oidc   service.IOidcSrv
+	keys   service.IKeySrv
 	logger *zap.SugaredLogger
 }
 
@@ -21,10 +33,12 @@ func NewAuthCtn() app.Controller {
 	var controller *AuthCtn
 
 	// Use the mock service for testing
-	app.Invoke(func(loginService service.IAuthService, logger *zap.SugaredLogger) {
+	app.Invoke(func(loginService service.IAuthService, oidcService service.IOidcSrv, keySrv service.IKeySrv, logger *zap.SugaredLogger) {
 		// create controller
 		controller = &AuthCtn{
 			auth:   loginService,
+			oidc:   oidcService,
+			keys:   keySrv,
 			logger: logger,
 		}
 	})
@@ -38,8 +52,22 @@ func (ctn *AuthCtn) RegisterEndpoints(api *gin.RouterGroup) {
 
 	// register Endpoints
 	group.POST("/login", ctn.login)
-	group.POST("/refresh", auth.Protect(), ctn.refreshToken)
-	group.POST("/logout", ctn.logout)
+	// cloudflare access owns the session, there is no bearer token to refresh or revoke
+	if app.AuthMode != app.AuthModeCfAccess {
+		group.POST("/refresh", auth.AllowReadOnly(), auth.Protect(), ctn.refreshToken)
+		group.POST("/logout", ctn.logout)
+	}
+	group.POST("/keys/rotate", auth.Protect(), auth.RequirePermission(model.PERM_KEYS_ROTATE), ctn.rotateKeys)
+
+	if ctn.oidc.Enabled() {
+		group.GET("/oidc/login", ctn.oidcLogin)
+		group.GET("/oidc/callback", ctn.oidcCallback)
+	}
+}
+
+// RegisterRootEndpoints implements app.RootController.
+func (ctn *AuthCtn) RegisterRootEndpoints(root *gin.RouterGroup) {
+	root.GET("/.well-known/jwks.json", ctn.jwks)
 }
 
 // Login godoc
@@ -51,8 +79,16 @@ func (ctn *AuthCtn) RegisterEndpoints(api *gin.RouterGroup) {
 //	@Produce		json
 //	@Param			loginDto	body		dto.LoginDto	true	"Login credentials"
 //	@Success		200			{object}	dto.TokenDto
+//	@Failure		401
+//	@Failure		403			"Local login is disabled in Cloudflare Access auth mode"
+//	@Failure		429			"Too many attempts or account locked, see Retry-After header"
 //	@Router			/auth/login [post]
 func (ctn *AuthCtn) login(c *gin.Context) {
+	if app.AuthMode != app.AuthModeLocal {
+		c.JSON(http.StatusForbidden, cerror.ErrLocalLoginDisabled.Error())
+		return
+	}
+
 	var loginDto dto.LoginDto
 
 	if err := c.BindJSON(&loginDto); err != nil {
@@ -60,9 +96,16 @@ func (ctn *AuthCtn) login(c *gin.Context) {
 		return
 	}
 
-	accessToken, err := ctn.auth.Login(loginDto.Username, loginDto.Password)
+	accessToken, err := ctn.auth.Login(c.Request.Context(), loginDto.Username, loginDto.Password, clientInfo(c))
 	if err != nil {
 		ctn.logger.Errorf("Login failed err = %+v", err)
+
+		var retryErr *cerror.RetryError
+		if errors.As(err, &retryErr) {
+			abortWithRetry(c, retryErr)
+			return
+		}
+
 		c.JSON(http.StatusUnauthorized, err.Error())
 		return
 	}
@@ -82,7 +125,7 @@ func (ctn *AuthCtn) login(c *gin.Context) {
 //	@Router			/auth/refresh [post]
 func (ctn *AuthCtn) refreshToken(c *gin.Context) {
 	tokenStr := c.Request.Header.Get("Authorization")
-	token, err := ctn.auth.RefreshTokens(tokenStr)
+	token, err := ctn.auth.RefreshTokens(c.Request.Context(), tokenStr, clientInfo(c))
 	if err != nil {
 		ctn.logger.Errorf("Refresh failed err = %w", err)
 		c.JSON(http.StatusInternalServerError, err.Error())
@@ -110,7 +153,7 @@ func (ctn *AuthCtn) logout(c *gin.Context) {
 		return
 	}
 
-	err = ctn.auth.Logout(claims.ID)
+	err = ctn.auth.Logout(c.Request.Context(), claims.ID, clientInfo(c))
 	if err != nil {
 		ctn.logger.Errorf("Logout failed err = %w", err)
 		c.JSON(http.StatusInternalServerError, err.Error())
@@ -119,3 +162,131 @@ func (ctn *AuthCtn) logout(c *gin.Context) {
 
 	c.AbortWithStatus(http.StatusOK)
 }
+
+// jwks godoc
+//
+//	@Summary		JSON Web Key Set
+//	@Description	Public keys access tokens are signed with, empty unless JWT_ALGORITHM is EdDSA
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{object}	auth.Jwks
+//	@Router			/.well-known/jwks.json [get]
+func (ctn *AuthCtn) jwks(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, auth.PublicJwks())
+}
+
+// rotateKeys godoc
+//
+//	@Summary		Rotate signing keys
+//	@Description	Creates new access and refresh signing keys, tokens signed with the previous keys stay valid until they expire
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{object}	dto.KeyRotationDto
+//	@Failure		500
+//	@Router			/auth/keys/rotate [post]
+func (ctn *AuthCtn) rotateKeys(c *gin.Context) {
+	keys, err := ctn.keys.Rotate(c.Request.Context())
+	if err != nil {
+		ctn.logger.Errorf("Failed to rotate signing keys err = %+v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, new(dto.KeyRotationDto).FromModel(keys, time.Now().Add(app.KeyRetiredTtl)))
+}
+
+// oidcLogin godoc
+//
+//	@Summary		OpenID Connect login
+//	@Description	Redirects the browser to the identity provider
+//	@Tags			auth
+//	@Success		302
+//	@Failure		500
+//	@Router			/auth/oidc/login [get]
+func (ctn *AuthCtn) oidcLogin(c *gin.Context) {
+	redirectUrl, loginCookie, err := ctn.oidc.AuthCodeURL(c.Request.Context())
+	if err != nil {
+		ctn.logger.Errorf("Failed to start oidc login err = %+v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	setOidcLoginCookie(c, loginCookie, int(service.OidcLoginDuration.Seconds()))
+	c.Redirect(http.StatusFound, redirectUrl)
+}
+
+// oidcCallback godoc
+//
+//	@Summary		OpenID Connect callback
+//	@Description	Finishes the identity provider login and returns an access token, or redirects to the client with the token in the url fragment
+//	@Tags			auth
+//	@Produce		json
+//	@Param			state	query		string	true	"login state"
+//	@Param			code	query		string	true	"authorization code"
+//	@Success		200		{object}	dto.TokenDto
+//	@Success		302
+//	@Failure		400
+//	@Failure		401
+//	@Router			/auth/oidc/callback [get]
+func (ctn *AuthCtn) oidcCallback(c *gin.Context) {
+	if errMsg := c.Query("error"); errMsg != "" {
+		ctn.logger.Infof("Identity provider returned error = %s, description = %s", errMsg, c.Query("error_description"))
+		c.JSON(http.StatusUnauthorized, errMsg)
+		return
+	}
+
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" || code == "" {
+		c.JSON(http.StatusBadRequest, "Missing state or code")
+		return
+	}
+
+	// the login cookie is single use, a missing one fails the state check
+	loginCookie, _ := c.Cookie(_OIDC_LOGIN_COOKIE)
+	setOidcLoginCookie(c, "", -1)
+
+	accessToken, err := ctn.oidc.Exchange(c.Request.Context(), loginCookie, state, code, clientInfo(c))
+	if err != nil {
+		ctn.logger.Errorf("Oidc login failed err = %+v", err)
+		c.JSON(http.StatusUnauthorized, "OpenID Connect login failed")
+		return
+	}
+
+	if app.OidcClientRedirect != "" {
+		fragment := url.Values{"accessToken": {accessToken}}
+		c.Redirect(http.StatusFound, app.OidcClientRedirect+"#"+fragment.Encode())
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.TokenDto{
+		AccessToken: accessToken,
+	})
+}
+
+// setOidcLoginCookie sets the login cookie for the callback path only, it is sent on the top level
+// redirect back from the identity provider but not on cross site requests
+func setOidcLoginCookie(c *gin.Context, value string, maxAge int) {
+	path, secure := "/", false
+	if callback, err := url.Parse(app.OidcRedirectUrl); err == nil && callback.Path != "" {
+		path, secure = callback.Path, callback.Scheme == "https"
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(_OIDC_LOGIN_COOKIE, value, maxAge, path, "", secure, true)
+}
+
+// clientInfo returns information about the caller used for rate limiting and security events
+func clientInfo(c *gin.Context) service.ClientInfo {
+	return service.ClientInfo{
+		Ip:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
+// abortWithRetry responds with 429 and a Retry-After header in whole seconds
+func abortWithRetry(c *gin.Context, err *cerror.RetryError) {
+	seconds := int(math.Ceil(err.RetryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(max(seconds, 1)))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, err.Error())
+}
@@ -0,0 +1,308 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/dto"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/service"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type GroupCtn struct {
+	groups service.IGroupSrv
+	logger *zap.SugaredLogger
+}
+
+func NewGroupCtn() app.Controller {
+	var controller *GroupCtn
+	app.Invoke(func(groupSrv service.IGroupSrv, logger *zap.SugaredLogger) {
+		controller = &GroupCtn{
+			groups: groupSrv,
+			logger: logger,
+		}
+	})
+	return controller
+}
+
+func (ctn *GroupCtn) RegisterEndpoints(api *gin.RouterGroup) {
+	group := api.Group("/group", auth.Protect())
+
+	group.GET("", ctn.list)
+	group.GET("/:uuid", ctn.get)
+
+	group.POST("", auth.RequirePermission(model.PERM_GROUP_MANAGE), ctn.create)
+	group.PUT("/:uuid", auth.RequirePermission(model.PERM_GROUP_MANAGE), ctn.update)
+	group.DELETE("/:uuid", auth.RequirePermission(model.PERM_GROUP_MANAGE), ctn.delete)
+	group.PUT("/:uuid/members/:userUuid", auth.RequirePermission(model.PERM_GROUP_MANAGE), ctn.addMember)
+	group.DELETE("/:uuid/members/:userUuid", auth.RequirePermission(model.PERM_GROUP_MANAGE), ctn.removeMember)
+}
+
+// list godoc
+//
+//	@Summary		List groups
+//	@Description	returns all groups with their roles and members
+//	@Tags			group
+//	@Produce		json
+//	@Success		200	{object}	[]dto.GroupDto
+//	@Failure		500
+//	@Router			/group [get]
+func (ctn *GroupCtn) list(c *gin.Context) {
+	groups, err := ctn.groups.List(c.Request.Context())
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	dtos := make([]dto.GroupDto, 0, len(groups))
+	for i := range groups {
+		dtos = append(dtos, dto.GroupDto{}.FromModel(&groups[i]))
+	}
+	c.JSON(http.StatusOK, dtos)
+}
+
+// get godoc
+//
+//	@Summary		Get a group
+//	@Tags			group
+//	@Produce		json
+//	@Param			uuid	path		string	true	"group uuid"
+//	@Success		200		{object}	dto.GroupDto
+//	@Failure		400
+//	@Failure		404
+//	@Failure		500
+//	@Router			/group/{uuid} [get]
+func (ctn *GroupCtn) get(c *gin.Context) {
+	groupUuid, ok := ctn.parseUuid(c, "uuid")
+	if !ok {
+		return
+	}
+
+	group, err := ctn.groups.Read(c.Request.Context(), groupUuid)
+	if err != nil {
+		ctn.abortWithGroupError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.GroupDto{}.FromModel(group))
+}
+
+// create godoc
+//
+//	@Summary		Create a group
+//	@Description	members of a group get the permissions of all its roles in addition to their own role
+//	@Tags			group
+//	@Accept			json
+//	@Produce		json
+//	@Param			model	body		dto.NewGroupDto	true	"group"
+//	@Success		201		{object}	dto.GroupDto
+//	@Failure		400
+//	@Failure		403		"Group grants roles the caller can't grant"
+//	@Failure		409		"Group already exists"
+//	@Failure		500
+//	@Router			/group [post]
+func (ctn *GroupCtn) create(c *gin.Context) {
+	var req dto.NewGroupDto
+	if err := c.BindJSON(&req); err != nil {
+		ctn.logger.Errorf("Failed to bind error = %+v", err)
+		return
+	}
+
+	group := req.ToModel()
+	if !ctn.canGrant(c, group.RoleList()) {
+		return
+	}
+
+	group, err := ctn.groups.Create(c.Request.Context(), group)
+	if err != nil {
+		ctn.abortWithGroupError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.GroupDto{}.FromModel(group))
+}
+
+// update godoc
+//
+//	@Summary		Update a group
+//	@Description	replaces description and roles of a group
+//	@Tags			group
+//	@Accept			json
+//	@Produce		json
+//	@Param			uuid	path		string				true	"group uuid"
+//	@Param			model	body		dto.UpdateGroupDto	true	"group"
+//	@Success		200		{object}	dto.GroupDto
+//	@Failure		400
+//	@Failure		403
+//	@Failure		404
+//	@Failure		500
+//	@Router			/group/{uuid} [put]
+func (ctn *GroupCtn) update(c *gin.Context) {
+	groupUuid, ok := ctn.parseUuid(c, "uuid")
+	if !ok {
+		return
+	}
+
+	var req dto.UpdateGroupDto
+	if err := c.BindJSON(&req); err != nil {
+		ctn.logger.Errorf("Failed to bind error = %+v", err)
+		return
+	}
+
+	roles := dto.ToRoles(req.Roles)
+	if !ctn.canManage(c, groupUuid) || !ctn.canGrant(c, roles) {
+		return
+	}
+
+	group, err := ctn.groups.Update(c.Request.Context(), groupUuid, req.Description, roles)
+	if err != nil {
+		ctn.abortWithGroupError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.GroupDto{}.FromModel(group))
+}
+
+// delete godoc
+//
+//	@Summary		Delete a group
+//	@Description	deletes the group, its memberships and tunnel access of the group
+//	@Tags			group
+//	@Param			uuid	path	string	true	"group uuid"
+//	@Success		204
+//	@Failure		400
+//	@Failure		403
+//	@Failure		404
+//	@Failure		500
+//	@Router			/group/{uuid} [delete]
+func (ctn *GroupCtn) delete(c *gin.Context) {
+	groupUuid, ok := ctn.parseUuid(c, "uuid")
+	if !ok || !ctn.canManage(c, groupUuid) {
+		return
+	}
+
+	if err := ctn.groups.Delete(c.Request.Context(), groupUuid); err != nil {
+		ctn.abortWithGroupError(c, err)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+// addMember godoc
+//
+//	@Summary		Add a group member
+//	@Description	the user gets the roles of the group once its access token is refreshed
+//	@Tags			group
+//	@Param			uuid		path	string	true	"group uuid"
+//	@Param			userUuid	path	string	true	"user uuid"
+//	@Success		204
+//	@Failure		400
+//	@Failure		403
+//	@Failure		404
+//	@Failure		500
+//	@Router			/group/{uuid}/members/{userUuid} [put]
+func (ctn *GroupCtn) addMember(c *gin.Context) {
+	groupUuid, ok := ctn.parseUuid(c, "uuid")
+	if !ok {
+		return
+	}
+	userUuid, ok := ctn.parseUuid(c, "userUuid")
+	if !ok || !ctn.canManage(c, groupUuid) {
+		return
+	}
+
+	if err := ctn.groups.AddMember(c.Request.Context(), groupUuid, userUuid); err != nil {
+		ctn.abortWithGroupError(c, err)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+// removeMember godoc
+//
+//	@Summary		Remove a group member
+//	@Tags			group
+//	@Param			uuid		path	string	true	"group uuid"
+//	@Param			userUuid	path	string	true	"user uuid"
+//	@Success		204
+//	@Failure		400
+//	@Failure		403
+//	@Failure		404
+//	@Failure		500
+//	@Router			/group/{uuid}/members/{userUuid} [delete]
+func (ctn *GroupCtn) removeMember(c *gin.Context) {
+	groupUuid, ok := ctn.parseUuid(c, "uuid")
+	if !ok {
+		return
+	}
+	userUuid, ok := ctn.parseUuid(c, "userUuid")
+	if !ok || !ctn.canManage(c, groupUuid) {
+		return
+	}
+
+	if err := ctn.groups.RemoveMember(c.Request.Context(), groupUuid, userUuid); err != nil {
+		ctn.abortWithGroupError(c, err)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+func (ctn *GroupCtn) parseUuid(c *gin.Context, param string) (uuid.UUID, bool) {
+	parsed, err := uuid.Parse(c.Param(param))
+	if err != nil {
+		ctn.logger.Errorf("error parsing uuid value = %s", c.Param(param))
+		c.AbortWithError(http.StatusBadRequest, err)
+		return uuid.Nil, false
+	}
+	return parsed, true
+}
+
+// canGrant aborts the request unless the caller could grant every role,
+// so a group manager can't create a group more privileged than their own role
+func (ctn *GroupCtn) canGrant(c *gin.Context, roles []model.UserRole) bool {
+	claims, _ := auth.GetClaims(c)
+	for _, role := range roles {
+		if !claims.CanGrant(role) {
+			ctn.logger.Infof("User = %s with role = %s can't grant role = %s", claims.ID, claims.Role, role)
+			c.AbortWithStatusJSON(http.StatusForbidden, cerror.ErrBadRole.Error())
+			return false
+		}
+	}
+	return true
+}
+
+// canManage aborts the request unless the caller could grant the current roles of the group,
+// so nobody can join or change a group more privileged than their own role
+func (ctn *GroupCtn) canManage(c *gin.Context, groupUuid uuid.UUID) bool {
+	group, err := ctn.groups.Read(c.Request.Context(), groupUuid)
+	if err != nil {
+		ctn.abortWithGroupError(c, err)
+		return false
+	}
+	return ctn.canGrant(c, group.RoleList())
+}
+
+func (ctn *GroupCtn) abortWithGroupError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, cerror.ErrInvalidGroupName), errors.Is(err, cerror.ErrUnknownRole):
+		c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+	case errors.Is(err, cerror.ErrGroupExists):
+		c.AbortWithStatusJSON(http.StatusConflict, err.Error())
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.AbortWithError(http.StatusNotFound, err)
+	default:
+		ctn.logger.Errorf("Group request failed, err = %+v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/dto"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/service"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type HealthCtn struct {
+	logger *zap.SugaredLogger
+	health service.IHealthSrv
+}
+
+// NewHealthCtn creates a new controller for liveness and readiness probes.
+func NewHealthCtn() app.Controller {
+	var controller *HealthCtn
+	app.Invoke(func(logger *zap.SugaredLogger, health service.IHealthSrv) {
+		controller = &HealthCtn{
+			logger: logger,
+			health: health,
+		}
+	})
+	return controller
+}
+
+// RegisterEndpoints implements app.Controller, probes are served outside of /api,
+// the readiness report with check messages is only served to authenticated users
+func (ctn *HealthCtn) RegisterEndpoints(router *gin.RouterGroup) {
+	router.GET("/health", auth.Protect(), auth.RequirePermission(model.PERM_CONFIG_READ), ctn.report)
+}
+
+// RegisterRootEndpoints implements app.RootController.
+func (ctn *HealthCtn) RegisterRootEndpoints(root *gin.RouterGroup) {
+	root.GET(app.HealthPath, ctn.healthz)
+	root.GET(app.ReadyPath, ctn.readyz)
+}
+
+// healthz godoc
+//
+//	@Summary		Liveness probe
+//	@Description	returns 200 while the server handles requests, dependencies aren't checked so a broken dependency doesn't restart the server
+//	@Tags			health
+//	@Produce		json
+//	@Success		200	{object}	dto.HealthDto
+//	@Router			/healthz [get]
+func (ctn *HealthCtn) healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, dto.HealthDto{Status: string(model.HEALTH_OK)})
+}
+
+// readyz godoc
+//
+//	@Summary		Readiness probe
+//	@Description	checks the database, the cloudflared binary, the config directory, the origin certificate, tunnel credentials and the Cloudflare API
+//	@Tags			health
+//	@Produce		json
+//	@Success		200	{object}	dto.HealthDto	"Every check passed"
+//	@Failure		503	{object}	dto.HealthDto	"A check failed, see checks"
+//	@Router			/readyz [get]
+func (ctn *HealthCtn) readyz(c *gin.Context) {
+	status, result := ctn.ready(c)
+	c.JSON(status, result.Public())
+}
+
+// report godoc
+//
+//	@Summary		Readiness report
+//	@Description	runs the readiness checks like /readyz and includes their messages
+//	@Tags			health
+//	@Produce		json
+//	@Success		200	{object}	dto.HealthDto	"Every check passed"
+//	@Failure		503	{object}	dto.HealthDto	"A check failed, see checks"
+//	@Router			/health [get]
+func (ctn *HealthCtn) report(c *gin.Context) {
+	status, result := ctn.ready(c)
+	c.JSON(status, result)
+}
+
+// ready runs the readiness checks and returns the report with the status code it should be served with
+func (ctn *HealthCtn) ready(c *gin.Context) (int, *dto.HealthDto) {
+	report := ctn.health.Ready(c.Request.Context())
+
+	status := http.StatusOK
+	if report.Status != model.HEALTH_OK {
+		status = http.StatusServiceUnavailable
+	}
+	return status, new(dto.HealthDto).FromModel(report)
+}
@@ -5,6 +5,8 @@ import (
 
 	"github.com/killi1812/cloudflared-web-gui/app"
 	"github.com/killi1812/cloudflared-web-gui/dto"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -28,6 +30,7 @@ func NewInfoCnt() app.Controller {
 // RegisterEndpoints registers the image manipulation endpoints.
 func (cnt *InfoCtn) RegisterEndpoints(router *gin.RouterGroup) {
 	router.GET("/info", cnt.getServerInfo)
+	router.GET("/info/config", auth.Protect(), auth.RequirePermission(model.PERM_CONFIG_READ), cnt.getConfig)
 }
 
 // getServerInfo godoc
@@ -44,6 +47,21 @@ func (ctn *InfoCtn) getServerInfo(c *gin.Context) {
 		Version:        app.Version,
 		CommitHash:     app.CommitHash,
 		BuildTimestamp: app.BuildTimestamp,
+		AuthMode:       app.AuthMode,
 	}
 	c.AbortWithStatusJSON(http.StatusOK, serverInfo)
 }
+
+// getConfig godoc
+//
+//	@Summary		Get server config
+//	@Description	return the loaded configuration keyed like the config file, secret values are redacted
+//	@Tags			info
+//	@Produce		json
+//	@Success		200	{object}	map[string]any	"Redacted configuration"
+//	@Failure		401
+//	@Failure		403
+//	@Router			/info/config [get]
+func (ctn *InfoCtn) getConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, app.Cfg.Redacted())
+}
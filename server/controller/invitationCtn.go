@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/dto"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/service"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type InvitationCtn struct {
+	invitations service.IInvitationSrv
+	auth        service.IAuthService
+	logger      *zap.SugaredLogger
+}
+
+func NewInvitationCtn() app.Controller {
+	var controller *InvitationCtn
+	app.Invoke(func(invitationSrv service.IInvitationSrv, authSrv service.IAuthService, logger *zap.SugaredLogger) {
+		controller = &InvitationCtn{
+			invitations: invitationSrv,
+			auth:        authSrv,
+			logger:      logger,
+		}
+	})
+	return controller
+}
+
+func (ctn *InvitationCtn) RegisterEndpoints(api *gin.RouterGroup) {
+	group := api.Group("/invitation")
+
+	// accepting is done by the invitee who has no account yet
+	group.POST("/accept", ctn.accept)
+
+	manage := group.Group("", auth.Protect(), auth.RequirePermission(model.PERM_USER_MANAGE))
+	manage.GET("", ctn.list)
+	manage.POST("", ctn.create)
+	manage.DELETE("/:uuid", ctn.revoke)
+}
+
+// list godoc
+//
+//	@Summary		List pending invitations
+//	@Description	returns invitations that weren't accepted, revoked or expired
+//	@Tags			invitation
+//	@Produce		json
+//	@Success		200	{object}	[]dto.InvitationDto
+//	@Failure		500
+//	@Router			/invitation [get]
+func (ctn *InvitationCtn) list(c *gin.Context) {
+	invitations, err := ctn.invitations.ListPending(c.Request.Context())
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	dtos := make([]dto.InvitationDto, 0, len(invitations))
+	for i := range invitations {
+		dtos = append(dtos, dto.InvitationDto{}.FromModel(&invitations[i]))
+	}
+	c.JSON(http.StatusOK, dtos)
+}
+
+// create godoc
+//
+//	@Summary		Invite a user
+//	@Description	returns a single-use signed link, the invitee chooses their own username and password
+//	@Tags			invitation
+//	@Accept			json
+//	@Produce		json
+//	@Param			model	body		dto.NewInvitationDto	true	"invitation"
+//	@Success		201		{object}	dto.InvitationDto
+//	@Failure		400
+//	@Failure		403		"Role can't be granted by the caller or local login is disabled"
+//	@Failure		500
+//	@Router			/invitation [post]
+func (ctn *InvitationCtn) create(c *gin.Context) {
+	if app.AuthMode != app.AuthModeLocal {
+		c.AbortWithStatusJSON(http.StatusForbidden, cerror.ErrLocalLoginDisabled.Error())
+		return
+	}
+
+	var req dto.NewInvitationDto
+	if err := c.BindJSON(&req); err != nil {
+		ctn.logger.Errorf("Failed to bind error = %+v", err)
+		return
+	}
+
+	invitation := req.ToModel()
+	claims, _ := auth.GetClaims(c)
+	if !claims.CanGrant(invitation.Role) {
+		ctn.logger.Infof("User = %s with role = %s can't grant role = %s", claims.ID, claims.Role, invitation.Role)
+		c.AbortWithStatusJSON(http.StatusForbidden, cerror.ErrBadRole.Error())
+		return
+	}
+
+	createdBy, err := uuid.Parse(claims.ID)
+	if err != nil {
+		c.AbortWithError(http.StatusUnauthorized, err)
+		return
+	}
+	invitation.CreatedByUuid = createdBy
+
+	invitation, token, err := ctn.invitations.Create(c.Request.Context(), invitation, clientInfo(c))
+	if err != nil {
+		ctn.abortWithInvitationError(c, err)
+		return
+	}
+
+	resp := dto.InvitationDto{}.FromModel(invitation)
+	resp.Token = token
+	resp.Link = service.InviteLink(token)
+	c.JSON(http.StatusCreated, resp)
+}
+
+// revoke godoc
+//
+//	@Summary		Revoke a pending invitation
+//	@Tags			invitation
+//	@Param			uuid	path	string	true	"invitation uuid"
+//	@Success		204
+//	@Failure		400
+//	@Failure		404
+//	@Failure		410	"Invitation was already accepted, revoked or has expired"
+//	@Failure		500
+//	@Router			/invitation/{uuid} [delete]
+func (ctn *InvitationCtn) revoke(c *gin.Context) {
+	invitationUuid, err := uuid.Parse(c.Param("uuid"))
+	if err != nil {
+		ctn.logger.Errorf("error parsing uuid value = %s", c.Param("uuid"))
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	claims, _ := auth.GetClaims(c)
+	by, _ := uuid.Parse(claims.ID)
+	if err := ctn.invitations.Revoke(c.Request.Context(), invitationUuid, by, clientInfo(c)); err != nil {
+		ctn.abortWithInvitationError(c, err)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+// accept godoc
+//
+//	@Summary		Accept an invitation
+//	@Description	creates the invited user with the chosen username and password and logs them in
+//	@Tags			invitation
+//	@Accept			json
+//	@Produce		json
+//	@Param			model	body		dto.AcceptInvitationDto	true	"token from the invite link and new credentials"
+//	@Success		201		{object}	dto.TokenDto
+//	@Failure		400		"Invalid link, weak password or bad request"
+//	@Failure		403		"Local login is disabled"
+//	@Failure		409		"Username is taken"
+//	@Failure		410		"Invitation was already accepted, revoked or has expired"
+//	@Failure		500
+//	@Router			/invitation/accept [post]
+func (ctn *InvitationCtn) accept(c *gin.Context) {
+	if app.AuthMode != app.AuthModeLocal {
+		c.AbortWithStatusJSON(http.StatusForbidden, cerror.ErrLocalLoginDisabled.Error())
+		return
+	}
+
+	var req dto.AcceptInvitationDto
+	if err := c.BindJSON(&req); err != nil {
+		ctn.logger.Errorf("Failed to bind error = %+v", err)
+		return
+	}
+
+	user, err := ctn.invitations.Accept(c.Request.Context(), req.Token, req.Username, req.Password, clientInfo(c))
+	if err != nil {
+		ctn.abortWithInvitationError(c, err)
+		return
+	}
+
+	accessToken, err := ctn.auth.CreateSession(c.Request.Context(), user)
+	if err != nil {
+		ctn.logger.Errorf("Failed to log in invited user = %s, err = %+v", user.Uuid, err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.TokenDto{
+		AccessToken: accessToken,
+	})
+}
+
+func (ctn *InvitationCtn) abortWithInvitationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, cerror.ErrInvalidInvitation), errors.Is(err, cerror.ErrInvalidInviteExpiry),
+		errors.Is(err, cerror.ErrUnknownRole), errors.Is(err, cerror.ErrWeakPassword):
+		c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+	case errors.Is(err, cerror.ErrUserExists):
+		c.AbortWithStatusJSON(http.StatusConflict, err.Error())
+	case errors.Is(err, cerror.ErrInvitationNotPending):
+		c.AbortWithStatusJSON(http.StatusGone, err.Error())
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.AbortWithError(http.StatusNotFound, err)
+	default:
+		ctn.logger.Errorf("Invitation request failed, err = %+v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+	}
+}
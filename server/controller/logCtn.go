@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/dto"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/logging"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type LogCtn struct {
+	logger *zap.SugaredLogger
+}
+
+// NewLogCtn creates a new controller for the server logger.
+func NewLogCtn() app.Controller {
+	var controller *LogCtn
+	app.Invoke(func(logger *zap.SugaredLogger) {
+		controller = &LogCtn{
+			logger: logger,
+		}
+	})
+	return controller
+}
+
+func (ctn *LogCtn) RegisterEndpoints(api *gin.RouterGroup) {
+	group := api.Group("/log", auth.Protect(), auth.RequirePermission(model.PERM_LOG_MANAGE))
+
+	group.GET("/level", ctn.getLevel)
+	group.PUT("/level", ctn.setLevel)
+}
+
+// getLevel godoc
+//
+//	@Summary		Get the log level
+//	@Description	returns the level of the server logger
+//	@Tags			log
+//	@Produce		json
+//	@Success		200	{object}	dto.LogLevelDto
+//	@Failure		401
+//	@Failure		403
+//	@Router			/log/level [get]
+func (ctn *LogCtn) getLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, dto.LogLevelDto{Level: app.LogLevel.Level().String()})
+}
+
+// setLevel godoc
+//
+//	@Summary		Set the log level
+//	@Description	changes the level of the server logger until the next restart, the gorm log level isn't changed
+//	@Tags			log
+//	@Accept			json
+//	@Produce		json
+//	@Param			model	body		dto.LogLevelDto	true	"debug, info, warn or error"
+//	@Success		200		{object}	dto.LogLevelDto
+//	@Failure		400
+//	@Failure		401
+//	@Failure		403
+//	@Router			/log/level [put]
+func (ctn *LogCtn) setLevel(c *gin.Context) {
+	var req dto.LogLevelDto
+	if err := c.BindJSON(&req); err != nil {
+		ctn.logger.Errorf("Failed to bind error = %+v", err)
+		return
+	}
+
+	level, err := zapcore.ParseLevel(req.Level)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	// logged before the change so raising the level doesn't hide it
+	logging.Logger(c.Request.Context(), ctn.logger).Warnf("Changing log level from %s to %s", app.LogLevel.Level(), level)
+	app.LogLevel.SetLevel(level)
+
+	c.JSON(http.StatusOK, dto.LogLevelDto{Level: level.String()})
+}
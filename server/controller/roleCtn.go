@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/dto"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/service"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type RoleCtn struct {
+	roles  service.IRoleSrv
+	logger *zap.SugaredLogger
+}
+
+func NewRoleCtn() app.Controller {
+	var controller *RoleCtn
+	app.Invoke(func(roleSrv service.IRoleSrv, logger *zap.SugaredLogger) {
+		controller = &RoleCtn{
+			roles:  roleSrv,
+			logger: logger,
+		}
+	})
+	return controller
+}
+
+func (ctn *RoleCtn) RegisterEndpoints(api *gin.RouterGroup) {
+	group := api.Group("/role", auth.Protect())
+
+	group.GET("", ctn.list)
+	group.GET("/permissions", ctn.permissions)
+
+	group.POST("", auth.RequirePermission(model.PERM_ROLE_MANAGE), ctn.create)
+	group.PUT("/:name", auth.RequirePermission(model.PERM_ROLE_MANAGE), ctn.update)
+	group.DELETE("/:name", auth.RequirePermission(model.PERM_ROLE_MANAGE), ctn.delete)
+}
+
+// list godoc
+//
+//	@Summary		List roles
+//	@Description	returns all roles with their permissions
+//	@Tags			role
+//	@Produce		json
+//	@Success		200	{object}	[]dto.RoleDto
+//	@Failure		500
+//	@Router			/role [get]
+func (ctn *RoleCtn) list(c *gin.Context) {
+	roles, err := ctn.roles.List(c.Request.Context())
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	dtos := make([]dto.RoleDto, 0, len(roles))
+	for i := range roles {
+		dtos = append(dtos, dto.RoleDto{}.FromModel(&roles[i]))
+	}
+	c.JSON(http.StatusOK, dtos)
+}
+
+// permissions godoc
+//
+//	@Summary		List permissions
+//	@Description	returns every permission that can be assigned to a role
+//	@Tags			role
+//	@Produce		json
+//	@Success		200	{object}	[]string
+//	@Router			/role/permissions [get]
+func (ctn *RoleCtn) permissions(c *gin.Context) {
+	c.JSON(http.StatusOK, model.ALL_PERMISSIONS)
+}
+
+// create godoc
+//
+//	@Summary		Create a custom role
+//	@Tags			role
+//	@Accept			json
+//	@Produce		json
+//	@Param			model	body		dto.NewRoleDto	true	"role"
+//	@Success		201		{object}	dto.RoleDto
+//	@Failure		400
+//	@Failure		403		"Role grants permissions the caller doesn't have"
+//	@Failure		409		"Role already exists"
+//	@Failure		500
+//	@Router			/role [post]
+func (ctn *RoleCtn) create(c *gin.Context) {
+	var req dto.NewRoleDto
+	if err := c.BindJSON(&req); err != nil {
+		ctn.logger.Errorf("Failed to bind error = %+v", err)
+		return
+	}
+
+	role := req.ToModel()
+	if !ctn.canGrant(c, role.PermissionList()) {
+		return
+	}
+
+	role, err := ctn.roles.Create(c.Request.Context(), role)
+	if err != nil {
+		ctn.abortWithRoleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.RoleDto{}.FromModel(role))
+}
+
+// update godoc
+//
+//	@Summary		Update a role
+//	@Description	replaces description and permissions of a role, the superadmin role can't be changed
+//	@Tags			role
+//	@Accept			json
+//	@Produce		json
+//	@Param			name	path		string				true	"role name"
+//	@Param			model	body		dto.UpdateRoleDto	true	"role"
+//	@Success		200		{object}	dto.RoleDto
+//	@Failure		400
+//	@Failure		403
+//	@Failure		404
+//	@Failure		500
+//	@Router			/role/{name} [put]
+func (ctn *RoleCtn) update(c *gin.Context) {
+	var req dto.UpdateRoleDto
+	if err := c.BindJSON(&req); err != nil {
+		ctn.logger.Errorf("Failed to bind error = %+v", err)
+		return
+	}
+
+	permissions := dto.ToPermissions(req.Permissions)
+	if !ctn.canGrant(c, permissions) {
+		return
+	}
+
+	role, err := ctn.roles.Update(c.Request.Context(), model.UserRole(c.Param("name")), req.Description, permissions)
+	if err != nil {
+		ctn.abortWithRoleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.RoleDto{}.FromModel(role))
+}
+
+// delete godoc
+//
+//	@Summary		Delete a custom role
+//	@Tags			role
+//	@Param			name	path	string	true	"role name"
+//	@Success		204
+//	@Failure		403	"Builtin roles can't be deleted"
+//	@Failure		404
+//	@Failure		409	"Role is assigned to users or groups"
+//	@Failure		500
+//	@Router			/role/{name} [delete]
+func (ctn *RoleCtn) delete(c *gin.Context) {
+	if err := ctn.roles.Delete(c.Request.Context(), model.UserRole(c.Param("name"))); err != nil {
+		ctn.abortWithRoleError(c, err)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+// canGrant aborts the request unless the caller holds every permission,
+// so a role manager can't create a role more privileged than their own
+func (ctn *RoleCtn) canGrant(c *gin.Context, permissions []model.Permission) bool {
+	claims, _ := auth.GetClaims(c)
+	if !claims.HasPermission(permissions...) {
+		ctn.logger.Infof("User = %s with role = %s can't grant permissions = %v", claims.ID, claims.Role, permissions)
+		c.AbortWithStatusJSON(http.StatusForbidden, cerror.ErrBadRole.Error())
+		return false
+	}
+	return true
+}
+
+func (ctn *RoleCtn) abortWithRoleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, cerror.ErrInvalidRoleName), errors.Is(err, cerror.ErrUnknownPermission):
+		c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+	case errors.Is(err, cerror.ErrBuiltinRole):
+		c.AbortWithStatusJSON(http.StatusForbidden, err.Error())
+	case errors.Is(err, cerror.ErrRoleExists), errors.Is(err, cerror.ErrRoleInUse):
+		c.AbortWithStatusJSON(http.StatusConflict, err.Error())
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.AbortWithError(http.StatusNotFound, err)
+	default:
+		ctn.logger.Errorf("Role request failed, err = %+v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+	}
+}
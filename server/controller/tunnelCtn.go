@@ -8,12 +8,14 @@ import (
 	"github.com/google/uuid"
 	"github.com/killi1812/cloudflared-web-gui/app"
 	"github.com/killi1812/cloudflared-web-gui/dto"
+	"github.com/killi1812/cloudflared-web-gui/model"
 	"github.com/killi1812/cloudflared-web-gui/service"
 	"github.com/killi1812/cloudflared-web-gui/util/auth"
 	"github.com/killi1812/cloudflared-web-gui/util/cerror"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 type (
@@ -28,11 +30,12 @@ type (
 // NewImageCnt creates a new controller for images.
 func NewTunnelCtn() app.Controller {
 	var controller *TunnelCtn
-	app.Invoke(func(logger *zap.SugaredLogger, srv service.ITunnelSrv, dns service.IDnsSrv) {
+	app.Invoke(func(logger *zap.SugaredLogger, srv service.ITunnelSrv, dns service.IDnsSrv, access service.ITunnelAccessSrv) {
 		controller = &TunnelCtn{
 			Logger:    logger,
 			TunnelSrv: srv,
 			DndSrv:    dns,
+			AccessSrv: access,
 		}
 	})
 	return controller
@@ -42,47 +45,65 @@ type TunnelCtn struct {
 	Logger    *zap.SugaredLogger
 	TunnelSrv service.ITunnelSrv
 	DndSrv    service.IDnsSrv
+	AccessSrv service.ITunnelAccessSrv
 }
 
+// _TUNNEL_ACCESS_KEY is the gin context key under which requireAccess stores the callers access level
+const _TUNNEL_ACCESS_KEY = "tunnel.access"
+
 // RegisterEndpoints registers the image manipulation endpoints.
 func (cnt *TunnelCtn) RegisterEndpoints(router *gin.RouterGroup) {
 	grp := router.Group("/tunnel", auth.Protect())
-	grp.GET("", cnt.getTunnels)
-	grp.POST("", cnt.createTunnel)
+	grp.GET("", auth.RequirePermission(model.PERM_TUNNEL_READ), cnt.getTunnels)
+	grp.POST("", auth.RequirePermission(model.PERM_TUNNEL_CREATE), cnt.createTunnel)
+
+	grp.DELETE("/:id", auth.RequirePermission(model.PERM_TUNNEL_DELETE), cnt.requireAccess(model.TUNNEL_ADMIN), cnt.deleteTunnel)
+	grp.GET("/:id", auth.RequirePermission(model.PERM_TUNNEL_READ), cnt.requireAccess(model.TUNNEL_VIEWER), cnt.getInfo)
 
-	grp.DELETE("/:id", cnt.deleteTunnel)
-	grp.GET("/:id", cnt.getInfo)
+	grp.POST("/dns/:id", auth.RequirePermission(model.PERM_DNS_WRITE), cnt.requireAccess(model.TUNNEL_ADMIN), cnt.createDnsRecord)
 
-	grp.POST("/dns/:id", cnt.createDnsRecord)
+	grp.PUT("/:id/start", auth.RequirePermission(model.PERM_TUNNEL_START), cnt.requireAccess(model.TUNNEL_OPERATOR), cnt.startTunnel)
+	grp.PUT("/:id/stop", auth.RequirePermission(model.PERM_TUNNEL_START), cnt.requireAccess(model.TUNNEL_OPERATOR), cnt.stopTunnel)
+	grp.PUT("/:id/restart", auth.RequirePermission(model.PERM_TUNNEL_START), cnt.requireAccess(model.TUNNEL_OPERATOR), cnt.restartTunnel)
 
-	grp.PUT("/:id/start", cnt.startTunnel)
-	grp.PUT("/:id/stop", cnt.stopTunnel)
-	grp.PUT("/:id/restart", cnt.restartTunnel)
+	grp.GET("/:id/access", auth.RequirePermission(model.PERM_TUNNEL_READ), cnt.requireAccess(model.TUNNEL_VIEWER), cnt.getAccess)
+	grp.PUT("/:id/owner", auth.RequirePermission(model.PERM_TUNNEL_READ), cnt.requireAccess(model.TUNNEL_ADMIN), cnt.setOwner)
+	grp.PUT("/:id/grants", auth.RequirePermission(model.PERM_TUNNEL_READ), cnt.requireAccess(model.TUNNEL_ADMIN), cnt.grantAccess)
+	grp.DELETE("/:id/grants/:userUuid", auth.RequirePermission(model.PERM_TUNNEL_READ), cnt.requireAccess(model.TUNNEL_ADMIN), cnt.revokeAccess)
+	grp.DELETE("/:id/group-grants/:groupUuid", auth.RequirePermission(model.PERM_TUNNEL_READ), cnt.requireAccess(model.TUNNEL_ADMIN), cnt.revokeGroupAccess)
 }
 
 // getTunnels godoc
 //
 //	@Summary		Get a list of all tunnels
-//	@Description	returns a list of all tunnels
+//	@Description	returns a list of tunnels the caller has access to
 //	@Tags			tunnel
 //	@Produce		json
 //	@Success		200	{object}	[]dto.TunnelDto	"List of tunnels"
 //	@Router			/tunnel [get]
 func (ctn *TunnelCtn) getTunnels(c *gin.Context) {
-	list, err := ctn.TunnelSrv.List()
+	all, err := ctn.TunnelSrv.List(c.Request.Context())
 	if err != nil {
 		ctn.Logger.Errorf("Error retrieving tunnels, err %v", err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
+	list, levels, err := ctn.visible(c, all)
+	if err != nil {
+		ctn.Logger.Errorf("Error resolving tunnel access, err %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
 	var resp []dto.TunnelDto = make([]dto.TunnelDto, len(list))
 	var wg sync.WaitGroup
 
 	for i, tnl := range list {
 		wg.Go(func() {
 			resp[i].FromModel(tnl)
-			dnsRecords, err := ctn.DndSrv.GetDnsRecords(tnl.Id)
+			resp[i].Access = string(levels[tnl.Id])
+			dnsRecords, err := ctn.DndSrv.GetDnsRecords(c.Request.Context(), tnl.Id)
 			if err != nil {
 				if !errors.Is(err, cerror.ErrZoneIdNotSet) && !errors.Is(err, cerror.ErrCloudflaredApiKeyNotSet) {
 					ctn.Logger.Warnln(err)
@@ -118,7 +139,7 @@ func (ctn *TunnelCtn) createTunnel(c *gin.Context) {
 		return
 	}
 
-	tunnel, err := ctn.TunnelSrv.Create(req.Name)
+	tunnel, err := ctn.TunnelSrv.Create(c.Request.Context(), req.Name)
 	if err != nil {
 		ctn.Logger.Errorf("Error creating a tunnel, err = %v", err)
 		c.AbortWithStatus(http.StatusInternalServerError)
@@ -128,6 +149,14 @@ func (ctn *TunnelCtn) createTunnel(c *gin.Context) {
 	var resp dto.TunnelDto
 	resp.FromModel(*tunnel)
 
+	// the tunnel already exists, without an owner it is only visible to admins
+	claims, _ := auth.GetClaims(c)
+	if err := ctn.AccessSrv.SetOwner(c.Request.Context(), tunnel.Id, uuid.MustParse(claims.ID)); err != nil {
+		ctn.Logger.Errorf("Error setting owner of tunnel = %s, err = %v", tunnel.Id, err)
+	} else {
+		resp.Access = string(model.TUNNEL_ADMIN)
+	}
+
 	c.AbortWithStatusJSON(http.StatusCreated, resp)
 }
 
@@ -155,13 +184,17 @@ func (ctn *TunnelCtn) deleteTunnel(c *gin.Context) {
 		return
 	}
 
-	err = ctn.TunnelSrv.Delete(uuid)
+	err = ctn.TunnelSrv.Delete(c.Request.Context(), uuid)
 	if err != nil {
 		ctn.Logger.Errorf("Error deleting a tunnel, err = %v", err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
+	if err := ctn.AccessSrv.Forget(c.Request.Context(), uuid); err != nil {
+		ctn.Logger.Errorf("Error removing access of deleted tunnel = %s, err = %v", uuid, err)
+	}
+
 	c.AbortWithStatus(http.StatusNoContent)
 }
 
@@ -199,7 +232,7 @@ func (ctn *TunnelCtn) createDnsRecord(c *gin.Context) {
 	}
 	ctn.Logger.Debugf("req: %+v", req)
 
-	tunnel, err := ctn.TunnelSrv.AddConn(uuid, req.Domain)
+	tunnel, err := ctn.TunnelSrv.AddConn(c.Request.Context(), uuid, req.Domain)
 	if err != nil {
 		ctn.Logger.Errorf("Error deleting a tunnel, err = %v", err)
 		c.AbortWithStatus(http.StatusInternalServerError)
@@ -235,7 +268,7 @@ func (ctn *TunnelCtn) getInfo(c *gin.Context) {
 		return
 	}
 
-	tunnel, err := ctn.TunnelSrv.Info(uuid)
+	tunnel, err := ctn.TunnelSrv.Info(c.Request.Context(), uuid)
 	if err != nil {
 		ctn.Logger.Errorf("Error getting tunnel info, id = %s , err = %v", uuid, err)
 		c.AbortWithStatus(http.StatusInternalServerError)
@@ -244,8 +277,9 @@ func (ctn *TunnelCtn) getInfo(c *gin.Context) {
 
 	var resp dto.TunnelDto
 	resp.FromModel(*tunnel)
+	resp.Access = c.GetString(_TUNNEL_ACCESS_KEY)
 
-	dnsRecords, err := ctn.DndSrv.GetDnsRecords(tunnel.Id)
+	dnsRecords, err := ctn.DndSrv.GetDnsRecords(c.Request.Context(), tunnel.Id)
 	if err != nil {
 		ctn.Logger.Errorf("Error retrieving tunnel dns records, id = %s, err = %v", tunnel.Id, err)
 		if !errors.Is(err, cerror.ErrZoneIdNotSet) && !errors.Is(err, cerror.ErrCloudflaredApiKeyNotSet) {
@@ -283,7 +317,7 @@ func (ctn *TunnelCtn) startTunnel(c *gin.Context) {
 		return
 	}
 
-	err = ctn.TunnelSrv.Start(uuid)
+	err = ctn.TunnelSrv.Start(c.Request.Context(), uuid)
 	if err != nil {
 		ctn.Logger.Errorf("Error starting a tunnel, err = %v", err)
 		c.AbortWithStatus(http.StatusInternalServerError)
@@ -317,7 +351,7 @@ func (ctn *TunnelCtn) stopTunnel(c *gin.Context) {
 		return
 	}
 
-	err = ctn.TunnelSrv.Stop(uuid)
+	err = ctn.TunnelSrv.Stop(c.Request.Context(), uuid)
 	if err != nil {
 		ctn.Logger.Errorf("Error stopping a tunnel, err = %v", err)
 		c.AbortWithStatus(http.StatusInternalServerError)
@@ -351,7 +385,7 @@ func (ctn *TunnelCtn) restartTunnel(c *gin.Context) {
 		return
 	}
 
-	err = ctn.TunnelSrv.Restart(uuid)
+	err = ctn.TunnelSrv.Restart(c.Request.Context(), uuid)
 	if err != nil {
 		ctn.Logger.Errorf("Error stopping a tunnel, err = %v", err)
 		c.AbortWithStatus(http.StatusInternalServerError)
@@ -360,3 +394,233 @@ func (ctn *TunnelCtn) restartTunnel(c *gin.Context) {
 
 	c.AbortWithStatus(http.StatusNoContent)
 }
+
+// getAccess godoc
+//
+//	@Summary		Get tunnel access
+//	@Description	returns the owner of the tunnel and users granted access to it
+//	@Tags			tunnel
+//	@Produce		json
+//	@Success		200	{object}	dto.TunnelAccessDto
+//	@Failure		403
+//	@Param			id	path	string	true	"tunnel id"
+//	@Router			/tunnel/{id}/access [get]
+func (ctn *TunnelCtn) getAccess(c *gin.Context) {
+	id := uuid.MustParse(c.Param("id"))
+
+	owner, grants, err := ctn.AccessSrv.Access(c.Request.Context(), id)
+	if err != nil {
+		ctn.Logger.Errorf("Error getting access of tunnel = %s, err = %v", id, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.TunnelAccessDto{}.FromModel(owner, grants))
+}
+
+// setOwner godoc
+//
+//	@Summary		Set tunnel owner
+//	@Description	transfers the tunnel to another user or group, owners and members of the owning group have admin access
+//	@Tags			tunnel
+//	@Accept			json
+//	@Success		204
+//	@Failure		400
+//	@Failure		403
+//	@Failure		404	"User or group not found"
+//	@Param			id		path	string				true	"tunnel id"
+//	@Param			model	body	dto.TunnelOwnerDto	true	"new owner"
+//	@Router			/tunnel/{id}/owner [put]
+func (ctn *TunnelCtn) setOwner(c *gin.Context) {
+	id := uuid.MustParse(c.Param("id"))
+
+	var req dto.TunnelOwnerDto
+	if err := c.BindJSON(&req); err != nil {
+		ctn.Logger.Errorf("Error body format, err = %v", err)
+		return
+	}
+
+	var err error
+	if req.GroupUuid != "" {
+		err = ctn.AccessSrv.SetGroupOwner(c.Request.Context(), id, uuid.MustParse(req.GroupUuid))
+	} else {
+		err = ctn.AccessSrv.SetOwner(c.Request.Context(), id, uuid.MustParse(req.UserUuid))
+	}
+	if err != nil {
+		ctn.abortWithAccessError(c, err)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+// grantAccess godoc
+//
+//	@Summary		Grant tunnel access
+//	@Description	gives a user or members of a group viewer, operator or admin access to the tunnel
+//	@Tags			tunnel
+//	@Accept			json
+//	@Success		204
+//	@Failure		400
+//	@Failure		403
+//	@Failure		404	"User or group not found"
+//	@Param			id		path	string						true	"tunnel id"
+//	@Param			model	body	dto.TunnelGrantRequestDto	true	"grant"
+//	@Router			/tunnel/{id}/grants [put]
+func (ctn *TunnelCtn) grantAccess(c *gin.Context) {
+	id := uuid.MustParse(c.Param("id"))
+
+	var req dto.TunnelGrantRequestDto
+	if err := c.BindJSON(&req); err != nil {
+		ctn.Logger.Errorf("Error body format, err = %v", err)
+		return
+	}
+
+	level := model.TunnelAccessLevel(req.Level)
+	var err error
+	if req.GroupUuid != "" {
+		err = ctn.AccessSrv.GrantGroup(c.Request.Context(), id, uuid.MustParse(req.GroupUuid), level)
+	} else {
+		err = ctn.AccessSrv.Grant(c.Request.Context(), id, uuid.MustParse(req.UserUuid), level)
+	}
+	if err != nil {
+		ctn.abortWithAccessError(c, err)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+// revokeAccess godoc
+//
+//	@Summary		Revoke tunnel access
+//	@Description	removes the grant of a user on the tunnel
+//	@Tags			tunnel
+//	@Success		204
+//	@Failure		400
+//	@Failure		403
+//	@Failure		404
+//	@Param			id			path	string	true	"tunnel id"
+//	@Param			userUuid	path	string	true	"user uuid"
+//	@Router			/tunnel/{id}/grants/{userUuid} [delete]
+func (ctn *TunnelCtn) revokeAccess(c *gin.Context) {
+	id := uuid.MustParse(c.Param("id"))
+
+	userUuid, err := uuid.Parse(c.Param("userUuid"))
+	if err != nil {
+		ctn.Logger.Errorf("Error parsing uuid, id = %s", c.Param("userUuid"))
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := ctn.AccessSrv.Revoke(c.Request.Context(), id, userUuid); err != nil {
+		ctn.abortWithAccessError(c, err)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+// revokeGroupAccess godoc
+//
+//	@Summary		Revoke tunnel access of a group
+//	@Description	removes the grant of a group on the tunnel
+//	@Tags			tunnel
+//	@Success		204
+//	@Failure		400
+//	@Failure		403
+//	@Failure		404
+//	@Param			id			path	string	true	"tunnel id"
+//	@Param			groupUuid	path	string	true	"group uuid"
+//	@Router			/tunnel/{id}/group-grants/{groupUuid} [delete]
+func (ctn *TunnelCtn) revokeGroupAccess(c *gin.Context) {
+	id := uuid.MustParse(c.Param("id"))
+
+	groupUuid, err := uuid.Parse(c.Param("groupUuid"))
+	if err != nil {
+		ctn.Logger.Errorf("Error parsing uuid, id = %s", c.Param("groupUuid"))
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := ctn.AccessSrv.RevokeGroup(c.Request.Context(), id, groupUuid); err != nil {
+		ctn.abortWithAccessError(c, err)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+// requireAccess allows access only to callers with at least level access on the tunnel in the id path param
+func (ctn *TunnelCtn) requireAccess(level model.TunnelAccessLevel) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			ctn.Logger.Errorf("Error parsing uuid, id = %s", c.Param("id"))
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		claims, _ := auth.GetClaims(c)
+		userUuid, err := uuid.Parse(claims.ID)
+		if err != nil {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		levels, err := ctn.AccessSrv.Levels(c.Request.Context(), userUuid, claims.Permissions(), []uuid.UUID{id})
+		if err != nil {
+			ctn.Logger.Errorf("Error resolving access to tunnel = %s, err = %v", id, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		if !levels[id].Allows(level) {
+			ctn.Logger.Infof("User = %s with %q access can't perform %s on tunnel = %s", userUuid, levels[id], c.FullPath(), id)
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		c.Set(_TUNNEL_ACCESS_KEY, string(levels[id]))
+		c.Next()
+	}
+}
+
+// visible filters tunnels down to the ones the caller has access to
+func (ctn *TunnelCtn) visible(c *gin.Context, tunnels []model.Tunnel) ([]model.Tunnel, map[uuid.UUID]model.TunnelAccessLevel, error) {
+	claims, _ := auth.GetClaims(c)
+	userUuid, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make([]uuid.UUID, 0, len(tunnels))
+	for _, tnl := range tunnels {
+		ids = append(ids, tnl.Id)
+	}
+
+	levels, err := ctn.AccessSrv.Levels(c.Request.Context(), userUuid, claims.Permissions(), ids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	visible := make([]model.Tunnel, 0, len(levels))
+	for _, tnl := range tunnels {
+		if _, ok := levels[tnl.Id]; ok {
+			visible = append(visible, tnl)
+		}
+	}
+	return visible, levels, nil
+}
+
+func (ctn *TunnelCtn) abortWithAccessError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.AbortWithStatus(http.StatusNotFound)
+	case errors.Is(err, cerror.ErrUnknownAccessLevel):
+		c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+	default:
+		ctn.Logger.Errorf("Tunnel access request failed, err = %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/killi1812/cloudflared-web-gui/model"
 	"github.com/killi1812/cloudflared-web-gui/service"
 	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -18,6 +19,8 @@ import (
 
 type UserCtn struct {
 	UserCrud service.IUserCrudService
+	Auth     service.IAuthService
+	Events   service.ISecurityEventSrv
 	logger   *zap.SugaredLogger
 }
 
@@ -25,10 +28,12 @@ func NewUserCtn() app.Controller {
 	var controller *UserCtn
 
 	// Call dependency injection
-	app.Invoke(func(UserService service.IUserCrudService, logger *zap.SugaredLogger) {
+	app.Invoke(func(UserService service.IUserCrudService, AuthService service.IAuthService, EventSrv service.ISecurityEventSrv, logger *zap.SugaredLogger) {
 		// create controller
 		controller = &UserCtn{
 			UserCrud: UserService,
+			Auth:     AuthService,
+			Events:   EventSrv,
 			logger:   logger,
 		}
 	})
@@ -41,17 +46,21 @@ func (u *UserCtn) RegisterEndpoints(api *gin.RouterGroup) {
 	group := api.Group("/user")
 
 	// Protected endpint
-	group.GET("/my-data", auth.Protect(), u.getLoggedInUser)
+	group.GET("/my-data", auth.AllowPendingPasswordChange(), auth.Protect(), u.getLoggedInUser)
+	group.PUT("/me/password", auth.AllowPendingPasswordChange(), auth.AllowReadOnly(), auth.Protect(), u.changePassword)
+	group.GET("/me/security-events", auth.Protect(), u.mySecurityEvents)
 
 	// register Endpoints
-	group.Use(auth.Protect(model.ROLE_SUPER_ADMIN, model.ROLE_ADMIN))
-	// group.POST("/", u.create)
-	// group.GET("/:uuid", u.get)
+	group.Use(auth.Protect(), auth.RequirePermission(model.PERM_USER_MANAGE))
+	group.GET("", u.list)
+	group.POST("", u.create)
+	group.GET("/security-events", u.securityEvents)
+	group.GET("/:uuid", u.get)
 	group.PUT("/:uuid", u.update)
-	// group.DELETE("/:uuid", u.delete)
-	// group.GET("/oib/:oib", u.getUserByOib)
-	// group.GET("/all-users", u.getAllUsersForSuperAdmin)
-	// group.GET("/search", u.searchUsersByName)
+	group.DELETE("/:uuid", u.delete)
+	group.PUT("/:uuid/restore", u.restore)
+	group.PUT("/:uuid/unlock", auth.Protect(model.ROLE_SUPER_ADMIN), u.unlock)
+	group.PUT("/:uuid/password", u.resetPassword)
 }
 
 // UserExample godoc
@@ -74,7 +83,7 @@ func (u *UserCtn) get(c *gin.Context) {
 		return
 	}
 
-	user, err := u.UserCrud.Read(userUuid)
+	user, err := u.UserCrud.Read(c.Request.Context(), userUuid)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			u.logger.Errorf("User with uuid = %s not found", userUuid)
@@ -98,37 +107,94 @@ func (u *UserCtn) get(c *gin.Context) {
 //	@Produce	json
 //	@Success	201	{object}	dto.UserDto
 //	@Failure	400
-//	@Failure	404
+//	@Failure	403	"Role can't be granted by the caller"
+//	@Failure	409	"Username is taken"
 //	@Failure	500
 //	@Param		model	body	dto.NewUserDto	true	"Data for new user"
 //	@Router		/user [post]
 func (u *UserCtn) create(c *gin.Context) {
-	var dto dto.NewUserDto
-	if err := c.BindJSON(&dto); err != nil {
+	var req dto.NewUserDto
+	if err := c.BindJSON(&req); err != nil {
 		u.logger.Errorf("Failed to bind error = %+v", err)
 		return
 	}
 
-	newUser, err := dto.ToModel()
+	newUser, err := req.ToModel()
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if claims, _ := auth.GetClaims(c); !claims.CanGrant(newUser.Role) {
+		u.logger.Infof("User = %s with role = %s can't grant role = %s", claims.ID, claims.Role, newUser.Role)
+		c.AbortWithStatusJSON(http.StatusForbidden, cerror.ErrBadRole.Error())
 		return
 	}
 
-	user, err := u.UserCrud.Create(newUser, dto.Password)
+	user, err := u.UserCrud.Create(c.Request.Context(), newUser, req.Password)
 	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
+		switch {
+		case errors.Is(err, cerror.ErrWeakPassword):
+			c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		case errors.Is(err, cerror.ErrUserExists):
+			c.AbortWithStatusJSON(http.StatusConflict, err.Error())
+		default:
+			u.logger.Errorf("Failed to create user = %s, err = %v", newUser.Username, err)
+			c.AbortWithError(http.StatusInternalServerError, err)
+		}
 		return
 	}
 
-	// Log the response for debugging
-	responseDto := dto.FromModel(user)
-	tokenValue := ""
+	u.logger.Infof("Created user = %s, uuid = %s, role = %s", user.Username, user.Uuid, user.Role)
+	c.JSON(http.StatusCreated, dto.UserDto{}.FromModel(user))
+}
+
+// list godoc
+//
+//	@Summary		List users
+//	@Description	returns a page of users, search matches part of the username or email
+//	@Tags			user
+//	@Produce		json
+//	@Param			page		query		int		false	"page starting at 1"
+//	@Param			pageSize	query		int		false	"users per page, at most 100"
+//	@Param			sort		query		string	false	"username, email, role or createdAt"
+//	@Param			order		query		string	false	"asc or desc"
+//	@Param			role		query		string	false	"only users with role"
+//	@Param			search		query		string	false	"part of the username or email"
+//	@Param			deleted		query		bool	false	"list deleted users instead"
+//	@Success		200			{object}	dto.UserPageDto
+//	@Failure		400
+//	@Failure		500
+//	@Router			/user [get]
+func (u *UserCtn) list(c *gin.Context) {
+	var query dto.UserListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		u.logger.Debugf("Invalid user list query, err = %+v", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
 
-	u.logger.Infof("Response DTO: %+v", responseDto)
-	u.logger.Infof("Response PoliceToken: %q", tokenValue)
+	filter := service.UserFilter{
+		Page:     query.Page,
+		PageSize: query.PageSize,
+		Sort:     query.Sort,
+		Desc:     query.Order == "desc",
+		Role:     model.UserRole(query.Role),
+		Search:   query.Search,
+		Deleted:  query.Deleted,
+	}.Normalized()
+	users, total, err := u.UserCrud.List(c.Request.Context(), filter)
+	if err != nil {
+		if errors.Is(err, cerror.ErrUnknownSortField) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+		u.logger.Errorf("Failed to list users, err = %v", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
 
-	c.JSON(http.StatusCreated, responseDto)
+	c.JSON(http.StatusOK, dto.UserPageDto{}.FromModel(users, filter.Page, filter.PageSize, total))
 }
 
 // UserExample godoc
@@ -138,6 +204,7 @@ func (u *UserCtn) create(c *gin.Context) {
 //	@Produce	json
 //	@Success	200	{object}	dto.UserDto
 //	@Failure	400
+//	@Failure	403	"Role can't be granted by the caller"
 //	@Failure	404
 //	@Failure	500
 //	@Param		uuid	path	string		true	"uuid of user to be updated"
@@ -163,7 +230,16 @@ func (u *UserCtn) update(c *gin.Context) {
 		return
 	}
 
-	user, err := u.UserCrud.Update(userUuid, newUser)
+	if !u.canManage(c, userUuid) {
+		return
+	}
+	if claims, _ := auth.GetClaims(c); !claims.CanGrant(newUser.Role) {
+		u.logger.Infof("User = %s with role = %s can't grant role = %s", claims.ID, claims.Role, newUser.Role)
+		c.AbortWithStatusJSON(http.StatusForbidden, cerror.ErrBadRole.Error())
+		return
+	}
+
+	user, err := u.UserCrud.Update(c.Request.Context(), userUuid, newUser)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
@@ -172,19 +248,131 @@ func (u *UserCtn) update(c *gin.Context) {
 	c.JSON(http.StatusOK, dto.FromModel(user))
 }
 
-// UserExample  godoc
+// changePassword godoc
 //
-//	@Summary		delete user with uuid
-//	@Description	delete a user with uuid
+//	@Summary		change own password
+//	@Description	changes the password of the logged-in user and returns a new access token
 //	@Tags			user
+//	@Accept			json
 //	@Produce		json
+//	@Param			model	body		dto.ChangePasswordDto	true	"current and new password"
+//	@Success		200		{object}	dto.TokenDto
+//	@Failure		400		"New password doesn't meet the password policy"
+//	@Failure		401
+//	@Failure		403		"Current password is wrong"
+//	@Failure		429		"Too many attempts or account locked"
+//	@Failure		500
+//	@Router			/user/me/password [put]
+func (u *UserCtn) changePassword(c *gin.Context) {
+	claims, ok := auth.GetClaims(c)
+	if !ok {
+		u.logger.Errorf("Claims not found in context")
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	userUuid, err := uuid.Parse(claims.ID)
+	if err != nil {
+		u.logger.Errorf("Error parsing UUID = %s", err)
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	var req dto.ChangePasswordDto
+	if err := c.BindJSON(&req); err != nil {
+		u.logger.Errorf("Failed to bind error = %+v", err)
+		return
+	}
+
+	user, err := u.UserCrud.ChangePassword(c.Request.Context(), userUuid, req.CurrentPassword, req.NewPassword, clientInfo(c))
+	if err != nil {
+		var retryErr *cerror.RetryError
+		switch {
+		case errors.As(err, &retryErr):
+			abortWithRetry(c, retryErr)
+		case errors.Is(err, cerror.ErrWeakPassword):
+			c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		case errors.Is(err, cerror.ErrInvalidCredentials):
+			c.AbortWithStatusJSON(http.StatusForbidden, err.Error())
+		default:
+			u.logger.Errorf("Failed to change password of user uuid = %s, err = %v", userUuid, err)
+			c.AbortWithError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	// issue a new token so the password change requirement is dropped right away
+	accessToken, err := u.Auth.CreateSession(c.Request.Context(), user)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.TokenDto{AccessToken: accessToken})
+}
+
+// resetPassword godoc
+//
+//	@Summary		reset user password
+//	@Description	sets a new password for a user and ends their session, forceChange requires them to change it after login
+//	@Tags			user
+//	@Accept			json
+//	@Param			uuid	path	string					true	"user uuid"
+//	@Param			model	body	dto.ResetPasswordDto	true	"new password"
 //	@Success		204
 //	@Failure		400
+//	@Failure		403	"User is more privileged than the caller"
+//	@Failure		404
+//	@Failure		500
+//	@Router			/user/{uuid}/password [put]
+func (u *UserCtn) resetPassword(c *gin.Context) {
+	userUuid, err := uuid.Parse(c.Param("uuid"))
+	if err != nil {
+		u.logger.Errorf("error parsing uuid value = %s", c.Param("uuid"))
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	var req dto.ResetPasswordDto
+	if err := c.BindJSON(&req); err != nil {
+		u.logger.Errorf("Failed to bind error = %+v", err)
+		return
+	}
+
+	if !u.canManage(c, userUuid) {
+		return
+	}
+
+	err = u.UserCrud.ResetPassword(c.Request.Context(), userUuid, req.Password, req.ForceChange)
+	if err != nil {
+		switch {
+		case errors.Is(err, cerror.ErrWeakPassword):
+			c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.AbortWithError(http.StatusNotFound, err)
+		default:
+			u.logger.Errorf("Failed to reset password of user uuid = %s, err = %v", userUuid, err)
+			c.AbortWithError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+// unlock godoc
+//
+//	@Summary		unlock user account
+//	@Description	removes a temporary lockout caused by failed logins
+//	@Tags			user
+//	@Success		204
+//	@Failure		400
+//	@Failure		403	"Only superadmins can unlock users"
 //	@Failure		404
 //	@Failure		500
 //	@Param			uuid	path	string	true	"user uuid"
-//	@Router			/user/{uuid} [delete]
-func (u *UserCtn) delete(c *gin.Context) {
+//	@Router			/user/{uuid}/unlock [put]
+func (u *UserCtn) unlock(c *gin.Context) {
 	userUuid, err := uuid.Parse(c.Param("uuid"))
 	if err != nil {
 		u.logger.Errorf("error parsing uuid value = %s", c.Param("uuid"))
@@ -192,7 +380,7 @@ func (u *UserCtn) delete(c *gin.Context) {
 		return
 	}
 
-	err = u.UserCrud.Delete(userUuid)
+	err = u.Auth.Unlock(c.Request.Context(), userUuid, clientInfo(c))
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			u.logger.Errorf("User with uuid = %s not found", userUuid)
@@ -200,42 +388,45 @@ func (u *UserCtn) delete(c *gin.Context) {
 			return
 		}
 
-		u.logger.Errorf("Failed to delete user with uuid = %s", userUuid)
+		u.logger.Errorf("Failed to unlock user with uuid = %s, err = %v", userUuid, err)
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
-	c.JSON(http.StatusNoContent, nil)
+	c.AbortWithStatus(http.StatusNoContent)
 }
 
-// GetLoggedInUser godoc
+// UserExample  godoc
 //
-//	@Summary		Get logged-in user data
-//	@Description	Fetches the currently logged-in user's data based on the JWT token
+//	@Summary		delete user with uuid
+//	@Description	anonymizes and soft deletes a user with uuid, it can be restored later
 //	@Tags			user
 //	@Produce		json
-//	@Success		200	{object}	dto.UserDto
+//	@Success		204
 //	@Failure		400
-//	@Failure		401
+//	@Failure		403
 //	@Failure		404
+//	@Failure		409	"Users can't delete themselves"
 //	@Failure		500
-//	@Router			/user/my-data [get]
-func (u *UserCtn) getLoggedInUser(c *gin.Context) {
-	_, claims, err := auth.ParseToken(c.Request.Header.Get("Authorization"))
+//	@Param			uuid	path	string	true	"user uuid"
+//	@Router			/user/{uuid} [delete]
+func (u *UserCtn) delete(c *gin.Context) {
+	userUuid, err := uuid.Parse(c.Param("uuid"))
 	if err != nil {
-		u.logger.Errorf("Failed to parse token: %v", err)
-		c.AbortWithError(http.StatusUnauthorized, err)
+		u.logger.Errorf("error parsing uuid value = %s", c.Param("uuid"))
+		c.AbortWithError(http.StatusBadRequest, err)
 		return
 	}
 
-	userUuid, err := uuid.Parse(claims.ID)
-	if err != nil {
-		u.logger.Errorf("Error parsing UUID = %s", err)
-		c.AbortWithError(http.StatusBadRequest, err)
+	if claims, _ := auth.GetClaims(c); claims.ID == userUuid.String() {
+		c.AbortWithStatusJSON(http.StatusConflict, cerror.ErrDeleteSelf.Error())
+		return
+	}
+	if !u.canManage(c, userUuid) {
 		return
 	}
 
-	user, err := u.UserCrud.Read(userUuid)
+	err = u.UserCrud.Delete(c.Request.Context(), userUuid)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			u.logger.Errorf("User with uuid = %s not found", userUuid)
@@ -243,126 +434,230 @@ func (u *UserCtn) getLoggedInUser(c *gin.Context) {
 			return
 		}
 
-		u.logger.Errorf("Failed to fetch user with uuid = %s: %v", userUuid, err)
+		u.logger.Errorf("Failed to delete user with uuid = %s", userUuid)
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
-	dto := dto.UserDto{}
-	c.JSON(http.StatusOK, dto.FromModel(user))
+	c.JSON(http.StatusNoContent, nil)
 }
 
-// GetAllUsers godoc
+// restore godoc
 //
-//	@Summary		Get all users for superadmin
-//	@Description	Fetches all users for superadmin
+//	@Summary		restore a deleted user
+//	@Description	undeletes a user under a new username, the user can't log in until its password is reset
 //	@Tags			user
+//	@Accept			json
 //	@Produce		json
-//	@Success		200	{array}	dto.UserDto
-//	@Failure		401
-//	@Failure		403
+//	@Success		200	{object}	dto.UserDto
+//	@Failure		400
+//	@Failure		403	"User is more privileged than the caller"
+//	@Failure		404
+//	@Failure		409	"Username is taken"
 //	@Failure		500
-//	@Router			/user/all-users [get]
-func (u *UserCtn) getAllUsersForSuperAdmin(c *gin.Context) {
-	_, claims, err := auth.ParseToken(c.Request.Header.Get("Authorization"))
+//	@Param			uuid	path	string				true	"user uuid"
+//	@Param			model	body	dto.RestoreUserDto	true	"new username"
+//	@Router			/user/{uuid}/restore [put]
+func (u *UserCtn) restore(c *gin.Context) {
+	userUuid, err := uuid.Parse(c.Param("uuid"))
 	if err != nil {
-		u.logger.Errorf("Failed to parse token: %v", err)
-		c.AbortWithError(http.StatusUnauthorized, err)
+		u.logger.Errorf("error parsing uuid value = %s", c.Param("uuid"))
+		c.AbortWithError(http.StatusBadRequest, err)
 		return
 	}
 
-	if claims.Role != model.ROLE_SUPER_ADMIN {
-		u.logger.Warnf("Unauthorized access attempt by user with role: %s", claims.Role)
-		c.AbortWithStatus(http.StatusForbidden)
+	var req dto.RestoreUserDto
+	if err := c.BindJSON(&req); err != nil {
+		u.logger.Errorf("Failed to bind error = %+v", err)
 		return
 	}
 
-	users, err := u.UserCrud.GetAllUsers()
+	deleted, err := u.UserCrud.ReadDeleted(c.Request.Context(), userUuid)
 	if err != nil {
-		u.logger.Errorf("Failed to fetch users: %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+		u.logger.Errorf("Failed to read deleted user with uuid = %s, err = %v", userUuid, err)
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
+	if !u.canManageUser(c, deleted) {
+		return
+	}
 
-	userDtos := make([]dto.UserDto, 0, len(users))
-	for _, user := range users {
-		dto := dto.UserDto{}
-		userDtos = append(userDtos, dto.FromModel(&user))
+	user, err := u.UserCrud.Restore(c.Request.Context(), userUuid, req.Username)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.AbortWithError(http.StatusNotFound, err)
+		case errors.Is(err, cerror.ErrUserExists):
+			c.AbortWithStatusJSON(http.StatusConflict, err.Error())
+		default:
+			u.logger.Errorf("Failed to restore user with uuid = %s, err = %v", userUuid, err)
+			c.AbortWithError(http.StatusInternalServerError, err)
+		}
+		return
 	}
 
-	c.JSON(http.StatusOK, userDtos)
+	c.JSON(http.StatusOK, dto.UserDto{}.FromModel(user))
 }
 
-// SearchUsersByName godoc
+// GetLoggedInUser godoc
 //
-//	@Summary		Search users by name
-//	@Description	Performs a fuzzy search for users by first name, last name, or full name with similarity matching
+//	@Summary		Get logged-in user data
+//	@Description	Fetches the currently logged-in user's data based on the JWT token, capabilities list permissions of the users role and groups
 //	@Tags			user
 //	@Produce		json
-//	@Param			query	query	string	true	"Search query"
-//	@Success		200		{array}	dto.UserDto
+//	@Success		200	{object}	dto.UserDto
 //	@Failure		400
+//	@Failure		401
+//	@Failure		404
 //	@Failure		500
-//	@Router			/user/search [get]
-func (u *UserCtn) searchUsersByName(c *gin.Context) {
-	query := c.Query("query")
-	if query == "" {
-		u.logger.Warn("Search query is empty")
-		c.JSON(http.StatusBadRequest, "Search query is required")
+//	@Router			/user/my-data [get]
+func (u *UserCtn) getLoggedInUser(c *gin.Context) {
+	claims, ok := auth.GetClaims(c)
+	if !ok {
+		u.logger.Errorf("Claims not found in context")
+		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
 
-	u.logger.Infof("Searching users with query: %s", query)
+	userUuid, err := uuid.Parse(claims.ID)
+	if err != nil {
+		u.logger.Errorf("Error parsing UUID = %s", err)
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
 
-	users, err := u.UserCrud.SearchUsersByName(query)
+	user, err := u.UserCrud.Read(c.Request.Context(), userUuid)
 	if err != nil {
-		u.logger.Errorf("Failed to search users: %v", err)
-		c.JSON(http.StatusInternalServerError, "Failed to search users")
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			u.logger.Errorf("User with uuid = %s not found", userUuid)
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		u.logger.Errorf("Failed to fetch user with uuid = %s: %v", userUuid, err)
+		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
-	userDtos := make([]dto.UserDto, 0, len(users))
-	for _, user := range users {
-		dto := dto.UserDto{}
-		userDtos = append(userDtos, dto.FromModel(&user))
+	resp := dto.UserDto{}.FromModel(user)
+	resp.Capabilities = capabilities(user)
+	c.JSON(http.StatusOK, resp)
+}
+
+// capabilities lists effective permissions of the user, the UI uses them to hide actions the user can't perform
+func capabilities(user *model.User) []string {
+	permissions := (&auth.Claims{Role: user.Role, Groups: user.GroupNames()}).Permissions()
+	caps := make([]string, 0, len(permissions))
+	for _, p := range permissions {
+		caps = append(caps, string(p))
 	}
+	return caps
+}
 
-	c.JSON(http.StatusOK, userDtos)
+// canManage aborts the request unless the caller could grant the current role and group roles of the user,
+// so nobody can take over or change an account more privileged than their own
+func (u *UserCtn) canManage(c *gin.Context, userUuid uuid.UUID) bool {
+	user, err := u.UserCrud.Read(c.Request.Context(), userUuid)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithError(http.StatusNotFound, err)
+			return false
+		}
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return false
+	}
+
+	return u.canManageUser(c, user)
+}
+
+// canManageUser is canManage of an already loaded user, including a deleted one
+func (u *UserCtn) canManageUser(c *gin.Context, user *model.User) bool {
+	claims, _ := auth.GetClaims(c)
+	target := &auth.Claims{Role: user.Role, Groups: user.GroupNames()}
+	for _, role := range target.Roles() {
+		if !claims.CanGrant(role) {
+			u.logger.Infof("User = %s with role = %s can't manage user = %s with role = %s", claims.ID, claims.Role, user.Uuid, role)
+			c.AbortWithStatusJSON(http.StatusForbidden, cerror.ErrBadRole.Error())
+			return false
+		}
+	}
+
+	return true
 }
 
-// GetUserByOIB godoc
+// mySecurityEvents godoc
 //
-//	@Summary		get user with oib
-//	@Description	get a user with oib
+//	@Summary		Login history of the logged-in user
+//	@Description	returns logins, token refreshes, logouts and lockouts of the caller newest first
 //	@Tags			user
 //	@Produce		json
-//	@Success		200	{object}	dto.UserDto
+//	@Param			page		query		int		false	"page, starts at 1"
+//	@Param			pageSize	query		int		false	"page size, at most 100"
+//	@Param			type		query		string	false	"event type"
+//	@Success		200			{object}	dto.SecurityEventPageDto
 //	@Failure		400
-//	@Failure		404
+//	@Failure		401
 //	@Failure		500
-//	@Param			oib	path	string	true	"user oib"
-//	@Router			/user/oib/{oib} [get]
-func (u *UserCtn) getUserByOib(c *gin.Context) {
-	oib := c.Param("oib")
-	if oib == "" {
-		u.logger.Errorf("OIB parameter is empty")
-		c.AbortWithError(http.StatusBadRequest, errors.New("OIB parameter is required"))
+//	@Router			/user/me/security-events [get]
+func (u *UserCtn) mySecurityEvents(c *gin.Context) {
+	claims, _ := auth.GetClaims(c)
+	userUuid, err := uuid.Parse(claims.ID)
+	if err != nil {
+		u.logger.Errorf("Error parsing UUID = %s", err)
+		c.AbortWithError(http.StatusBadRequest, err)
 		return
 	}
 
-	user, err := u.UserCrud.GetUserByOIB(oib)
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			u.logger.Errorf("User with OIB = %s not found", oib)
-			c.AbortWithError(http.StatusNotFound, err)
-			return
-		}
+	u.listSecurityEvents(c, &userUuid)
+}
 
-		u.logger.Errorf("Failed to get user with OIB = %s", oib)
+// securityEvents godoc
+//
+//	@Summary		Security events of all users
+//	@Description	returns security events newest first, optionally of a single user
+//	@Tags			user
+//	@Produce		json
+//	@Param			page		query		int		false	"page, starts at 1"
+//	@Param			pageSize	query		int		false	"page size, at most 100"
+//	@Param			type		query		string	false	"event type"
+//	@Param			userUuid	query		string	false	"user uuid"
+//	@Success		200			{object}	dto.SecurityEventPageDto
+//	@Failure		400
+//	@Failure		403
+//	@Failure		500
+//	@Router			/user/security-events [get]
+func (u *UserCtn) securityEvents(c *gin.Context) {
+	u.listSecurityEvents(c, nil)
+}
+
+// listSecurityEvents responds with a page of events of userUuid, or of the userUuid query param when nil
+func (u *UserCtn) listSecurityEvents(c *gin.Context, userUuid *uuid.UUID) {
+	var query dto.SecurityEventQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		u.logger.Debugf("Invalid security event query, err = %+v", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	if userUuid == nil && query.UserUuid != "" {
+		parsed := uuid.MustParse(query.UserUuid)
+		userUuid = &parsed
+	}
+
+	filter := service.SecurityEventFilter{
+		Page:     query.Page,
+		PageSize: query.PageSize,
+		UserUuid: userUuid,
+		Type:     model.SecurityEventType(query.Type),
+	}.Normalized()
+	events, total, err := u.Events.List(c.Request.Context(), filter)
+	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
-	dto := dto.UserDto{}
-	c.JSON(http.StatusOK, dto.FromModel(user))
+	c.JSON(http.StatusOK, dto.SecurityEventPageDto{}.FromModel(events, filter.Page, filter.PageSize, total))
 }
@@ -0,0 +1,66 @@
+package dto
+
+import (
+	"github.com/killi1812/cloudflared-web-gui/model"
+)
+
+type GroupMemberDto struct {
+	Uuid     string `json:"uuid"`
+	Username string `json:"username"`
+}
+
+type GroupDto struct {
+	Uuid        string           `json:"uuid"`
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Roles       []string         `json:"roles"`
+	Members     []GroupMemberDto `json:"members"`
+}
+
+// FromModel returns a dto from model struct
+func (GroupDto) FromModel(m *model.Group) GroupDto {
+	dto := GroupDto{
+		Uuid:        m.Uuid.String(),
+		Name:        m.Name,
+		Description: m.Description,
+		Roles:       make([]string, 0, len(m.Roles)),
+		Members:     make([]GroupMemberDto, 0, len(m.Members)),
+	}
+	for _, r := range m.RoleList() {
+		dto.Roles = append(dto.Roles, string(r))
+	}
+	for _, u := range m.Members {
+		dto.Members = append(dto.Members, GroupMemberDto{Uuid: u.Uuid.String(), Username: u.Username})
+	}
+	return dto
+}
+
+type NewGroupDto struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description" binding:"max=255"`
+	Roles       []string `json:"roles"`
+}
+
+// ToModel create a model from a dto
+func (dto NewGroupDto) ToModel() *model.Group {
+	group := &model.Group{
+		Name:        dto.Name,
+		Description: dto.Description,
+	}
+	group.SetRoles(ToRoles(dto.Roles))
+	return group
+}
+
+type UpdateGroupDto struct {
+	Description string   `json:"description" binding:"max=255"`
+	Roles       []string `json:"roles"`
+}
+
+// ToRoles converts role names to model.UserRole
+func ToRoles(names []string) []model.UserRole {
+	roles := make([]model.UserRole, 0, len(names))
+	for _, name := range names {
+		roles = append(roles, model.UserRole(name))
+	}
+	return roles
+}
@@ -0,0 +1,44 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/model"
+)
+
+type HealthCheckDto struct {
+	Status     string    `json:"status"`
+	Message    string    `json:"message,omitempty"`
+	DurationMs float64   `json:"durationMs"`
+	CheckedAt  time.Time `json:"checkedAt"`
+}
+
+type HealthDto struct {
+	Status string                    `json:"status"`
+	Checks map[string]HealthCheckDto `json:"checks,omitempty"`
+}
+
+// FromModel converts a model.HealthReport to a HealthDto
+func (dto *HealthDto) FromModel(report model.HealthReport) *HealthDto {
+	dto.Status = string(report.Status)
+	dto.Checks = make(map[string]HealthCheckDto, len(report.Checks))
+	for name, check := range report.Checks {
+		dto.Checks[name] = HealthCheckDto{
+			Status:     string(check.Status),
+			Message:    check.Message,
+			DurationMs: float64(check.Duration.Microseconds()) / 1e3,
+			CheckedAt:  check.CheckedAt,
+		}
+	}
+	return dto
+}
+
+// Public drops messages of checks, they name paths, tunnels and versions that
+// unauthenticated callers shouldn't see
+func (dto *HealthDto) Public() *HealthDto {
+	for name, check := range dto.Checks {
+		check.Message = ""
+		dto.Checks[name] = check
+	}
+	return dto
+}
@@ -0,0 +1,30 @@
+package dto_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/killi1812/cloudflared-web-gui/dto"
+	"github.com/killi1812/cloudflared-web-gui/model"
+)
+
+func TestHealthDto_Public(t *testing.T) {
+	report := model.HealthReport{
+		Status: model.HEALTH_FAIL,
+		Checks: map[string]model.HealthCheck{
+			"config_dir":  {Status: model.HEALTH_OK, Message: "/config is writable", CheckedAt: time.Now()},
+			"credentials": {Status: model.HEALTH_FAIL, Message: "credentials missing for tunnels 6f1c"},
+		},
+	}
+
+	result := new(dto.HealthDto).FromModel(report).Public()
+
+	assert.Equal(t, string(model.HEALTH_FAIL), result.Status)
+	assert.Len(t, result.Checks, 2)
+	assert.Equal(t, string(model.HEALTH_FAIL), result.Checks["credentials"].Status)
+	for name, check := range result.Checks {
+		assert.Empty(t, check.Message, name)
+	}
+}
@@ -0,0 +1,56 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/model"
+)
+
+type InvitationDto struct {
+	Uuid          string    `json:"uuid"`
+	Role          string    `json:"role"`
+	Email         string    `json:"email,omitempty"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+	CreatedByUuid string    `json:"createdByUuid"`
+	CreatedAt     time.Time `json:"createdAt"`
+	// Token and Link are only returned when the invitation is created
+	Token string `json:"token,omitempty"`
+	Link  string `json:"link,omitempty"`
+}
+
+// FromModel returns a dto from model struct
+func (InvitationDto) FromModel(m *model.Invitation) InvitationDto {
+	return InvitationDto{
+		Uuid:          m.Uuid.String(),
+		Role:          string(m.Role),
+		Email:         m.Email,
+		ExpiresAt:     m.ExpiresAt,
+		CreatedByUuid: m.CreatedByUuid.String(),
+		CreatedAt:     m.CreatedAt,
+	}
+}
+
+type NewInvitationDto struct {
+	Role  string `json:"role" binding:"required"`
+	Email string `json:"email" binding:"omitempty,email,max=255"`
+	// ExpiresAt defaults to INVITE_TTL from now
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// ToModel create a model from a dto
+func (dto NewInvitationDto) ToModel() *model.Invitation {
+	invitation := &model.Invitation{
+		Role:  model.UserRole(dto.Role),
+		Email: dto.Email,
+	}
+	if dto.ExpiresAt != nil {
+		invitation.ExpiresAt = *dto.ExpiresAt
+	}
+	return invitation
+}
+
+type AcceptInvitationDto struct {
+	Token    string `json:"token" binding:"required"`
+	Username string `json:"username" binding:"required,min=2,max=100"`
+	Password string `json:"password" binding:"required"`
+}
@@ -0,0 +1,5 @@
+package dto
+
+type LogLevelDto struct {
+	Level string `json:"level" binding:"required,oneof=debug info warn error"`
+}
@@ -2,6 +2,7 @@ package dto
 
 import (
 	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
 	"github.com/killi1812/cloudflared-web-gui/util/cerror"
 
 	"github.com/google/uuid"
@@ -11,13 +12,13 @@ import (
 type NewUserDto struct {
 	Uuid     string `json:"uuid"`
 	Username string `json:"username" binding:"required,min=2,max=100"`
-	Password string `json:"password" binding:"required,min=6"`
-	Role     string `json:"role" binding:"required,oneof=admin user superadmin"`
+	Password string `json:"password" binding:"required"`
+	Role     string `json:"role" binding:"required"`
 }
 
 // ToModel create a model from a dto
 func (dto NewUserDto) ToModel() (*model.User, error) {
-	role, err := model.StrToUserRole(dto.Role)
+	role, err := auth.ParseRole(dto.Role)
 	if err != nil {
 		zap.S().Error("Failed to parse role = %+v, err = %+v", dto.Role, err)
 		return nil, cerror.ErrUnknownRole
@@ -29,6 +30,10 @@ func (dto NewUserDto) ToModel() (*model.User, error) {
 			return nil, cerror.ErrBadUuid
 		}
 	}
+	if err := auth.ValidatePassword(dto.Password); err != nil {
+		zap.S().Debugf("Password rejected by policy, err = %+v", err)
+		return nil, err
+	}
 
 	return &model.User{
 		Uuid:     uuid.New(),
@@ -68,6 +68,16 @@ func TestNewUserDto_ToModel(t *testing.T) {
 			want:    nil,
 			wantErr: cerror.ErrBadUuid,
 		},
+		{
+			name: "Password rejected by policy",
+			dto: dto.NewUserDto{
+				Username: "test",
+				Role:     string(model.ROLE_USER),
+				Password: "short",
+			},
+			want:    nil,
+			wantErr: cerror.ErrWeakPassword,
+		},
 	}
 
 	for _, tt := range tests {
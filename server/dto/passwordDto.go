@@ -0,0 +1,11 @@
+package dto
+
+type ChangePasswordDto struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+}
+
+type ResetPasswordDto struct {
+	Password    string `json:"password" binding:"required"`
+	ForceChange bool   `json:"forceChange"` // ForceChange requires the user to change the password after the next login
+}
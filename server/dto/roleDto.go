@@ -0,0 +1,56 @@
+package dto
+
+import (
+	"github.com/killi1812/cloudflared-web-gui/model"
+)
+
+type RoleDto struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Builtin     bool     `json:"builtin"`
+	Permissions []string `json:"permissions"`
+}
+
+// FromModel returns a dto from model struct
+func (RoleDto) FromModel(m *model.Role) RoleDto {
+	dto := RoleDto{
+		Name:        string(m.Name),
+		Description: m.Description,
+		Builtin:     m.Builtin,
+		Permissions: make([]string, 0, len(m.Permissions)),
+	}
+	for _, p := range m.PermissionList() {
+		dto.Permissions = append(dto.Permissions, string(p))
+	}
+	return dto
+}
+
+type NewRoleDto struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description" binding:"max=255"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+// ToModel create a model from a dto
+func (dto NewRoleDto) ToModel() *model.Role {
+	role := &model.Role{
+		Name:        model.UserRole(dto.Name),
+		Description: dto.Description,
+	}
+	role.SetPermissions(ToPermissions(dto.Permissions))
+	return role
+}
+
+type UpdateRoleDto struct {
+	Description string   `json:"description" binding:"max=255"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+// ToPermissions converts permission names to model.Permission
+func ToPermissions(names []string) []model.Permission {
+	permissions := make([]model.Permission, 0, len(names))
+	for _, name := range names {
+		permissions = append(permissions, model.Permission(name))
+	}
+	return permissions
+}
@@ -0,0 +1,62 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/model"
+)
+
+type SecurityEventDto struct {
+	Type      string    `json:"type"`
+	UserUuid  string    `json:"userUuid,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	Ip        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// FromModel returns a dto from model struct
+func (SecurityEventDto) FromModel(m *model.SecurityEvent) SecurityEventDto {
+	dto := SecurityEventDto{
+		Type:      string(m.Type),
+		Username:  m.Username,
+		Ip:        m.Ip,
+		UserAgent: m.UserAgent,
+		Reason:    m.Reason,
+		CreatedAt: m.CreatedAt,
+	}
+	if m.UserUuid != nil {
+		dto.UserUuid = m.UserUuid.String()
+	}
+	return dto
+}
+
+// SecurityEventQuery are query params of security event lists, UserUuid is ignored for the callers own events
+type SecurityEventQuery struct {
+	Page     int    `form:"page" binding:"omitempty,min=1"`
+	PageSize int    `form:"pageSize" binding:"omitempty,min=1,max=100"`
+	Type     string `form:"type"`
+	UserUuid string `form:"userUuid" binding:"omitempty,uuid"`
+}
+
+type SecurityEventPageDto struct {
+	Items    []SecurityEventDto `json:"items"`
+	Page     int                `json:"page"`
+	PageSize int                `json:"pageSize"`
+	Total    int64              `json:"total"`
+}
+
+// FromModel returns a page of security events
+func (SecurityEventPageDto) FromModel(events []model.SecurityEvent, page, pageSize int, total int64) SecurityEventPageDto {
+	dto := SecurityEventPageDto{
+		Items:    make([]SecurityEventDto, 0, len(events)),
+		Page:     page,
+		PageSize: pageSize,
+		Total:    total,
+	}
+	for i := range events {
+		dto.Items = append(dto.Items, SecurityEventDto{}.FromModel(&events[i]))
+	}
+	return dto
+}
@@ -5,4 +5,5 @@ type ServerInfoDto struct {
 	Version        string `json:"version"`
 	CommitHash     string `json:"commitHash"`
 	BuildTimestamp string `json:"buildTimestamp"`
+	AuthMode       string `json:"authMode"`
 }
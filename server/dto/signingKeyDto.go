@@ -0,0 +1,28 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/model"
+)
+
+type SigningKeyDto struct {
+	Kid     string `json:"kid"`
+	Purpose string `json:"purpose"`
+}
+
+// KeyRotationDto lists the new active keys, tokens signed with the previous keys stay valid until RetiredUntil
+type KeyRotationDto struct {
+	Keys         []SigningKeyDto `json:"keys"`
+	RetiredUntil time.Time       `json:"retiredUntil"`
+}
+
+// FromModel converts newly created keys to a KeyRotationDto
+func (dto *KeyRotationDto) FromModel(keys []model.SigningKey, retiredUntil time.Time) *KeyRotationDto {
+	dto.Keys = make([]SigningKeyDto, 0, len(keys))
+	for _, key := range keys {
+		dto.Keys = append(dto.Keys, SigningKeyDto{Kid: key.Kid, Purpose: string(key.Purpose)})
+	}
+	dto.RetiredUntil = retiredUntil
+	return dto
+}
@@ -0,0 +1,77 @@
+package dto
+
+import (
+	"github.com/killi1812/cloudflared-web-gui/model"
+)
+
+type TunnelAccessUserDto struct {
+	Uuid     string `json:"uuid"`
+	Username string `json:"username"`
+}
+
+type TunnelAccessGroupDto struct {
+	Uuid string `json:"uuid"`
+	Name string `json:"name"`
+}
+
+// TunnelGrantDto is a grant to either a user or a group
+type TunnelGrantDto struct {
+	User  *TunnelAccessUserDto  `json:"user,omitempty"`
+	Group *TunnelAccessGroupDto `json:"group,omitempty"`
+	Level string                `json:"level"`
+}
+
+// TunnelAccessDto lists who can access a tunnel, the tunnel is owned by Owner or OwnerGroup,
+// both are nil for tunnels created outside of the app
+type TunnelAccessDto struct {
+	Owner      *TunnelAccessUserDto  `json:"owner"`
+	OwnerGroup *TunnelAccessGroupDto `json:"ownerGroup"`
+	Grants     []TunnelGrantDto      `json:"grants"`
+}
+
+// FromModel returns a dto from the tunnel owner and grants
+func (TunnelAccessDto) FromModel(owner *model.TunnelOwner, grants []model.TunnelGrant) TunnelAccessDto {
+	dto := TunnelAccessDto{Grants: make([]TunnelGrantDto, 0, len(grants))}
+	if owner != nil {
+		dto.Owner = accessUserDto(owner.OwnerUser)
+		dto.OwnerGroup = accessGroupDto(owner.OwnerGroup)
+	}
+	for _, grant := range grants {
+		if grant.User == nil && grant.Group == nil {
+			continue
+		}
+		dto.Grants = append(dto.Grants, TunnelGrantDto{
+			User:  accessUserDto(grant.User),
+			Group: accessGroupDto(grant.Group),
+			Level: string(grant.Level),
+		})
+	}
+	return dto
+}
+
+func accessUserDto(user *model.User) *TunnelAccessUserDto {
+	if user == nil {
+		return nil
+	}
+	return &TunnelAccessUserDto{Uuid: user.Uuid.String(), Username: user.Username}
+}
+
+func accessGroupDto(group *model.Group) *TunnelAccessGroupDto {
+	if group == nil {
+		return nil
+	}
+	return &TunnelAccessGroupDto{Uuid: group.Uuid.String(), Name: group.Name}
+}
+
+// TunnelOwnerDto sets either a user or a group as the owner
+type TunnelOwnerDto struct {
+	UserUuid  string `json:"userUuid" binding:"required_without=GroupUuid,excluded_with=GroupUuid,omitempty,uuid"`
+	GroupUuid string `json:"groupUuid" binding:"required_without=UserUuid,excluded_with=UserUuid,omitempty,uuid"`
+}
+
+// TunnelGrantRequestDto grants access to either a user or a group
+type TunnelGrantRequestDto struct {
+	UserUuid  string `json:"userUuid" binding:"required_without=GroupUuid,excluded_with=GroupUuid,omitempty,uuid"`
+	GroupUuid string `json:"groupUuid" binding:"required_without=UserUuid,excluded_with=UserUuid,omitempty,uuid"`
+	Level     string `json:"level" binding:"required,oneof=viewer operator admin"`
+}
@@ -10,6 +10,7 @@ type TunnelDto struct {
 	Name       string          `json:"name"`
 	DnsRecords ArrDnsRecordDto `json:"dnsRecords"`
 	IsRunning  bool            `json:"isRunning"`
+	Access     string          `json:"access"` // Access is the callers access level on the tunnel
 
 	CreatedAt string `json:"created_at"`
 	DeletedAt string `json:"deleted_at"`
@@ -2,8 +2,10 @@ package dto
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
 	"github.com/killi1812/cloudflared-web-gui/util/cerror"
 
 	"github.com/google/uuid"
@@ -11,10 +13,16 @@ import (
 )
 
 type UserDto struct {
-	Uuid        string `json:"uuid"`
-	Username    string `json:"username"`
-	Role        string `json:"role"`
-	PoliceToken string `json:"policeToken"`
+	Uuid        string     `json:"uuid"`
+	Username    string     `json:"username"`
+	Email       string     `json:"email"`
+	Role        string     `json:"role"`
+	PoliceToken string     `json:"policeToken"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty"`
+
+	MustChangePassword bool     `json:"mustChangePassword"`
+	Groups             []string `json:"groups"`
+	Capabilities       []string `json:"capabilities,omitempty"` // Capabilities are only returned for the logged in user
 }
 
 func (dto UserDto) ToModel() (*model.User, error) {
@@ -24,7 +32,7 @@ func (dto UserDto) ToModel() (*model.User, error) {
 		return nil, cerror.ErrBadUuid
 	}
 
-	role, err := model.StrToUserRole(dto.Role)
+	role, err := auth.ParseRole(dto.Role)
 	if err != nil {
 		zap.S().Errorf("Failed to parse role = %+v, err = %+v", dto.Role, err)
 		return nil, cerror.ErrUnknownRole
@@ -39,8 +47,51 @@ func (dto UserDto) ToModel() (*model.User, error) {
 // FromModel returns a dto from model struct
 func (UserDto) FromModel(m *model.User) UserDto {
 	dto := &UserDto{
-		Uuid: m.Uuid.String(),
-		Role: fmt.Sprint(m.Role),
+		Uuid:               m.Uuid.String(),
+		Username:           m.Username,
+		Email:              m.Email,
+		Role:               fmt.Sprint(m.Role),
+		MustChangePassword: m.MustChangePassword,
+		Groups:             m.GroupNames(),
+	}
+	if m.DeletedAt.Valid {
+		dto.DeletedAt = &m.DeletedAt.Time
 	}
 	return *dto
 }
+
+// UserListQuery are query params of the user list
+type UserListQuery struct {
+	Page     int    `form:"page" binding:"omitempty,min=1"`
+	PageSize int    `form:"pageSize" binding:"omitempty,min=1,max=100"`
+	Sort     string `form:"sort" binding:"omitempty,oneof=username email role createdAt"`
+	Order    string `form:"order" binding:"omitempty,oneof=asc desc"`
+	Role     string `form:"role"`
+	Search   string `form:"search" binding:"max=100"`
+	Deleted  bool   `form:"deleted"`
+}
+
+type UserPageDto struct {
+	Items    []UserDto `json:"items"`
+	Page     int       `json:"page"`
+	PageSize int       `json:"pageSize"`
+	Total    int64     `json:"total"`
+}
+
+// FromModel returns a page of users
+func (UserPageDto) FromModel(users []model.User, page, pageSize int, total int64) UserPageDto {
+	dto := UserPageDto{
+		Items:    make([]UserDto, 0, len(users)),
+		Page:     page,
+		PageSize: pageSize,
+		Total:    total,
+	}
+	for i := range users {
+		dto.Items = append(dto.Items, UserDto{}.FromModel(&users[i]))
+	}
+	return dto
+}
+
+type RestoreUserDto struct {
+	Username string `json:"username" binding:"required,min=2,max=100"`
+}
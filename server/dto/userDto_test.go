@@ -71,13 +71,15 @@ func TestUserDto_ToModel(t *testing.T) {
 func TestUserDto_FromModel(t *testing.T) {
 	userUUID := uuid.New()
 	userModel := &model.User{
-		Uuid: userUUID,
-		Role: model.ROLE_USER,
+		Uuid:   userUUID,
+		Role:   model.ROLE_USER,
+		Groups: []model.Group{{Name: "on-call"}},
 	}
 
 	expectedDto := dto.UserDto{
-		Uuid: userUUID.String(),
-		Role: string(model.ROLE_USER),
+		Uuid:   userUUID.String(),
+		Role:   string(model.ROLE_USER),
+		Groups: []string{"on-call"},
 	}
 
 	var gotDto dto.UserDto
@@ -0,0 +1,143 @@
+// Package baseline is a frozen copy of the models as they were when versioned migrations were introduced.
+//
+// It must not change with the models, migration 1 creates exactly these tables so databases
+// created by AutoMigrate can be adopted. Type and field names match the models since gorm
+// derives table, join table and constraint names from them
+package baseline
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Models returns the baseline models in creation order
+func Models() []any {
+	return []any{
+		&User{},
+		&Session{},
+		&SigningKey{},
+		&Role{},
+		&RolePermission{},
+		&Group{},
+		&GroupRole{},
+		&TunnelOwner{},
+		&TunnelGrant{},
+		&Invitation{},
+		&SecurityEvent{},
+	}
+}
+
+type User struct {
+	gorm.Model
+
+	Uuid         string   `gorm:"type:varchar(36);unique;not null"`
+	Username     string   `gorm:"type:varchar(100);not null"`
+	Email        string   `gorm:"type:varchar(255)"`
+	PasswordHash string   `gorm:"type:varchar(255);not null"`
+	Role         string   `gorm:"type:varchar(20);not null"`
+	OidcSubject  *string  `gorm:"type:varchar(255);uniqueIndex"`
+	LdapDn       *string  `gorm:"type:varchar(255);uniqueIndex"`
+	Session      *Session `gorm:"foreignKey:UserId;null"`
+	Groups       []Group  `gorm:"many2many:group_members"`
+
+	MustChangePassword bool `gorm:"not null;default:false"`
+
+	FailedLogins    int        `gorm:"not null;default:0"`
+	LastFailedLogin *time.Time `gorm:"null"`
+	LockedUntil     *time.Time `gorm:"null"`
+}
+
+type Session struct {
+	ID           uint   `gorm:"primarykey"`
+	UserId       uint   `gorm:"unique;not null"`
+	UserUuid     string `gorm:"type:varchar(36);unique;not null"`
+	RefreshToken string `gorm:"type:text;not null"`
+}
+
+type SigningKey struct {
+	gorm.Model
+
+	Kid       string     `gorm:"type:varchar(64);uniqueIndex;not null"`
+	Purpose   string     `gorm:"type:varchar(20);not null"`
+	Algorithm string     `gorm:"type:varchar(20);not null;default:HS256"`
+	Secret    string     `gorm:"type:varchar(255);not null"`
+	Active    bool       `gorm:"not null;default:false"`
+	RetiredAt *time.Time `gorm:"null"`
+	ExpiresAt *time.Time `gorm:"null"`
+}
+
+type Role struct {
+	gorm.Model
+
+	Name        string           `gorm:"type:varchar(20);uniqueIndex;not null"`
+	Description string           `gorm:"type:varchar(255)"`
+	Builtin     bool             `gorm:"not null;default:false"`
+	Permissions []RolePermission `gorm:"foreignKey:RoleId"`
+}
+
+type RolePermission struct {
+	ID         uint   `gorm:"primarykey"`
+	RoleId     uint   `gorm:"not null;uniqueIndex:idx_role_permission"`
+	Permission string `gorm:"type:varchar(50);not null;uniqueIndex:idx_role_permission"`
+}
+
+type Group struct {
+	gorm.Model
+
+	Uuid        string      `gorm:"type:varchar(36);unique;not null"`
+	Name        string      `gorm:"type:varchar(50);uniqueIndex;not null"`
+	Description string      `gorm:"type:varchar(255)"`
+	Roles       []GroupRole `gorm:"foreignKey:GroupId"`
+	Members     []User      `gorm:"many2many:group_members"`
+}
+
+type GroupRole struct {
+	ID      uint   `gorm:"primarykey"`
+	GroupId uint   `gorm:"not null;uniqueIndex:idx_group_role"`
+	Role    string `gorm:"type:varchar(20);not null;uniqueIndex:idx_group_role"`
+}
+
+type TunnelOwner struct {
+	gorm.Model
+
+	TunnelId     string `gorm:"type:varchar(36);uniqueIndex;not null"`
+	OwnerUserId  *uint  `gorm:"index"`
+	OwnerUser    *User  `gorm:"foreignKey:OwnerUserId"`
+	OwnerGroupId *uint  `gorm:"index"`
+	OwnerGroup   *Group `gorm:"foreignKey:OwnerGroupId"`
+}
+
+type TunnelGrant struct {
+	gorm.Model
+
+	TunnelId string `gorm:"type:varchar(36);not null;uniqueIndex:idx_tunnel_grant;uniqueIndex:idx_tunnel_group_grant"`
+	UserId   *uint  `gorm:"uniqueIndex:idx_tunnel_grant"`
+	User     *User  `gorm:"foreignKey:UserId"`
+	GroupId  *uint  `gorm:"uniqueIndex:idx_tunnel_group_grant"`
+	Group    *Group `gorm:"foreignKey:GroupId"`
+	Level    string `gorm:"type:varchar(20);not null"`
+}
+
+type Invitation struct {
+	gorm.Model
+
+	Uuid          string     `gorm:"type:varchar(36);unique;not null"`
+	Role          string     `gorm:"type:varchar(20);not null"`
+	Email         string     `gorm:"type:varchar(255)"`
+	ExpiresAt     time.Time  `gorm:"not null"`
+	CreatedByUuid string     `gorm:"type:varchar(36);not null"`
+	AcceptedAt    *time.Time `gorm:"null"`
+	UserUuid      *string    `gorm:"type:varchar(36);null"`
+}
+
+type SecurityEvent struct {
+	ID        uint      `gorm:"primarykey"`
+	Type      string    `gorm:"type:varchar(30);not null;index"`
+	UserUuid  *string   `gorm:"type:varchar(36);index"`
+	Username  string    `gorm:"type:varchar(100)"`
+	Ip        string    `gorm:"type:varchar(45)"`
+	UserAgent string    `gorm:"type:varchar(255)"`
+	Reason    string    `gorm:"type:varchar(255)"`
+	CreatedAt time.Time `gorm:"not null;index"`
+}
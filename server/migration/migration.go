@@ -0,0 +1,258 @@
+// Package migration applies ordered, versioned schema migrations and records them in the schema_migrations table
+package migration
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Migration is one versioned schema change, Up and Down run in a transaction.
+// MySQL commits DDL implicitly, a failed migration there can be left partly applied
+type Migration struct {
+	Version uint
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error // Down is nil when the migration can't be rolled back
+}
+
+// SchemaMigration records an applied migration
+type SchemaMigration struct {
+	Version   uint      `gorm:"primarykey;autoIncrement:false"`
+	Name      string    `gorm:"type:varchar(100);not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Status is a known migration and when it was applied, AppliedAt is nil when pending
+type Status struct {
+	Version   uint
+	Name      string
+	AppliedAt *time.Time
+}
+
+// Migrator applies and rolls back migrations of a database
+type Migrator struct {
+	db         *gorm.DB
+	logger     *zap.SugaredLogger
+	migrations []Migration
+}
+
+// New returns a Migrator of all migrations
+func New(db *gorm.DB, logger *zap.SugaredLogger) *Migrator {
+	return newMigrator(db, logger, All())
+}
+
+// newMigrator panics when migrations aren't ordered by unique versions starting above zero
+func newMigrator(db *gorm.DB, logger *zap.SugaredLogger, migrations []Migration) *Migrator {
+	var previous uint
+	for _, migration := range migrations {
+		if migration.Version <= previous {
+			logger.Panicf("Migration %d %s is out of order", migration.Version, migration.Name)
+		}
+		previous = migration.Version
+	}
+
+	return &Migrator{
+		db:         db,
+		logger:     logger,
+		migrations: migrations,
+	}
+}
+
+// Latest returns the version of the newest known migration
+func (m *Migrator) Latest() uint {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the newest applied version, zero for an empty database
+func (m *Migrator) Version() (uint, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+
+	var version uint
+	for v := range applied {
+		version = max(version, v)
+	}
+	return version, nil
+}
+
+// Check returns an error when the database has migrations applied that aren't known,
+// cerror.ErrSchemaTooNew when they are newer than Latest
+func (m *Migrator) Check() error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for version, record := range applied {
+		if m.find(version) != nil {
+			continue
+		}
+		if version > m.Latest() {
+			return fmt.Errorf("%w: database is at migration %d %s, latest known is %d", cerror.ErrSchemaTooNew, version, record.Name, m.Latest())
+		}
+		return fmt.Errorf("%w: %d %s", cerror.ErrUnknownMigration, version, record.Name)
+	}
+	return nil
+}
+
+// Pending returns the migrations not applied yet ordered by version
+func (m *Migrator) Pending() ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var rez []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			rez = append(rez, migration)
+		}
+	}
+	return rez, nil
+}
+
+// Status returns every known migration ordered by version
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	rez := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if record, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &record.AppliedAt
+		}
+		rez = append(rez, status)
+	}
+	return rez, nil
+}
+
+// Up applies pending migrations up to and including version target, zero applies all of them.
+// It returns the applied migrations, the ones applied before an error stay applied
+func (m *Migrator) Up(target uint) ([]Migration, error) {
+	if err := m.Check(); err != nil {
+		return nil, err
+	}
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+
+	var rez []Migration
+	for _, migration := range pending {
+		if target != 0 && migration.Version > target {
+			break
+		}
+
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			// the primary key also stops a second instance applying the same migration
+			return tx.Create(&SchemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			m.logger.Errorf("Failed to apply migration %d %s, err = %+v", migration.Version, migration.Name, err)
+			return rez, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+
+		m.logger.Infof("Applied migration %d %s", migration.Version, migration.Name)
+		rez = append(rez, migration)
+	}
+	return rez, nil
+}
+
+// Down rolls back the last steps applied migrations newest first.
+// It returns the rolled back migrations, the ones rolled back before an error stay rolled back
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	if err := m.Check(); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]uint, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	slices.Sort(versions)
+	slices.Reverse(versions)
+
+	var rez []Migration
+	for _, version := range versions[:min(steps, len(versions))] {
+		migration := m.find(version)
+		if migration.Down == nil {
+			return rez, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, cerror.ErrIrreversibleMigration)
+		}
+
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{Version: migration.Version}).Error
+		})
+		if err != nil {
+			m.logger.Errorf("Failed to roll back migration %d %s, err = %+v", migration.Version, migration.Name, err)
+			return rez, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+
+		m.logger.Infof("Rolled back migration %d %s", migration.Version, migration.Name)
+		rez = append(rez, *migration)
+	}
+	return rez, nil
+}
+
+// applied returns the applied migrations by version, creating schema_migrations when missing
+func (m *Migrator) applied() (map[uint]SchemaMigration, error) {
+	if !m.db.Migrator().HasTable(&SchemaMigration{}) {
+		if err := m.db.Migrator().CreateTable(&SchemaMigration{}); err != nil {
+			m.logger.Errorf("Failed to create schema_migrations, err = %+v", err)
+			return nil, err
+		}
+	}
+
+	var records []SchemaMigration
+	if err := m.db.Find(&records).Error; err != nil {
+		m.logger.Errorf("Failed to read schema_migrations, err = %+v", err)
+		return nil, err
+	}
+
+	rez := make(map[uint]SchemaMigration, len(records))
+	for _, record := range records {
+		rez[record.Version] = record
+	}
+	return rez, nil
+}
+
+// find returns the known migration of version or nil
+func (m *Migrator) find(version uint) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
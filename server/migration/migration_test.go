@@ -0,0 +1,244 @@
+package migration
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/killi1812/cloudflared-web-gui/migration/baseline"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newDb(t *testing.T, name string) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+name+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// createTable returns a migration creating table name
+func createTable(version uint, name string) Migration {
+	return Migration{
+		Version: version,
+		Name:    "create " + name,
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("CREATE TABLE " + name + " (id integer PRIMARY KEY)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(name)
+		},
+	}
+}
+
+func versions(migrations []Migration) []uint {
+	var rez []uint
+	for _, migration := range migrations {
+		rez = append(rez, migration.Version)
+	}
+	return rez
+}
+
+func TestMigrator_UpDown(t *testing.T) {
+	db := newDb(t, "migrate_up_down_test.db")
+	migrator := newMigrator(db, zap.S(), []Migration{createTable(1, "a"), createTable(2, "b"), createTable(5, "c")})
+
+	applied, err := migrator.Up(2)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{1, 2}, versions(applied))
+	assert.True(t, db.Migrator().HasTable("b"))
+	assert.False(t, db.Migrator().HasTable("c"))
+
+	status, err := migrator.Status()
+	require.NoError(t, err)
+	require.Len(t, status, 3)
+	assert.NotNil(t, status[1].AppliedAt)
+	assert.Nil(t, status[2].AppliedAt)
+
+	applied, err = migrator.Up(0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{5}, versions(applied))
+	version, err := migrator.Version()
+	require.NoError(t, err)
+	assert.Equal(t, uint(5), version)
+
+	rolledBack, err := migrator.Down(2)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{5, 2}, versions(rolledBack))
+	assert.True(t, db.Migrator().HasTable("a"))
+	assert.False(t, db.Migrator().HasTable("b"))
+
+	pending, err := migrator.Pending()
+	require.NoError(t, err)
+	assert.Equal(t, []uint{2, 5}, versions(pending))
+}
+
+func TestMigrator_FailedMigrationIsNotRecorded(t *testing.T) {
+	db := newDb(t, "migrate_failed_test.db")
+	failing := createTable(2, "b")
+	failing.Up = func(tx *gorm.DB) error {
+		if err := tx.Exec("CREATE TABLE b (id integer PRIMARY KEY)").Error; err != nil {
+			return err
+		}
+		return errors.New("boom")
+	}
+	migrator := newMigrator(db, zap.S(), []Migration{createTable(1, "a"), failing})
+
+	applied, err := migrator.Up(0)
+	assert.ErrorContains(t, err, "migration 2 create b: boom")
+	assert.Equal(t, []uint{1}, versions(applied))
+	assert.False(t, db.Migrator().HasTable("b"), "the failed migration is rolled back")
+
+	version, err := migrator.Version()
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), version)
+}
+
+func TestMigrator_NewerSchemaIsRefused(t *testing.T) {
+	db := newDb(t, "migrate_newer_test.db")
+	newer := newMigrator(db, zap.S(), []Migration{createTable(1, "a"), createTable(2, "b")})
+	_, err := newer.Up(0)
+	require.NoError(t, err)
+
+	older := newMigrator(db, zap.S(), []Migration{createTable(1, "a")})
+	assert.ErrorIs(t, older.Check(), cerror.ErrSchemaTooNew)
+	_, err = older.Up(0)
+	assert.ErrorIs(t, err, cerror.ErrSchemaTooNew)
+	_, err = older.Down(1)
+	assert.ErrorIs(t, err, cerror.ErrSchemaTooNew)
+
+	gap := newMigrator(db, zap.S(), []Migration{createTable(2, "b"), createTable(3, "c")})
+	assert.ErrorIs(t, gap.Check(), cerror.ErrUnknownMigration)
+}
+
+func TestMigrator_IrreversibleMigration(t *testing.T) {
+	db := newDb(t, "migrate_irreversible_test.db")
+	irreversible := createTable(1, "a")
+	irreversible.Down = nil
+	migrator := newMigrator(db, zap.S(), []Migration{irreversible})
+
+	_, err := migrator.Up(0)
+	require.NoError(t, err)
+	_, err = migrator.Down(1)
+	assert.ErrorIs(t, err, cerror.ErrIrreversibleMigration)
+}
+
+func TestNewMigrator_OutOfOrderPanics(t *testing.T) {
+	db := newDb(t, "migrate_order_test.db")
+	assert.Panics(t, func() {
+		newMigrator(db, zap.S(), []Migration{createTable(2, "b"), createTable(1, "a")})
+	})
+	assert.NotPanics(t, func() { New(db, zap.S()) })
+}
+
+// TestMigrations_MatchModels fails when a model changed without a migration
+func TestMigrations_MatchModels(t *testing.T) {
+	db := newDb(t, "migrate_models_test.db")
+	_, err := New(db, zap.S()).Up(0)
+	require.NoError(t, err)
+
+	for _, value := range model.GetAllModels() {
+		stmt := &gorm.Statement{DB: db}
+		require.NoError(t, stmt.Parse(value))
+		require.True(t, db.Migrator().HasTable(stmt.Table), "table %s", stmt.Table)
+
+		var want []string
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !field.IgnoreMigration {
+				want = append(want, field.DBName)
+			}
+		}
+		columns, err := db.Migrator().ColumnTypes(stmt.Table)
+		require.NoError(t, err)
+		var got []string
+		for _, column := range columns {
+			got = append(got, column.Name())
+		}
+		slices.Sort(want)
+		slices.Sort(got)
+		assert.Equal(t, want, got, "columns of %s", stmt.Table)
+
+		for _, index := range stmt.Schema.ParseIndexes() {
+			assert.True(t, db.Migrator().HasIndex(stmt.Table, index.Name), "index %s of %s", index.Name, stmt.Table)
+		}
+	}
+}
+
+// TestBaseline_AdoptsAutoMigrate adopts a database created by AutoMigrate before versioned migrations
+func TestBaseline_AdoptsAutoMigrate(t *testing.T) {
+	db := newDb(t, "migrate_adopt_test.db")
+	require.NoError(t, db.AutoMigrate(model.GetAllModels()...))
+	require.NoError(t, db.Create(&model.User{Username: "kept", PasswordHash: "hash", Role: model.ROLE_USER}).Error)
+
+	migrator := New(db, zap.S())
+	applied, err := migrator.Up(0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{1, 2}, versions(applied))
+
+	var count int64
+	require.NoError(t, db.Model(&model.User{}).Where("username = ?", "kept").Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	_, err = migrator.Down(2)
+	require.NoError(t, err)
+	for _, value := range baseline.Models() {
+		assert.False(t, db.Migrator().HasTable(value))
+	}
+	assert.False(t, db.Migrator().HasTable("group_members"))
+}
+
+func TestDropLegacySessions(t *testing.T) {
+	db := newDb(t, "legacy_sessions_test.db")
+
+	// sessions as created before they had an id, with a foreign key mentioning users(id)
+	require.NoError(t, db.Exec("CREATE TABLE `users` (`id` integer PRIMARY KEY AUTOINCREMENT)").Error)
+	require.NoError(t, db.Exec("CREATE TABLE `sessions` (`user_id` uint NOT NULL UNIQUE, `user_uuid` uuid NOT NULL UNIQUE, "+
+		"`refresh_token` varchar(350) NOT NULL, CONSTRAINT `fk_users_session` FOREIGN KEY (`user_id`) REFERENCES `users`(`id`))").Error)
+
+	require.NoError(t, dropLegacySessions(db))
+	assert.False(t, db.Migrator().HasTable(&baseline.Session{}))
+
+	require.NoError(t, db.AutoMigrate(&baseline.Session{}))
+	require.NoError(t, dropLegacySessions(db))
+	assert.True(t, db.Migrator().HasTable(&baseline.Session{}), "sessions with an id are kept")
+}
+
+func TestGrantApiWrite(t *testing.T) {
+	db := newDb(t, "grant_api_write_test.db")
+	require.NoError(t, db.AutoMigrate(baseline.Models()...))
+	hasWrite := func(name string) bool {
+		var count int64
+		require.NoError(t, db.Model(&baseline.RolePermission{}).
+			Joins("JOIN roles ON roles.id = role_permissions.role_id").
+			Where("roles.name = ? AND permission = ?", name, "api:write").Count(&count).Error)
+		return count == 1
+	}
+
+	// roles of a database created before read only roles
+	require.NoError(t, db.Create(&baseline.Role{Name: "user", Permissions: []baseline.RolePermission{{Permission: "tunnel:read"}}}).Error)
+	require.NoError(t, db.Create(&baseline.Role{Name: "admin", Permissions: []baseline.RolePermission{{Permission: "api:write"}}}).Error)
+
+	require.NoError(t, grantApiWriteUp(db))
+	assert.True(t, hasWrite("user"))
+	assert.True(t, hasWrite("admin"))
+
+	// once the viewer role exists new roles stay read only
+	require.NoError(t, db.Create(&baseline.Role{Name: "viewer", Permissions: []baseline.RolePermission{{Permission: "tunnel:read"}}}).Error)
+	require.NoError(t, db.Create(&baseline.Role{Name: "auditor"}).Error)
+	require.NoError(t, grantApiWriteUp(db))
+	assert.False(t, hasWrite("viewer"))
+	assert.False(t, hasWrite("auditor"))
+}
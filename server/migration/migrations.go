@@ -0,0 +1,98 @@
+package migration
+
+import (
+	"github.com/killi1812/cloudflared-web-gui/migration/baseline"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// All returns every migration ordered by version.
+//
+// NOTE: add a schema change as a new migration with the next version and update the models to match,
+// never edit a migration that was released, databases already recorded it as applied
+func All() []Migration {
+	return []Migration{
+		{Version: 1, Name: "baseline", Up: baselineUp, Down: baselineDown},
+		{Version: 2, Name: "grant api write", Up: grantApiWriteUp, Down: grantApiWriteDown},
+	}
+}
+
+// baselineUp creates the schema AutoMigrate created before versioned migrations.
+// AutoMigrate leaves existing tables as they are, so databases created by it are adopted as the baseline
+func baselineUp(tx *gorm.DB) error {
+	if err := dropLegacySessions(tx); err != nil {
+		return err
+	}
+	return tx.AutoMigrate(baseline.Models()...)
+}
+
+// baselineDown drops the baseline tables, dependent tables first
+func baselineDown(tx *gorm.DB) error {
+	if err := tx.Migrator().DropTable("group_members"); err != nil {
+		return err
+	}
+	models := baseline.Models()
+	for i := len(models) - 1; i >= 0; i-- {
+		if err := tx.Migrator().DropTable(models[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropLegacySessions drops the sessions table created before sessions had an id,
+// a primary key can't be added to an existing sqlite table. Users have to log in again
+func dropLegacySessions(tx *gorm.DB) error {
+	migrator := tx.Migrator()
+	if !migrator.HasTable(&baseline.Session{}) {
+		return nil
+	}
+
+	// HasColumn of sqlite also matches the id in the foreign key to users
+	columns, err := migrator.ColumnTypes(&baseline.Session{})
+	if err != nil {
+		return err
+	}
+	for _, column := range columns {
+		if column.Name() == "id" {
+			return nil
+		}
+	}
+
+	zap.S().Infof("Dropping sessions table without an id, users have to log in again")
+	return migrator.DropTable(&baseline.Session{})
+}
+
+// grantApiWriteUp gives api:write to the roles of a database created before read only roles existed,
+// those roles could change things before. A database with the viewer role already has read only roles
+func grantApiWriteUp(tx *gorm.DB) error {
+	var viewers int64
+	if err := tx.Model(&baseline.Role{}).Where("name = ?", "viewer").Count(&viewers).Error; err != nil {
+		return err
+	}
+	if viewers > 0 {
+		return nil
+	}
+
+	var roleIds []uint
+	writers := tx.Model(&baseline.RolePermission{}).Select("role_id").Where("permission = ?", "api:write")
+	if err := tx.Model(&baseline.Role{}).Where("id NOT IN (?)", writers).Pluck("id", &roleIds).Error; err != nil {
+		return err
+	}
+	if len(roleIds) == 0 {
+		return nil
+	}
+
+	granted := make([]baseline.RolePermission, 0, len(roleIds))
+	for _, id := range roleIds {
+		granted = append(granted, baseline.RolePermission{RoleId: id, Permission: "api:write"})
+	}
+	zap.S().Infof("Granting api:write to %d roles created before read only roles", len(granted))
+	return tx.Create(&granted).Error
+}
+
+// grantApiWriteDown keeps the granted permissions, they can't be told apart from ones granted by an administrator
+func grantApiWriteDown(tx *gorm.DB) error {
+	return nil
+}
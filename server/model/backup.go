@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// BACKUP_FORMAT is the layout version of backup archives, restore rejects other versions
+const BACKUP_FORMAT = 1
+
+// BackupManifest describes a backup archive, it is stored in the archive as manifest.json
+type BackupManifest struct {
+	Format        int          `json:"format"`
+	AppVersion    string       `json:"appVersion"`
+	CommitHash    string       `json:"commitHash"`
+	SchemaVersion uint         `json:"schemaVersion"` // SchemaVersion is the newest migration applied to the database
+	CreatedAt     time.Time    `json:"createdAt"`
+	Files         []BackupFile `json:"files"`
+}
+
+// BackupFile is a file in a backup archive
+type BackupFile struct {
+	Name   string `json:"name"` // Name is the path in the archive, database.sqlite or config/<file>
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"` // Sha256 is hex encoded
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Group is a team of users, members get the permissions of every role of the group
+// in addition to their own role and access to tunnels owned by or shared with the group
+type Group struct {
+	gorm.Model
+
+	Uuid        uuid.UUID   `gorm:"type:varchar(36);unique;not null"`
+	Name        string      `gorm:"type:varchar(50);uniqueIndex;not null"`
+	Description string      `gorm:"type:varchar(255)"`
+	Roles       []GroupRole `gorm:"foreignKey:GroupId"`
+	Members     []User      `gorm:"many2many:group_members"`
+}
+
+type GroupRole struct {
+	ID      uint     `gorm:"primarykey"`
+	GroupId uint     `gorm:"not null;uniqueIndex:idx_group_role"`
+	Role    UserRole `gorm:"type:varchar(20);not null;uniqueIndex:idx_group_role"`
+}
+
+// RoleList returns roles of the group
+func (g *Group) RoleList() []UserRole {
+	roles := make([]UserRole, 0, len(g.Roles))
+	for _, r := range g.Roles {
+		roles = append(roles, r.Role)
+	}
+	return roles
+}
+
+// SetRoles replaces roles of the group, the group has to be saved afterwards
+func (g *Group) SetRoles(roles []UserRole) {
+	g.Roles = make([]GroupRole, 0, len(roles))
+	seen := make(map[UserRole]bool)
+	for _, r := range roles {
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+		g.Roles = append(g.Roles, GroupRole{GroupId: g.ID, Role: r})
+	}
+}
+
+// GroupNames returns names of the loaded groups of the user
+func (u *User) GroupNames() []string {
+	names := make([]string, 0, len(u.Groups))
+	for _, g := range u.Groups {
+		names = append(names, g.Name)
+	}
+	return names
+}
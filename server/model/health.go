@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+type HealthStatus string
+
+const (
+	HEALTH_OK   HealthStatus = "ok"
+	HEALTH_FAIL HealthStatus = "fail"
+)
+
+// HealthCheck is the result of checking one dependency
+type HealthCheck struct {
+	Status    HealthStatus
+	Message   string
+	Duration  time.Duration
+	CheckedAt time.Time
+}
+
+// HealthReport holds checks by name, it fails when any check fails
+type HealthReport struct {
+	Status HealthStatus
+	Checks map[string]HealthCheck
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Invitation lets a new user create their own account with Role, it is deleted when revoked
+type Invitation struct {
+	gorm.Model
+
+	Uuid          uuid.UUID  `gorm:"type:varchar(36);unique;not null"`
+	Role          UserRole   `gorm:"type:varchar(20);not null"`
+	Email         string     `gorm:"type:varchar(255)"` // Email is set on the created user
+	ExpiresAt     time.Time  `gorm:"not null"`
+	CreatedByUuid uuid.UUID  `gorm:"type:varchar(36);not null"`
+	AcceptedAt    *time.Time `gorm:"null"`
+	UserUuid      *uuid.UUID `gorm:"type:varchar(36);null"` // UserUuid is the user created by accepting the invitation
+}
+
+// Pending reports whether the invitation can still be accepted
+func (i *Invitation) Pending(now time.Time) bool {
+	return i.AcceptedAt == nil && !i.DeletedAt.Valid && now.Before(i.ExpiresAt)
+}
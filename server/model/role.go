@@ -0,0 +1,107 @@
+package model
+
+import "gorm.io/gorm"
+
+type Permission string
+
+const (
+	PERM_API_WRITE       Permission = "api:write"  // PERM_API_WRITE allows any request other than GET, roles without it are read only
+	PERM_TUNNEL_ALL      Permission = "tunnel:all" // PERM_TUNNEL_ALL gives admin access to every tunnel regardless of its owner and grants
+	PERM_TUNNEL_READ     Permission = "tunnel:read"
+	PERM_TUNNEL_READ_ALL Permission = "tunnel:read-all" // PERM_TUNNEL_READ_ALL gives viewer access to every tunnel the caller has no higher access to
+	PERM_TUNNEL_CREATE   Permission = "tunnel:create"
+	PERM_TUNNEL_START    Permission = "tunnel:start" // PERM_TUNNEL_START allows starting, stopping and restarting tunnels
+	PERM_TUNNEL_DELETE   Permission = "tunnel:delete"
+	PERM_DNS_WRITE       Permission = "dns:write"
+	PERM_USER_MANAGE     Permission = "user:manage"
+	PERM_GROUP_MANAGE    Permission = "group:manage"
+	PERM_ROLE_MANAGE     Permission = "role:manage"
+	PERM_KEYS_ROTATE     Permission = "keys:rotate"
+	PERM_CONFIG_READ     Permission = "config:read" // PERM_CONFIG_READ allows reading the effective server config
+	PERM_LOG_MANAGE      Permission = "log:manage"  // PERM_LOG_MANAGE allows reading and changing the log level at runtime
+)
+
+// ALL_PERMISSIONS lists every permission known to the app
+var ALL_PERMISSIONS = []Permission{
+	PERM_API_WRITE,
+	PERM_TUNNEL_ALL,
+	PERM_TUNNEL_READ,
+	PERM_TUNNEL_READ_ALL,
+	PERM_TUNNEL_CREATE,
+	PERM_TUNNEL_START,
+	PERM_TUNNEL_DELETE,
+	PERM_DNS_WRITE,
+	PERM_USER_MANAGE,
+	PERM_GROUP_MANAGE,
+	PERM_ROLE_MANAGE,
+	PERM_KEYS_ROTATE,
+	PERM_CONFIG_READ,
+	PERM_LOG_MANAGE,
+}
+
+// DefaultRoles returns permissions of the builtin roles, they are seeded into the database on startup.
+// Users only see tunnels they own or were granted access to, admins manage every tunnel
+// and viewers see every tunnel but lack PERM_API_WRITE so they can't change anything
+func DefaultRoles() map[UserRole][]Permission {
+	return map[UserRole][]Permission{
+		ROLE_VIEWER: {
+			PERM_TUNNEL_READ_ALL,
+			PERM_TUNNEL_READ,
+		},
+		ROLE_USER: {
+			PERM_API_WRITE,
+			PERM_TUNNEL_READ,
+			PERM_TUNNEL_START,
+		},
+		ROLE_ADMIN: {
+			PERM_API_WRITE,
+			PERM_TUNNEL_ALL,
+			PERM_TUNNEL_READ,
+			PERM_TUNNEL_CREATE,
+			PERM_TUNNEL_START,
+			PERM_TUNNEL_DELETE,
+			PERM_DNS_WRITE,
+			PERM_USER_MANAGE,
+			PERM_GROUP_MANAGE,
+		},
+		ROLE_SUPER_ADMIN: ALL_PERMISSIONS,
+	}
+}
+
+// Role is a named set of permissions assigned to users
+type Role struct {
+	gorm.Model
+
+	Name        UserRole         `gorm:"type:varchar(20);uniqueIndex;not null"`
+	Description string           `gorm:"type:varchar(255)"`
+	Builtin     bool             `gorm:"not null;default:false"` // Builtin roles are seeded and can't be deleted
+	Permissions []RolePermission `gorm:"foreignKey:RoleId"`
+}
+
+type RolePermission struct {
+	ID         uint       `gorm:"primarykey"`
+	RoleId     uint       `gorm:"not null;uniqueIndex:idx_role_permission"`
+	Permission Permission `gorm:"type:varchar(50);not null;uniqueIndex:idx_role_permission"`
+}
+
+// PermissionList returns permissions of the role
+func (r *Role) PermissionList() []Permission {
+	permissions := make([]Permission, 0, len(r.Permissions))
+	for _, p := range r.Permissions {
+		permissions = append(permissions, p.Permission)
+	}
+	return permissions
+}
+
+// SetPermissions replaces permissions of the role, the role has to be saved afterwards
+func (r *Role) SetPermissions(permissions []Permission) {
+	r.Permissions = make([]RolePermission, 0, len(permissions))
+	seen := make(map[Permission]bool)
+	for _, p := range permissions {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		r.Permissions = append(r.Permissions, RolePermission{RoleId: r.ID, Permission: p})
+	}
+}
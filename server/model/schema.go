@@ -1,11 +1,20 @@
 package model
 
-// NOTE: Here register all models to be used in migration
+// NOTE: Here register all models, the schema itself is changed by a new migration in package migration
 
-// GetAllModels returns an array of all models
+// GetAllModels returns an array of all models, tests create their schema from it
 func GetAllModels() []any {
 	return []any{
 		&User{},
 		&Session{},
+		&SigningKey{},
+		&Role{},
+		&RolePermission{},
+		&Group{},
+		&GroupRole{},
+		&TunnelOwner{},
+		&TunnelGrant{},
+		&Invitation{},
+		&SecurityEvent{},
 	}
 }
@@ -0,0 +1,34 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type SecurityEventType string
+
+const (
+	EVENT_LOGIN_SUCCEEDED    SecurityEventType = "login_succeeded"
+	EVENT_LOGIN_FAILED       SecurityEventType = "login_failed"
+	EVENT_LOGIN_RATE_LIMITED SecurityEventType = "login_rate_limited"
+	EVENT_ACCOUNT_LOCKED     SecurityEventType = "account_locked"
+	EVENT_ACCOUNT_UNLOCKED   SecurityEventType = "account_unlocked"
+	EVENT_TOKEN_REFRESHED    SecurityEventType = "token_refreshed"
+	EVENT_LOGOUT             SecurityEventType = "logout"
+	EVENT_INVITE_CREATED     SecurityEventType = "invite_created"
+	EVENT_INVITE_REVOKED     SecurityEventType = "invite_revoked"
+	EVENT_INVITE_ACCEPTED    SecurityEventType = "invite_accepted"
+)
+
+// SecurityEvent describes a security relevant action, UserUuid is nil when the user is unknown
+type SecurityEvent struct {
+	ID        uint              `gorm:"primarykey"`
+	Type      SecurityEventType `gorm:"type:varchar(30);not null;index"`
+	UserUuid  *uuid.UUID        `gorm:"type:varchar(36);index"`
+	Username  string            `gorm:"type:varchar(100)"`
+	Ip        string            `gorm:"type:varchar(45)"`
+	UserAgent string            `gorm:"type:varchar(255)"`
+	Reason    string            `gorm:"type:varchar(255)"`
+	CreatedAt time.Time         `gorm:"not null;index"`
+}
@@ -3,7 +3,8 @@ package model
 import "github.com/google/uuid"
 
 type Session struct {
-	UserId       uint      `gorm:"type:uint;unique;not null"`
-	UserUuid     uuid.UUID `gorm:"type:uuid;unique;not null"`
-	RefreshToken string    `gorm:"type:varchar(350);not null"`
+	ID           uint      `gorm:"primarykey"`
+	UserId       uint      `gorm:"unique;not null"`
+	UserUuid     uuid.UUID `gorm:"type:varchar(36);unique;not null"`
+	RefreshToken string    `gorm:"type:text;not null"`
 }
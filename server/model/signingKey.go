@@ -0,0 +1,28 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type KeyPurpose string
+
+const (
+	KEY_PURPOSE_ACCESS  KeyPurpose = "access"
+	KEY_PURPOSE_REFRESH KeyPurpose = "refresh"
+)
+
+// SigningKey is a jwt signing key, only one key per purpose is active,
+// retired keys are used for verification until they expire
+type SigningKey struct {
+	gorm.Model
+
+	Kid       string     `gorm:"type:varchar(64);uniqueIndex;not null"`
+	Purpose   KeyPurpose `gorm:"type:varchar(20);not null"`
+	Algorithm string     `gorm:"type:varchar(20);not null;default:HS256"`
+	Secret    string     `gorm:"type:varchar(255);not null"` // Secret is base64 encoded, PKCS8 for EdDSA keys
+	Active    bool       `gorm:"not null;default:false"`
+	RetiredAt *time.Time `gorm:"null"`
+	ExpiresAt *time.Time `gorm:"null"`
+}
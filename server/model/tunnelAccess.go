@@ -0,0 +1,64 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type TunnelAccessLevel string
+
+const (
+	TUNNEL_VIEWER   TunnelAccessLevel = "viewer"   // TUNNEL_VIEWER can see the tunnel and its dns records
+	TUNNEL_OPERATOR TunnelAccessLevel = "operator" // TUNNEL_OPERATOR can also start, stop and restart the tunnel
+	TUNNEL_ADMIN    TunnelAccessLevel = "admin"    // TUNNEL_ADMIN can also delete the tunnel, route dns and manage access
+)
+
+// _TUNNEL_ACCESS_PRIORITY orders access levels from least to most privileged
+var _TUNNEL_ACCESS_PRIORITY = map[TunnelAccessLevel]int{
+	TUNNEL_VIEWER:   1,
+	TUNNEL_OPERATOR: 2,
+	TUNNEL_ADMIN:    3,
+}
+
+// StrToTunnelAccessLevel converts string to TunnelAccessLevel
+func StrToTunnelAccessLevel(text string) (TunnelAccessLevel, bool) {
+	level := TunnelAccessLevel(text)
+	_, ok := _TUNNEL_ACCESS_PRIORITY[level]
+	return level, ok
+}
+
+// Allows reports whether the level includes required, the empty level allows nothing
+func (l TunnelAccessLevel) Allows(required TunnelAccessLevel) bool {
+	return l != "" && _TUNNEL_ACCESS_PRIORITY[l] >= _TUNNEL_ACCESS_PRIORITY[required]
+}
+
+// HigherTunnelAccess returns the more privileged of two levels
+func HigherTunnelAccess(a, b TunnelAccessLevel) TunnelAccessLevel {
+	if _TUNNEL_ACCESS_PRIORITY[b] > _TUNNEL_ACCESS_PRIORITY[a] {
+		return b
+	}
+	return a
+}
+
+// TunnelOwner records the user or group owning a tunnel, owners and members of the owning group have TUNNEL_ADMIN access
+type TunnelOwner struct {
+	gorm.Model
+
+	TunnelId     uuid.UUID `gorm:"type:varchar(36);uniqueIndex;not null"`
+	OwnerUserId  *uint     `gorm:"index"`
+	OwnerUser    *User     `gorm:"foreignKey:OwnerUserId"`
+	OwnerGroupId *uint     `gorm:"index"`
+	OwnerGroup   *Group    `gorm:"foreignKey:OwnerGroupId"`
+}
+
+// TunnelGrant gives a user or every member of a group access to a single tunnel
+type TunnelGrant struct {
+	gorm.Model
+
+	TunnelId uuid.UUID         `gorm:"type:varchar(36);not null;uniqueIndex:idx_tunnel_grant;uniqueIndex:idx_tunnel_group_grant"`
+	UserId   *uint             `gorm:"uniqueIndex:idx_tunnel_grant"`
+	User     *User             `gorm:"foreignKey:UserId"`
+	GroupId  *uint             `gorm:"uniqueIndex:idx_tunnel_group_grant"`
+	Group    *Group            `gorm:"foreignKey:GroupId"`
+	Level    TunnelAccessLevel `gorm:"type:varchar(20);not null"`
+}
@@ -1,7 +1,7 @@
 package model
 
 import (
-	"errors"
+	"time"
 
 	"github.com/killi1812/cloudflared-web-gui/util/cerror"
 
@@ -12,47 +12,51 @@ import (
 type UserRole string
 
 const (
+	ROLE_VIEWER      UserRole = "viewer"
 	ROLE_USER        UserRole = "user"
 	ROLE_ADMIN       UserRole = "admin"
 	ROLE_SUPER_ADMIN UserRole = "superadmin"
 )
 
-// StrToUserRole converts string to UserRole
-func StrToUserRole(text string) (UserRole, error) {
-	role := UserRole(text)
-	switch role {
-	case ROLE_ADMIN:
-		return ROLE_ADMIN, nil
-	case ROLE_USER:
-		return ROLE_USER, nil
-	case ROLE_SUPER_ADMIN:
-		return ROLE_SUPER_ADMIN, nil
-
-	default:
-		return "", cerror.ErrUnknownRole
-	}
+// _ROLE_PRIORITY orders builtin roles from least to most privileged, custom roles rank lowest
+var _ROLE_PRIORITY = map[UserRole]int{
+	ROLE_VIEWER:      1,
+	ROLE_USER:        2,
+	ROLE_ADMIN:       3,
+	ROLE_SUPER_ADMIN: 4,
 }
 
-// _VALID_USER_ROLES contains all user roles and are they valid or not
-var _VALID_USER_ROLES = map[UserRole]bool{
-	ROLE_USER:        true,
-	ROLE_SUPER_ADMIN: true,
-	ROLE_ADMIN:       false,
+// HigherRole returns the more privileged of two roles
+func HigherRole(a, b UserRole) UserRole {
+	if _ROLE_PRIORITY[b] > _ROLE_PRIORITY[a] {
+		return b
+	}
+	return a
 }
 
 type User struct {
 	gorm.Model
 
-	Uuid         uuid.UUID `gorm:"type:uuid;unique;not null"`
+	Uuid         uuid.UUID `gorm:"type:varchar(36);unique;not null"`
 	Username     string    `gorm:"type:varchar(100);not null"`
+	Email        string    `gorm:"type:varchar(255)"`
 	PasswordHash string    `gorm:"type:varchar(255);not null"`
 	Role         UserRole  `gorm:"type:varchar(20);not null"`
+	OidcSubject  *string   `gorm:"type:varchar(255);uniqueIndex"` // OidcSubject is set for users provisioned by OpenID Connect
+	LdapDn       *string   `gorm:"type:varchar(255);uniqueIndex"` // LdapDn is set for users provisioned by LDAP
 	Session      *Session  `gorm:"foreignKey:UserId;null"`
+	Groups       []Group   `gorm:"many2many:group_members"`
+
+	MustChangePassword bool `gorm:"not null;default:false"` // MustChangePassword is set by an administrator password reset
+
+	FailedLogins    int        `gorm:"not null;default:0"` // FailedLogins counts failed logins since the last successful one
+	LastFailedLogin *time.Time `gorm:"null"`
+	LockedUntil     *time.Time `gorm:"null"` // LockedUntil is set while the account is locked after too many failed logins
 }
 
 func (u *User) BeforeCreate(tx *gorm.DB) error {
-	if _, ok := _VALID_USER_ROLES[u.Role]; !ok {
-		return errors.New("invalid user role")
+	if u.Role == "" {
+		return cerror.ErrUnknownRole
 	}
 	return nil
 }
@@ -62,3 +66,15 @@ func (u *User) Update(user *User) *User {
 
 	return u
 }
+
+// IsLocked reports whether the account is locked at the given time
+func (u *User) IsLocked(now time.Time) bool {
+	return u.LockedUntil != nil && now.Before(*u.LockedUntil)
+}
+
+// ResetFailedLogins unlocks the account and clears failed login counters
+func (u *User) ResetFailedLogins() {
+	u.FailedLogins = 0
+	u.LastFailedLogin = nil
+	u.LockedUntil = nil
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"context"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// SetupCfAccess switches auth.Protect to Cloudflare Access assertions,
+// users are matched by email and created with app.CfAccessDefaultRole on first login.
+// A login event is recorded for the first request of every Access session
+func SetupCfAccess(users IUserCrudService, events ISecurityEventSrv, logger *zap.SugaredLogger) {
+	role, err := auth.ParseRole(app.CfAccessDefaultRole)
+	if err != nil {
+		logger.Panicf("Invalid CF_ACCESS_DEFAULT_ROLE = %s", app.CfAccessDefaultRole)
+	}
+
+	keySet := auth.NewAccessKeySet(app.CfAccessJwksUrl)
+	verifier := auth.NewAccessVerifier(app.CfAccessTeamDomain, app.CfAccessAudience, keySet, func(ctx context.Context, email string) (*model.User, error) {
+		return users.FindOrCreateByEmail(ctx, email, role)
+	})
+	verifier.OnLogin(func(ctx context.Context, claims *auth.Claims, ip, userAgent string) {
+		event := model.SecurityEvent{
+			Type:      model.EVENT_LOGIN_SUCCEEDED,
+			Username:  claims.Username,
+			Ip:        ip,
+			UserAgent: userAgent,
+			Reason:    "cloudflare access",
+		}
+		if userUuid, err := uuid.Parse(claims.ID); err == nil {
+			event.UserUuid = &userUuid
+		}
+		events.Record(ctx, event)
+	})
+	auth.UseAccess(verifier)
+
+	logger.Infof("Using Cloudflare Access authentication, team = %s", app.CfAccessTeamDomain)
+}
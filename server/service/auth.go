@@ -1,74 +1,341 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	"github.com/killi1812/cloudflared-web-gui/app"
 	"github.com/killi1812/cloudflared-web-gui/model"
 	"github.com/killi1812/cloudflared-web-gui/util/auth"
 	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+	"github.com/killi1812/cloudflared-web-gui/util/logging"
+	"github.com/killi1812/cloudflared-web-gui/util/ratelimit"
 
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
+// ClientInfo describes who is making a request, used for rate limiting and security events
+type ClientInfo struct {
+	Ip        string
+	UserAgent string
+}
+
 type IAuthService interface {
-	Login(username, password string) (string, error)
-	RefreshTokens(accessToken string) (string, error)
-	Logout(userUuid string) error
+	// Login verifies credentials and returns an access token, when the attempt is
+	// rate limited or the account locked a *cerror.RetryError is returned
+	Login(ctx context.Context, username, password string, client ClientInfo) (string, error)
+	// RefreshTokens issues a new access token for the session of the token
+	RefreshTokens(ctx context.Context, accessToken string, client ClientInfo) (string, error)
+	// Logout ends the session of the user
+	Logout(ctx context.Context, userUuid string, client ClientInfo) error
+	// CreateSession issues tokens for an already authenticated user and stores the refresh token
+	CreateSession(ctx context.Context, user *model.User) (string, error)
+	// Unlock removes a lockout caused by failed logins
+	Unlock(ctx context.Context, userUuid uuid.UUID, client ClientInfo) error
+	// VerifyPassword checks the password of an already authenticated user, like before it is changed,
+	// failures count towards the rate limits and lockout of Login
+	VerifyPassword(ctx context.Context, user *model.User, password string, client ClientInfo) error
 }
 
 type AuthService struct {
 	db     *gorm.DB
 	logger *zap.SugaredLogger
+	events ISecurityEventSrv
+	policy LoginPolicy
+
+	authenticators []Authenticator // authenticators are asked in order which one handles a login
+
+	ipLimiter       *ratelimit.Limiter
+	usernameLimiter *ratelimit.Limiter
 }
 
 func NewAuthService() IAuthService {
 	var service IAuthService
 
-	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger) {
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger, events ISecurityEventSrv) {
+		policy := loginPolicyFromConfig()
+
+		authenticators := []Authenticator{NewLocalAuthenticator(db, logger)}
+		if app.LdapUrl != "" {
+			authenticators = append(authenticators, NewLdapAuthenticator(db, logger, ldapConfigFromApp(logger)))
+		}
+
 		service = &AuthService{
-			db:     db,
-			logger: logger,
+			db:              db,
+			logger:          logger,
+			events:          events,
+			policy:          policy,
+			authenticators:  authenticators,
+			ipLimiter:       ratelimit.New(policy.RateIp, policy.RateWindow),
+			usernameLimiter: ratelimit.New(policy.RateUsername, policy.RateWindow),
 		}
 	})
 
 	return service
 }
 
-func (s *AuthService) Login(username, password string) (string, error) {
-	var user model.User
-	if err := s.db.Where("username = ?", username).First(&user).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			s.logger.Debugf("User not found username = %s", username)
-			return "", cerror.ErrInvalidCredentials
+func (s *AuthService) Login(ctx context.Context, username, password string, client ClientInfo) (string, error) {
+	logger := logging.Logger(ctx, s.logger)
+	// rate limits are checked before anything else so attackers can't burn cpu on password verification
+	if err := s.checkRateLimits(ctx, nil, username, client); err != nil {
+		return "", err
+	}
+
+	user, err := s.findUser(ctx, username)
+	if err != nil {
+		return "", err
+	}
+
+	authenticator := s.authenticatorFor(user)
+	if authenticator == nil {
+		logger.Debugf("No authenticator for username = %s", username)
+		s.record(ctx, model.EVENT_LOGIN_FAILED, user, username, client, "unknown username")
+		return "", cerror.ErrInvalidCredentials
+	}
+
+	now := time.Now()
+	hadFailures := false
+	if user != nil {
+		hadFailures = user.FailedLogins != 0 || user.LockedUntil != nil
+		if err := s.checkLockout(ctx, user, client, now); err != nil {
+			return "", err
 		}
+	}
 
-		s.logger.Errorf("Failed to query user, error = %+v", err)
+	authenticated, err := authenticator.Authenticate(ctx, user, username, password)
+	if errors.Is(err, cerror.ErrInvalidCredentials) {
+		if user == nil {
+			s.record(ctx, model.EVENT_LOGIN_FAILED, nil, username, client, "invalid password")
+			return "", cerror.ErrInvalidCredentials
+		}
+		if err := s.loginFailed(ctx, user, now, client, "invalid password"); err != nil {
+			return "", err
+		}
+		return "", cerror.ErrInvalidCredentials
+	}
+	if err != nil {
 		return "", err
 	}
+	user = authenticated
+
+	if hadFailures {
+		if err := s.resetFailedLogins(ctx, user); err != nil {
+			return "", err
+		}
+	}
+	s.usernameLimiter.Reset(username)
+	s.record(ctx, model.EVENT_LOGIN_SUCCEEDED, user, username, client, "")
+
+	return s.CreateSession(ctx, user)
+}
+
+// VerifyPassword implements IAuthService.
+func (s *AuthService) VerifyPassword(ctx context.Context, user *model.User, password string, client ClientInfo) error {
+	if err := s.checkRateLimits(ctx, user, user.Username, client); err != nil {
+		return err
+	}
+	now := time.Now()
+	hadFailures := user.FailedLogins != 0 || user.LockedUntil != nil
+	if err := s.checkLockout(ctx, user, client, now); err != nil {
+		return err
+	}
 
 	if !auth.VerifyPassword(user.PasswordHash, password) {
-		s.logger.Debugf("Invalid password for user: %s, uuid: %s", user.Username, user.Uuid)
-		return "", cerror.ErrInvalidCredentials
+		if err := s.loginFailed(ctx, user, now, client, "invalid current password"); err != nil {
+			return err
+		}
+		return cerror.ErrInvalidCredentials
+	}
+
+	if hadFailures {
+		if err := s.resetFailedLogins(ctx, user); err != nil {
+			return err
+		}
+	}
+	s.usernameLimiter.Reset(user.Username)
+	return nil
+}
+
+// checkRateLimits returns a *cerror.RetryError when the ip or username made too many attempts,
+// user is nil when the username isn't looked up yet
+func (s *AuthService) checkRateLimits(ctx context.Context, user *model.User, username string, client ClientInfo) error {
+	if ok, retryAfter := s.ipLimiter.Allow(client.Ip); !ok {
+		s.record(ctx, model.EVENT_LOGIN_RATE_LIMITED, user, username, client, "too many attempts from ip")
+		return &cerror.RetryError{Err: cerror.ErrTooManyRequests, RetryAfter: retryAfter}
+	}
+	if ok, retryAfter := s.usernameLimiter.Allow(username); !ok {
+		s.record(ctx, model.EVENT_LOGIN_RATE_LIMITED, user, username, client, "too many attempts for username")
+		return &cerror.RetryError{Err: cerror.ErrTooManyRequests, RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// checkLockout returns a *cerror.RetryError while user is locked or within the progressive delay,
+// an expired lockout is cleared
+func (s *AuthService) checkLockout(ctx context.Context, user *model.User, client ClientInfo, now time.Time) error {
+	logger := logging.Logger(ctx, s.logger)
+	if user.IsLocked(now) {
+		s.record(ctx, model.EVENT_LOGIN_FAILED, user, user.Username, client, "account locked")
+		return &cerror.RetryError{Err: cerror.ErrAccountLocked, RetryAfter: user.LockedUntil.Sub(now)}
+	}
+	if user.LockedUntil != nil {
+		logger.Infof("Lockout of user uuid = %s expired", user.Uuid)
+		if err := s.resetFailedLogins(ctx, user); err != nil {
+			return err
+		}
+	}
+	if next := s.policy.nextAttempt(user); now.Before(next) {
+		s.record(ctx, model.EVENT_LOGIN_RATE_LIMITED, user, user.Username, client, "attempt during progressive delay")
+		return &cerror.RetryError{Err: cerror.ErrTooManyRequests, RetryAfter: next.Sub(now)}
+	}
+	return nil
+}
+
+// findUser returns the user with username or nil if there is none
+func (s *AuthService) findUser(ctx context.Context, username string) (*model.User, error) {
+	logger := logging.Logger(ctx, s.logger)
+	var user model.User
+	if err := s.db.WithContext(ctx).Where("username = ?", username).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Debugf("User not found username = %s", username)
+			return nil, nil
+		}
+
+		logger.Errorf("Failed to query user, error = %+v", err)
+		return nil, err
+	}
+	return &user, nil
+}
+
+// authenticatorFor returns the first authenticator handling user, or nil if none does.
+// Without configured authenticators only local passwords are checked
+func (s *AuthService) authenticatorFor(user *model.User) Authenticator {
+	authenticators := s.authenticators
+	if len(authenticators) == 0 {
+		authenticators = []Authenticator{NewLocalAuthenticator(s.db, s.logger)}
+	}
+
+	for _, authenticator := range authenticators {
+		if authenticator.Handles(user) {
+			return authenticator
+		}
+	}
+	return nil
+}
+
+// loginFailed counts the failure and locks the account when the policy threshold is reached.
+// The counter is incremented in the database so concurrent failures aren't lost, and only
+// the counter columns are written so a password reset made during the login isn't undone
+func (s *AuthService) loginFailed(ctx context.Context, user *model.User, now time.Time, client ClientInfo, reason string) error {
+	logger := logging.Logger(ctx, s.logger)
+	err := s.db.WithContext(ctx).Model(&model.User{}).Where("id = ?", user.ID).Updates(map[string]any{
+		"failed_logins":     gorm.Expr("failed_logins + 1"),
+		"last_failed_login": now,
+	}).Error
+	if err != nil {
+		logger.Errorf("Failed to save failed login, err = %+v", err)
+		return err
+	}
+
+	var counter model.User
+	if err := s.db.WithContext(ctx).Select("failed_logins").Where("id = ?", user.ID).First(&counter).Error; err != nil {
+		logger.Errorf("Failed to query failed logins, err = %+v", err)
+		return err
+	}
+	user.FailedLogins = counter.FailedLogins
+	user.LastFailedLogin = &now
+	s.record(ctx, model.EVENT_LOGIN_FAILED, user, user.Username, client, reason)
+
+	if !s.policy.shouldLock(user) {
+		return nil
+	}
+	lockedUntil := now.Add(s.policy.LockoutDuration)
+	if err := s.db.WithContext(ctx).Model(&model.User{}).Where("id = ?", user.ID).Update("locked_until", lockedUntil).Error; err != nil {
+		logger.Errorf("Failed to lock user uuid = %s, err = %+v", user.Uuid, err)
+		return err
+	}
+	user.LockedUntil = &lockedUntil
+	s.record(ctx, model.EVENT_ACCOUNT_LOCKED, user, user.Username, client, "too many failed logins")
+	return nil
+}
+
+// resetFailedLogins clears the failed login counters of user, only those columns are written
+// so changes made to the user while the login was in progress aren't overwritten
+func (s *AuthService) resetFailedLogins(ctx context.Context, user *model.User) error {
+	logger := logging.Logger(ctx, s.logger)
+	err := s.db.WithContext(ctx).Model(&model.User{}).Where("id = ?", user.ID).Updates(map[string]any{
+		"failed_logins":     0,
+		"last_failed_login": nil,
+		"locked_until":      nil,
+	}).Error
+	if err != nil {
+		logger.Errorf("Failed to reset failed logins of user uuid = %s, err = %+v", user.Uuid, err)
+		return err
 	}
+	user.ResetFailedLogins()
+	return nil
+}
+
+// Unlock implements IAuthService.
+func (s *AuthService) Unlock(ctx context.Context, userUuid uuid.UUID, client ClientInfo) error {
+	var user model.User
+	if err := s.db.WithContext(ctx).Where("uuid = ?", userUuid).First(&user).Error; err != nil {
+		return err
+	}
+
+	if err := s.resetFailedLogins(ctx, &user); err != nil {
+		return err
+	}
+	s.usernameLimiter.Reset(user.Username)
+	s.record(ctx, model.EVENT_ACCOUNT_UNLOCKED, &user, user.Username, client, "unlocked by administrator")
 
-	token, refresh, err := auth.GenerateTokens(&user)
+	return nil
+}
+
+// record stores a security event if an event service is configured
+func (s *AuthService) record(ctx context.Context, eventType model.SecurityEventType, user *model.User, username string, client ClientInfo, reason string) {
+	if s.events == nil {
+		return
+	}
+
+	event := model.SecurityEvent{
+		Type:      eventType,
+		Username:  username,
+		Ip:        client.Ip,
+		UserAgent: client.UserAgent,
+		Reason:    reason,
+	}
+	if user != nil {
+		event.UserUuid = &user.Uuid
+	}
+	s.events.Record(ctx, event)
+}
+
+// CreateSession implements IAuthService.
+func (s *AuthService) CreateSession(ctx context.Context, user *model.User) (string, error) {
+	logger := logging.Logger(ctx, s.logger)
+	if err := s.db.WithContext(ctx).Model(user).Association("Groups").Find(&user.Groups); err != nil {
+		logger.Errorf("Failed to load groups of user = %s, err = %+v", user.Uuid, err)
+		return "", err
+	}
+
+	token, refresh, err := auth.GenerateTokens(user)
 	if err != nil {
-		s.logger.Errorf("Failed to generate token error = %+v", err)
+		logger.Errorf("Failed to generate token error = %+v", err)
 		return "", err
 	}
 
 	session := model.Session{}
-	rez := s.db.Where("user_uuid = ?", user.Uuid).First(&session)
+	rez := s.db.WithContext(ctx).Where("user_uuid = ?", user.Uuid).First(&session)
 	if rez.Error != nil && !errors.Is(rez.Error, gorm.ErrRecordNotFound) {
-		s.logger.Errorf("Failed query session, err = %w", err)
+		logger.Errorf("Failed query session, err = %w", err)
 		return "", rez.Error
 	} else {
-		s.logger.Infof("User with uuid = %s, is logging in again", user.Uuid.String())
-		s.Logout(user.Uuid.String())
+		logger.Infof("User with uuid = %s, is logging in again", user.Uuid.String())
+		s.endSession(ctx, user.Uuid.String())
 	}
 
 	session = model.Session{
@@ -76,81 +343,98 @@ func (s *AuthService) Login(username, password string) (string, error) {
 		UserUuid:     user.Uuid,
 		RefreshToken: refresh,
 	}
-	if rez := s.db.Create(&session); rez.Error != nil {
-		s.logger.Errorf("Failed to create a session, err = %w", err)
+	if rez := s.db.WithContext(ctx).Create(&session); rez.Error != nil {
+		logger.Errorf("Failed to create a session, err = %w", err)
 		return "", rez.Error
 	}
 
 	return token, nil
 }
 
-func (s *AuthService) RefreshTokens(accessToken string) (string, error) {
+// RefreshTokens implements IAuthService.
+func (s *AuthService) RefreshTokens(ctx context.Context, accessToken string, client ClientInfo) (string, error) {
+	logger := logging.Logger(ctx, s.logger)
 	// 1. Parsing accessToken
 	token, claims, err := auth.ParseToken(accessToken)
 	if err != nil {
-		s.logger.Errorf("Error Parsing claims err = %+v", err)
+		logger.Errorf("Error Parsing claims err = %+v", err)
 		return "", err
 	}
 	if !token.Valid {
-		s.logger.Errorf("Token is not valid")
+		logger.Errorf("Token is not valid")
 		return "", cerror.ErrInvalidTokenFormat
 	}
 
 	userUuid, err := uuid.Parse(claims.ID)
 	if err != nil {
-		s.logger.Errorf("Error Parsing uuid err = %+v", err)
+		logger.Errorf("Error Parsing uuid err = %+v", err)
 		return "", err
 	}
 
 	// 2. getting and parsing refreshToken
 	session := model.Session{}
-	rez := s.db.Where("user_uuid = ?", userUuid).First(&session)
+	rez := s.db.WithContext(ctx).Where("user_uuid = ?", userUuid).First(&session)
 	if rez.Error != nil {
-		s.logger.Errorf("Failed to create a session, err = %w", rez.Error)
+		logger.Errorf("Failed to create a session, err = %w", rez.Error)
 		return "", rez.Error
 	}
 
-	var refreshClaims auth.Claims
-	_, err = jwt.ParseWithClaims(session.RefreshToken, &refreshClaims, func(token *jwt.Token) (any, error) {
-		return []byte(app.RefreshKey), nil
-	})
+	_, refreshClaims, err := auth.ParseRefreshToken(session.RefreshToken)
 	if err != nil {
-		s.logger.Errorf("Error parsing refresh token claims, err = %w", err)
+		logger.Errorf("Error parsing refresh token claims, err = %w", err)
 		return "", err
 	}
 
 	// 3. verifying token
 	if claims.TokenUuid != refreshClaims.TokenUuid {
-		s.logger.Errorf("Error token uuids don't match, err = %+v", err)
+		logger.Errorf("Error token uuids don't match, err = %+v", err)
 		return "", err
 	}
 
 	// 4. new session
 	var user model.User
-	rez = s.db.Where("uuid = ?", userUuid).First(&user)
+	rez = s.db.WithContext(ctx).Preload("Groups").Where("uuid = ?", userUuid).First(&user)
 	if rez.Error != nil {
 		return "", rez.Error
 	}
 
 	newAccessToken, refreshToken, err := auth.GenerateTokens(&user)
 	if err != nil {
-		s.logger.Errorf("Failed to generate tokens, err = %w", err)
+		logger.Errorf("Failed to generate tokens, err = %w", err)
 		return "", err
 	}
 	session.RefreshToken = refreshToken
 
-	if rez := s.db.Where("user_uuid = ?", userUuid).Save(session); rez.Error != nil {
+	if rez := s.db.WithContext(ctx).Where("user_uuid = ?", userUuid).Save(session); rez.Error != nil {
 		return "", rez.Error
 	}
+	s.record(ctx, model.EVENT_TOKEN_REFRESHED, &user, user.Username, client, "")
 
 	return newAccessToken, nil
 }
 
 // Logout implements IAuthService.
-func (s *AuthService) Logout(userUuid string) error {
-	s.logger.Debugf("logging out user with uuid = %s", userUuid)
-	if rez := s.db.Where("user_uuid = ?", userUuid).Delete(&model.Session{}); rez.Error != nil {
-		s.logger.Errorf("Error session: %+v", rez)
+func (s *AuthService) Logout(ctx context.Context, userUuid string, client ClientInfo) error {
+	if err := s.endSession(ctx, userUuid); err != nil {
+		return err
+	}
+
+	event := model.SecurityEvent{Type: model.EVENT_LOGOUT, Ip: client.Ip, UserAgent: client.UserAgent}
+	if parsed, err := uuid.Parse(userUuid); err == nil {
+		event.UserUuid = &parsed
+	}
+	if s.events != nil {
+		s.events.Record(ctx, event)
+	}
+	return nil
+}
+
+// endSession deletes the session of the user, its refresh token can't be used anymore
+func (s *AuthService) endSession(ctx context.Context, userUuid string) error {
+	logger := logging.Logger(ctx, s.logger)
+	logger.Debugf("logging out user with uuid = %s", userUuid)
+	if rez := s.db.WithContext(ctx).Where("user_uuid = ?", userUuid).Delete(&model.Session{}); rez.Error != nil {
+		logger.Errorf("Error session: %+v", rez)
 		return rez.Error
 	}
 
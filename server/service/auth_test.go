@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+	"strings"
 	"testing"
 
 	"github.com/killi1812/cloudflared-web-gui/model"
@@ -11,6 +13,7 @@ import (
 	"github.com/stretchr/testify/suite"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -89,7 +92,7 @@ func TestAuthTestSuite(t *testing.T) {
 
 func (suite *authTestSuite) TestLogin_Success() {
 	// Act
-	accessToken, err := suite.authService.Login(suite.seededUser.Username, suite.seededRawPass)
+	accessToken, err := suite.authService.Login(context.Background(), suite.seededUser.Username, suite.seededRawPass, ClientInfo{})
 
 	// Assert
 	suite.NoError(err)
@@ -104,7 +107,7 @@ func (suite *authTestSuite) TestLogin_Success() {
 
 func (suite *authTestSuite) TestLogin_UserNotFound() {
 	// Act
-	accessToken, err := suite.authService.Login("nonexistent@example.com", "password")
+	accessToken, err := suite.authService.Login(context.Background(), "nonexistent@example.com", "password", ClientInfo{})
 
 	// Assert
 	suite.ErrorIs(err, cerror.ErrInvalidCredentials)
@@ -113,7 +116,7 @@ func (suite *authTestSuite) TestLogin_UserNotFound() {
 
 func (suite *authTestSuite) TestLogin_InvalidPassword() {
 	// Act
-	accessToken, err := suite.authService.Login(suite.seededUser.Username, "wrongpassword")
+	accessToken, err := suite.authService.Login(context.Background(), suite.seededUser.Username, "wrongpassword", ClientInfo{})
 
 	// Assert
 	suite.ErrorIs(err, cerror.ErrInvalidCredentials)
@@ -122,7 +125,7 @@ func (suite *authTestSuite) TestLogin_InvalidPassword() {
 
 func (suite *authTestSuite) TestLogin_ExistingSessionIsReplaced() {
 	// Arrange: Log the user in once to create a session
-	_, err := suite.authService.Login(suite.seededUser.Username, suite.seededRawPass)
+	_, err := suite.authService.Login(context.Background(), suite.seededUser.Username, suite.seededRawPass, ClientInfo{})
 	suite.Require().NoError(err)
 
 	var firstSession model.Session
@@ -130,7 +133,7 @@ func (suite *authTestSuite) TestLogin_ExistingSessionIsReplaced() {
 	suite.Require().NotEmpty(firstSession.RefreshToken)
 
 	// Act: Log the user in a second time
-	_, err = suite.authService.Login(suite.seededUser.Username, suite.seededRawPass)
+	_, err = suite.authService.Login(context.Background(), suite.seededUser.Username, suite.seededRawPass, ClientInfo{})
 	suite.Require().NoError(err)
 
 	// Assert: Check that the session has been updated
@@ -142,11 +145,11 @@ func (suite *authTestSuite) TestLogin_ExistingSessionIsReplaced() {
 
 func (suite *authTestSuite) TestLogout_Success() {
 	// Arrange: Log in to create a session
-	_, err := suite.authService.Login(suite.seededUser.Username, suite.seededRawPass)
+	_, err := suite.authService.Login(context.Background(), suite.seededUser.Username, suite.seededRawPass, ClientInfo{})
 	suite.Require().NoError(err)
 
 	// Act
-	err = suite.authService.Logout(suite.seededUser.Uuid.String())
+	err = suite.authService.Logout(context.Background(), suite.seededUser.Uuid.String(), ClientInfo{})
 
 	// Assert
 	suite.NoError(err)
@@ -159,12 +162,12 @@ func (suite *authTestSuite) TestLogout_Success() {
 
 func (suite *authTestSuite) TestRefreshTokens_Success() {
 	// Arrange: Log in to get a valid token and create a session
-	originalAccessToken, err := suite.authService.Login(suite.seededUser.Username, suite.seededRawPass)
+	originalAccessToken, err := suite.authService.Login(context.Background(), suite.seededUser.Username, suite.seededRawPass, ClientInfo{})
 	suite.Require().NoError(err)
 	suite.Require().NotEmpty(originalAccessToken)
 
 	// Act
-	newAccessToken, err := suite.authService.RefreshTokens("Bearer " + originalAccessToken)
+	newAccessToken, err := suite.authService.RefreshTokens(context.Background(), "Bearer "+originalAccessToken, ClientInfo{})
 
 	// Assert
 	suite.NoError(err)
@@ -174,7 +177,7 @@ func (suite *authTestSuite) TestRefreshTokens_Success() {
 
 func (suite *authTestSuite) TestRefreshTokens_InvalidToken() {
 	// Act
-	newAccessToken, err := suite.authService.RefreshTokens("Bearer invalidtoken")
+	newAccessToken, err := suite.authService.RefreshTokens(context.Background(), "Bearer invalidtoken", ClientInfo{})
 
 	// Assert
 	suite.Error(err)
@@ -187,9 +190,49 @@ func (suite *authTestSuite) TestRefreshTokens_NoSession() {
 	suite.Require().NoError(err)
 
 	// Act
-	newAccessToken, err := suite.authService.RefreshTokens("Bearer " + token)
+	newAccessToken, err := suite.authService.RefreshTokens(context.Background(), "Bearer "+token, ClientInfo{})
 
 	// Assert
 	suite.ErrorIs(err, gorm.ErrRecordNotFound)
 	suite.Empty(newAccessToken)
 }
+
+func (suite *authTestSuite) TestLogin_UpgradesBcryptHash() {
+	// Arrange: a user created before passwords were hashed with argon2id
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte(suite.seededRawPass), bcrypt.MinCost)
+	suite.Require().NoError(err)
+	user := model.User{Uuid: uuid.New(), Username: "bcrypt", PasswordHash: string(bcryptHash), Role: model.ROLE_USER}
+	suite.Require().NoError(suite.db.Create(&user).Error)
+
+	// Act
+	_, err = suite.authService.Login(context.Background(), user.Username, suite.seededRawPass, ClientInfo{})
+	suite.Require().NoError(err)
+
+	// Assert: the hash was replaced and the password still works
+	var upgraded model.User
+	suite.Require().NoError(suite.db.Where("uuid = ?", user.Uuid).First(&upgraded).Error)
+	suite.True(strings.HasPrefix(upgraded.PasswordHash, "$argon2id$"))
+	suite.False(auth.NeedsRehash(upgraded.PasswordHash))
+
+	_, err = suite.authService.Login(context.Background(), user.Username, suite.seededRawPass, ClientInfo{})
+	suite.NoError(err)
+}
+
+func (suite *authTestSuite) TestRehashPassword_KeepsPasswordChangedDuringLogin() {
+	// Arrange: the login verified the bcrypt hash, then the password was changed before the rehash
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte(suite.seededRawPass), bcrypt.MinCost)
+	suite.Require().NoError(err)
+	user := model.User{Uuid: uuid.New(), Username: "bcrypt-changed", PasswordHash: string(bcryptHash), Role: model.ROLE_USER}
+	suite.Require().NoError(suite.db.Create(&user).Error)
+	changed, err := auth.HashPassword("changed-Passw0rd")
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.db.Model(&model.User{}).Where("id = ?", user.ID).Update("password_hash", changed).Error)
+
+	// Act
+	NewLocalAuthenticator(suite.db, zap.NewNop().Sugar()).rehashPassword(context.Background(), &user, suite.seededRawPass)
+
+	// Assert
+	var saved model.User
+	suite.Require().NoError(suite.db.Where("uuid = ?", user.Uuid).First(&saved).Error)
+	suite.Equal(changed, saved.PasswordHash)
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+	"github.com/killi1812/cloudflared-web-gui/util/logging"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Authenticator verifies the password of a username for AuthService.Login,
+// rate limits and lockouts are handled by Login before it is called
+type Authenticator interface {
+	// Handles reports whether the authenticator is responsible for user,
+	// user is the local user with the login username or nil if there is none
+	Handles(user *model.User) bool
+	// Authenticate returns the authenticated user, provisioning it when needed,
+	// or cerror.ErrInvalidCredentials when the password is wrong
+	Authenticate(ctx context.Context, user *model.User, username, password string) (*model.User, error)
+}
+
+// LocalAuthenticator verifies passwords against the hash stored on the user
+type LocalAuthenticator struct {
+	db     *gorm.DB
+	logger *zap.SugaredLogger
+}
+
+func NewLocalAuthenticator(db *gorm.DB, logger *zap.SugaredLogger) *LocalAuthenticator {
+	return &LocalAuthenticator{db: db, logger: logger}
+}
+
+// Handles implements Authenticator.
+func (a *LocalAuthenticator) Handles(user *model.User) bool {
+	return user != nil && user.LdapDn == nil && user.PasswordHash != ""
+}
+
+// Authenticate implements Authenticator.
+func (a *LocalAuthenticator) Authenticate(ctx context.Context, user *model.User, username, password string) (*model.User, error) {
+	logger := logging.Logger(ctx, a.logger)
+	if !auth.VerifyPassword(user.PasswordHash, password) {
+		logger.Debugf("Invalid password for user: %s, uuid: %s", user.Username, user.Uuid)
+		return nil, cerror.ErrInvalidCredentials
+	}
+
+	a.rehashPassword(ctx, user, password)
+	return user, nil
+}
+
+// rehashPassword replaces a bcrypt hash or one with outdated parameters after a successful login,
+// failing to do so doesn't fail the login and is retried on the next one
+func (a *LocalAuthenticator) rehashPassword(ctx context.Context, user *model.User, password string) {
+	logger := logging.Logger(ctx, a.logger)
+	if !auth.NeedsRehash(user.PasswordHash) {
+		return
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		logger.Errorf("Failed to rehash password of user = %s, err = %+v", user.Uuid, err)
+		return
+	}
+	// only replace the hash that was verified, a password changed in the meantime is kept
+	rez := a.db.WithContext(ctx).Model(&model.User{}).
+		Where("id = ? AND password_hash = ?", user.ID, user.PasswordHash).
+		Update("password_hash", hash)
+	if rez.Error != nil {
+		logger.Errorf("Failed to save rehashed password of user = %s, err = %+v", user.Uuid, rez.Error)
+		return
+	}
+	if rez.RowsAffected == 0 {
+		logger.Debugf("Password of user = %s changed during login, skipping rehash", user.Uuid)
+		return
+	}
+	user.PasswordHash = hash
+	logger.Infof("Upgraded password hash of user = %s", user.Uuid)
+}
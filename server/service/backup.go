@@ -0,0 +1,601 @@
+package service
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/migration"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"filippo.io/age"
+	"github.com/mattn/go-sqlite3"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	_BACKUP_MANIFEST   = "manifest.json"
+	_BACKUP_DATABASE   = "database.sqlite"
+	_BACKUP_CONFIG_DIR = "config"
+	_BACKUP_PREFIX     = "backup-"
+	_BACKUP_EXT        = ".tar.gz"
+	_BACKUP_AGE_EXT    = ".age" // _BACKUP_AGE_EXT is appended to archives encrypted with a passphrase
+	_BACKUP_TIME_FMT   = "20060102-150405"
+)
+
+// _AGE_HEADER starts every age encrypted file
+var _AGE_HEADER = []byte("age-encryption.org/")
+
+type IBackupSrv interface {
+	// Create writes a backup archive of the sqlite database, tunnel configs and credentials to w while the
+	// server runs, the archive is encrypted when passphrase isn't empty
+	Create(w io.Writer, passphrase string) (*model.BackupManifest, error)
+	// CreateFile writes a backup archive named by the current time into dir and returns its path
+	CreateFile(dir, passphrase string) (string, *model.BackupManifest, error)
+	// Prune deletes all but the newest keep archives in dir and returns how many were deleted
+	Prune(dir string, keep int) (int, error)
+}
+
+type BackupSrv struct {
+	db        *gorm.DB
+	logger    *zap.SugaredLogger
+	configDir string
+}
+
+func NewBackupSrv() IBackupSrv {
+	var service IBackupSrv
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger) {
+		service = &BackupSrv{
+			db:        db,
+			logger:    logger,
+			configDir: app.CloudflaredConfigDir,
+		}
+	})
+
+	return service
+}
+
+// KeepBackups writes a backup into dir every interval and prunes all but the newest keep
+// until ctx is done, it is meant to run as an app.Job. Zero interval disables scheduled backups
+func KeepBackups(ctx context.Context, backups IBackupSrv, dir string, interval time.Duration, keep int, passphrase string) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		path, _, err := backups.CreateFile(dir, passphrase)
+		if err != nil {
+			continue
+		}
+		zap.S().Infof("Scheduled backup written to %s", path)
+
+		if pruned, err := backups.Prune(dir, keep); err == nil && pruned > 0 {
+			zap.S().Infof("Pruned %d old backups", pruned)
+		}
+	}
+}
+
+// Create implements IBackupSrv.
+func (b *BackupSrv) Create(w io.Writer, passphrase string) (*model.BackupManifest, error) {
+	if b.db.Dialector.Name() != app.DbDriverSqlite {
+		return nil, cerror.ErrBackupUnsupported
+	}
+
+	tmp, err := os.MkdirTemp("", "backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	// read first, so the snapshot is at least at this version and has the schema_migrations table
+	schemaVersion, err := migration.New(b.db, b.logger).Version()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := filepath.Join(tmp, _BACKUP_DATABASE)
+	if err := b.snapshot(snapshot); err != nil {
+		b.logger.Errorf("Failed to snapshot database, err = %+v", err)
+		return nil, err
+	}
+
+	configFiles, err := b.configFiles()
+	if err != nil {
+		b.logger.Errorf("Failed to list config files in %s, err = %+v", b.configDir, err)
+		return nil, err
+	}
+
+	manifest := &model.BackupManifest{
+		Format:        model.BACKUP_FORMAT,
+		AppVersion:    app.Version,
+		CommitHash:    app.CommitHash,
+		SchemaVersion: schemaVersion,
+		CreatedAt:     time.Now().UTC(),
+	}
+
+	// layers: tar in gzip in the optional age encryption
+	out := nopWriteCloser{w}
+	var encrypted io.WriteCloser = out
+	if passphrase != "" {
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		if encrypted, err = age.Encrypt(w, recipient); err != nil {
+			return nil, err
+		}
+	}
+	gz := gzip.NewWriter(encrypted)
+	tw := tar.NewWriter(gz)
+
+	if err := addFile(tw, manifest, _BACKUP_DATABASE, snapshot); err != nil {
+		return nil, err
+	}
+	for _, name := range configFiles {
+		if err := addFile(tw, manifest, path.Join(_BACKUP_CONFIG_DIR, name), filepath.Join(b.configDir, name)); err != nil {
+			b.logger.Errorf("Failed to add %s to backup, err = %+v", name, err)
+			return nil, err
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = tw.WriteHeader(&tar.Header{Name: _BACKUP_MANIFEST, Mode: 0o644, Size: int64(len(data)), ModTime: manifest.CreatedAt})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return nil, err
+	}
+
+	for _, closer := range []io.Closer{tw, gz, encrypted} {
+		if err := closer.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	b.logger.Infof("Backup created with %d config files, schema version %d", len(configFiles), schemaVersion)
+	return manifest, nil
+}
+
+// CreateFile implements IBackupSrv.
+func (b *BackupSrv) CreateFile(dir, passphrase string) (string, *model.BackupManifest, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", nil, err
+	}
+
+	name := _BACKUP_PREFIX + time.Now().UTC().Format(_BACKUP_TIME_FMT) + _BACKUP_EXT
+	if passphrase != "" {
+		name += _BACKUP_AGE_EXT
+	}
+	dest := filepath.Join(dir, name)
+
+	// written under a temporary name, a partial archive is never taken for a backup
+	file, err := os.CreateTemp(dir, ".partial-")
+	if err != nil {
+		return "", nil, err
+	}
+	defer os.Remove(file.Name())
+
+	manifest, err := b.Create(file, passphrase)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		b.logger.Errorf("Failed to write backup %s, err = %+v", dest, err)
+		return "", nil, err
+	}
+
+	if err := os.Rename(file.Name(), dest); err != nil {
+		b.logger.Errorf("Failed to write backup %s, err = %+v", dest, err)
+		return "", nil, err
+	}
+	return dest, manifest, nil
+}
+
+// Prune implements IBackupSrv.
+func (b *BackupSrv) Prune(dir string, keep int) (int, error) {
+	if keep <= 0 {
+		return 0, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		b.logger.Errorf("Failed to list backups in %s, err = %+v", dir, err)
+		return 0, err
+	}
+
+	var archives []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, _BACKUP_PREFIX) &&
+			(strings.HasSuffix(name, _BACKUP_EXT) || strings.HasSuffix(name, _BACKUP_EXT+_BACKUP_AGE_EXT)) {
+			archives = append(archives, name)
+		}
+	}
+	// names hold the creation time, newest sort last
+	slices.Sort(archives)
+
+	pruned := 0
+	for _, name := range archives[:max(len(archives)-keep, 0)] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			b.logger.Errorf("Failed to delete backup %s, err = %+v", name, err)
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// snapshot copies the database to dest with the sqlite online backup API,
+// writers are only blocked while the pages are copied
+func (b *BackupSrv) snapshot(dest string) error {
+	ctx := context.Background()
+	sqlDB, err := b.db.DB()
+	if err != nil {
+		return err
+	}
+	src, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	destDB, err := sql.Open("sqlite3", dest)
+	if err != nil {
+		return err
+	}
+	defer destDB.Close()
+	destConn, err := destDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	return destConn.Raw(func(destRaw any) error {
+		return src.Raw(func(srcRaw any) error {
+			backup, err := destRaw.(*sqlite3.SQLiteConn).Backup("main", srcRaw.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// configFiles returns names of tunnel configs, credentials and the origin certificate in the config directory
+func (b *BackupSrv) configFiles() ([]string, error) {
+	entries, err := os.ReadDir(b.configDir)
+	if errors.Is(err, os.ErrNotExist) {
+		b.logger.Warnf("Config directory %s doesn't exist, backing up only the database", b.configDir)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rez []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		switch name := entry.Name(); {
+		case strings.HasSuffix(name, ".yml"), strings.HasSuffix(name, ".yaml"), strings.HasSuffix(name, ".json"), name == "cert.pem":
+			rez = append(rez, name)
+		}
+	}
+	return rez, nil
+}
+
+// addFile writes the file at src to tw as name and records it in manifest
+func addFile(tw *tar.Writer, manifest *model.BackupManifest, name, src string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{Name: name, Mode: int64(info.Mode().Perm()), Size: info.Size(), ModTime: info.ModTime()})
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tw, hash), file); err != nil {
+		return err
+	}
+
+	manifest.Files = append(manifest.Files, model.BackupFile{
+		Name:   name,
+		Size:   info.Size(),
+		Sha256: hex.EncodeToString(hash.Sum(nil)),
+	})
+	return nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// VerifyBackup checks the archive at path without changing any state and returns its manifest
+func VerifyBackup(path, passphrase string) (*model.BackupManifest, error) {
+	tmp, err := os.MkdirTemp("", "backup-verify-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	return extractBackup(path, passphrase, tmp)
+}
+
+// RestoreBackup checks the archive at path, then replaces the sqlite database at dbPath and
+// the config files in configDir with the ones in the archive. The server must not be running.
+//
+// The replaced database is kept as dbPath.pre-restore, config files not in the archive are kept
+func RestoreBackup(path, passphrase, dbPath, configDir string) (*model.BackupManifest, error) {
+	// extracted next to the database so it can be renamed into place
+	tmp, err := os.MkdirTemp(filepath.Dir(dbPath), ".restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	manifest, err := extractBackup(path, passphrase, tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	// the write-ahead log belongs to the replaced database, it would corrupt the restored one
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		err := os.Rename(dbPath+suffix, dbPath+".pre-restore"+suffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	if err := os.Rename(filepath.Join(tmp, _BACKUP_DATABASE), dbPath); err != nil {
+		return nil, err
+	}
+	zap.S().Infof("Restored database to %s, the replaced one is %s.pre-restore", dbPath, dbPath)
+
+	if err := os.MkdirAll(configDir, 0o700); err != nil {
+		return nil, err
+	}
+	for _, file := range manifest.Files {
+		name, ok := strings.CutPrefix(file.Name, _BACKUP_CONFIG_DIR+"/")
+		if !ok {
+			continue
+		}
+		if err := copyFile(filepath.Join(tmp, _BACKUP_CONFIG_DIR, name), filepath.Join(configDir, name)); err != nil {
+			return nil, err
+		}
+		zap.S().Infof("Restored %s", filepath.Join(configDir, name))
+	}
+
+	return manifest, nil
+}
+
+// extractBackup decrypts and extracts the archive at path into dir and validates the manifest,
+// checksums and database
+func extractBackup(path, passphrase, dir string) (*model.BackupManifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var archive io.Reader = r
+	if header, _ := r.Peek(len(_AGE_HEADER)); bytes.Equal(header, _AGE_HEADER) {
+		if passphrase == "" {
+			return nil, cerror.ErrBackupEncrypted
+		}
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		archive, err = age.Decrypt(r, identity)
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return nil, cerror.ErrBackupPassphrase
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", cerror.ErrInvalidBackup, err)
+		}
+	}
+
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", cerror.ErrInvalidBackup, err)
+	}
+	files, err := extractTar(tar.NewReader(gz), dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest model.BackupManifest
+	data, err := os.ReadFile(filepath.Join(dir, _BACKUP_MANIFEST))
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing %s", cerror.ErrInvalidBackup, _BACKUP_MANIFEST)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", cerror.ErrInvalidBackup, _BACKUP_MANIFEST, err)
+	}
+	if manifest.Format != model.BACKUP_FORMAT {
+		return nil, fmt.Errorf("%w: unsupported format %d", cerror.ErrInvalidBackup, manifest.Format)
+	}
+
+	delete(files, _BACKUP_MANIFEST)
+	for _, want := range manifest.Files {
+		got, ok := files[want.Name]
+		if !ok {
+			return nil, fmt.Errorf("%w: missing %s", cerror.ErrInvalidBackup, want.Name)
+		}
+		if got != want {
+			return nil, fmt.Errorf("%w: checksum of %s doesn't match", cerror.ErrInvalidBackup, want.Name)
+		}
+		delete(files, want.Name)
+	}
+	for name := range files {
+		return nil, fmt.Errorf("%w: %s isn't in the manifest", cerror.ErrInvalidBackup, name)
+	}
+
+	if err := checkBackupDatabase(filepath.Join(dir, _BACKUP_DATABASE), manifest.SchemaVersion); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// extractTar writes regular files of the archive into dir and returns them by name with their checksums
+func extractTar(tr *tar.Reader, dir string) (map[string]model.BackupFile, error) {
+	if err := os.MkdirAll(filepath.Join(dir, _BACKUP_CONFIG_DIR), 0o700); err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]model.BackupFile)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", cerror.ErrInvalidBackup, err)
+		}
+
+		// only known names, a name can't escape dir
+		name := header.Name
+		configName, inConfig := strings.CutPrefix(name, _BACKUP_CONFIG_DIR+"/")
+		valid := name == _BACKUP_MANIFEST || name == _BACKUP_DATABASE ||
+			(inConfig && configName != "" && configName != "." && configName != ".." && !strings.ContainsAny(configName, `/\`))
+		if header.Typeflag != tar.TypeReg || !valid {
+			return nil, fmt.Errorf("%w: unexpected entry %s", cerror.ErrInvalidBackup, name)
+		}
+		if _, ok := files[name]; ok {
+			return nil, fmt.Errorf("%w: duplicate entry %s", cerror.ErrInvalidBackup, name)
+		}
+
+		out, err := os.OpenFile(filepath.Join(dir, filepath.FromSlash(name)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(header.Mode).Perm()|0o600)
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.New()
+		size, err := io.Copy(io.MultiWriter(out, hash), tr)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", cerror.ErrInvalidBackup, name, err)
+		}
+
+		files[name] = model.BackupFile{Name: name, Size: size, Sha256: hex.EncodeToString(hash.Sum(nil))}
+	}
+}
+
+// checkBackupDatabase checks the integrity of the sqlite database at path and that its schema is known
+func checkBackupDatabase(path string, schemaVersion uint) error {
+	db, err := gorm.Open(sqlite.Open("file:"+path+"?mode=ro"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	var result string
+	if err := db.Raw("PRAGMA integrity_check").Scan(&result).Error; err != nil {
+		return fmt.Errorf("%w: database: %w", cerror.ErrInvalidBackup, err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%w: database integrity check: %s", cerror.ErrInvalidBackup, result)
+	}
+
+	var version uint
+	if err := db.Model(&migration.SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error; err != nil {
+		return fmt.Errorf("%w: database has no schema_migrations: %w", cerror.ErrInvalidBackup, err)
+	}
+	if version != schemaVersion {
+		return fmt.Errorf("%w: database is at schema version %d, the manifest says %d", cerror.ErrInvalidBackup, version, schemaVersion)
+	}
+	if latest := migration.New(db, zap.S()).Latest(); version > latest {
+		return fmt.Errorf("%w: backup is at schema version %d, latest known is %d", cerror.ErrSchemaTooNew, version, latest)
+	}
+	return nil
+}
+
+// copyFile copies src to dest through a temporary file, so dest is replaced at once
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(dest), ".restore-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Chmod(info.Mode().Perm())
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dest)
+}
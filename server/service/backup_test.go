@@ -0,0 +1,244 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/migration"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// --- Backup Service Test Suite ---
+type backupTestSuite struct {
+	suite.Suite
+	db            *gorm.DB
+	configDir     string
+	backupService IBackupSrv
+}
+
+func (suite *backupTestSuite) SetupSuite() {
+	db, err := gorm.Open(sqlite.Open("file:backup_test.db?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	suite.Require().NoError(err)
+	_, err = migration.New(db, zap.NewNop().Sugar()).Up(0)
+	suite.Require().NoError(err)
+	suite.db = db
+
+	user := model.User{Uuid: uuid.New(), Username: "backed-up", PasswordHash: "hash", Role: model.ROLE_USER}
+	suite.Require().NoError(db.Create(&user).Error)
+}
+
+func (suite *backupTestSuite) SetupTest() {
+	suite.configDir = suite.T().TempDir()
+	suite.writeFile(suite.configDir, "tunnel-config.yml", "tunnel: tunnel\n")
+	suite.writeFile(suite.configDir, "tunnel.json", `{"TunnelSecret":"secret"}`)
+	suite.writeFile(suite.configDir, "tunnel.pid", "42")
+
+	suite.backupService = &BackupSrv{db: suite.db, logger: zap.NewNop().Sugar(), configDir: suite.configDir}
+}
+
+func (suite *backupTestSuite) TearDownSuite() {
+	sqlDB, _ := suite.db.DB()
+	suite.Require().NoError(sqlDB.Close())
+}
+
+func TestBackupTestSuite(t *testing.T) {
+	suite.Run(t, new(backupTestSuite))
+}
+
+func (suite *backupTestSuite) writeFile(dir, name, content string) {
+	suite.Require().NoError(os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+}
+
+func (suite *backupTestSuite) readFile(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	suite.Require().NoError(err)
+	return string(data)
+}
+
+func (suite *backupTestSuite) TestCreateAndRestore() {
+	dir := suite.T().TempDir()
+	path, manifest, err := suite.backupService.CreateFile(dir, "")
+	suite.Require().NoError(err)
+	suite.True(strings.HasSuffix(path, ".tar.gz"))
+	suite.Equal(migration.New(suite.db, zap.NewNop().Sugar()).Latest(), manifest.SchemaVersion)
+	suite.Len(manifest.Files, 3, "the database and two config files, pid files are skipped")
+
+	verified, err := VerifyBackup(path, "")
+	suite.Require().NoError(err)
+	suite.Equal(manifest.Files, verified.Files)
+
+	restoreDir := suite.T().TempDir()
+	dbPath := filepath.Join(restoreDir, "db.sqlite")
+	suite.writeFile(restoreDir, "db.sqlite", "old database")
+	configDir := filepath.Join(restoreDir, "config")
+	suite.Require().NoError(os.Mkdir(configDir, 0o700))
+	suite.writeFile(configDir, "tunnel.json", "old credentials")
+	suite.writeFile(configDir, "other.json", "untouched")
+
+	_, err = RestoreBackup(path, "", dbPath, configDir)
+	suite.Require().NoError(err)
+
+	suite.Equal("old database", suite.readFile(restoreDir, "db.sqlite.pre-restore"))
+	suite.Equal(`{"TunnelSecret":"secret"}`, suite.readFile(configDir, "tunnel.json"))
+	suite.Equal("tunnel: tunnel\n", suite.readFile(configDir, "tunnel-config.yml"))
+	suite.Equal("untouched", suite.readFile(configDir, "other.json"))
+	suite.NoFileExists(filepath.Join(configDir, "tunnel.pid"))
+
+	restored, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	suite.Require().NoError(err)
+	var user model.User
+	suite.NoError(restored.Where("username = ?", "backed-up").First(&user).Error)
+	sqlDB, _ := restored.DB()
+	suite.Require().NoError(sqlDB.Close())
+}
+
+func (suite *backupTestSuite) TestEncrypted() {
+	path, _, err := suite.backupService.CreateFile(suite.T().TempDir(), "passphrase")
+	suite.Require().NoError(err)
+	suite.True(strings.HasSuffix(path, ".tar.gz.age"))
+
+	_, err = VerifyBackup(path, "")
+	suite.ErrorIs(err, cerror.ErrBackupEncrypted)
+	_, err = VerifyBackup(path, "wrong")
+	suite.ErrorIs(err, cerror.ErrBackupPassphrase)
+	_, err = VerifyBackup(path, "passphrase")
+	suite.NoError(err)
+}
+
+func (suite *backupTestSuite) TestVerify_Tampered() {
+	var archive bytes.Buffer
+	_, err := suite.backupService.Create(&archive, "")
+	suite.Require().NoError(err)
+
+	tests := []struct {
+		name   string
+		modify func(header *tar.Header, data []byte) (*tar.Header, []byte)
+	}{
+		{"changed file", func(header *tar.Header, data []byte) (*tar.Header, []byte) {
+			if header.Name == "config/tunnel.json" {
+				data = []byte(`{"TunnelSecret":"other"}`)
+			}
+			return header, data
+		}},
+		{"missing file", func(header *tar.Header, data []byte) (*tar.Header, []byte) {
+			if header.Name == "config/tunnel.json" {
+				return nil, nil
+			}
+			return header, data
+		}},
+		{"unsafe name", func(header *tar.Header, data []byte) (*tar.Header, []byte) {
+			if header.Name == "config/tunnel.json" {
+				header.Name = "config/../../tunnel.json"
+			}
+			return header, data
+		}},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			path := filepath.Join(suite.T().TempDir(), "backup.tar.gz")
+			suite.Require().NoError(os.WriteFile(path, suite.rewrite(archive.Bytes(), tt.modify), 0o600))
+
+			dbPath := filepath.Join(suite.T().TempDir(), "db.sqlite")
+			suite.writeFile(filepath.Dir(dbPath), "db.sqlite", "old database")
+			_, err := RestoreBackup(path, "", dbPath, suite.T().TempDir())
+			suite.ErrorIs(err, cerror.ErrInvalidBackup)
+			suite.Equal("old database", suite.readFile(filepath.Dir(dbPath), "db.sqlite"), "state isn't changed")
+		})
+	}
+}
+
+// rewrite passes every entry of the archive through modify, a nil header drops the entry
+func (suite *backupTestSuite) rewrite(archive []byte, modify func(*tar.Header, []byte) (*tar.Header, []byte)) []byte {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	suite.Require().NoError(err)
+	tr := tar.NewReader(gz)
+
+	var out bytes.Buffer
+	gzw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gzw)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		suite.Require().NoError(err)
+		data, err := io.ReadAll(tr)
+		suite.Require().NoError(err)
+
+		if header, data = modify(header, data); header == nil {
+			continue
+		}
+		header.Size = int64(len(data))
+		suite.Require().NoError(tw.WriteHeader(header))
+		_, err = tw.Write(data)
+		suite.Require().NoError(err)
+	}
+	suite.Require().NoError(tw.Close())
+	suite.Require().NoError(gzw.Close())
+	return out.Bytes()
+}
+
+func (suite *backupTestSuite) TestPrune() {
+	dir := suite.T().TempDir()
+	for _, name := range []string{
+		"backup-20260101-000000.tar.gz",
+		"backup-20260102-000000.tar.gz.age",
+		"backup-20260103-000000.tar.gz",
+		"notes.txt",
+	} {
+		suite.writeFile(dir, name, "")
+	}
+
+	pruned, err := suite.backupService.Prune(dir, 2)
+	suite.Require().NoError(err)
+	suite.Equal(1, pruned)
+	suite.NoFileExists(filepath.Join(dir, "backup-20260101-000000.tar.gz"))
+	suite.FileExists(filepath.Join(dir, "backup-20260102-000000.tar.gz.age"))
+	suite.FileExists(filepath.Join(dir, "notes.txt"))
+
+	pruned, err = suite.backupService.Prune(dir, 0)
+	suite.NoError(err)
+	suite.Zero(pruned, "zero keeps all")
+}
+
+func (suite *backupTestSuite) TestKeepBackups_StopsWithContext() {
+	dir := suite.T().TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		KeepBackups(ctx, suite.backupService, dir, 20*time.Millisecond, 1, "")
+		close(done)
+	}()
+
+	suite.Eventually(func() bool {
+		entries, _ := os.ReadDir(dir)
+		return len(entries) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	suite.Eventually(func() bool {
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/migration"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// dialectors returns a dialector of every supported driver, postgres and mysql are only opened when
+// TEST_POSTGRES_DSN or TEST_MYSQL_DSN point to a database the test may create and drop tables in
+func dialectors(t *testing.T) map[string]gorm.Dialector {
+	dialectors := map[string]gorm.Dialector{
+		app.DbDriverSqlite: sqlite.Open("file:dialect_test.db?mode=memory&cache=shared"),
+	}
+	if dsn := os.Getenv("TEST_POSTGRES_DSN"); dsn != "" {
+		dialectors[app.DbDriverPostgres] = postgres.Open(dsn)
+	}
+	if dsn := os.Getenv("TEST_MYSQL_DSN"); dsn != "" {
+		config, err := gomysql.ParseDSN(dsn)
+		require.NoError(t, err)
+		config.ParseTime = true
+		dialectors[app.DbDriverMysql] = mysql.New(mysql.Config{DSNConfig: config})
+	}
+	return dialectors
+}
+
+// TestDialects_LoginAndRefresh runs the session queries against every configured driver,
+// with the longest username and EdDSA signed tokens the refresh token is the longest it gets
+func TestDialects_LoginAndRefresh(t *testing.T) {
+	for driver, dialector := range dialectors(t) {
+		t.Run(driver, func(t *testing.T) {
+			db, err := gorm.Open(dialector, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+			require.NoError(t, err)
+			migrator := migration.New(db, zap.NewNop().Sugar())
+			_, err = migrator.Up(0)
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				_, err := migrator.Down(len(migration.All()))
+				require.NoError(t, err)
+				sqlDB, _ := db.DB()
+				sqlDB.Close()
+			})
+
+			key, err := auth.GenerateKey(app.JwtAlgEdDSA)
+			require.NoError(t, err)
+			auth.SetKeySets(auth.KeySet{Active: key}, auth.KeySet{Active: auth.ConfigKey("test-dialect-refresh")})
+			t.Cleanup(auth.ResetKeySets)
+
+			password := "dialect-Passw0rd"
+			hash, err := auth.HashPassword(password)
+			require.NoError(t, err)
+			user := model.User{Uuid: uuid.New(), Username: strings.Repeat("u", 100), PasswordHash: hash, Role: model.ROLE_USER}
+			require.NoError(t, db.Create(&user).Error)
+
+			authService := &AuthService{db: db, logger: zap.NewNop().Sugar()}
+			accessToken, err := authService.Login(context.Background(), user.Username, password, ClientInfo{})
+			require.NoError(t, err)
+
+			refreshed, err := authService.RefreshTokens(context.Background(), "Bearer "+accessToken, ClientInfo{})
+			require.NoError(t, err)
+			require.NotEmpty(t, refreshed)
+		})
+	}
+}
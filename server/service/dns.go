@@ -1,21 +1,34 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/killi1812/cloudflared-web-gui/app"
 	"github.com/killi1812/cloudflared-web-gui/model"
 	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+	"github.com/killi1812/cloudflared-web-gui/util/logging"
+	"github.com/killi1812/cloudflared-web-gui/util/metrics"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
+const (
+	_CLOUDFLARE_API        = "https://api.cloudflare.com/client/v4"
+	_DNS_RECORDS_ENDPOINT  = "dns_records"   // _DNS_RECORDS_ENDPOINT labels Cloudflare API calls listing dns records in metrics
+	_TOKEN_VERIFY_ENDPOINT = "tokens_verify" // _TOKEN_VERIFY_ENDPOINT labels Cloudflare API calls verifying the api key in metrics
+)
+
 type IDnsSrv interface {
-	GetDnsRecords(uuid uuid.UUID) ([]model.DnsRecord, error)
+	GetDnsRecords(ctx context.Context, uuid uuid.UUID) ([]model.DnsRecord, error)
+	// VerifyToken checks that the Cloudflare API is reachable and accepts the api key
+	VerifyToken(ctx context.Context) error
 }
 
 func NewDnsSrv() IDnsSrv {
@@ -48,24 +61,26 @@ type DnsSrv struct {
 }
 
 // GetDnsRecords implements IDnsSrv.
-func (d *DnsSrv) GetDnsRecords(uuid uuid.UUID) ([]model.DnsRecord, error) {
+func (d *DnsSrv) GetDnsRecords(ctx context.Context, uuid uuid.UUID) ([]model.DnsRecord, error) {
+	logger := logging.Logger(ctx, d.logger)
+
 	if app.ZoneId == "" {
-		d.logger.Error(cerror.ErrZoneIdNotSet)
+		logger.Error(cerror.ErrZoneIdNotSet)
 		return nil, cerror.ErrZoneIdNotSet
 	}
 
 	if app.CloudflaredApiKey == "" {
-		d.logger.Error(cerror.ErrCloudflaredApiKeyNotSet)
+		logger.Error(cerror.ErrCloudflaredApiKeyNotSet)
 		return nil, cerror.ErrCloudflaredApiKeyNotSet
 	}
 
-	baseURL := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records", app.ZoneId)
+	baseURL := fmt.Sprintf("%s/zones/%s/dns_records", _CLOUDFLARE_API, app.ZoneId)
 
 	tunnelContent := uuid.String() + ".cfargotunnel.com"
 	// 2. Create a new HTTP request
-	req, err := http.NewRequest("GET", baseURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL, nil)
 	if err != nil {
-		d.logger.Errorf("Error creating request, err = %v", err)
+		logger.Errorf("Error creating request, err = %v", err)
 		return nil, err
 	}
 
@@ -80,25 +95,76 @@ func (d *DnsSrv) GetDnsRecords(uuid uuid.UUID) ([]model.DnsRecord, error) {
 	req.Header.Set("Content-Type", "application/json")
 
 	// 5. Create a client and execute the request
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := &http.Client{Timeout: 10 * time.Second, Transport: otelhttp.NewTransport(http.DefaultTransport)}
 	resp, err := client.Do(req)
 	if err != nil {
-		d.logger.Errorf("Error sending request, err = %v", err)
+		metrics.CountApiCall(_DNS_RECORDS_ENDPOINT, 0)
+		logger.Errorf("Error sending request, err = %v", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
+	metrics.CountApiCall(_DNS_RECORDS_ENDPOINT, resp.StatusCode)
 
 	// 6. Read and print the response
-	d.logger.Debugf("Cloudflared response Status Code: %d", resp.StatusCode)
+	logger.Debugf("Cloudflared response Status Code: %d", resp.StatusCode)
 
 	var res respT
 	err = json.NewDecoder(resp.Body).Decode(&res)
 	if err != nil {
-		d.logger.Errorf("Error reading response body, err = %v", err)
+		logger.Errorf("Error reading response body, err = %v", err)
 		return nil, err
 	}
 
-	d.logger.Debugf("Cloudflared response = %+v", res)
+	logger.Debugf("Cloudflared response = %+v", res)
 
 	return res.Result, nil
 }
+
+// VerifyToken implements IDnsSrv.
+func (d *DnsSrv) VerifyToken(ctx context.Context) error {
+	logger := logging.Logger(ctx, d.logger)
+
+	if app.CloudflaredApiKey == "" {
+		return cerror.ErrCloudflaredApiKeyNotSet
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", _CLOUDFLARE_API+"/user/tokens/verify", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+app.CloudflaredApiKey)
+
+	client := &http.Client{Timeout: 10 * time.Second, Transport: otelhttp.NewTransport(http.DefaultTransport)}
+	resp, err := client.Do(req)
+	if err != nil {
+		metrics.CountApiCall(_TOKEN_VERIFY_ENDPOINT, 0)
+		logger.Errorf("Error sending request, err = %v", err)
+		return err
+	}
+	defer resp.Body.Close()
+	metrics.CountApiCall(_TOKEN_VERIFY_ENDPOINT, resp.StatusCode)
+
+	var res struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result struct {
+			Status string `json:"status"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("%w: %s", cerror.ErrCloudflareApi, resp.Status)
+	}
+	if !res.Success {
+		messages := make([]string, 0, len(res.Errors))
+		for _, e := range res.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("%w: %s %s", cerror.ErrCloudflareApi, resp.Status, strings.Join(messages, ", "))
+	}
+	if res.Result.Status != "active" {
+		return fmt.Errorf("%w: api key is %s", cerror.ErrCloudflareApi, res.Result.Status)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+	"github.com/killi1812/cloudflared-web-gui/util/logging"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var _GROUP_NAME_REGEX = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9 ._-]{1,49}$`)
+
+type IGroupSrv interface {
+	// Load hands roles of all groups to util/auth
+	Load() error
+	// Reload hands roles of all groups to util/auth again, picking up changes made by other instances
+	Reload(ctx context.Context) error
+	List(ctx context.Context) ([]model.Group, error)
+	// Read returns the group with its roles and members
+	Read(ctx context.Context, uuid uuid.UUID) (*model.Group, error)
+	Create(ctx context.Context, group *model.Group) (*model.Group, error)
+	// Update replaces description and roles of a group
+	Update(ctx context.Context, uuid uuid.UUID, description string, roles []model.UserRole) (*model.Group, error)
+	// Delete deletes the group with its memberships and tunnel access
+	Delete(ctx context.Context, uuid uuid.UUID) error
+	AddMember(ctx context.Context, group, user uuid.UUID) error
+	// RemoveMember removes user from group, it returns gorm.ErrRecordNotFound if the user isn't a member
+	RemoveMember(ctx context.Context, group, user uuid.UUID) error
+}
+
+type GroupSrv struct {
+	db     *gorm.DB
+	logger *zap.SugaredLogger
+}
+
+func NewGroupSrv() IGroupSrv {
+	var service IGroupSrv
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger) {
+		service = &GroupSrv{
+			db:     db,
+			logger: logger,
+		}
+	})
+
+	return service
+}
+
+// Load implements IGroupSrv.
+func (s *GroupSrv) Load() error {
+	return s.Reload(context.Background())
+}
+
+// Reload implements IGroupSrv.
+func (s *GroupSrv) Reload(ctx context.Context) error {
+	logger := logging.Logger(ctx, s.logger)
+	var groups []model.Group
+	if err := s.db.WithContext(ctx).Preload("Roles").Find(&groups).Error; err != nil {
+		logger.Errorf("Failed to load groups, err = %+v", err)
+		return err
+	}
+
+	roles := make(map[string][]model.UserRole, len(groups))
+	for _, group := range groups {
+		roles[group.Name] = group.RoleList()
+	}
+	auth.SetGroups(roles)
+	return nil
+}
+
+// List implements IGroupSrv.
+func (s *GroupSrv) List(ctx context.Context) ([]model.Group, error) {
+	logger := logging.Logger(ctx, s.logger)
+	var groups []model.Group
+	if err := s.db.WithContext(ctx).Preload("Roles").Preload("Members").Order("name").Find(&groups).Error; err != nil {
+		logger.Errorf("Failed to list groups, err = %+v", err)
+		return nil, err
+	}
+	return groups, nil
+}
+
+// Read implements IGroupSrv.
+func (s *GroupSrv) Read(ctx context.Context, _uuid uuid.UUID) (*model.Group, error) {
+	var group model.Group
+	if err := s.db.WithContext(ctx).Preload("Roles").Preload("Members").Where("uuid = ?", _uuid).First(&group).Error; err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// Create implements IGroupSrv.
+func (s *GroupSrv) Create(ctx context.Context, group *model.Group) (*model.Group, error) {
+	logger := logging.Logger(ctx, s.logger)
+	if !_GROUP_NAME_REGEX.MatchString(group.Name) {
+		return nil, cerror.ErrInvalidGroupName
+	}
+	if err := validateRoles(group.RoleList()); err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&model.Group{}).Where("name = ?", group.Name).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, cerror.ErrGroupExists
+	}
+
+	group.Uuid = uuid.New()
+	if err := s.db.WithContext(ctx).Create(group).Error; err != nil {
+		logger.Errorf("Failed to create group = %s, err = %+v", group.Name, err)
+		return nil, err
+	}
+	logger.Infof("Created group = %s, roles = %v", group.Name, group.RoleList())
+
+	return group, s.Reload(ctx)
+}
+
+// Update implements IGroupSrv.
+func (s *GroupSrv) Update(ctx context.Context, _uuid uuid.UUID, description string, roles []model.UserRole) (*model.Group, error) {
+	logger := logging.Logger(ctx, s.logger)
+	if err := validateRoles(roles); err != nil {
+		return nil, err
+	}
+
+	group, err := s.Read(ctx, _uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", group.ID).Delete(&model.GroupRole{}).Error; err != nil {
+			return err
+		}
+
+		group.Description = description
+		group.SetRoles(roles)
+		return tx.Omit("Members").Session(&gorm.Session{FullSaveAssociations: true}).Save(group).Error
+	})
+	if err != nil {
+		logger.Errorf("Failed to update group = %s, err = %+v", group.Name, err)
+		return nil, err
+	}
+	logger.Infof("Updated group = %s, roles = %v", group.Name, roles)
+
+	return group, s.Reload(ctx)
+}
+
+// Delete implements IGroupSrv.
+func (s *GroupSrv) Delete(ctx context.Context, _uuid uuid.UUID) error {
+	logger := logging.Logger(ctx, s.logger)
+	group, err := s.Read(ctx, _uuid)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(group).Association("Members").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ?", group.ID).Delete(&model.GroupRole{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("group_id = ?", group.ID).Delete(&model.TunnelGrant{}).Error; err != nil {
+			return err
+		}
+		// tunnels owned by the group become visible only to holders of tunnel:all or tunnel:read-all
+		if err := tx.Unscoped().Where("owner_group_id = ?", group.ID).Delete(&model.TunnelOwner{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(group).Error
+	})
+	if err != nil {
+		logger.Errorf("Failed to delete group = %s, err = %+v", group.Name, err)
+		return err
+	}
+	logger.Infof("Deleted group = %s", group.Name)
+
+	return s.Reload(ctx)
+}
+
+// AddMember implements IGroupSrv.
+func (s *GroupSrv) AddMember(ctx context.Context, groupUuid, userUuid uuid.UUID) error {
+	logger := logging.Logger(ctx, s.logger)
+	group, user, err := s.readMembership(ctx, groupUuid, userUuid)
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.WithContext(ctx).Model(group).Association("Members").Append(user); err != nil {
+		logger.Errorf("Failed to add user = %s to group = %s, err = %+v", userUuid, group.Name, err)
+		return err
+	}
+
+	logger.Infof("Added user = %s to group = %s", userUuid, group.Name)
+	return nil
+}
+
+// RemoveMember implements IGroupSrv.
+func (s *GroupSrv) RemoveMember(ctx context.Context, groupUuid, userUuid uuid.UUID) error {
+	logger := logging.Logger(ctx, s.logger)
+	group, user, err := s.readMembership(ctx, groupUuid, userUuid)
+	if err != nil {
+		return err
+	}
+
+	rez := s.db.WithContext(ctx).Table("group_members").Where("group_id = ? AND user_id = ?", group.ID, user.ID).Delete(map[string]any{})
+	if rez.Error != nil {
+		logger.Errorf("Failed to remove user = %s from group = %s, err = %+v", userUuid, group.Name, rez.Error)
+		return rez.Error
+	}
+	if rez.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	logger.Infof("Removed user = %s from group = %s", userUuid, group.Name)
+	return nil
+}
+
+// readMembership returns the group and user of a membership change
+func (s *GroupSrv) readMembership(ctx context.Context, groupUuid, userUuid uuid.UUID) (*model.Group, *model.User, error) {
+	var group model.Group
+	if err := s.db.WithContext(ctx).Where("uuid = ?", groupUuid).First(&group).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var user model.User
+	if err := s.db.WithContext(ctx).Where("uuid = ?", userUuid).First(&user).Error; err != nil {
+		return nil, nil, err
+	}
+
+	return &group, &user, nil
+}
+
+// validateRoles checks that every role is known
+func validateRoles(roles []model.UserRole) error {
+	for _, role := range roles {
+		if _, err := auth.ParseRole(string(role)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// --- Group Service Test Suite ---
+type groupTestSuite struct {
+	suite.Suite
+	db           *gorm.DB
+	groupService *GroupSrv
+	roleService  *RoleSrv
+}
+
+func (suite *groupTestSuite) SetupSuite() {
+	db, err := gorm.Open(sqlite.Open("file:group_test.db?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(db.AutoMigrate(model.GetAllModels()...))
+	suite.db = db
+
+	suite.roleService = &RoleSrv{db: db, logger: zap.NewNop().Sugar()}
+	suite.Require().NoError(suite.roleService.Load())
+	suite.groupService = &GroupSrv{db: db, logger: zap.NewNop().Sugar()}
+	suite.Require().NoError(suite.groupService.Load())
+}
+
+func (suite *groupTestSuite) TearDownSuite() {
+	auth.ResetRoles()
+	auth.ResetGroups()
+	sqlDB, _ := suite.db.DB()
+	suite.Require().NoError(sqlDB.Close())
+}
+
+func TestGroupTestSuite(t *testing.T) {
+	suite.Run(t, new(groupTestSuite))
+}
+
+// createGroup creates a group with a unique name
+func (suite *groupTestSuite) createGroup(roles ...model.UserRole) *model.Group {
+	group := &model.Group{Name: "g-" + uuid.NewString()[:8]}
+	group.SetRoles(roles)
+	group, err := suite.groupService.Create(context.Background(), group)
+	suite.Require().NoError(err)
+	return group
+}
+
+// --- Test Cases ---
+
+func (suite *groupTestSuite) TestCreate_PermissionsAreUnion() {
+	group := suite.createGroup(model.ROLE_VIEWER, model.ROLE_VIEWER)
+	suite.Len(group.Roles, 1, "duplicate roles should be dropped")
+
+	claims := &auth.Claims{Role: model.ROLE_USER, Groups: []string{group.Name}}
+	suite.True(claims.HasPermission(model.PERM_TUNNEL_START, model.PERM_TUNNEL_READ_ALL))
+	suite.True(claims.HasRole(model.ROLE_VIEWER))
+	suite.False(claims.HasPermission(model.PERM_TUNNEL_DELETE))
+}
+
+func (suite *groupTestSuite) TestReload_PicksUpGroupsCreatedElsewhere() {
+	group := &model.Group{Uuid: uuid.New(), Name: "g-" + uuid.NewString()[:8]}
+	group.SetRoles([]model.UserRole{model.ROLE_ADMIN})
+	// another instance sharing the database created the group
+	suite.Require().NoError(suite.db.Create(group).Error)
+	suite.Empty(auth.GroupRoles(group.Name))
+
+	suite.Require().NoError(suite.groupService.Reload(context.Background()))
+
+	suite.Equal([]model.UserRole{model.ROLE_ADMIN}, auth.GroupRoles(group.Name))
+}
+
+func (suite *groupTestSuite) TestCreate_Invalid() {
+	_, err := suite.groupService.Create(context.Background(), &model.Group{Name: "!"})
+	suite.ErrorIs(err, cerror.ErrInvalidGroupName)
+
+	group := &model.Group{Name: "unknown-role"}
+	group.SetRoles([]model.UserRole{"nobody"})
+	_, err = suite.groupService.Create(context.Background(), group)
+	suite.ErrorIs(err, cerror.ErrUnknownRole)
+
+	existing := suite.createGroup()
+	_, err = suite.groupService.Create(context.Background(), &model.Group{Name: existing.Name})
+	suite.ErrorIs(err, cerror.ErrGroupExists)
+}
+
+func (suite *groupTestSuite) TestUpdate_ReplacesRoles() {
+	group := suite.createGroup(model.ROLE_ADMIN)
+
+	updated, err := suite.groupService.Update(context.Background(), group.Uuid, "on-call", []model.UserRole{model.ROLE_VIEWER})
+	suite.Require().NoError(err)
+	suite.Equal([]model.UserRole{model.ROLE_VIEWER}, updated.RoleList())
+	suite.Equal([]model.UserRole{model.ROLE_VIEWER}, auth.GroupRoles(group.Name))
+}
+
+func (suite *groupTestSuite) TestMembers() {
+	group := suite.createGroup(model.ROLE_VIEWER)
+	user := createUser(suite.T(), suite.db, "")
+
+	suite.Require().NoError(suite.groupService.AddMember(context.Background(), group.Uuid, user.Uuid))
+	// adding twice keeps a single membership
+	suite.Require().NoError(suite.groupService.AddMember(context.Background(), group.Uuid, user.Uuid))
+
+	read, err := suite.groupService.Read(context.Background(), group.Uuid)
+	suite.Require().NoError(err)
+	suite.Len(read.Members, 1)
+
+	suite.Require().NoError(suite.groupService.RemoveMember(context.Background(), group.Uuid, user.Uuid))
+	err = suite.groupService.RemoveMember(context.Background(), group.Uuid, user.Uuid)
+	suite.True(errors.Is(err, gorm.ErrRecordNotFound))
+}
+
+func (suite *groupTestSuite) TestDelete_RemovesTunnelAccess() {
+	group := suite.createGroup(model.ROLE_VIEWER)
+	user := createUser(suite.T(), suite.db, "")
+	suite.Require().NoError(suite.groupService.AddMember(context.Background(), group.Uuid, user.Uuid))
+
+	access := &TunnelAccessSrv{db: suite.db, logger: zap.NewNop().Sugar()}
+	owned, shared := uuid.New(), uuid.New()
+	suite.Require().NoError(access.SetGroupOwner(context.Background(), owned, group.Uuid))
+	suite.Require().NoError(access.GrantGroup(context.Background(), shared, group.Uuid, model.TUNNEL_OPERATOR))
+
+	suite.Require().NoError(suite.groupService.Delete(context.Background(), group.Uuid))
+
+	levels, err := access.Levels(context.Background(), user.Uuid, nil, []uuid.UUID{owned, shared})
+	suite.Require().NoError(err)
+	suite.Empty(levels)
+	suite.Empty(auth.GroupRoles(group.Name))
+}
+
+func (suite *groupTestSuite) TestRoleInUseByGroup() {
+	role := &model.Role{Name: model.UserRole("r-" + uuid.NewString()[:8])}
+	role.SetPermissions([]model.Permission{model.PERM_TUNNEL_READ})
+	_, err := suite.roleService.Create(context.Background(), role)
+	suite.Require().NoError(err)
+
+	suite.createGroup(role.Name)
+	suite.ErrorIs(suite.roleService.Delete(context.Background(), role.Name), cerror.ErrRoleInUse)
+}
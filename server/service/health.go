@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+	"github.com/killi1812/cloudflared-web-gui/util/metrics"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	_HEALTH_CHECK_TIMEOUT = 3 * time.Second // _HEALTH_CHECK_TIMEOUT bounds every check, so a hanging dependency fails instead of blocking the probe
+	_ORIGIN_CERT          = "cert.pem"
+	_ORIGIN_CERT_ENV      = "TUNNEL_ORIGIN_CERT" // _ORIGIN_CERT_ENV overrides where cloudflared reads the origin certificate from
+)
+
+// _CLOUDFLARED_DIRS are searched for the origin certificate by cloudflared, ~ is the home directory
+var _CLOUDFLARED_DIRS = []string{"~/.cloudflared", "~/.cloudflare-warp", "~/cloudflare-warp", "/etc/cloudflared", "/usr/local/etc/cloudflared"}
+
+type IHealthSrv interface {
+	// Ready checks every dependency the server needs to serve requests, the report is cached for a short time
+	// and the Cloudflare API result for longer
+	Ready(ctx context.Context) model.HealthReport
+}
+
+type HealthSrv struct {
+	db        *gorm.DB
+	logger    *zap.SugaredLogger
+	dns       IDnsSrv
+	configDir string
+
+	report cached[model.HealthReport] // report is the last readiness report
+	api    cached[model.HealthCheck]  // api is the last Cloudflare API check
+}
+
+// cached holds the last result of a check and reuses it for ttl
+type cached[T any] struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	last    *T
+	at      time.Time
+	running bool // running is set while the check runs, callers get the last result meanwhile
+}
+
+func NewHealthSrv() IHealthSrv {
+	var service IHealthSrv
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger, dns IDnsSrv) {
+		service = &HealthSrv{
+			db:        db,
+			logger:    logger,
+			dns:       dns,
+			configDir: app.CloudflaredConfigDir,
+			report:    cached[model.HealthReport]{ttl: app.HealthCacheTtl},
+			api:       cached[model.HealthCheck]{ttl: app.HealthCloudflareCacheTtl},
+		}
+	})
+
+	return service
+}
+
+// Ready implements IHealthSrv.
+func (h *HealthSrv) Ready(ctx context.Context) model.HealthReport {
+	return h.report.get(func() model.HealthReport { return h.check(ctx) })
+}
+
+// check runs every readiness check in parallel
+func (h *HealthSrv) check(ctx context.Context) model.HealthReport {
+	checks := map[string]func(context.Context) model.HealthCheck{
+		"database":       timed(h.checkDatabase),
+		"cloudflared":    timed(h.checkCloudflared),
+		"config_dir":     timed(h.checkConfigDir),
+		"origin_cert":    timed(func(context.Context) (string, error) { return h.originCert() }),
+		"credentials":    timed(h.checkCredentials),
+		"cloudflare_api": h.cloudflareApi,
+	}
+
+	report := model.HealthReport{Status: model.HEALTH_OK, Checks: make(map[string]model.HealthCheck, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Go(func() {
+			result := check(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+		})
+	}
+	wg.Wait()
+
+	for name, check := range report.Checks {
+		if check.Status == model.HEALTH_FAIL {
+			h.logger.Warnf("Readiness check %s failed, err = %s", name, check.Message)
+			report.Status = model.HEALTH_FAIL
+		}
+	}
+	return report
+}
+
+// timed returns check run with _HEALTH_CHECK_TIMEOUT, an error fails the check and is its message
+func timed(check func(context.Context) (string, error)) func(context.Context) model.HealthCheck {
+	return func(ctx context.Context) model.HealthCheck {
+		ctx, cancel := context.WithTimeout(ctx, _HEALTH_CHECK_TIMEOUT)
+		defer cancel()
+
+		start := time.Now()
+		message, err := check(ctx)
+		result := model.HealthCheck{Status: model.HEALTH_OK, Message: message, Duration: time.Since(start), CheckedAt: start}
+		if err != nil {
+			result.Status = model.HEALTH_FAIL
+			result.Message = err.Error()
+		}
+		return result
+	}
+}
+
+// get returns the last result, run is called again when it is older than ttl.
+// The lock isn't held during run so a slow dependency doesn't queue up probes behind it
+func (c *cached[T]) get(run func() T) T {
+	c.mu.Lock()
+	if c.last != nil && (c.running || time.Since(c.at) < c.ttl) {
+		defer c.mu.Unlock()
+		return *c.last
+	}
+	c.running = true
+	c.mu.Unlock()
+
+	start := time.Now()
+	result := run()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = &result
+	c.at = start
+	c.running = false
+	return result
+}
+
+// cloudflareApi returns the cached Cloudflare API check
+func (h *HealthSrv) cloudflareApi(ctx context.Context) model.HealthCheck {
+	return h.api.get(func() model.HealthCheck {
+		return timed(func(ctx context.Context) (string, error) {
+			return "api key is active", h.dns.VerifyToken(ctx)
+		})(ctx)
+	})
+}
+
+func (h *HealthSrv) checkDatabase(ctx context.Context) (string, error) {
+	sqlDB, err := h.db.DB()
+	if err != nil {
+		return "", err
+	}
+	return h.db.Dialector.Name(), sqlDB.PingContext(ctx)
+}
+
+func (h *HealthSrv) checkCloudflared(ctx context.Context) (string, error) {
+	data, err := exec.CommandContext(ctx, _CLOUDFLARED, "--version").Output()
+	metrics.CountCommand("version", err)
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", cerror.ErrCloudflaredNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (h *HealthSrv) checkConfigDir(ctx context.Context) (string, error) {
+	file, err := os.CreateTemp(h.configDir, ".healthcheck-")
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+	return h.configDir + " is writable", os.Remove(file.Name())
+}
+
+// checkCredentials checks that every tunnel with a config in the config directory has its credentials file,
+// cloudflared writes it next to the origin certificate
+func (h *HealthSrv) checkCredentials(ctx context.Context) (string, error) {
+	configs, err := filepath.Glob(filepath.Join(h.configDir, fmt.Sprintf(_CONFIG_FMT, "*")))
+	if err != nil {
+		return "", err
+	}
+	if len(configs) == 0 {
+		return "no tunnel configs", nil
+	}
+
+	dirs := []string{h.configDir}
+	if cert, err := h.originCert(); err == nil {
+		dirs = append(dirs, filepath.Dir(cert))
+	}
+
+	var missing []string
+	checked := 0
+	for _, config := range configs {
+		id := strings.TrimSuffix(filepath.Base(config), fmt.Sprintf(_CONFIG_FMT, ""))
+		if _, err := uuid.Parse(id); err != nil {
+			continue
+		}
+		checked++
+		if !anyExists(dirs, id+".json") {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("credentials missing for tunnels %s", strings.Join(missing, ", "))
+	}
+	return fmt.Sprintf("credentials found for %d tunnels", checked), nil
+}
+
+// originCert returns the path of the origin certificate cloudflared uses
+func (h *HealthSrv) originCert() (string, error) {
+	if path, ok := os.LookupEnv(_ORIGIN_CERT_ENV); ok {
+		_, err := os.Stat(path)
+		return path, err
+	}
+
+	home, _ := os.UserHomeDir()
+	dirs := []string{h.configDir}
+	for _, dir := range _CLOUDFLARED_DIRS {
+		if rest, ok := strings.CutPrefix(dir, "~"); ok {
+			if home == "" {
+				continue
+			}
+			dir = filepath.Join(home, rest)
+		}
+		dirs = append(dirs, dir)
+	}
+
+	for _, dir := range dirs {
+		path := filepath.Join(dir, _ORIGIN_CERT)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found in %s, run cloudflared tunnel login", _ORIGIN_CERT, strings.Join(dirs, ", "))
+}
+
+// anyExists reports whether name exists in any of dirs
+func anyExists(dirs []string, name string) bool {
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
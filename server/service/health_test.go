@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeDnsSrv counts token verifications and fails them with err,
+// verifications wait for block to be closed when it is set
+type fakeDnsSrv struct {
+	verified int
+	err      error
+	block    chan struct{}
+}
+
+func (f *fakeDnsSrv) GetDnsRecords(ctx context.Context, uuid uuid.UUID) ([]model.DnsRecord, error) {
+	return nil, nil
+}
+
+func (f *fakeDnsSrv) VerifyToken(ctx context.Context) error {
+	f.verified++
+	if f.block != nil {
+		<-f.block
+	}
+	return f.err
+}
+
+// --- Health Service Test Suite ---
+type healthTestSuite struct {
+	suite.Suite
+	db            *gorm.DB
+	dns           *fakeDnsSrv
+	configDir     string
+	healthService *HealthSrv
+}
+
+func (suite *healthTestSuite) SetupSuite() {
+	db, err := gorm.Open(sqlite.Open("file:health_test.db?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	suite.Require().NoError(err)
+	suite.db = db
+}
+
+func (suite *healthTestSuite) SetupTest() {
+	suite.configDir = suite.T().TempDir()
+	suite.dns = &fakeDnsSrv{}
+	suite.healthService = &HealthSrv{
+		db:        suite.db,
+		logger:    zap.NewNop().Sugar(),
+		dns:       suite.dns,
+		configDir: suite.configDir,
+		api:       cached[model.HealthCheck]{ttl: time.Minute},
+	}
+	suite.T().Setenv(_ORIGIN_CERT_ENV, filepath.Join(suite.configDir, _ORIGIN_CERT))
+}
+
+func (suite *healthTestSuite) TearDownSuite() {
+	sqlDB, _ := suite.db.DB()
+	suite.Require().NoError(sqlDB.Close())
+}
+
+func TestHealthTestSuite(t *testing.T) {
+	suite.Run(t, new(healthTestSuite))
+}
+
+func (suite *healthTestSuite) writeFile(name string) {
+	suite.Require().NoError(os.WriteFile(filepath.Join(suite.configDir, name), []byte("{}"), 0o600))
+}
+
+func (suite *healthTestSuite) TestReady_ReportsEveryCheck() {
+	report := suite.healthService.Ready(context.Background())
+
+	suite.Len(report.Checks, 6)
+	suite.Equal(model.HEALTH_OK, report.Checks["database"].Status)
+	suite.Equal(model.HEALTH_OK, report.Checks["config_dir"].Status)
+	suite.Equal(model.HEALTH_FAIL, report.Checks["origin_cert"].Status)
+	suite.Equal(model.HEALTH_FAIL, report.Status, "a failed check fails the report")
+}
+
+func (suite *healthTestSuite) TestReady_ReportIsCached() {
+	suite.healthService.report.ttl = time.Minute
+	suite.healthService.api.ttl = 0
+
+	first := suite.healthService.Ready(context.Background())
+	suite.writeFile(_ORIGIN_CERT)
+	suite.Equal(first, suite.healthService.Ready(context.Background()), "the report is reused within the ttl")
+	suite.Equal(1, suite.dns.verified, "no check runs for a cached report")
+
+	suite.healthService.report.ttl = 0
+	report := suite.healthService.Ready(context.Background())
+	suite.Equal(model.HEALTH_OK, report.Checks["origin_cert"].Status)
+	suite.Equal(2, suite.dns.verified)
+}
+
+func (suite *healthTestSuite) TestConfigDir_NotWritable() {
+	suite.healthService.configDir = filepath.Join(suite.configDir, "missing")
+
+	_, err := suite.healthService.checkConfigDir(context.Background())
+	suite.Error(err)
+}
+
+func (suite *healthTestSuite) TestOriginCert_FromEnv() {
+	suite.writeFile(_ORIGIN_CERT)
+
+	path, err := suite.healthService.originCert()
+	suite.NoError(err)
+	suite.Equal(filepath.Join(suite.configDir, _ORIGIN_CERT), path)
+}
+
+func (suite *healthTestSuite) TestCredentials() {
+	present, missing := uuid.New(), uuid.New()
+	suite.writeFile(present.String() + "-config.yml")
+	suite.writeFile(present.String() + ".json")
+
+	message, err := suite.healthService.checkCredentials(context.Background())
+	suite.NoError(err)
+	suite.Equal("credentials found for 1 tunnels", message)
+
+	suite.writeFile(missing.String() + "-config.yml")
+	_, err = suite.healthService.checkCredentials(context.Background())
+	suite.ErrorContains(err, missing.String())
+	suite.NotContains(err.Error(), present.String())
+}
+
+func (suite *healthTestSuite) TestCredentials_NoTunnels() {
+	message, err := suite.healthService.checkCredentials(context.Background())
+	suite.NoError(err)
+	suite.Equal("no tunnel configs", message)
+}
+
+func (suite *healthTestSuite) TestCloudflareApi_Cached() {
+	suite.dns.err = errors.New("unreachable")
+
+	first := suite.healthService.cloudflareApi(context.Background())
+	suite.Equal(model.HEALTH_FAIL, first.Status)
+	suite.Equal("unreachable", first.Message)
+
+	suite.dns.err = nil
+	suite.Equal(first, suite.healthService.cloudflareApi(context.Background()), "the result is reused within the ttl")
+	suite.Equal(1, suite.dns.verified)
+
+	suite.healthService.api.ttl = 0
+	suite.Equal(model.HEALTH_OK, suite.healthService.cloudflareApi(context.Background()).Status)
+	suite.Equal(2, suite.dns.verified)
+}
+
+func (suite *healthTestSuite) TestCloudflareApi_SlowCheckDoesNotBlock() {
+	first := suite.healthService.cloudflareApi(context.Background())
+	suite.Require().Equal(model.HEALTH_OK, first.Status)
+
+	suite.healthService.api.ttl = 0
+	suite.dns.block = make(chan struct{})
+	done := make(chan model.HealthCheck)
+	go func() { done <- suite.healthService.cloudflareApi(context.Background()) }()
+	suite.Eventually(func() bool {
+		suite.healthService.api.mu.Lock()
+		defer suite.healthService.api.mu.Unlock()
+		return suite.healthService.api.running
+	}, time.Second, time.Millisecond)
+
+	suite.Equal(first, suite.healthService.cloudflareApi(context.Background()), "the last result is served while the check runs")
+
+	close(suite.dns.block)
+	suite.NotEqual(first.CheckedAt, (<-done).CheckedAt)
+	suite.Equal(2, suite.dns.verified)
+}
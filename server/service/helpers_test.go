@@ -0,0 +1,28 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// createUser stores a user with a unique username and password as its password,
+// without a password the user can't log in which skips the slow hashing
+func createUser(t *testing.T, db *gorm.DB, password string) *model.User {
+	t.Helper()
+	hash := "x"
+	if password != "" {
+		var err error
+		hash, err = auth.HashPassword(password)
+		require.NoError(t, err)
+	}
+
+	user := model.User{Uuid: uuid.New(), Username: uuid.NewString(), PasswordHash: hash, Role: model.ROLE_USER}
+	require.NoError(t, db.Create(&user).Error)
+	return &user
+}
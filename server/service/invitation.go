@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+	"github.com/killi1812/cloudflared-web-gui/util/logging"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// _MAX_INVITE_TTL keeps invitations within the lifetime of a retired refresh key, which signs their links
+const _MAX_INVITE_TTL = 7 * 24 * time.Hour
+
+type IInvitationSrv interface {
+	// Create stores the invitation and returns it with its signed token,
+	// ExpiresAt defaults to app.InviteTtl from now when zero
+	Create(ctx context.Context, invitation *model.Invitation, client ClientInfo) (*model.Invitation, string, error)
+	// ListPending returns invitations that weren't accepted, revoked or expired
+	ListPending(ctx context.Context) ([]model.Invitation, error)
+	// Revoke deletes a pending invitation, by is the uuid of the user revoking it
+	Revoke(ctx context.Context, uuid uuid.UUID, by uuid.UUID, client ClientInfo) error
+	// Accept creates the invited user, every invitation can be accepted only once
+	Accept(ctx context.Context, token, username, password string, client ClientInfo) (*model.User, error)
+}
+
+type InvitationSrv struct {
+	db     *gorm.DB
+	logger *zap.SugaredLogger
+	users  IUserCrudService
+	events ISecurityEventSrv
+}
+
+func NewInvitationSrv() IInvitationSrv {
+	var service IInvitationSrv
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger, users IUserCrudService, events ISecurityEventSrv) {
+		service = &InvitationSrv{
+			db:     db,
+			logger: logger,
+			users:  users,
+			events: events,
+		}
+	})
+
+	return service
+}
+
+// InviteLink returns the link sent to the invitee, it is empty when app.InviteUrl isn't configured
+func InviteLink(token string) string {
+	if app.InviteUrl == "" {
+		return ""
+	}
+	return app.InviteUrl + "?" + url.Values{"token": {token}}.Encode()
+}
+
+// Create implements IInvitationSrv.
+func (s *InvitationSrv) Create(ctx context.Context, invitation *model.Invitation, client ClientInfo) (*model.Invitation, string, error) {
+	logger := logging.Logger(ctx, s.logger)
+	if _, err := auth.ParseRole(string(invitation.Role)); err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	if invitation.ExpiresAt.IsZero() {
+		invitation.ExpiresAt = now.Add(app.InviteTtl)
+	}
+	if !invitation.ExpiresAt.After(now) || invitation.ExpiresAt.After(now.Add(_MAX_INVITE_TTL)) {
+		return nil, "", cerror.ErrInvalidInviteExpiry
+	}
+
+	invitation.Uuid = uuid.New()
+	token, err := auth.GenerateInviteToken(invitation.Uuid, invitation.ExpiresAt)
+	if err != nil {
+		logger.Errorf("Failed to sign invitation, err = %+v", err)
+		return nil, "", err
+	}
+
+	if err := s.db.WithContext(ctx).Create(invitation).Error; err != nil {
+		logger.Errorf("Failed to create invitation, err = %+v", err)
+		return nil, "", err
+	}
+
+	s.record(ctx, model.EVENT_INVITE_CREATED, &invitation.CreatedByUuid, "", client, invitation)
+	return invitation, token, nil
+}
+
+// ListPending implements IInvitationSrv.
+func (s *InvitationSrv) ListPending(ctx context.Context) ([]model.Invitation, error) {
+	logger := logging.Logger(ctx, s.logger)
+	var invitations []model.Invitation
+	rez := s.db.WithContext(ctx).Where("accepted_at IS NULL AND expires_at > ?", time.Now()).Order("expires_at").Find(&invitations)
+	if rez.Error != nil {
+		logger.Errorf("Failed to list invitations, err = %+v", rez.Error)
+		return nil, rez.Error
+	}
+	return invitations, nil
+}
+
+// Revoke implements IInvitationSrv.
+func (s *InvitationSrv) Revoke(ctx context.Context, _uuid uuid.UUID, by uuid.UUID, client ClientInfo) error {
+	logger := logging.Logger(ctx, s.logger)
+	var invitation model.Invitation
+	if err := s.db.WithContext(ctx).Where("uuid = ?", _uuid).First(&invitation).Error; err != nil {
+		return err
+	}
+	if !invitation.Pending(time.Now()) {
+		return cerror.ErrInvitationNotPending
+	}
+
+	if err := s.db.WithContext(ctx).Delete(&invitation).Error; err != nil {
+		logger.Errorf("Failed to revoke invitation = %s, err = %+v", _uuid, err)
+		return err
+	}
+
+	s.record(ctx, model.EVENT_INVITE_REVOKED, &by, "", client, &invitation)
+	return nil
+}
+
+// Accept implements IInvitationSrv.
+func (s *InvitationSrv) Accept(ctx context.Context, token, username, password string, client ClientInfo) (*model.User, error) {
+	logger := logging.Logger(ctx, s.logger)
+	invitationUuid, err := auth.ParseInviteToken(token)
+	if err != nil {
+		logger.Infof("Invalid invitation token, err = %v", err)
+		return nil, cerror.ErrInvalidInvitation
+	}
+
+	var invitation model.Invitation
+	if err := s.db.WithContext(ctx).Unscoped().Where("uuid = ?", invitationUuid).First(&invitation).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if !invitation.Pending(now) {
+		return nil, cerror.ErrInvitationNotPending
+	}
+
+	// claiming the invitation before creating the user keeps two concurrent requests from both using it
+	rez := s.db.WithContext(ctx).Model(&model.Invitation{}).
+		Where("id = ? AND accepted_at IS NULL", invitation.ID).
+		Update("accepted_at", now)
+	if rez.Error != nil {
+		logger.Errorf("Failed to claim invitation = %s, err = %+v", invitation.Uuid, rez.Error)
+		return nil, rez.Error
+	}
+	if rez.RowsAffected == 0 {
+		return nil, cerror.ErrInvitationNotPending
+	}
+
+	user, err := s.users.Create(ctx, &model.User{
+		Uuid:     uuid.New(),
+		Username: username,
+		Email:    invitation.Email,
+		Role:     invitation.Role,
+	}, password)
+	if err != nil {
+		// a rejected username or password shouldn't use up the invitation
+		if err := s.db.WithContext(ctx).Model(&invitation).Update("accepted_at", nil).Error; err != nil {
+			logger.Errorf("Failed to release invitation = %s, err = %+v", invitation.Uuid, err)
+		}
+		return nil, err
+	}
+
+	if err := s.db.WithContext(ctx).Model(&invitation).Update("user_uuid", user.Uuid).Error; err != nil {
+		logger.Errorf("Failed to link invitation = %s to user = %s, err = %+v", invitation.Uuid, user.Uuid, err)
+	}
+
+	s.record(ctx, model.EVENT_INVITE_ACCEPTED, &user.Uuid, user.Username, client, &invitation)
+	return user, nil
+}
+
+// record stores a security event about invitation if an event service is configured
+func (s *InvitationSrv) record(ctx context.Context, eventType model.SecurityEventType, userUuid *uuid.UUID, username string, client ClientInfo, invitation *model.Invitation) {
+	if s.events == nil {
+		return
+	}
+
+	s.events.Record(ctx, model.SecurityEvent{
+		Type:      eventType,
+		UserUuid:  userUuid,
+		Username:  username,
+		Ip:        client.Ip,
+		UserAgent: client.UserAgent,
+		Reason:    fmt.Sprintf("invitation = %s, role = %s", invitation.Uuid, invitation.Role),
+	})
+}
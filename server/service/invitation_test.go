@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// --- Invitation Service Test Suite ---
+type invitationTestSuite struct {
+	suite.Suite
+	db                *gorm.DB
+	events            *SecurityEventSrv
+	invitationService *InvitationSrv
+}
+
+func (suite *invitationTestSuite) SetupSuite() {
+	app.RefreshKey = "test-invitation-refresh-key"
+	app.InviteTtl = time.Hour
+	log := zap.NewNop().Sugar()
+
+	db, err := gorm.Open(sqlite.Open("file:invitation_test.db?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(db.AutoMigrate(model.GetAllModels()...))
+	suite.db = db
+
+	suite.events = &SecurityEventSrv{db: db, logger: log}
+	suite.invitationService = &InvitationSrv{
+		db:     db,
+		logger: log,
+		users:  &UserCrudService{db: db, logger: log},
+		events: suite.events,
+	}
+}
+
+func (suite *invitationTestSuite) TearDownSuite() {
+	sqlDB, _ := suite.db.DB()
+	suite.Require().NoError(sqlDB.Close())
+}
+
+func TestInvitationTestSuite(t *testing.T) {
+	suite.Run(t, new(invitationTestSuite))
+}
+
+// invite creates an invitation for role with the default expiry and returns its token
+func (suite *invitationTestSuite) invite(role model.UserRole) (*model.Invitation, string) {
+	invitation, token, err := suite.invitationService.Create(context.Background(), &model.Invitation{
+		Role:          role,
+		Email:         "invitee@example.com",
+		CreatedByUuid: uuid.New(),
+	}, ClientInfo{})
+	suite.Require().NoError(err)
+	return invitation, token
+}
+
+// lastEvent returns the newest stored security event
+func (suite *invitationTestSuite) lastEvent() model.SecurityEvent {
+	events, _, err := suite.events.List(context.Background(), SecurityEventFilter{}.Normalized())
+	suite.Require().NoError(err)
+	suite.Require().NotEmpty(events)
+	return events[0]
+}
+
+// --- Test Cases ---
+
+func (suite *invitationTestSuite) TestAccept_CreatesUserOnce() {
+	invitation, token := suite.invite(model.ROLE_VIEWER)
+
+	user, err := suite.invitationService.Accept(context.Background(), token, "invited-"+invitation.Uuid.String()[:8], "password123", ClientInfo{Ip: "10.0.0.1"})
+	suite.Require().NoError(err)
+	suite.Equal(model.ROLE_VIEWER, user.Role)
+	suite.Equal("invitee@example.com", user.Email)
+
+	last := suite.lastEvent()
+	suite.Equal(model.EVENT_INVITE_ACCEPTED, last.Type)
+	suite.Equal(user.Uuid, *last.UserUuid)
+	suite.Equal("10.0.0.1", last.Ip)
+
+	_, err = suite.invitationService.Accept(context.Background(), token, "second-"+invitation.Uuid.String()[:8], "password123", ClientInfo{})
+	suite.ErrorIs(err, cerror.ErrInvitationNotPending)
+
+	pending, err := suite.invitationService.ListPending(context.Background())
+	suite.Require().NoError(err)
+	for _, p := range pending {
+		suite.NotEqual(invitation.Uuid, p.Uuid)
+	}
+}
+
+func (suite *invitationTestSuite) TestAccept_WeakPasswordKeepsInvitation() {
+	invitation, token := suite.invite(model.ROLE_USER)
+	username := "weak-" + invitation.Uuid.String()[:8]
+
+	_, err := suite.invitationService.Accept(context.Background(), token, username, "short", ClientInfo{})
+	suite.ErrorIs(err, cerror.ErrWeakPassword)
+
+	_, err = suite.invitationService.Accept(context.Background(), token, username, "password123", ClientInfo{})
+	suite.NoError(err)
+}
+
+func (suite *invitationTestSuite) TestAccept_InvalidToken() {
+	_, err := suite.invitationService.Accept(context.Background(), "not-a-token", "nobody", "password123", ClientInfo{})
+	suite.ErrorIs(err, cerror.ErrInvalidInvitation)
+}
+
+func (suite *invitationTestSuite) TestRevoke() {
+	invitation, token := suite.invite(model.ROLE_USER)
+
+	suite.Require().NoError(suite.invitationService.Revoke(context.Background(), invitation.Uuid, uuid.New(), ClientInfo{}))
+	suite.Equal(model.EVENT_INVITE_REVOKED, suite.lastEvent().Type)
+
+	_, err := suite.invitationService.Accept(context.Background(), token, "revoked", "password123", ClientInfo{})
+	suite.ErrorIs(err, cerror.ErrInvitationNotPending)
+
+	err = suite.invitationService.Revoke(context.Background(), invitation.Uuid, uuid.New(), ClientInfo{})
+	suite.ErrorIs(err, gorm.ErrRecordNotFound)
+}
+
+func (suite *invitationTestSuite) TestCreate_InvalidExpiry() {
+	for _, expiresAt := range []time.Time{time.Now().Add(-time.Minute), time.Now().Add(30 * 24 * time.Hour)} {
+		_, _, err := suite.invitationService.Create(context.Background(), &model.Invitation{
+			Role:      model.ROLE_USER,
+			ExpiresAt: expiresAt,
+		}, ClientInfo{})
+		suite.ErrorIs(err, cerror.ErrInvalidInviteExpiry)
+	}
+}
+
+func (suite *invitationTestSuite) TestCreate_UnknownRole() {
+	_, _, err := suite.invitationService.Create(context.Background(), &model.Invitation{Role: "owner"}, ClientInfo{})
+	suite.ErrorIs(err, cerror.ErrUnknownRole)
+}
@@ -0,0 +1,298 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/logging"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type IKeySrv interface {
+	// Load builds key sets from the database and config and hands them to util/auth
+	Load() error
+	// Reload hands key sets to util/auth again, picking up keys rotated by other instances
+	Reload(ctx context.Context) error
+	// Rotate creates new active signing keys, the previous ones verify tokens until they expire
+	Rotate(ctx context.Context) ([]model.SigningKey, error)
+}
+
+type KeySrv struct {
+	db         *gorm.DB
+	logger     *zap.SugaredLogger
+	retiredTtl time.Duration
+	algorithm  string // algorithm of access keys, refresh tokens are only verified by the app and always use HS256
+	keyFile    string
+}
+
+func NewKeySrv() IKeySrv {
+	var service IKeySrv
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger) {
+		service = &KeySrv{
+			db:         db,
+			logger:     logger,
+			retiredTtl: app.KeyRetiredTtl,
+			algorithm:  app.JwtAlgorithm,
+			keyFile:    app.JwtPrivateKeyFile,
+		}
+	})
+
+	return service
+}
+
+// purposeAlgorithm returns the algorithm new keys of purpose are generated with
+func (s *KeySrv) purposeAlgorithm(purpose model.KeyPurpose) string {
+	if purpose == model.KEY_PURPOSE_ACCESS {
+		return s.algorithm
+	}
+	return app.JwtAlgHS256
+}
+
+// configKeys maps every key purpose to keys loaded from config, the first key is the configured active key
+func (s *KeySrv) configKeys() (map[model.KeyPurpose][]auth.SigningKey, error) {
+	keys := map[model.KeyPurpose][]auth.SigningKey{
+		model.KEY_PURPOSE_ACCESS:  configSecrets(app.AccessKey, app.AccessKeysPrevious),
+		model.KEY_PURPOSE_REFRESH: configSecrets(app.RefreshKey, app.RefreshKeysPrevious),
+	}
+
+	if s.algorithm == app.JwtAlgEdDSA && s.keyFile != "" {
+		key, err := auth.LoadKeyFile(s.keyFile)
+		if err != nil {
+			s.logger.Errorf("Failed to load jwt private key file = %s, err = %+v", s.keyFile, err)
+			return nil, err
+		}
+		// the shared secrets stay valid for tokens issued before switching to EdDSA
+		keys[model.KEY_PURPOSE_ACCESS] = append([]auth.SigningKey{key}, keys[model.KEY_PURPOSE_ACCESS]...)
+	}
+
+	return keys, nil
+}
+
+func configSecrets(secret string, previous []string) []auth.SigningKey {
+	keys := []auth.SigningKey{auth.ConfigKey(secret)}
+	for _, p := range previous {
+		keys = append(keys, auth.ConfigKey(p))
+	}
+	return keys
+}
+
+// Load implements IKeySrv.
+func (s *KeySrv) Load() error {
+	// expired keys are soft deleted without their secret, the kid is kept
+	// so a retired config secret isn't trusted again on the next start
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		expired := tx.Model(&model.SigningKey{}).Where("expires_at IS NOT NULL AND expires_at < ?", time.Now())
+		if err := expired.Update("secret", "").Error; err != nil {
+			return err
+		}
+		rez := tx.Where("expires_at IS NOT NULL AND expires_at < ?", time.Now()).Delete(&model.SigningKey{})
+		if rez.RowsAffected > 0 {
+			s.logger.Infof("Deleted %d expired signing keys", rez.RowsAffected)
+		}
+		return rez.Error
+	})
+	if err != nil {
+		s.logger.Errorf("Failed to delete expired signing keys, err = %+v", err)
+		return err
+	}
+
+	sets, err := s.keySets(context.Background())
+	if err != nil {
+		return err
+	}
+
+	// switching JWT_ALGORITHM replaces the active access key, older tokens stay valid until the old key expires
+	if active := sets[model.KEY_PURPOSE_ACCESS].Active; active.Algorithm != s.algorithm {
+		s.logger.Infof("Active access key kid = %s uses %s, generating a %s key", active.Kid, active.Algorithm, s.algorithm)
+		if _, err := s.rotate(context.Background(), model.KEY_PURPOSE_ACCESS); err != nil {
+			return err
+		}
+		if sets, err = s.keySets(context.Background()); err != nil {
+			return err
+		}
+	}
+
+	for purpose, set := range sets {
+		s.logger.Infof("Loaded %s keys, active kid = %s, previous = %d", purpose, set.Active.Kid, len(set.Previous))
+	}
+
+	auth.SetKeySets(sets[model.KEY_PURPOSE_ACCESS], sets[model.KEY_PURPOSE_REFRESH])
+	return nil
+}
+
+// Reload implements IKeySrv.
+func (s *KeySrv) Reload(ctx context.Context) error {
+	sets, err := s.keySets(ctx)
+	if err != nil {
+		return err
+	}
+
+	auth.SetKeySets(sets[model.KEY_PURPOSE_ACCESS], sets[model.KEY_PURPOSE_REFRESH])
+	return nil
+}
+
+// keySets builds key sets of every purpose from the database and config
+func (s *KeySrv) keySets(ctx context.Context) (map[model.KeyPurpose]auth.KeySet, error) {
+	logger := logging.Logger(ctx, s.logger)
+	var keys []model.SigningKey
+	if err := s.db.WithContext(ctx).Unscoped().Find(&keys).Error; err != nil {
+		logger.Errorf("Failed to load signing keys, err = %+v", err)
+		return nil, err
+	}
+
+	configKeys, err := s.configKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	sets := make(map[model.KeyPurpose]auth.KeySet)
+	for purpose, purposeConfigKeys := range configKeys {
+		set, err := buildKeySet(keys, purpose, purposeConfigKeys)
+		if err != nil {
+			logger.Errorf("Failed to build %s key set, err = %+v", purpose, err)
+			return nil, err
+		}
+		sets[purpose] = set
+	}
+
+	return sets, nil
+}
+
+// buildKeySet combines database keys with configured keys, a database key wins over the configured
+// key with the same kid, deleted keys are skipped and an active database key wins over the configured one
+func buildKeySet(keys []model.SigningKey, purpose model.KeyPurpose, configKeys []auth.SigningKey) (auth.KeySet, error) {
+	var set auth.KeySet
+	known := make(map[string]bool)
+
+	for _, key := range keys {
+		if key.Purpose != purpose {
+			continue
+		}
+		known[key.Kid] = true
+		if key.DeletedAt.Valid {
+			continue
+		}
+
+		data, err := base64.StdEncoding.DecodeString(key.Secret)
+		if err != nil {
+			return set, err
+		}
+		signingKey, err := auth.UnmarshalKey(key.Kid, key.Algorithm, data)
+		if err != nil {
+			return set, err
+		}
+		if key.ExpiresAt != nil {
+			signingKey.ExpiresAt = *key.ExpiresAt
+		}
+
+		if key.Active {
+			set.Active = signingKey
+		} else {
+			set.Previous = append(set.Previous, signingKey)
+		}
+	}
+
+	for i, key := range configKeys {
+		if known[key.Kid] {
+			continue
+		}
+		if i == 0 && set.Active.Kid == "" {
+			set.Active = key
+			continue
+		}
+		set.Previous = append(set.Previous, key)
+	}
+
+	return set, nil
+}
+
+// Rotate implements IKeySrv.
+func (s *KeySrv) Rotate(ctx context.Context) ([]model.SigningKey, error) {
+	var created []model.SigningKey
+	for _, purpose := range []model.KeyPurpose{model.KEY_PURPOSE_ACCESS, model.KEY_PURPOSE_REFRESH} {
+		key, err := s.rotate(ctx, purpose)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, *key)
+	}
+
+	return created, s.Load()
+}
+
+// rotate retires the active key of purpose and stores a new active key
+func (s *KeySrv) rotate(ctx context.Context, purpose model.KeyPurpose) (*model.SigningKey, error) {
+	logger := logging.Logger(ctx, s.logger)
+	sets, err := s.keySets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current := sets[purpose].Active
+
+	now := time.Now()
+	expiresAt := now.Add(s.retiredTtl)
+
+	generated, err := auth.GenerateKey(s.purposeAlgorithm(purpose))
+	if err != nil {
+		logger.Errorf("Failed to generate %s key, err = %+v", purpose, err)
+		return nil, err
+	}
+	key, err := newSigningKeyModel(generated, purpose)
+	if err != nil {
+		return nil, err
+	}
+	key.Active = true
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var active model.SigningKey
+		rez := tx.Where("kid = ?", current.Kid).Limit(1).Find(&active)
+		if rez.Error != nil {
+			return rez.Error
+		}
+
+		if rez.RowsAffected == 0 {
+			// the configured key was active, store it so it expires like any other retired key
+			configured, err := newSigningKeyModel(current, purpose)
+			if err != nil {
+				return err
+			}
+			active = *configured
+		}
+		active.Active = false
+		active.RetiredAt = &now
+		active.ExpiresAt = &expiresAt
+		if err := tx.Save(&active).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(key).Error
+	})
+	if err != nil {
+		logger.Errorf("Failed to rotate %s key, err = %+v", purpose, err)
+		return nil, err
+	}
+
+	logger.Infof("Rotated %s key, retired kid = %s until %s, new kid = %s", purpose, current.Kid, expiresAt, key.Kid)
+	return key, nil
+}
+
+// newSigningKeyModel converts key to its database model
+func newSigningKeyModel(key auth.SigningKey, purpose model.KeyPurpose) (*model.SigningKey, error) {
+	data, err := auth.MarshalKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.SigningKey{
+		Kid:       key.Kid,
+		Purpose:   purpose,
+		Algorithm: key.Algorithm,
+		Secret:    base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
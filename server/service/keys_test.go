@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// --- Key Service Test Suite ---
+type keysTestSuite struct {
+	suite.Suite
+	db         *gorm.DB
+	keyService *KeySrv
+	user       *model.User
+}
+
+func (suite *keysTestSuite) SetupSuite() {
+	db, err := gorm.Open(sqlite.Open("file:keys_test.db?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(db.AutoMigrate(model.GetAllModels()...))
+	suite.db = db
+
+	suite.keyService = &KeySrv{
+		db:         db,
+		logger:     zap.NewNop().Sugar(),
+		retiredTtl: time.Hour,
+		algorithm:  app.JwtAlgHS256,
+	}
+	suite.user = &model.User{Uuid: uuid.New(), Username: "keys", Role: model.ROLE_USER}
+}
+
+func (suite *keysTestSuite) SetupTest() {
+	app.AccessKey = "test-keys-access"
+	app.RefreshKey = "test-keys-refresh"
+	app.AccessKeysPrevious = nil
+	app.RefreshKeysPrevious = nil
+	suite.keyService.algorithm = app.JwtAlgHS256
+	suite.Require().NoError(suite.db.Unscoped().Where("1 = 1").Delete(&model.SigningKey{}).Error)
+	auth.ResetKeySets()
+}
+
+func (suite *keysTestSuite) TearDownSuite() {
+	auth.ResetKeySets()
+	sqlDB, _ := suite.db.DB()
+	suite.Require().NoError(sqlDB.Close())
+}
+
+func TestKeysTestSuite(t *testing.T) {
+	suite.Run(t, new(keysTestSuite))
+}
+
+// --- Test Cases ---
+
+func (suite *keysTestSuite) TestLoad_PreviousConfigKeys() {
+	accessToken, _, err := auth.GenerateTokens(suite.user)
+	suite.Require().NoError(err)
+
+	app.AccessKeysPrevious = []string{app.AccessKey}
+	app.AccessKey = "test-keys-access-changed"
+	suite.Require().NoError(suite.keyService.Load())
+
+	_, _, err = auth.ParseToken("Bearer " + accessToken)
+	suite.NoError(err, "tokens signed with a previous config key should stay valid")
+
+	app.AccessKeysPrevious = nil
+	suite.Require().NoError(suite.keyService.Load())
+
+	_, _, err = auth.ParseToken("Bearer " + accessToken)
+	suite.ErrorIs(err, cerror.ErrUnknownSigningKey)
+}
+
+func (suite *keysTestSuite) TestRotate_OldTokensStayValid() {
+	oldAccess, oldRefresh, err := auth.GenerateTokens(suite.user)
+	suite.Require().NoError(err)
+
+	keys, err := suite.keyService.Rotate(context.Background())
+	suite.Require().NoError(err)
+	suite.Len(keys, 2)
+
+	newAccess, _, err := auth.GenerateTokens(suite.user)
+	suite.Require().NoError(err)
+	token, _, err := auth.ParseToken("Bearer " + newAccess)
+	suite.Require().NoError(err)
+	suite.NotEqual(auth.ConfigKid(app.AccessKey), token.Header["kid"])
+
+	_, _, err = auth.ParseToken("Bearer " + oldAccess)
+	suite.NoError(err)
+	_, _, err = auth.ParseRefreshToken(oldRefresh)
+	suite.NoError(err)
+}
+
+func (suite *keysTestSuite) TestRotate_SurvivesRestart() {
+	_, err := suite.keyService.Rotate(context.Background())
+	suite.Require().NoError(err)
+	accessToken, _, err := auth.GenerateTokens(suite.user)
+	suite.Require().NoError(err)
+
+	auth.ResetKeySets()
+	suite.Require().NoError(suite.keyService.Load())
+
+	_, _, err = auth.ParseToken("Bearer " + accessToken)
+	suite.NoError(err)
+}
+
+func (suite *keysTestSuite) TestReload_PicksUpKeysRotatedElsewhere() {
+	_, err := suite.keyService.Rotate(context.Background())
+	suite.Require().NoError(err)
+	// an instance that started before the rotation still signs with the config key
+	auth.ResetKeySets()
+
+	suite.Require().NoError(suite.keyService.Reload(context.Background()))
+
+	accessToken, _, err := auth.GenerateTokens(suite.user)
+	suite.Require().NoError(err)
+	token, _, err := auth.ParseToken("Bearer " + accessToken)
+	suite.Require().NoError(err)
+	suite.NotEqual(auth.ConfigKid(app.AccessKey), token.Header["kid"])
+}
+
+func (suite *keysTestSuite) TestLoad_DeletesExpiredKeys() {
+	oldAccess, _, err := auth.GenerateTokens(suite.user)
+	suite.Require().NoError(err)
+
+	_, err = suite.keyService.Rotate(context.Background())
+	suite.Require().NoError(err)
+
+	past := time.Now().Add(-time.Minute)
+	suite.Require().NoError(suite.db.Model(&model.SigningKey{}).Where("active = ?", false).Update("expires_at", past).Error)
+	suite.Require().NoError(suite.keyService.Load())
+
+	var count int64
+	suite.Require().NoError(suite.db.Model(&model.SigningKey{}).Count(&count).Error)
+	suite.EqualValues(2, count, "only the active keys should remain")
+
+	_, _, err = auth.ParseToken("Bearer " + oldAccess)
+	suite.ErrorIs(err, cerror.ErrUnknownSigningKey, "the retired config key should not be trusted again after it expired")
+}
+
+func (suite *keysTestSuite) TestLoad_SwitchToEdDSA() {
+	oldAccess, _, err := auth.GenerateTokens(suite.user)
+	suite.Require().NoError(err)
+
+	suite.keyService.algorithm = app.JwtAlgEdDSA
+	suite.Require().NoError(suite.keyService.Load())
+
+	newAccess, _, err := auth.GenerateTokens(suite.user)
+	suite.Require().NoError(err)
+	token, _, err := auth.ParseToken("Bearer " + newAccess)
+	suite.Require().NoError(err)
+	suite.Equal(app.JwtAlgEdDSA, token.Method.Alg())
+	suite.Len(auth.PublicJwks().Keys, 1)
+
+	_, _, err = auth.ParseToken("Bearer " + oldAccess)
+	suite.NoError(err, "tokens signed before the switch should stay valid")
+
+	// the generated key is reused on the next start
+	auth.ResetKeySets()
+	suite.Require().NoError(suite.keyService.Load())
+	_, _, err = auth.ParseToken("Bearer " + newAccess)
+	suite.NoError(err)
+}
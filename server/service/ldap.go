@@ -0,0 +1,297 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+	"github.com/killi1812/cloudflared-web-gui/util/logging"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// _LDAP_TIMEOUT limits connecting to and every request sent to the directory
+const _LDAP_TIMEOUT = 10 * time.Second
+
+// LdapConfig holds the directory settings, see app.Ldap* variables
+type LdapConfig struct {
+	Url         string
+	StartTls    bool
+	TlsConfig   *tls.Config
+	BindDn      string // BindDn is a template, {username} is replaced with the escaped username
+	UserBaseDn  string
+	UserFilter  string // UserFilter is a template, {username} is replaced with the escaped username
+	EmailAttr   string
+	GroupBaseDn string
+	GroupFilter string // GroupFilter is a template, {dn} is replaced with the escaped user DN
+	DefaultRole model.UserRole
+	RoleMapping map[string]model.UserRole
+}
+
+// LdapAuthenticator binds to the directory as the user and provisions users on their first login
+type LdapAuthenticator struct {
+	db     *gorm.DB
+	logger *zap.SugaredLogger
+	config LdapConfig
+}
+
+func NewLdapAuthenticator(db *gorm.DB, logger *zap.SugaredLogger, config LdapConfig) *LdapAuthenticator {
+	return &LdapAuthenticator{db: db, logger: logger, config: config}
+}
+
+// ldapConfigFromApp builds the config from app.Ldap* variables, it panics on invalid ones
+func ldapConfigFromApp(logger *zap.SugaredLogger) LdapConfig {
+	config := LdapConfig{
+		Url:         app.LdapUrl,
+		StartTls:    app.LdapStartTls,
+		BindDn:      app.LdapBindDn,
+		UserBaseDn:  app.LdapUserBaseDn,
+		UserFilter:  app.LdapUserFilter,
+		EmailAttr:   app.LdapEmailAttr,
+		GroupBaseDn: app.LdapGroupBaseDn,
+		GroupFilter: app.LdapGroupFilter,
+		RoleMapping: make(map[string]model.UserRole),
+	}
+
+	tlsConfig, err := ldapTlsConfig(app.LdapUrl, app.LdapCaFile)
+	if err != nil {
+		logger.Panicf("Invalid LDAP_URL = %s or LDAP_CA_FILE = %s, err = %+v", app.LdapUrl, app.LdapCaFile, err)
+	}
+	config.TlsConfig = tlsConfig
+	if app.LdapInsecure && strings.HasPrefix(app.LdapUrl, "ldap://") && !app.LdapStartTls {
+		logger.Warnf("LDAP_INSECURE is set, passwords are sent to directory = %s in cleartext", app.LdapUrl)
+	}
+
+	role, err := auth.ParseRole(app.LdapDefaultRole)
+	if err != nil {
+		logger.Panicf("Invalid LDAP_DEFAULT_ROLE = %s", app.LdapDefaultRole)
+	}
+	config.DefaultRole = role
+
+	for group, roleName := range app.LdapRoleMapping {
+		role, err := auth.ParseRole(roleName)
+		if err != nil {
+			logger.Panicf("Invalid role = %s in LDAP_ROLE_MAPPING for group = %s", roleName, group)
+		}
+		config.RoleMapping[group] = role
+	}
+
+	return config
+}
+
+// ldapTlsConfig verifies the directory certificate against the host of rawUrl,
+// with the CA from caFile or the system roots when caFile is empty
+func ldapTlsConfig(rawUrl, caFile string) (*tls.Config, error) {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{ServerName: parsed.Hostname(), MinVersion: tls.VersionTLS12}
+
+	if caFile == "" {
+		return config, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return config, nil
+}
+
+// Handles implements Authenticator.
+func (a *LdapAuthenticator) Handles(user *model.User) bool {
+	return user == nil || user.LdapDn != nil
+}
+
+// Authenticate implements Authenticator.
+func (a *LdapAuthenticator) Authenticate(ctx context.Context, user *model.User, username, password string) (*model.User, error) {
+	logger := logging.Logger(ctx, a.logger)
+	// most directories treat a bind with an empty password as an anonymous bind that succeeds
+	if password == "" {
+		return nil, cerror.ErrInvalidCredentials
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	dn := strings.ReplaceAll(a.config.BindDn, "{username}", ldap.EscapeDN(username))
+	if err := conn.Bind(dn, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			logger.Debugf("Directory rejected bind of dn = %s", dn)
+			return nil, cerror.ErrInvalidCredentials
+		}
+		logger.Errorf("Failed to bind to directory as dn = %s, err = %+v", dn, err)
+		return nil, err
+	}
+
+	email, memberOf := "", []string(nil)
+	if a.config.UserBaseDn != "" {
+		entry, err := a.findEntry(conn, username)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			dn = entry.DN
+			email = entry.GetAttributeValue(a.config.EmailAttr)
+			memberOf = entry.GetAttributeValues("memberOf")
+		}
+	}
+
+	groupDns := memberOf
+	if a.config.GroupBaseDn != "" {
+		if groupDns, err = a.findGroups(conn, dn); err != nil {
+			return nil, err
+		}
+	}
+
+	role, syncRole := mapGroupsToRole(groupNames(groupDns), a.config.DefaultRole, a.config.RoleMapping)
+	return a.provision(ctx, user, dn, username, email, role, syncRole)
+}
+
+// dial connects to the directory and upgrades the connection when StartTLS is enabled
+func (a *LdapAuthenticator) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(a.config.Url,
+		ldap.DialWithDialer(&net.Dialer{Timeout: _LDAP_TIMEOUT}),
+		ldap.DialWithTLSConfig(a.config.TlsConfig),
+	)
+	if err != nil {
+		a.logger.Errorf("Failed to connect to directory = %s, err = %+v", a.config.Url, err)
+		return nil, err
+	}
+	conn.SetTimeout(_LDAP_TIMEOUT)
+
+	if a.config.StartTls {
+		if err := conn.StartTLS(a.config.TlsConfig); err != nil {
+			conn.Close()
+			a.logger.Errorf("Failed to start tls with directory = %s, err = %+v", a.config.Url, err)
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// findEntry returns the entry of username or nil when the filter doesn't match exactly one entry
+func (a *LdapAuthenticator) findEntry(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	filter := strings.ReplaceAll(a.config.UserFilter, "{username}", ldap.EscapeFilter(username))
+	rez, err := conn.Search(ldap.NewSearchRequest(
+		a.config.UserBaseDn, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(_LDAP_TIMEOUT.Seconds()), false,
+		filter, []string{a.config.EmailAttr, "memberOf"}, nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		a.logger.Errorf("Failed to search user entry with filter = %s, err = %+v", filter, err)
+		return nil, err
+	}
+	if rez == nil || len(rez.Entries) != 1 {
+		a.logger.Warnf("User filter = %s didn't match exactly one entry, using the bind dn", filter)
+		return nil, nil
+	}
+	return rez.Entries[0], nil
+}
+
+// findGroups returns DNs of groups matching the group filter for the user dn
+func (a *LdapAuthenticator) findGroups(conn *ldap.Conn, dn string) ([]string, error) {
+	filter := strings.ReplaceAll(a.config.GroupFilter, "{dn}", ldap.EscapeFilter(dn))
+	rez, err := conn.Search(ldap.NewSearchRequest(
+		a.config.GroupBaseDn, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(_LDAP_TIMEOUT.Seconds()), false,
+		filter, []string{"dn"}, nil,
+	))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, nil
+		}
+		a.logger.Errorf("Failed to search groups with filter = %s, err = %+v", filter, err)
+		return nil, err
+	}
+
+	groups := make([]string, 0, len(rez.Entries))
+	for _, entry := range rez.Entries {
+		groups = append(groups, entry.DN)
+	}
+	return groups, nil
+}
+
+// provision finds the user by DN or creates it, and syncs its email and role with the directory.
+// user is the local user with the login username, it is renamed to dn when its DN changed
+func (a *LdapAuthenticator) provision(ctx context.Context, user *model.User, dn, username, email string, role model.UserRole, syncRole bool) (*model.User, error) {
+	logger := logging.Logger(ctx, a.logger)
+	var existing model.User
+	err := a.db.WithContext(ctx).Unscoped().Where("ldap_dn = ?", dn).First(&existing).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Errorf("Failed to query user by dn, err = %+v", err)
+		return nil, err
+	}
+
+	switch {
+	case err == nil && existing.DeletedAt.Valid:
+		logger.Infof("Deleted ldap user = %s tried to log in", existing.Uuid)
+		return nil, cerror.ErrInvalidCredentials
+	case err == nil:
+		user = &existing
+	case user != nil:
+		logger.Infof("Dn of ldap user = %s changed to %s", user.Uuid, dn)
+		user.LdapDn = &dn
+	default:
+		user = &model.User{
+			Uuid:     uuid.New(),
+			Username: username,
+			Email:    email,
+			Role:     role,
+			LdapDn:   &dn,
+		}
+		if err := a.db.WithContext(ctx).Create(user).Error; err != nil {
+			logger.Errorf("Failed to provision ldap user, err = %+v", err)
+			return nil, err
+		}
+
+		logger.Infof("Provisioned ldap user = %s, uuid = %s, role = %s", user.Username, user.Uuid, user.Role)
+		return user, nil
+	}
+
+	if syncRole && user.Role != role {
+		logger.Infof("Updating role of ldap user = %s from %s to %s", user.Uuid, user.Role, role)
+		user.Role = role
+	}
+	if email != "" {
+		user.Email = email
+	}
+	if err := a.db.WithContext(ctx).Save(user).Error; err != nil {
+		logger.Errorf("Failed to update ldap user = %s, err = %+v", user.Uuid, err)
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// groupNames returns the value of the first RDN of every group DN, cn=admins,ou=groups,... is admins
+func groupNames(dns []string) []string {
+	names := make([]string, 0, len(dns))
+	for _, raw := range dns {
+		dn, err := ldap.ParseDN(raw)
+		if err != nil || len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+			continue
+		}
+		names = append(names, dn.RDNs[0].Attributes[0].Value)
+	}
+	return names
+}
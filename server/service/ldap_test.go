@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"testing"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/google/uuid"
+	"github.com/jimlambrt/gldap"
+	"github.com/jimlambrt/gldap/testdirectory"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// --- LDAP Authenticator Test Suite ---
+type ldapTestSuite struct {
+	suite.Suite
+	db        *gorm.DB
+	logger    *zap.SugaredLogger
+	events    *SecurityEventSrv
+	directory *testdirectory.Directory
+}
+
+func (suite *ldapTestSuite) SetupSuite() {
+	app.AccessKey = "test-ldap-access-key"
+	app.RefreshKey = "test-ldap-refresh-key"
+	suite.logger = zap.NewNop().Sugar()
+
+	db, err := gorm.Open(sqlite.Open("file:ldap_test.db?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(db.AutoMigrate(model.GetAllModels()...))
+	suite.db = db
+	suite.events = &SecurityEventSrv{db: db, logger: suite.logger}
+
+	t := suite.T()
+	users := testdirectory.NewUsers(t, []string{"alice", "bob"})
+	users = append(users, testdirectory.NewUsers(t, []string{"carol"},
+		testdirectory.WithMembersOf(t, "cn=gui-admins,"+testdirectory.DefaultGroupDN))...)
+	users = append(users, testdirectory.NewUsers(t, []string{"dave", "erin", "frank", "grace"})...)
+	suite.directory = testdirectory.Start(t,
+		testdirectory.WithNoTLS(t),
+		testdirectory.WithDefaults(t, &testdirectory.Defaults{
+			Users: users,
+			Groups: []*gldap.Entry{
+				testdirectory.NewGroup(t, "gui-viewers", []string{"alice"}),
+				testdirectory.NewGroup(t, "gui-operators", []string{"alice"}),
+			},
+		}),
+	)
+}
+
+func (suite *ldapTestSuite) TearDownSuite() {
+	sqlDB, _ := suite.db.DB()
+	suite.Require().NoError(sqlDB.Close())
+}
+
+func TestLdapTestSuite(t *testing.T) {
+	suite.Run(t, new(ldapTestSuite))
+}
+
+// config returns a config for the test directory that maps groups from memberOf
+func (suite *ldapTestSuite) config() LdapConfig {
+	return LdapConfig{
+		Url:         fmt.Sprintf("ldap://%s:%d", suite.directory.Host(), suite.directory.Port()),
+		BindDn:      "cn={username}," + testdirectory.DefaultUserDN,
+		UserBaseDn:  testdirectory.DefaultUserDN,
+		UserFilter:  "(cn={username})",
+		EmailAttr:   "email",
+		GroupFilter: "(member={dn})",
+		DefaultRole: model.ROLE_USER,
+		RoleMapping: map[string]model.UserRole{
+			"gui-admins":    model.ROLE_SUPER_ADMIN,
+			"gui-viewers":   model.ROLE_VIEWER,
+			"gui-operators": model.ROLE_ADMIN,
+		},
+	}
+}
+
+// authService returns an auth service checking local passwords first and the directory second
+func (suite *ldapTestSuite) authService(config LdapConfig) *AuthService {
+	return &AuthService{
+		db:     suite.db,
+		logger: suite.logger,
+		events: suite.events,
+		authenticators: []Authenticator{
+			NewLocalAuthenticator(suite.db, suite.logger),
+			NewLdapAuthenticator(suite.db, suite.logger, config),
+		},
+	}
+}
+
+// findUser returns the user with username including deleted ones
+func (suite *ldapTestSuite) findUser(username string) model.User {
+	var user model.User
+	suite.Require().NoError(suite.db.Unscoped().Where("username = ?", username).First(&user).Error)
+	return user
+}
+
+// --- Test Cases ---
+
+func (suite *ldapTestSuite) TestLogin_ProvisionsUserOnFirstLogin() {
+	token, err := suite.authService(suite.config()).Login(context.Background(), "carol", "password", ClientInfo{})
+	suite.Require().NoError(err)
+	suite.NotEmpty(token)
+
+	user := suite.findUser("carol")
+	suite.Require().NotNil(user.LdapDn)
+	suite.Equal("cn=carol,"+testdirectory.DefaultUserDN, *user.LdapDn)
+	suite.Equal("carol@example.com", user.Email)
+	suite.Equal(model.ROLE_SUPER_ADMIN, user.Role)
+	suite.Empty(user.PasswordHash)
+
+	_, err = suite.authService(suite.config()).Login(context.Background(), "carol", "password", ClientInfo{})
+	suite.Require().NoError(err)
+	var count int64
+	suite.Require().NoError(suite.db.Model(&model.User{}).Where("username = ?", "carol").Count(&count).Error)
+	suite.EqualValues(1, count)
+}
+
+func (suite *ldapTestSuite) TestLogin_GroupSearchMapsHighestRole() {
+	config := suite.config()
+	config.GroupBaseDn = testdirectory.DefaultGroupDN
+
+	_, err := suite.authService(config).Login(context.Background(), "alice", "password", ClientInfo{})
+	suite.Require().NoError(err)
+	suite.Equal(model.ROLE_ADMIN, suite.findUser("alice").Role)
+}
+
+func (suite *ldapTestSuite) TestLogin_DefaultRoleAndRoleSync() {
+	_, err := suite.authService(suite.config()).Login(context.Background(), "bob", "password", ClientInfo{})
+	suite.Require().NoError(err)
+	suite.Equal(model.ROLE_USER, suite.findUser("bob").Role)
+
+	config := suite.config()
+	config.DefaultRole = model.ROLE_VIEWER
+	_, err = suite.authService(config).Login(context.Background(), "bob", "password", ClientInfo{})
+	suite.Require().NoError(err)
+	suite.Equal(model.ROLE_VIEWER, suite.findUser("bob").Role, "users without a mapped group get the default role")
+
+	config.RoleMapping = nil
+	config.DefaultRole = model.ROLE_ADMIN
+	_, err = suite.authService(config).Login(context.Background(), "bob", "password", ClientInfo{})
+	suite.Require().NoError(err)
+	suite.Equal(model.ROLE_VIEWER, suite.findUser("bob").Role, "without a role mapping the role isn't synced")
+}
+
+func (suite *ldapTestSuite) TestLogin_RemovedFromGroupIsDemoted() {
+	config := suite.config()
+	config.GroupBaseDn = testdirectory.DefaultGroupDN
+	groups := suite.directory.Groups()
+	suite.T().Cleanup(func() { suite.directory.SetGroups(groups...) })
+
+	suite.directory.SetGroups(append(groups, testdirectory.NewGroup(suite.T(), "gui-operators", []string{"grace"}))...)
+	_, err := suite.authService(config).Login(context.Background(), "grace", "password", ClientInfo{})
+	suite.Require().NoError(err)
+	suite.Equal(model.ROLE_ADMIN, suite.findUser("grace").Role)
+
+	suite.directory.SetGroups(groups...)
+	_, err = suite.authService(config).Login(context.Background(), "grace", "password", ClientInfo{})
+	suite.Require().NoError(err)
+	suite.Equal(model.ROLE_USER, suite.findUser("grace").Role)
+}
+
+func (suite *ldapTestSuite) TestLogin_WrongPassword() {
+	service := suite.authService(suite.config())
+
+	_, err := service.Login(context.Background(), "dave", "wrong-password", ClientInfo{})
+	suite.ErrorIs(err, cerror.ErrInvalidCredentials)
+	_, err = service.Login(context.Background(), "dave", "", ClientInfo{})
+	suite.ErrorIs(err, cerror.ErrInvalidCredentials)
+	_, err = service.Login(context.Background(), "nobody", "password", ClientInfo{})
+	suite.ErrorIs(err, cerror.ErrInvalidCredentials)
+
+	var count int64
+	suite.Require().NoError(suite.db.Model(&model.User{}).Where("username IN ?", []string{"dave", "nobody"}).Count(&count).Error)
+	suite.Zero(count)
+}
+
+func (suite *ldapTestSuite) TestLogin_LocalUserIsNotCheckedAgainstDirectory() {
+	hash, err := auth.HashPassword("local-password")
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.db.Create(&model.User{Uuid: uuid.New(), Username: "erin", PasswordHash: hash, Role: model.ROLE_USER}).Error)
+	service := suite.authService(suite.config())
+
+	_, err = service.Login(context.Background(), "erin", "password", ClientInfo{})
+	suite.ErrorIs(err, cerror.ErrInvalidCredentials)
+	_, err = service.Login(context.Background(), "erin", "local-password", ClientInfo{})
+	suite.NoError(err)
+	suite.Nil(suite.findUser("erin").LdapDn)
+}
+
+func (suite *ldapTestSuite) TestLogin_DeletedUserIsNotProvisionedAgain() {
+	service := suite.authService(suite.config())
+	_, err := service.Login(context.Background(), "frank", "password", ClientInfo{})
+	suite.Require().NoError(err)
+	user := suite.findUser("frank")
+	suite.Require().NoError(suite.db.Delete(&user).Error)
+
+	_, err = service.Login(context.Background(), "frank", "password", ClientInfo{})
+	suite.ErrorIs(err, cerror.ErrInvalidCredentials)
+}
+
+func (suite *ldapTestSuite) TestLogin_StartTls() {
+	roots := x509.NewCertPool()
+	suite.Require().True(roots.AppendCertsFromPEM([]byte(suite.directory.Cert())))
+	config := suite.config()
+	config.StartTls = true
+	config.TlsConfig = &tls.Config{RootCAs: roots, ServerName: suite.directory.Host()}
+
+	_, err := NewLdapAuthenticator(suite.db, suite.logger, config).Authenticate(context.Background(), nil, "alice", "password")
+	suite.NoError(err)
+
+	config.TlsConfig = &tls.Config{ServerName: suite.directory.Host()}
+	_, err = NewLdapAuthenticator(suite.db, suite.logger, config).Authenticate(context.Background(), nil, "alice", "password")
+	suite.Error(err, "the directory certificate must be verified")
+	suite.NotErrorIs(err, cerror.ErrInvalidCredentials)
+}
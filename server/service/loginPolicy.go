@@ -0,0 +1,56 @@
+package service
+
+import (
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+)
+
+// LoginPolicy configures login brute-force protection, zero values disable the given protection
+type LoginPolicy struct {
+	RateWindow       time.Duration
+	RateIp           int
+	RateUsername     int
+	DelayBase        time.Duration
+	DelayMax         time.Duration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+}
+
+// loginPolicyFromConfig creates a policy from app.Login* variables
+func loginPolicyFromConfig() LoginPolicy {
+	return LoginPolicy{
+		RateWindow:       app.LoginRateWindow,
+		RateIp:           app.LoginRateIp,
+		RateUsername:     app.LoginRateUsername,
+		DelayBase:        app.LoginDelayBase,
+		DelayMax:         app.LoginDelayMax,
+		LockoutThreshold: app.LoginLockoutThreshold,
+		LockoutDuration:  app.LoginLockoutDuration,
+	}
+}
+
+// nextAttempt returns when the user is allowed to try again after failed logins,
+// the wait doubles with every failure starting at DelayBase and is capped at DelayMax
+func (p LoginPolicy) nextAttempt(user *model.User) time.Time {
+	if p.DelayBase <= 0 || user.FailedLogins == 0 || user.LastFailedLogin == nil {
+		return time.Time{}
+	}
+
+	delay := p.DelayBase
+	for i := 1; i < user.FailedLogins; i++ {
+		delay *= 2
+		if p.DelayMax > 0 && delay >= p.DelayMax {
+			delay = p.DelayMax
+			break
+		}
+	}
+
+	return user.LastFailedLogin.Add(delay)
+}
+
+// shouldLock reports if the failed login count reached the lockout threshold
+func (p LoginPolicy) shouldLock(user *model.User) bool {
+	return p.LockoutThreshold > 0 && p.LockoutDuration > 0 && user.FailedLogins >= p.LockoutThreshold
+}
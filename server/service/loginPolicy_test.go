@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+	"github.com/killi1812/cloudflared-web-gui/util/ratelimit"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestLoginPolicy_NextAttempt(t *testing.T) {
+	policy := LoginPolicy{DelayBase: time.Second, DelayMax: 5 * time.Second}
+	last := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 1, want: time.Second},
+		{failures: 2, want: 2 * time.Second},
+		{failures: 3, want: 4 * time.Second},
+		{failures: 4, want: 5 * time.Second},
+		{failures: 50, want: 5 * time.Second},
+	}
+	for _, tt := range tests {
+		user := model.User{FailedLogins: tt.failures, LastFailedLogin: &last}
+		if got := policy.nextAttempt(&user).Sub(last); got != tt.want {
+			t.Errorf("nextAttempt() with %d failures = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+
+	if next := policy.nextAttempt(&model.User{}); !next.IsZero() {
+		t.Errorf("nextAttempt() without failures = %v, want zero time", next)
+	}
+}
+
+// --- Login brute-force protection Test Suite ---
+type loginPolicyTestSuite struct {
+	suite.Suite
+	db          *gorm.DB
+	logObserver *observer.ObservedLogs
+	authService *AuthService
+	rawPass     string
+}
+
+func (suite *loginPolicyTestSuite) SetupSuite() {
+	core, obs := observer.New(zap.InfoLevel)
+	suite.logObserver = obs
+	log := zap.New(core).Sugar()
+
+	db, err := gorm.Open(sqlite.Open("file:login_policy_test.db?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(db.AutoMigrate(model.GetAllModels()...))
+	suite.db = db
+
+	suite.authService = &AuthService{
+		db:     db,
+		logger: log,
+		events: &SecurityEventSrv{db: db, logger: log},
+	}
+	suite.rawPass = "password123"
+}
+
+func (suite *loginPolicyTestSuite) TearDownSuite() {
+	sqlDB, _ := suite.db.DB()
+	suite.Require().NoError(sqlDB.Close())
+}
+
+func TestLoginPolicyTestSuite(t *testing.T) {
+	suite.Run(t, new(loginPolicyTestSuite))
+}
+
+// usePolicy replaces the policy and limiters of the service
+func (suite *loginPolicyTestSuite) usePolicy(policy LoginPolicy) {
+	suite.authService.policy = policy
+	suite.authService.ipLimiter = ratelimit.New(policy.RateIp, policy.RateWindow)
+	suite.authService.usernameLimiter = ratelimit.New(policy.RateUsername, policy.RateWindow)
+}
+
+// --- Test Cases ---
+
+func (suite *loginPolicyTestSuite) TestLogin_RateLimitedPerIp() {
+	suite.usePolicy(LoginPolicy{RateWindow: time.Minute, RateIp: 2})
+	client := ClientInfo{Ip: "10.0.0.1"}
+
+	for range 2 {
+		_, err := suite.authService.Login(context.Background(), "unknown", "password", client)
+		suite.ErrorIs(err, cerror.ErrInvalidCredentials)
+	}
+
+	_, err := suite.authService.Login(context.Background(), "unknown", "password", client)
+	var retryErr *cerror.RetryError
+	suite.Require().ErrorAs(err, &retryErr)
+	suite.ErrorIs(err, cerror.ErrTooManyRequests)
+	suite.Positive(retryErr.RetryAfter)
+
+	_, err = suite.authService.Login(context.Background(), "unknown", "password", ClientInfo{Ip: "10.0.0.2"})
+	suite.ErrorIs(err, cerror.ErrInvalidCredentials, "other ips should not be limited")
+}
+
+func (suite *loginPolicyTestSuite) TestLogin_RateLimitedPerUsername() {
+	suite.usePolicy(LoginPolicy{RateWindow: time.Minute, RateUsername: 1})
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+
+	_, err := suite.authService.Login(context.Background(), user.Username, "wrong", ClientInfo{Ip: "10.0.0.1"})
+	suite.ErrorIs(err, cerror.ErrInvalidCredentials)
+
+	_, err = suite.authService.Login(context.Background(), user.Username, suite.rawPass, ClientInfo{Ip: "10.0.0.2"})
+	suite.ErrorIs(err, cerror.ErrTooManyRequests)
+}
+
+func (suite *loginPolicyTestSuite) TestLogin_ProgressiveDelay() {
+	suite.usePolicy(LoginPolicy{DelayBase: time.Hour})
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+
+	_, err := suite.authService.Login(context.Background(), user.Username, "wrong", ClientInfo{})
+	suite.ErrorIs(err, cerror.ErrInvalidCredentials)
+
+	_, err = suite.authService.Login(context.Background(), user.Username, suite.rawPass, ClientInfo{})
+	suite.ErrorIs(err, cerror.ErrTooManyRequests)
+}
+
+func (suite *loginPolicyTestSuite) TestLogin_LockoutAndUnlock() {
+	suite.usePolicy(LoginPolicy{LockoutThreshold: 3, LockoutDuration: time.Hour})
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+
+	for range 3 {
+		_, err := suite.authService.Login(context.Background(), user.Username, "wrong", ClientInfo{})
+		suite.ErrorIs(err, cerror.ErrInvalidCredentials)
+	}
+
+	_, err := suite.authService.Login(context.Background(), user.Username, suite.rawPass, ClientInfo{})
+	suite.ErrorIs(err, cerror.ErrAccountLocked)
+	suite.NotZero(suite.logObserver.FilterField(zap.Any("type", model.EVENT_ACCOUNT_LOCKED)).Len())
+
+	suite.Require().NoError(suite.authService.Unlock(context.Background(), user.Uuid, ClientInfo{}))
+
+	token, err := suite.authService.Login(context.Background(), user.Username, suite.rawPass, ClientInfo{})
+	suite.NoError(err)
+	suite.NotEmpty(token)
+}
+
+func (suite *loginPolicyTestSuite) TestLogin_LockoutExpires() {
+	suite.usePolicy(LoginPolicy{LockoutThreshold: 3, LockoutDuration: time.Hour})
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+
+	past := time.Now().Add(-time.Minute)
+	user.FailedLogins = 3
+	user.LastFailedLogin = &past
+	user.LockedUntil = &past
+	suite.Require().NoError(suite.db.Save(user).Error)
+
+	_, err := suite.authService.Login(context.Background(), user.Username, suite.rawPass, ClientInfo{})
+	suite.Require().NoError(err)
+
+	var saved model.User
+	suite.Require().NoError(suite.db.Where("uuid = ?", user.Uuid).First(&saved).Error)
+	suite.Zero(saved.FailedLogins)
+	suite.Nil(saved.LockedUntil)
+}
+
+func (suite *loginPolicyTestSuite) TestLoginFailed_CountsConcurrentFailures() {
+	suite.usePolicy(LoginPolicy{LockoutThreshold: 2, LockoutDuration: time.Hour})
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+	// both logins loaded the user before either failure was saved
+	first, second := *user, *user
+
+	suite.Require().NoError(suite.authService.loginFailed(context.Background(), &first, time.Now(), ClientInfo{}, "invalid password"))
+	suite.Require().NoError(suite.authService.loginFailed(context.Background(), &second, time.Now(), ClientInfo{}, "invalid password"))
+
+	var saved model.User
+	suite.Require().NoError(suite.db.Where("uuid = ?", user.Uuid).First(&saved).Error)
+	suite.Equal(2, saved.FailedLogins)
+	suite.NotNil(saved.LockedUntil)
+}
+
+func (suite *loginPolicyTestSuite) TestLoginFailed_KeepsChangesMadeDuringLogin() {
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+	stale := *user
+	// an admin resets the password and demotes the user while the login verifies the password
+	suite.Require().NoError(suite.db.Model(&model.User{}).Where("id = ?", user.ID).
+		Updates(map[string]any{"password_hash": "reset", "role": model.ROLE_VIEWER}).Error)
+
+	suite.Require().NoError(suite.authService.loginFailed(context.Background(), &stale, time.Now(), ClientInfo{}, "invalid password"))
+	suite.Require().NoError(suite.authService.resetFailedLogins(context.Background(), &stale))
+
+	var saved model.User
+	suite.Require().NoError(suite.db.Where("uuid = ?", user.Uuid).First(&saved).Error)
+	suite.Equal("reset", saved.PasswordHash)
+	suite.Equal(model.ROLE_VIEWER, saved.Role)
+}
+
+func (suite *loginPolicyTestSuite) TestChangePassword_FailuresLockAccount() {
+	suite.usePolicy(LoginPolicy{LockoutThreshold: 2, LockoutDuration: time.Hour})
+	userService := &UserCrudService{db: suite.db, logger: zap.NewNop().Sugar(), auth: suite.authService}
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+
+	for range 2 {
+		_, err := userService.ChangePassword(context.Background(), user.Uuid, "wrong", "new-password-456", ClientInfo{})
+		suite.ErrorIs(err, cerror.ErrInvalidCredentials)
+	}
+
+	_, err := userService.ChangePassword(context.Background(), user.Uuid, suite.rawPass, "new-password-456", ClientInfo{})
+	suite.ErrorIs(err, cerror.ErrAccountLocked)
+	_, err = suite.authService.Login(context.Background(), user.Username, suite.rawPass, ClientInfo{})
+	suite.ErrorIs(err, cerror.ErrAccountLocked)
+
+	var events []model.SecurityEvent
+	suite.Require().NoError(suite.db.Where("user_uuid = ? AND type = ?", user.Uuid, model.EVENT_LOGIN_FAILED).Find(&events).Error)
+	suite.Require().NotEmpty(events)
+	suite.Equal("invalid current password", events[0].Reason)
+}
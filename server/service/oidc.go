@@ -0,0 +1,366 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+	"github.com/killi1812/cloudflared-web-gui/util/logging"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// OidcLoginDuration is how long the browser has to finish a login at the identity provider
+const OidcLoginDuration = 10 * time.Minute
+
+type IOidcSrv interface {
+	// Enabled reports whether OpenID Connect login is configured
+	Enabled() bool
+	// AuthCodeURL returns the identity provider url the browser should be redirected to
+	// and the login cookie the browser must send back to the callback
+	AuthCodeURL(ctx context.Context) (string, string, error)
+	// Exchange finishes the login with the login cookie and the state and code returned to the callback,
+	// and returns an access token
+	Exchange(ctx context.Context, loginCookie, state, code string, client ClientInfo) (string, error)
+}
+
+// OidcConfig holds the identity provider settings, see app.Oidc* variables
+type OidcConfig struct {
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectUrl  string
+	Scopes       []string
+	DefaultRole  model.UserRole
+	GroupsClaim  string
+	RoleMapping  map[string]model.UserRole
+}
+
+type OidcSrv struct {
+	db     *gorm.DB
+	logger *zap.SugaredLogger
+	auth   IAuthService
+	events ISecurityEventSrv
+	config OidcConfig
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// oidcLogin is a login attempt waiting for the identity provider callback, it is kept in a cookie of the
+// browser that started it so no other browser can finish it and any instance can handle the callback
+type oidcLogin struct {
+	state    string
+	verifier string
+	nonce    string
+}
+
+// cookie encodes the attempt, all values are base64url so they can be joined with dots
+func (l oidcLogin) cookie() string {
+	return strings.Join([]string{l.state, l.verifier, l.nonce}, ".")
+}
+
+func parseOidcLogin(cookie string) (oidcLogin, bool) {
+	parts := strings.Split(cookie, ".")
+	if len(parts) != 3 || slices.Contains(parts, "") {
+		return oidcLogin{}, false
+	}
+	return oidcLogin{state: parts[0], verifier: parts[1], nonce: parts[2]}, true
+}
+
+func NewOidcSrv() IOidcSrv {
+	var service IOidcSrv
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger, authSrv IAuthService, events ISecurityEventSrv) {
+		config := OidcConfig{
+			Issuer:       app.OidcIssuer,
+			ClientId:     app.OidcClientId,
+			ClientSecret: app.OidcClientSecret,
+			RedirectUrl:  app.OidcRedirectUrl,
+			Scopes:       app.OidcScopes,
+			GroupsClaim:  app.OidcGroupsClaim,
+			RoleMapping:  make(map[string]model.UserRole),
+		}
+
+		role, err := auth.ParseRole(app.OidcDefaultRole)
+		if err != nil {
+			logger.Panicf("Invalid OIDC_DEFAULT_ROLE = %s", app.OidcDefaultRole)
+		}
+		config.DefaultRole = role
+
+		for group, roleName := range app.OidcRoleMapping {
+			role, err := auth.ParseRole(roleName)
+			if err != nil {
+				logger.Panicf("Invalid role = %s in OIDC_ROLE_MAPPING for group = %s", roleName, group)
+			}
+			config.RoleMapping[group] = role
+		}
+
+		service = &OidcSrv{
+			db:     db,
+			logger: logger,
+			auth:   authSrv,
+			events: events,
+			config: config,
+		}
+	})
+
+	return service
+}
+
+// Enabled implements IOidcSrv.
+func (s *OidcSrv) Enabled() bool {
+	return s.config.Issuer != ""
+}
+
+// AuthCodeURL implements IOidcSrv.
+func (s *OidcSrv) AuthCodeURL(ctx context.Context) (string, string, error) {
+	oauthConfig, err := s.oauthConfig(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	login := oidcLogin{state: state, verifier: oauth2.GenerateVerifier(), nonce: nonce}
+
+	authUrl := oauthConfig.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(login.verifier))
+	return authUrl, login.cookie(), nil
+}
+
+// Exchange implements IOidcSrv.
+func (s *OidcSrv) Exchange(ctx context.Context, loginCookie, state, code string, client ClientInfo) (string, error) {
+	user, err := s.authenticate(ctx, loginCookie, state, code)
+	if err != nil {
+		reason := "openid connect login failed"
+		switch {
+		case errors.Is(err, cerror.ErrOidcInvalidState):
+			reason = "invalid openid connect state"
+		case errors.Is(err, cerror.ErrUserDeleted):
+			reason = "deleted user"
+		}
+		s.record(ctx, model.EVENT_LOGIN_FAILED, user, client, reason)
+		return "", err
+	}
+
+	s.record(ctx, model.EVENT_LOGIN_SUCCEEDED, user, client, "openid connect")
+	return s.auth.CreateSession(ctx, user)
+}
+
+// authenticate verifies the callback and returns the provisioned user,
+// the user is also returned with cerror.ErrUserDeleted so the failure can be attributed
+func (s *OidcSrv) authenticate(ctx context.Context, loginCookie, state, code string) (*model.User, error) {
+	oauthConfig, err := s.oauthConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	attempt, ok := parseOidcLogin(loginCookie)
+	if !ok || subtle.ConstantTimeCompare([]byte(attempt.state), []byte(state)) != 1 {
+		s.logger.Infof("Oidc state doesn't match the login cookie of the browser")
+		return nil, cerror.ErrOidcInvalidState
+	}
+
+	oauthToken, err := oauthConfig.Exchange(ctx, code, oauth2.VerifierOption(attempt.verifier))
+	if err != nil {
+		s.logger.Errorf("Failed to exchange oidc code, err = %v", err)
+		return nil, err
+	}
+
+	rawIdToken, ok := oauthToken.Extra("id_token").(string)
+	if !ok {
+		return nil, cerror.ErrOidcMissingIdToken
+	}
+
+	idToken, err := s.provider.Verifier(&oidc.Config{ClientID: s.config.ClientId}).Verify(ctx, rawIdToken)
+	if err != nil {
+		s.logger.Errorf("Failed to verify id token, err = %v", err)
+		return nil, err
+	}
+	if idToken.Nonce != attempt.nonce {
+		s.logger.Errorf("Id token nonce doesn't match")
+		return nil, cerror.ErrOidcInvalidState
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		s.logger.Errorf("Failed to decode id token claims, err = %v", err)
+		return nil, err
+	}
+
+	return s.provision(ctx, idToken.Subject, claims)
+}
+
+// record stores a security event if an event service is configured
+func (s *OidcSrv) record(ctx context.Context, eventType model.SecurityEventType, user *model.User, client ClientInfo, reason string) {
+	if s.events == nil {
+		return
+	}
+
+	event := model.SecurityEvent{
+		Type:      eventType,
+		Ip:        client.Ip,
+		UserAgent: client.UserAgent,
+		Reason:    reason,
+	}
+	if user != nil {
+		event.UserUuid = &user.Uuid
+		event.Username = user.Username
+	}
+	s.events.Record(ctx, event)
+}
+
+// provision finds the user by subject or creates it, and syncs its role with the mapped groups, see mapGroupsToRole
+func (s *OidcSrv) provision(ctx context.Context, subject string, claims map[string]any) (*model.User, error) {
+	logger := logging.Logger(ctx, s.logger)
+	role, syncRole := s.mapRole(claims)
+
+	// deleted users keep their subject, so they are found and rejected instead of recreated
+	var user model.User
+	err := s.db.WithContext(ctx).Unscoped().Where("oidc_subject = ?", subject).First(&user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Errorf("Failed to query user by subject, err = %v", err)
+		return nil, err
+	}
+	if err == nil && user.DeletedAt.Valid {
+		logger.Infof("Deleted oidc user = %s tried to log in", user.Uuid)
+		return &user, cerror.ErrUserDeleted
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		username, err := uniqueUsername(ctx, s.db, claimString(claims, "preferred_username", "email", "sub"))
+		if err != nil {
+			return nil, err
+		}
+
+		user = model.User{
+			Uuid:        uuid.New(),
+			Username:    username,
+			Email:       claimString(claims, "email"),
+			Role:        role,
+			OidcSubject: &subject,
+		}
+		if err := s.db.WithContext(ctx).Create(&user).Error; err != nil {
+			logger.Errorf("Failed to provision oidc user, err = %v", err)
+			return nil, err
+		}
+
+		logger.Infof("Provisioned oidc user = %s, uuid = %s, role = %s", user.Username, user.Uuid, user.Role)
+		return &user, nil
+	}
+
+	if syncRole && user.Role != role {
+		logger.Infof("Updating role of oidc user = %s from %s to %s", user.Uuid, user.Role, role)
+		if err := s.db.WithContext(ctx).Model(&user).Update("role", role).Error; err != nil {
+			logger.Errorf("Failed to update role of oidc user = %s, err = %v", user.Uuid, err)
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// mapRole returns the role mapped from the groups claim, see mapGroupsToRole
+func (s *OidcSrv) mapRole(claims map[string]any) (model.UserRole, bool) {
+	groups, _ := claims[s.config.GroupsClaim].([]any)
+
+	names := make([]string, 0, len(groups))
+	for _, group := range groups {
+		if name, ok := group.(string); ok {
+			names = append(names, name)
+		}
+	}
+
+	return mapGroupsToRole(names, s.config.DefaultRole, s.config.RoleMapping)
+}
+
+// mapGroupsToRole returns the most privileged role mapped from groups, or defaultRole if none of the groups are mapped,
+// and whether existing users should be synced to it. That is whenever a mapping is configured, so a user removed
+// from every mapped group falls back to defaultRole, without one roles changed in the gui are kept
+func mapGroupsToRole(groups []string, defaultRole model.UserRole, mapping map[string]model.UserRole) (model.UserRole, bool) {
+	role, matched := defaultRole, false
+	for _, group := range groups {
+		groupRole, ok := mapping[group]
+		if !ok {
+			continue
+		}
+		if !matched {
+			role, matched = groupRole, true
+			continue
+		}
+		role = model.HigherRole(role, groupRole)
+	}
+
+	return role, len(mapping) != 0
+}
+
+// oauthConfig lazily discovers the provider so the app can start while the provider is unreachable
+func (s *OidcSrv) oauthConfig(ctx context.Context) (*oauth2.Config, error) {
+	if !s.Enabled() {
+		return nil, cerror.ErrOidcNotConfigured
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.provider == nil {
+		provider, err := oidc.NewProvider(ctx, s.config.Issuer)
+		if err != nil {
+			s.logger.Errorf("Failed to discover oidc provider = %s, err = %v", s.config.Issuer, err)
+			return nil, err
+		}
+		s.provider = provider
+	}
+
+	scopes := []string{oidc.ScopeOpenID}
+	for _, scope := range s.config.Scopes {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return &oauth2.Config{
+		ClientID:     s.config.ClientId,
+		ClientSecret: s.config.ClientSecret,
+		RedirectURL:  s.config.RedirectUrl,
+		Endpoint:     s.provider.Endpoint(),
+		Scopes:       scopes,
+	}, nil
+}
+
+// claimString returns the first non empty string claim of the given names
+func claimString(claims map[string]any, names ...string) string {
+	for _, name := range names {
+		if value, ok := claims[name].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func randomString() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,377 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// --- Mock OpenID Connect provider ---
+
+// mockOidcProvider is a minimal identity provider serving discovery, jwks and token endpoints
+type mockOidcProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockOidcCode
+}
+
+type mockOidcCode struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newMockOidcProvider() (*mockOidcProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &mockOidcProvider{key: key, codes: make(map[string]mockOidcCode)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                p.server.URL,
+			"authorization_endpoint":                p.server.URL + "/authorize",
+			"token_endpoint":                        p.server.URL + "/token",
+			"jwks_uri":                              p.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", p.token)
+	p.server = httptest.NewServer(mux)
+
+	return p, nil
+}
+
+// authorize simulates a user logging in at the provider and returns the code for the callback
+func (p *mockOidcProvider) authorize(authUrl string, claims jwt.MapClaims) (state, code string, err error) {
+	parsed, err := url.Parse(authUrl)
+	if err != nil {
+		return "", "", err
+	}
+	query := parsed.Query()
+
+	claims["iss"] = p.server.URL
+	claims["aud"] = query.Get("client_id")
+	claims["nonce"] = query.Get("nonce")
+	claims["iat"] = time.Now().Unix()
+	claims["exp"] = time.Now().Add(time.Minute).Unix()
+
+	code, err = randomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	p.mu.Lock()
+	p.codes[code] = mockOidcCode{challenge: query.Get("code_challenge"), claims: claims}
+	p.mu.Unlock()
+
+	return query.Get("state"), code, nil
+}
+
+func (p *mockOidcProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	code, ok := p.codes[r.FormValue("code")]
+	delete(p.codes, r.FormValue("code"))
+	p.mu.Unlock()
+	if !ok {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	// PKCE S256 verification
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, code.claims)
+	idToken.Header["kid"] = "test"
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     signed,
+	})
+}
+
+// --- Oidc Service Test Suite ---
+type oidcTestSuite struct {
+	suite.Suite
+	db          *gorm.DB
+	provider    *mockOidcProvider
+	oidcService *OidcSrv
+}
+
+func (suite *oidcTestSuite) SetupSuite() {
+	app.AccessKey = "test-oidc-access-key"
+	app.RefreshKey = "test-oidc-refresh-key"
+	log := zap.NewNop().Sugar()
+
+	db, err := gorm.Open(sqlite.Open("file:oidc_test.db?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(db.AutoMigrate(model.GetAllModels()...))
+	suite.db = db
+
+	suite.provider, err = newMockOidcProvider()
+	suite.Require().NoError(err)
+
+	suite.oidcService = &OidcSrv{
+		db:     db,
+		logger: log,
+		auth:   &AuthService{db: db, logger: log},
+		events: &SecurityEventSrv{db: db, logger: log},
+		config: OidcConfig{
+			Issuer:       suite.provider.server.URL,
+			ClientId:     "cloudflared-web-gui",
+			ClientSecret: "secret",
+			RedirectUrl:  "http://localhost/api/auth/oidc/callback",
+			Scopes:       []string{"profile", "email"},
+			DefaultRole:  model.ROLE_USER,
+			GroupsClaim:  "groups",
+			RoleMapping: map[string]model.UserRole{
+				"ops":    model.ROLE_ADMIN,
+				"owners": model.ROLE_SUPER_ADMIN,
+			},
+		},
+	}
+}
+
+func (suite *oidcTestSuite) TearDownSuite() {
+	suite.provider.server.Close()
+	sqlDB, _ := suite.db.DB()
+	suite.Require().NoError(sqlDB.Close())
+}
+
+func TestOidcTestSuite(t *testing.T) {
+	suite.Run(t, new(oidcTestSuite))
+}
+
+// login runs the full authorization code flow for the given id token claims
+func (suite *oidcTestSuite) login(claims jwt.MapClaims) (string, error) {
+	ctx := context.Background()
+	authUrl, loginCookie, err := suite.oidcService.AuthCodeURL(ctx)
+	suite.Require().NoError(err)
+
+	state, code, err := suite.provider.authorize(authUrl, claims)
+	suite.Require().NoError(err)
+
+	return suite.oidcService.Exchange(ctx, loginCookie, state, code, ClientInfo{})
+}
+
+// --- Test Cases ---
+
+func (suite *oidcTestSuite) TestAuthCodeURL_UsesPkce() {
+	authUrl, _, err := suite.oidcService.AuthCodeURL(context.Background())
+	suite.Require().NoError(err)
+
+	parsed, err := url.Parse(authUrl)
+	suite.Require().NoError(err)
+	suite.Equal("S256", parsed.Query().Get("code_challenge_method"))
+	suite.NotEmpty(parsed.Query().Get("code_challenge"))
+	suite.Contains(parsed.Query().Get("scope"), "openid")
+}
+
+func (suite *oidcTestSuite) TestExchange_ProvisionsUserWithDefaultRole() {
+	accessToken, err := suite.login(jwt.MapClaims{
+		"sub":                "subject-default",
+		"preferred_username": "jane",
+		"email":              "jane@example.com",
+	})
+	suite.Require().NoError(err)
+
+	_, claims, err := auth.ParseToken("Bearer " + accessToken)
+	suite.Require().NoError(err)
+	suite.Equal("jane", claims.Username)
+	suite.Equal(model.ROLE_USER, claims.Role)
+
+	var user model.User
+	suite.Require().NoError(suite.db.Where("oidc_subject = ?", "subject-default").First(&user).Error)
+	suite.Equal("jane@example.com", user.Email)
+	suite.Equal(claims.ID, user.Uuid.String())
+}
+
+func (suite *oidcTestSuite) TestExchange_MapsGroupsToHighestRole() {
+	accessToken, err := suite.login(jwt.MapClaims{
+		"sub":    "subject-groups",
+		"email":  "ops@example.com",
+		"groups": []string{"unmapped", "ops", "owners"},
+	})
+	suite.Require().NoError(err)
+
+	_, claims, err := auth.ParseToken("Bearer " + accessToken)
+	suite.Require().NoError(err)
+	suite.Equal(model.ROLE_SUPER_ADMIN, claims.Role)
+}
+
+func (suite *oidcTestSuite) TestExchange_ExistingUserRoleIsSynced() {
+	_, err := suite.login(jwt.MapClaims{"sub": "subject-sync", "groups": []string{"ops"}})
+	suite.Require().NoError(err)
+
+	_, err = suite.login(jwt.MapClaims{"sub": "subject-sync", "groups": []string{"owners"}})
+	suite.Require().NoError(err)
+
+	var users []model.User
+	suite.Require().NoError(suite.db.Where("oidc_subject = ?", "subject-sync").Find(&users).Error)
+	suite.Require().Len(users, 1)
+	suite.Equal(model.ROLE_SUPER_ADMIN, users[0].Role)
+}
+
+func (suite *oidcTestSuite) TestExchange_RemovedFromGroupIsDemoted() {
+	_, err := suite.login(jwt.MapClaims{"sub": "subject-removed", "groups": []string{"owners"}})
+	suite.Require().NoError(err)
+
+	accessToken, err := suite.login(jwt.MapClaims{"sub": "subject-removed", "groups": []string{"unmapped"}})
+	suite.Require().NoError(err)
+
+	_, claims, err := auth.ParseToken("Bearer " + accessToken)
+	suite.Require().NoError(err)
+	suite.Equal(model.ROLE_USER, claims.Role)
+}
+
+func (suite *oidcTestSuite) TestExchange_DeletedUserIsRejected() {
+	_, err := suite.login(jwt.MapClaims{"sub": "subject-deleted", "preferred_username": "gone"})
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.db.Where("oidc_subject = ?", "subject-deleted").Delete(&model.User{}).Error)
+
+	_, err = suite.login(jwt.MapClaims{"sub": "subject-deleted", "preferred_username": "gone"})
+	suite.ErrorIs(err, cerror.ErrUserDeleted)
+
+	var user model.User
+	suite.Require().NoError(suite.db.Unscoped().Where("oidc_subject = ?", "subject-deleted").First(&user).Error)
+	var events []model.SecurityEvent
+	suite.Require().NoError(suite.db.Where("user_uuid = ?", user.Uuid).Order("id").Find(&events).Error)
+	suite.Require().Len(events, 2)
+	suite.Equal(model.EVENT_LOGIN_SUCCEEDED, events[0].Type)
+	suite.Equal("openid connect", events[0].Reason)
+	suite.Equal(model.EVENT_LOGIN_FAILED, events[1].Type)
+	suite.Equal("deleted user", events[1].Reason)
+}
+
+func (suite *oidcTestSuite) TestExchange_UsernameCollisionIsSuffixed() {
+	local := model.User{Uuid: uuid.New(), Username: "taken", PasswordHash: "hash", Role: model.ROLE_USER}
+	suite.Require().NoError(suite.db.Create(&local).Error)
+
+	accessToken, err := suite.login(jwt.MapClaims{"sub": "subject-taken", "preferred_username": "taken"})
+	suite.Require().NoError(err)
+	_, claims, err := auth.ParseToken("Bearer " + accessToken)
+	suite.Require().NoError(err)
+	suite.Equal("taken-2", claims.Username)
+
+	accessToken, err = suite.login(jwt.MapClaims{"sub": "subject-taken-again", "preferred_username": "taken"})
+	suite.Require().NoError(err)
+	_, claims, err = auth.ParseToken("Bearer " + accessToken)
+	suite.Require().NoError(err)
+	suite.Equal("taken-3", claims.Username)
+}
+
+func (suite *oidcTestSuite) TestExchange_MissingLoginCookie() {
+	ctx := context.Background()
+	authUrl, _, err := suite.oidcService.AuthCodeURL(ctx)
+	suite.Require().NoError(err)
+	state, code, err := suite.provider.authorize(authUrl, jwt.MapClaims{"sub": "subject-no-cookie"})
+	suite.Require().NoError(err)
+
+	accessToken, err := suite.oidcService.Exchange(ctx, "", state, code, ClientInfo{Ip: "192.0.2.26"})
+
+	suite.ErrorIs(err, cerror.ErrOidcInvalidState)
+	suite.Empty(accessToken)
+	var event model.SecurityEvent
+	suite.Require().NoError(suite.db.Where("ip = ?", "192.0.2.26").First(&event).Error)
+	suite.Equal(model.EVENT_LOGIN_FAILED, event.Type)
+	suite.Equal("invalid openid connect state", event.Reason)
+}
+
+func (suite *oidcTestSuite) TestExchange_LoginCookieOfAnotherBrowser() {
+	// the attacker starts a login and sends the victim the callback url with their own code
+	ctx := context.Background()
+	attackerUrl, _, err := suite.oidcService.AuthCodeURL(ctx)
+	suite.Require().NoError(err)
+	state, code, err := suite.provider.authorize(attackerUrl, jwt.MapClaims{"sub": "subject-attacker"})
+	suite.Require().NoError(err)
+	_, victimCookie, err := suite.oidcService.AuthCodeURL(ctx)
+	suite.Require().NoError(err)
+
+	accessToken, err := suite.oidcService.Exchange(ctx, victimCookie, state, code, ClientInfo{})
+
+	suite.ErrorIs(err, cerror.ErrOidcInvalidState)
+	suite.Empty(accessToken)
+}
+
+func (suite *oidcTestSuite) TestExchange_CodeIsSingleUse() {
+	ctx := context.Background()
+	authUrl, loginCookie, err := suite.oidcService.AuthCodeURL(ctx)
+	suite.Require().NoError(err)
+	state, code, err := suite.provider.authorize(authUrl, jwt.MapClaims{"sub": "subject-replay"})
+	suite.Require().NoError(err)
+
+	_, err = suite.oidcService.Exchange(ctx, loginCookie, state, code, ClientInfo{})
+	suite.Require().NoError(err)
+
+	_, err = suite.oidcService.Exchange(ctx, loginCookie, state, code, ClientInfo{})
+	suite.Error(err)
+}
+
+func (suite *oidcTestSuite) TestExchange_WrongVerifierIsRejected() {
+	ctx := context.Background()
+	authUrl, loginCookie, err := suite.oidcService.AuthCodeURL(ctx)
+	suite.Require().NoError(err)
+	state, code, err := suite.provider.authorize(authUrl, jwt.MapClaims{"sub": "subject-pkce"})
+	suite.Require().NoError(err)
+
+	attempt, ok := parseOidcLogin(loginCookie)
+	suite.Require().True(ok)
+	attempt.verifier = "tampered-verifier"
+
+	accessToken, err := suite.oidcService.Exchange(ctx, attempt.cookie(), state, code, ClientInfo{})
+	suite.Error(err)
+	suite.Empty(accessToken)
+}
@@ -0,0 +1,28 @@
+package service
+
+import (
+	"context"
+	"time"
+)
+
+// KeepReloading calls reload every interval until ctx is done, it is meant to run as an app.Job.
+// State cached in util/auth is only refreshed by the instance that changed it, reloading lets
+// instances sharing a database pick up each other's changes. Zero interval disables reloading
+func KeepReloading(ctx context.Context, interval time.Duration, reload func(ctx context.Context) error) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// reload logs its own errors, the cached state stays as it was until the next tick
+		_ = reload(ctx)
+	}
+}
@@ -0,0 +1,261 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+	"github.com/killi1812/cloudflared-web-gui/util/logging"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var _ROLE_NAME_REGEX = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,19}$`)
+
+type IRoleSrv interface {
+	// Load seeds missing builtin roles and hands all roles to util/auth
+	Load() error
+	// Reload hands all roles to util/auth again, picking up changes made by other instances
+	Reload(ctx context.Context) error
+	List(ctx context.Context) ([]model.Role, error)
+	Read(ctx context.Context, name model.UserRole) (*model.Role, error)
+	// Create creates a custom role
+	Create(ctx context.Context, role *model.Role) (*model.Role, error)
+	// Update replaces description and permissions of a role, the superadmin role can't be changed
+	Update(ctx context.Context, name model.UserRole, description string, permissions []model.Permission) (*model.Role, error)
+	// Delete deletes a custom role that isn't assigned to any user or group
+	Delete(ctx context.Context, name model.UserRole) error
+}
+
+type RoleSrv struct {
+	db     *gorm.DB
+	logger *zap.SugaredLogger
+}
+
+func NewRoleSrv() IRoleSrv {
+	var service IRoleSrv
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger) {
+		service = &RoleSrv{
+			db:     db,
+			logger: logger,
+		}
+	})
+
+	return service
+}
+
+// Load implements IRoleSrv.
+func (s *RoleSrv) Load() error {
+	ctx := context.Background()
+	for name, permissions := range model.DefaultRoles() {
+		var count int64
+		if err := s.db.Model(&model.Role{}).Where("name = ?", name).Count(&count).Error; err != nil {
+			s.logger.Errorf("Failed to query role = %s, err = %+v", name, err)
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		role := model.Role{Name: name, Builtin: true}
+		role.SetPermissions(permissions)
+		if err := s.db.Create(&role).Error; err != nil {
+			s.logger.Errorf("Failed to seed role = %s, err = %+v", name, err)
+			return err
+		}
+		s.logger.Infof("Seeded builtin role = %s", name)
+	}
+
+	// superadmin can't be edited, so it gets permissions added in newer versions here
+	if err := s.syncSuperAdmin(ctx); err != nil {
+		return err
+	}
+
+	return s.publish(ctx)
+}
+
+// Reload implements IRoleSrv.
+func (s *RoleSrv) Reload(ctx context.Context) error {
+	return s.publish(ctx)
+}
+
+// syncSuperAdmin gives the superadmin role every permission
+func (s *RoleSrv) syncSuperAdmin(ctx context.Context) error {
+	logger := logging.Logger(ctx, s.logger)
+	role, err := s.Read(ctx, model.ROLE_SUPER_ADMIN)
+	if err != nil {
+		return err
+	}
+	if len(role.Permissions) == len(model.ALL_PERMISSIONS) {
+		return nil
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role_id = ?", role.ID).Delete(&model.RolePermission{}).Error; err != nil {
+			return err
+		}
+		role.SetPermissions(model.ALL_PERMISSIONS)
+		return tx.Create(&role.Permissions).Error
+	})
+	if err != nil {
+		logger.Errorf("Failed to update superadmin permissions, err = %+v", err)
+		return err
+	}
+	logger.Infof("Updated superadmin permissions to %v", model.ALL_PERMISSIONS)
+	return nil
+}
+
+// publish hands the current roles to util/auth
+func (s *RoleSrv) publish(ctx context.Context) error {
+	roles, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	permissions := make(map[model.UserRole][]model.Permission, len(roles))
+	for _, role := range roles {
+		permissions[role.Name] = role.PermissionList()
+	}
+	auth.SetRoles(permissions)
+	return nil
+}
+
+// List implements IRoleSrv.
+func (s *RoleSrv) List(ctx context.Context) ([]model.Role, error) {
+	logger := logging.Logger(ctx, s.logger)
+	var roles []model.Role
+	if err := s.db.WithContext(ctx).Preload("Permissions").Order("name").Find(&roles).Error; err != nil {
+		logger.Errorf("Failed to list roles, err = %+v", err)
+		return nil, err
+	}
+	return roles, nil
+}
+
+// Read implements IRoleSrv.
+func (s *RoleSrv) Read(ctx context.Context, name model.UserRole) (*model.Role, error) {
+	var role model.Role
+	if err := s.db.WithContext(ctx).Preload("Permissions").Where("name = ?", name).First(&role).Error; err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// Create implements IRoleSrv.
+func (s *RoleSrv) Create(ctx context.Context, role *model.Role) (*model.Role, error) {
+	logger := logging.Logger(ctx, s.logger)
+	if !_ROLE_NAME_REGEX.MatchString(string(role.Name)) {
+		return nil, cerror.ErrInvalidRoleName
+	}
+	if err := validatePermissions(role.PermissionList()); err != nil {
+		return nil, err
+	}
+
+	_, err := s.Read(ctx, role.Name)
+	if err == nil {
+		return nil, cerror.ErrRoleExists
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	role.Builtin = false
+	if err := s.db.WithContext(ctx).Create(role).Error; err != nil {
+		logger.Errorf("Failed to create role = %s, err = %+v", role.Name, err)
+		return nil, err
+	}
+	logger.Infof("Created role = %s, permissions = %v", role.Name, role.PermissionList())
+
+	return role, s.publish(ctx)
+}
+
+// Update implements IRoleSrv.
+func (s *RoleSrv) Update(ctx context.Context, name model.UserRole, description string, permissions []model.Permission) (*model.Role, error) {
+	logger := logging.Logger(ctx, s.logger)
+	// superadmin has to keep every permission so roles can always be managed
+	if name == model.ROLE_SUPER_ADMIN {
+		return nil, cerror.ErrBuiltinRole
+	}
+	if err := validatePermissions(permissions); err != nil {
+		return nil, err
+	}
+
+	role, err := s.Read(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role_id = ?", role.ID).Delete(&model.RolePermission{}).Error; err != nil {
+			return err
+		}
+
+		role.Description = description
+		role.SetPermissions(permissions)
+		return tx.Session(&gorm.Session{FullSaveAssociations: true}).Save(role).Error
+	})
+	if err != nil {
+		logger.Errorf("Failed to update role = %s, err = %+v", name, err)
+		return nil, err
+	}
+	logger.Infof("Updated role = %s, permissions = %v", name, permissions)
+
+	return role, s.publish(ctx)
+}
+
+// Delete implements IRoleSrv.
+func (s *RoleSrv) Delete(ctx context.Context, name model.UserRole) error {
+	logger := logging.Logger(ctx, s.logger)
+	role, err := s.Read(ctx, name)
+	if err != nil {
+		return err
+	}
+	if role.Builtin {
+		return cerror.ErrBuiltinRole
+	}
+
+	var users int64
+	if err := s.db.WithContext(ctx).Model(&model.User{}).Where("role = ?", name).Count(&users).Error; err != nil {
+		return err
+	}
+	if users > 0 {
+		return cerror.ErrRoleInUse
+	}
+
+	var groups int64
+	if err := s.db.WithContext(ctx).Model(&model.GroupRole{}).Where("role = ?", name).Count(&groups).Error; err != nil {
+		return err
+	}
+	if groups > 0 {
+		return cerror.ErrRoleInUse
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role_id = ?", role.ID).Delete(&model.RolePermission{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(role).Error
+	})
+	if err != nil {
+		logger.Errorf("Failed to delete role = %s, err = %+v", name, err)
+		return err
+	}
+	logger.Infof("Deleted role = %s", name)
+
+	return s.publish(ctx)
+}
+
+// validatePermissions checks that every permission is known
+func validatePermissions(permissions []model.Permission) error {
+	for _, p := range permissions {
+		if !slices.Contains(model.ALL_PERMISSIONS, p) {
+			return cerror.ErrUnknownPermission
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// --- Role Service Test Suite ---
+type roleTestSuite struct {
+	suite.Suite
+	db          *gorm.DB
+	roleService *RoleSrv
+}
+
+func (suite *roleTestSuite) SetupSuite() {
+	db, err := gorm.Open(sqlite.Open("file:role_test.db?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(db.AutoMigrate(model.GetAllModels()...))
+	suite.db = db
+
+	suite.roleService = &RoleSrv{db: db, logger: zap.NewNop().Sugar()}
+	suite.Require().NoError(suite.roleService.Load())
+}
+
+func (suite *roleTestSuite) TearDownSuite() {
+	auth.ResetRoles()
+	sqlDB, _ := suite.db.DB()
+	suite.Require().NoError(sqlDB.Close())
+}
+
+func TestRoleTestSuite(t *testing.T) {
+	suite.Run(t, new(roleTestSuite))
+}
+
+// createRole creates a custom role with a unique name
+func (suite *roleTestSuite) createRole(permissions ...model.Permission) *model.Role {
+	role := &model.Role{Name: model.UserRole("r-" + uuid.NewString()[:8])}
+	role.SetPermissions(permissions)
+	role, err := suite.roleService.Create(context.Background(), role)
+	suite.Require().NoError(err)
+	return role
+}
+
+// --- Test Cases ---
+
+func (suite *roleTestSuite) TestLoad_SeedsBuiltinRolesOnce() {
+	suite.Require().NoError(suite.roleService.Load())
+
+	var count int64
+	suite.Require().NoError(suite.db.Model(&model.Role{}).Where("builtin = ?", true).Count(&count).Error)
+	suite.EqualValues(len(model.DefaultRoles()), count)
+
+	suite.True(auth.HasPermission(model.ROLE_ADMIN, model.PERM_TUNNEL_DELETE))
+	suite.False(auth.HasPermission(model.ROLE_USER, model.PERM_TUNNEL_DELETE))
+}
+
+func (suite *roleTestSuite) TestCreate_PublishesRole() {
+	role := suite.createRole(model.PERM_TUNNEL_READ, model.PERM_DNS_WRITE, model.PERM_DNS_WRITE)
+
+	suite.Len(role.Permissions, 2, "duplicate permissions should be dropped")
+	parsed, err := auth.ParseRole(string(role.Name))
+	suite.Require().NoError(err)
+	suite.True(auth.HasPermission(parsed, model.PERM_DNS_WRITE))
+}
+
+func (suite *roleTestSuite) TestReload_PicksUpRolesCreatedElsewhere() {
+	role := &model.Role{Name: model.UserRole("r-" + uuid.NewString()[:8])}
+	role.SetPermissions([]model.Permission{model.PERM_DNS_WRITE})
+	// another instance sharing the database created the role
+	suite.Require().NoError(suite.db.Create(role).Error)
+	_, err := auth.ParseRole(string(role.Name))
+	suite.Require().ErrorIs(err, cerror.ErrUnknownRole)
+
+	suite.Require().NoError(suite.roleService.Reload(context.Background()))
+
+	suite.True(auth.HasPermission(role.Name, model.PERM_DNS_WRITE))
+}
+
+func (suite *roleTestSuite) TestCreate_Invalid() {
+	_, err := suite.roleService.Create(context.Background(), &model.Role{Name: "Not a role!"})
+	suite.ErrorIs(err, cerror.ErrInvalidRoleName)
+
+	role := &model.Role{Name: "unknown-perm"}
+	role.SetPermissions([]model.Permission{"tunnel:explode"})
+	_, err = suite.roleService.Create(context.Background(), role)
+	suite.ErrorIs(err, cerror.ErrUnknownPermission)
+
+	_, err = suite.roleService.Create(context.Background(), &model.Role{Name: model.ROLE_ADMIN})
+	suite.ErrorIs(err, cerror.ErrRoleExists)
+}
+
+func (suite *roleTestSuite) TestUpdate_ReplacesPermissions() {
+	role := suite.createRole(model.PERM_TUNNEL_READ)
+
+	updated, err := suite.roleService.Update(context.Background(), role.Name, "operators", []model.Permission{model.PERM_TUNNEL_START})
+	suite.Require().NoError(err)
+	suite.Equal([]model.Permission{model.PERM_TUNNEL_START}, updated.PermissionList())
+
+	saved, err := suite.roleService.Read(context.Background(), role.Name)
+	suite.Require().NoError(err)
+	suite.Equal("operators", saved.Description)
+	suite.Equal([]model.Permission{model.PERM_TUNNEL_START}, saved.PermissionList())
+
+	suite.False(auth.HasPermission(role.Name, model.PERM_TUNNEL_READ))
+	suite.True(auth.HasPermission(role.Name, model.PERM_TUNNEL_START))
+}
+
+func (suite *roleTestSuite) TestUpdate_SuperAdminIsFixed() {
+	_, err := suite.roleService.Update(context.Background(), model.ROLE_SUPER_ADMIN, "", nil)
+	suite.ErrorIs(err, cerror.ErrBuiltinRole)
+}
+
+func (suite *roleTestSuite) TestDelete() {
+	suite.ErrorIs(suite.roleService.Delete(context.Background(), model.ROLE_USER), cerror.ErrBuiltinRole)
+
+	inUse := suite.createRole(model.PERM_TUNNEL_READ)
+	user := model.User{Uuid: uuid.New(), Username: "role-user", PasswordHash: "x", Role: inUse.Name}
+	suite.Require().NoError(suite.db.Create(&user).Error)
+	suite.ErrorIs(suite.roleService.Delete(context.Background(), inUse.Name), cerror.ErrRoleInUse)
+
+	role := suite.createRole(model.PERM_TUNNEL_READ)
+	suite.Require().NoError(suite.roleService.Delete(context.Background(), role.Name))
+	_, err := auth.ParseRole(string(role.Name))
+	suite.ErrorIs(err, cerror.ErrUnknownRole)
+
+	var permissions int64
+	suite.Require().NoError(suite.db.Model(&model.RolePermission{}).Where("role_id = ?", role.ID).Count(&permissions).Error)
+	suite.Zero(permissions)
+}
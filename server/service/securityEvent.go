@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"time"
+	"unicode/utf8"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/logging"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// _PRUNE_INTERVAL is how often KeepSecurityEvents deletes events past retention
+const _PRUNE_INTERVAL = time.Hour
+
+type ISecurityEventSrv interface {
+	// Record stores a security event, CreatedAt is set if empty
+	Record(ctx context.Context, event model.SecurityEvent)
+	// List returns a page of events newest first and the number of all matching events
+	List(ctx context.Context, filter SecurityEventFilter) ([]model.SecurityEvent, int64, error)
+	// Prune deletes events created before before and returns how many were deleted
+	Prune(before time.Time) (int64, error)
+}
+
+// SecurityEventFilter selects events returned by List
+type SecurityEventFilter struct {
+	Page     int // Page starts at 1
+	PageSize int
+	UserUuid *uuid.UUID // UserUuid limits events to one user, nil returns events of every user
+	Type     model.SecurityEventType
+}
+
+// Normalized returns the filter with defaults for a missing or out of range page and page size
+func (f SecurityEventFilter) Normalized() SecurityEventFilter {
+	if f.Page < 1 {
+		f.Page = 1
+	}
+	if f.PageSize < 1 || f.PageSize > _MAX_PAGE_SIZE {
+		f.PageSize = _DEFAULT_PAGE_SIZE
+	}
+	return f
+}
+
+type SecurityEventSrv struct {
+	db     *gorm.DB
+	logger *zap.SugaredLogger
+}
+
+func NewSecurityEventSrv() ISecurityEventSrv {
+	var service ISecurityEventSrv
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger) {
+		service = &SecurityEventSrv{
+			db:     db,
+			logger: logger,
+		}
+	})
+
+	return service
+}
+
+// KeepSecurityEvents prunes events older than retention now and every _PRUNE_INTERVAL after
+// until ctx is done, it is meant to run as an app.Job. Zero retention keeps events forever
+func KeepSecurityEvents(ctx context.Context, events ISecurityEventSrv, retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(_PRUNE_INTERVAL)
+	defer ticker.Stop()
+	for {
+		if pruned, err := events.Prune(time.Now().Add(-retention)); err == nil && pruned > 0 {
+			zap.S().Infof("Pruned %d security events older than %s", pruned, retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Record implements ISecurityEventSrv.
+func (s *SecurityEventSrv) Record(ctx context.Context, event model.SecurityEvent) {
+	logger := logging.Logger(ctx, s.logger)
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	// usernames come from login requests, they can be longer than any user has
+	event.Username = truncate(event.Username, 100)
+	event.UserAgent = truncate(event.UserAgent, 255)
+
+	userUuid := ""
+	if event.UserUuid != nil {
+		userUuid = event.UserUuid.String()
+	}
+
+	log := logger.Warnw
+	switch event.Type {
+	case model.EVENT_LOGIN_SUCCEEDED, model.EVENT_ACCOUNT_UNLOCKED, model.EVENT_TOKEN_REFRESHED, model.EVENT_LOGOUT,
+		model.EVENT_INVITE_CREATED, model.EVENT_INVITE_REVOKED, model.EVENT_INVITE_ACCEPTED:
+		log = logger.Infow
+	}
+
+	log("Security event",
+		"type", event.Type,
+		"userUuid", userUuid,
+		"username", event.Username,
+		"ip", event.Ip,
+		"userAgent", event.UserAgent,
+		"reason", event.Reason,
+	)
+
+	// the event is already logged, a failed insert must not fail the request that caused it
+	if err := s.db.WithContext(ctx).Create(&event).Error; err != nil {
+		logger.Errorf("Failed to store security event = %s, err = %+v", event.Type, err)
+	}
+}
+
+// List implements ISecurityEventSrv.
+func (s *SecurityEventSrv) List(ctx context.Context, filter SecurityEventFilter) ([]model.SecurityEvent, int64, error) {
+	logger := logging.Logger(ctx, s.logger)
+	query := s.db.WithContext(ctx).Model(&model.SecurityEvent{})
+	if filter.UserUuid != nil {
+		query = query.Where("user_uuid = ?", *filter.UserUuid)
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		logger.Errorf("Failed to count security events, err = %+v", err)
+		return nil, 0, err
+	}
+
+	var events []model.SecurityEvent
+	rez := query.Order("created_at DESC").Order("id DESC").
+		Limit(filter.PageSize).
+		Offset((filter.Page - 1) * filter.PageSize).
+		Find(&events)
+	if rez.Error != nil {
+		logger.Errorf("Failed to list security events, err = %+v", rez.Error)
+		return nil, 0, rez.Error
+	}
+
+	return events, total, nil
+}
+
+// Prune implements ISecurityEventSrv.
+func (s *SecurityEventSrv) Prune(before time.Time) (int64, error) {
+	rez := s.db.Where("created_at < ?", before).Delete(&model.SecurityEvent{})
+	if rez.Error != nil {
+		s.logger.Errorf("Failed to prune security events, err = %+v", rez.Error)
+		return 0, rez.Error
+	}
+	return rez.RowsAffected, nil
+}
+
+// truncate shortens text to at most n bytes without splitting a rune
+func truncate(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return text[:n]
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// --- Security Event Service Test Suite ---
+type securityEventTestSuite struct {
+	suite.Suite
+	db           *gorm.DB
+	eventService *SecurityEventSrv
+	authService  *AuthService
+	rawPass      string
+}
+
+func (suite *securityEventTestSuite) SetupSuite() {
+	app.AccessKey = "test-events-access-key"
+	app.RefreshKey = "test-events-refresh-key"
+	log := zap.NewNop().Sugar()
+
+	db, err := gorm.Open(sqlite.Open("file:security_event_test.db?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(db.AutoMigrate(model.GetAllModels()...))
+	suite.db = db
+
+	suite.eventService = &SecurityEventSrv{db: db, logger: log}
+	suite.authService = &AuthService{db: db, logger: log, events: suite.eventService}
+	suite.rawPass = "password123"
+}
+
+func (suite *securityEventTestSuite) TearDownSuite() {
+	sqlDB, _ := suite.db.DB()
+	suite.Require().NoError(sqlDB.Close())
+}
+
+func TestSecurityEventTestSuite(t *testing.T) {
+	suite.Run(t, new(securityEventTestSuite))
+}
+
+// eventsOf returns all events of user newest first
+func (suite *securityEventTestSuite) eventsOf(user *model.User) []model.SecurityEvent {
+	events, _, err := suite.eventService.List(context.Background(), SecurityEventFilter{UserUuid: &user.Uuid, PageSize: 100}.Normalized())
+	suite.Require().NoError(err)
+	return events
+}
+
+// --- Test Cases ---
+
+func (suite *securityEventTestSuite) TestSessionLifecycleIsRecorded() {
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+	client := ClientInfo{Ip: "192.0.2.10", UserAgent: "test-agent"}
+
+	_, err := suite.authService.Login(context.Background(), user.Username, "wrong-password", client)
+	suite.Require().Error(err)
+	accessToken, err := suite.authService.Login(context.Background(), user.Username, suite.rawPass, client)
+	suite.Require().NoError(err)
+	_, err = suite.authService.RefreshTokens(context.Background(), "Bearer "+accessToken, client)
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.authService.Logout(context.Background(), user.Uuid.String(), client))
+
+	events := suite.eventsOf(user)
+	suite.Require().Len(events, 4)
+	types := []model.SecurityEventType{events[3].Type, events[2].Type, events[1].Type, events[0].Type}
+	suite.Equal([]model.SecurityEventType{
+		model.EVENT_LOGIN_FAILED,
+		model.EVENT_LOGIN_SUCCEEDED,
+		model.EVENT_TOKEN_REFRESHED,
+		model.EVENT_LOGOUT,
+	}, types)
+	suite.Equal("invalid password", events[3].Reason)
+	for _, event := range events {
+		suite.Equal(client.Ip, event.Ip)
+		suite.Equal(client.UserAgent, event.UserAgent)
+		suite.False(event.CreatedAt.IsZero())
+	}
+}
+
+func (suite *securityEventTestSuite) TestList_FiltersAndPaginates() {
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+	for range 3 {
+		suite.eventService.Record(context.Background(), model.SecurityEvent{Type: model.EVENT_LOGIN_FAILED, UserUuid: &user.Uuid})
+	}
+	suite.eventService.Record(context.Background(), model.SecurityEvent{Type: model.EVENT_ACCOUNT_LOCKED, UserUuid: &user.Uuid})
+
+	events, total, err := suite.eventService.List(context.Background(), SecurityEventFilter{UserUuid: &user.Uuid, PageSize: 3}.Normalized())
+	suite.Require().NoError(err)
+	suite.EqualValues(4, total)
+	suite.Len(events, 3)
+	suite.Equal(model.EVENT_ACCOUNT_LOCKED, events[0].Type)
+
+	_, total, err = suite.eventService.List(context.Background(), SecurityEventFilter{UserUuid: &user.Uuid, Type: model.EVENT_LOGIN_FAILED}.Normalized())
+	suite.Require().NoError(err)
+	suite.EqualValues(3, total)
+}
+
+func (suite *securityEventTestSuite) TestPrune() {
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+	suite.eventService.Record(context.Background(), model.SecurityEvent{Type: model.EVENT_LOGOUT, UserUuid: &user.Uuid, CreatedAt: time.Now().Add(-48 * time.Hour)})
+	suite.eventService.Record(context.Background(), model.SecurityEvent{Type: model.EVENT_LOGOUT, UserUuid: &user.Uuid})
+
+	pruned, err := suite.eventService.Prune(time.Now().Add(-24 * time.Hour))
+	suite.Require().NoError(err)
+	suite.GreaterOrEqual(pruned, int64(1))
+	suite.Len(suite.eventsOf(user), 1)
+}
+
+func (suite *securityEventTestSuite) TestKeepSecurityEvents_StopsWithContext() {
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+	suite.eventService.Record(context.Background(), model.SecurityEvent{Type: model.EVENT_LOGOUT, UserUuid: &user.Uuid, CreatedAt: time.Now().Add(-48 * time.Hour)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		KeepSecurityEvents(ctx, suite.eventService, 24*time.Hour)
+		close(done)
+	}()
+
+	suite.Eventually(func() bool { return len(suite.eventsOf(user)) == 0 }, time.Second, 10*time.Millisecond)
+	cancel()
+	suite.Eventually(func() bool {
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+}
+
+func (suite *securityEventTestSuite) TestRecord_TruncatesUserAgent() {
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+	suite.eventService.Record(context.Background(), model.SecurityEvent{Type: model.EVENT_LOGOUT, UserUuid: &user.Uuid, UserAgent: strings.Repeat("ž", 200)})
+
+	events := suite.eventsOf(user)
+	suite.Require().Len(events, 1)
+	suite.LessOrEqual(len(events[0].UserAgent), 255)
+	suite.Equal(strings.Repeat("ž", 127), events[0].UserAgent)
+}
+
+func (suite *securityEventTestSuite) TestRecord_TruncatesUsername() {
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+	suite.eventService.Record(context.Background(), model.SecurityEvent{Type: model.EVENT_LOGIN_FAILED, UserUuid: &user.Uuid, Username: strings.Repeat("a", 500)})
+
+	events := suite.eventsOf(user)
+	suite.Require().Len(events, 1)
+	suite.Equal(strings.Repeat("a", 100), events[0].Username)
+}
@@ -2,15 +2,29 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/google/uuid"
 	"github.com/killi1812/cloudflared-web-gui/app"
 	"github.com/killi1812/cloudflared-web-gui/model"
 	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+	"github.com/killi1812/cloudflared-web-gui/util/logging"
+	"github.com/killi1812/cloudflared-web-gui/util/metrics"
+	"github.com/killi1812/cloudflared-web-gui/util/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
@@ -19,31 +33,39 @@ const (
 	_CLOUDFLARED = "cloudflared"
 	_TUNNEL      = "tunnel"
 	_OUTPUT      = "--output=json"
-	_CONFIG_FMT  = "--config /config/%s-config.yml"
+	_CONFIG_FMT  = "%s-config.yml" // _CONFIG_FMT is the config file of a tunnel in app.CloudflaredConfigDir
+	_PID_FMT     = "%s.pid"        // _PID_FMT is the pid file of a running tunnel, other processes like the tunnel command read it
 )
 
+// tunnelFile returns the path of the file named by format for tunnel uuid in app.CloudflaredConfigDir
+func tunnelFile(format string, uuid uuid.UUID) string {
+	return filepath.Join(app.CloudflaredConfigDir, fmt.Sprintf(format, uuid.String()))
+}
+
 type ITunnelSrv interface {
-	Start(uuid uuid.UUID) error
-	Stop(uuid uuid.UUID) error
-	Restart(uuid uuid.UUID) error
+	Start(ctx context.Context, uuid uuid.UUID) error
+	Stop(ctx context.Context, uuid uuid.UUID) error
+	Restart(ctx context.Context, uuid uuid.UUID) error
 
-	AddConn(uuid uuid.UUID, domain string) (*model.Tunnel, error)
-	RemoveConn(uuid uuid.UUID) (*model.Tunnel, error)
+	AddConn(ctx context.Context, uuid uuid.UUID, domain string) (*model.Tunnel, error)
+	RemoveConn(ctx context.Context, uuid uuid.UUID) (*model.Tunnel, error)
 
-	Create(name string) (*model.Tunnel, error)
-	Info(uuid uuid.UUID) (*model.Tunnel, error)
-	List() ([]model.Tunnel, error)
-	Delete(uuid uuid.UUID) error
+	Create(ctx context.Context, name string) (*model.Tunnel, error)
+	Info(ctx context.Context, uuid uuid.UUID) (*model.Tunnel, error)
+	List(ctx context.Context) ([]model.Tunnel, error)
+	Delete(ctx context.Context, uuid uuid.UUID) error
 }
 
 func NewTunelSrv() ITunnelSrv {
 	var service ITunnelSrv
 	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger) {
-		service = &TunnelSrv{
+		srv := &TunnelSrv{
 			db:         db,
 			logger:     logger,
 			tunnelProc: make(map[uuid.UUID]*os.Process),
 		}
+		metrics.ObserveTunnels(srv.running)
+		service = srv
 	})
 
 	return service
@@ -53,46 +75,50 @@ type TunnelSrv struct {
 	db         *gorm.DB
 	logger     *zap.SugaredLogger
 	tunnelProc map[uuid.UUID]*os.Process // tunnelPid is a map with [Key] tunnel id and [Value] *os.process
-	// TODO: check if mutex is needed
+	mu         sync.Mutex                // mu guards tunnelProc, metrics scrapes read it while requests change it
 }
 
 // Info implements ITunnelSrv.
-func (t *TunnelSrv) Info(uuid uuid.UUID) (*model.Tunnel, error) {
-	cmd := exec.Command(_CLOUDFLARED, _TUNNEL, "info", _OUTPUT, uuid.String())
+func (t *TunnelSrv) Info(ctx context.Context, uuid uuid.UUID) (*model.Tunnel, error) {
+	logger := logging.Logger(ctx, t.logger)
+	cmd, done := t.cloudflared(ctx, "info", "info", _OUTPUT, uuid.String())
 	data, err := cmd.Output()
+	done(err)
 	if err != nil {
 		checkErr(err)
-		t.logger.Errorf("Error running the command = %s, err = %w", cmd.String(), err)
+		logger.Errorf("Error running the command = %s, err = %w", cmd.String(), err)
 		return nil, err
 	}
 
 	var tunnel model.Tunnel
 	err = json.NewDecoder(bytes.NewReader(data)).Decode(&tunnel)
 	if err != nil {
-		t.logger.Errorf("Error decoding data, err = %w", err)
+		logger.Errorf("Error decoding data, err = %w", err)
 		return nil, err
 	}
 
-	_, tunnel.IsRunning = t.tunnelProc[uuid]
+	_, _, tunnel.IsRunning = t.process(uuid)
 
 	return &tunnel, nil
 }
 
 // AddConn implements ITunnelSrv.
 // runs and parses ❯ cloudflared tunnel route dns [uuid] [domain]
-func (t *TunnelSrv) AddConn(uuid uuid.UUID, domain string) (*model.Tunnel, error) {
-	cmd := exec.Command(_CLOUDFLARED, _TUNNEL, "route", "dns", uuid.String(), domain)
+func (t *TunnelSrv) AddConn(ctx context.Context, uuid uuid.UUID, domain string) (*model.Tunnel, error) {
+	logger := logging.Logger(ctx, t.logger)
+	cmd, done := t.cloudflared(ctx, "route", "route", "dns", uuid.String(), domain)
 
 	err := cmd.Run()
+	done(err)
 	if err != nil {
 		checkErr(err)
-		t.logger.Errorf("Error running the command = %s, err = %w", cmd.String(), err)
+		logger.Errorf("Error running the command = %s, err = %w", cmd.String(), err)
 		return nil, err
 	}
 
-	tunnel, err := t.Info(uuid)
+	tunnel, err := t.Info(ctx, uuid)
 	if err != nil {
-		t.logger.Errorf("Error retriving information about the tunnel, err = %v", err)
+		logger.Errorf("Error retriving information about the tunnel, err = %v", err)
 		return nil, err
 	}
 
@@ -100,119 +126,206 @@ func (t *TunnelSrv) AddConn(uuid uuid.UUID, domain string) (*model.Tunnel, error
 }
 
 // RemoveConn implements ITunnelSrv.
-func (t *TunnelSrv) RemoveConn(uuid uuid.UUID) (*model.Tunnel, error) {
+func (t *TunnelSrv) RemoveConn(ctx context.Context, uuid uuid.UUID) (*model.Tunnel, error) {
 	// TODO: see with cloudflared api
 	panic("unimplemented")
 }
 
 // Restart implements ITunnelSrv.
-func (t *TunnelSrv) Restart(uuid uuid.UUID) error {
-	t.logger.Infof("Starting restart procedure for tunnel %s", uuid.String())
+func (t *TunnelSrv) Restart(ctx context.Context, uuid uuid.UUID) error {
+	logger := logging.Logger(ctx, t.logger)
+	logger.Infof("Starting restart procedure for tunnel %s", uuid.String())
 
-	oldProc, ok := t.tunnelProc[uuid]
+	oldProc, child, ok := t.process(uuid)
 	if !ok {
-		zap.S().Infof("Tunnel uuid = %s isn't running", uuid)
+		logger.Infof("Tunnel uuid = %s isn't running", uuid)
 		return cerror.ErrTunnelNotRunning
 	}
 
-	t.logger.Infof("Old process found, pid = %d", oldProc.Pid)
-	t.logger.Infoln("Starting new process")
+	logger.Infof("Old process found, pid = %d", oldProc.Pid)
+	logger.Infoln("Starting new process")
 
-	cmd := exec.Command(_CLOUDFLARED, _TUNNEL, _OUTPUT, "run", uuid.String())
+	cmd, done := t.cloudflared(ctx, "run", _OUTPUT, "run", uuid.String())
 	err := cmd.Start()
+	done(err)
 	if err != nil {
 		checkErr(err)
-		t.logger.Errorf("Error running the command = %s, err = %w", cmd.String(), err)
+		logger.Errorf("Error running the command = %s, err = %w", cmd.String(), err)
 		return err
 	}
 
-	t.logger.Infof("New process started, pid = %d", cmd.Process.Pid)
-	t.logger.Infof("Stopping old process, pid = %d", oldProc.Pid)
-
-	err = oldProc.Kill()
-	if err != nil {
-		t.logger.Errorf("Failed to kill process = %+v, err = %v", *oldProc, err)
-		return err
-	}
+	logger.Infof("New process started, pid = %d", cmd.Process.Pid)
+	logger.Infof("Stopping old process, pid = %d", oldProc.Pid)
 
-	_, err = oldProc.Wait()
+	err = t.kill(oldProc, child)
 	if err != nil {
-		t.logger.Errorf("Failed to Wait for process = %+v, err = %v", *oldProc, err)
 		return err
 	}
 
-	t.logger.Infof("Old process stopped, pid = %d", oldProc.Pid)
+	logger.Infof("Old process stopped, pid = %d", oldProc.Pid)
 
+	t.mu.Lock()
 	t.tunnelProc[uuid] = cmd.Process
+	t.mu.Unlock()
+	t.writePid(uuid, cmd.Process)
+	metrics.SetTunnelDesired(uuid.String(), true)
+	metrics.CountTunnelRestart(uuid.String())
 
-	t.logger.Infoln("Restart procedure done")
+	logger.Infoln("Restart procedure done")
 	return nil
 }
 
 // Start implements ITunnelSrv.
 // runs and parses ❯ cloudflared tunnel --config /config/[tunnel id]-config.yml run [tunnel id]
-func (t *TunnelSrv) Start(uuid uuid.UUID) error {
-	_, ok := t.tunnelProc[uuid]
+func (t *TunnelSrv) Start(ctx context.Context, uuid uuid.UUID) error {
+	logger := logging.Logger(ctx, t.logger)
+	_, _, ok := t.process(uuid)
 	if ok {
-		zap.S().Infof("Tunnel uuid = %s already running", uuid)
+		logger.Infof("Tunnel uuid = %s already running", uuid)
 		return cerror.ErrTunnelAlreadyRunning
 	}
 
-	cmd := exec.Command(_CLOUDFLARED, _TUNNEL, fmt.Sprintf(_CONFIG_FMT, uuid.String()), _OUTPUT, "run", uuid.String())
+	cmd, done := t.cloudflared(ctx, "run", "--config", tunnelFile(_CONFIG_FMT, uuid), _OUTPUT, "run", uuid.String())
 	err := cmd.Start()
+	done(err)
 	if err != nil {
 		checkErr(err)
-		t.logger.Errorf("Error running the command = %s, err = %w", cmd.String(), err)
+		logger.Errorf("Error running the command = %s, err = %w", cmd.String(), err)
 		return err
 	}
+	t.mu.Lock()
 	t.tunnelProc[uuid] = cmd.Process
+	t.mu.Unlock()
+	t.writePid(uuid, cmd.Process)
+	metrics.SetTunnelDesired(uuid.String(), true)
 
 	return nil
 }
 
 // Stop implements ITunnelSrv.
-func (t *TunnelSrv) Stop(uuid uuid.UUID) error {
-	proc, ok := t.tunnelProc[uuid]
+func (t *TunnelSrv) Stop(ctx context.Context, uuid uuid.UUID) error {
+	logger := logging.Logger(ctx, t.logger)
+	proc, child, ok := t.process(uuid)
 	if !ok {
-		t.logger.Errorf("process running a tunnel %s not found", uuid.String())
+		logger.Errorf("process running a tunnel %s not found", uuid.String())
 		return cerror.ErrProcessNotFound
 	}
 
+	err := t.kill(proc, child)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	delete(t.tunnelProc, uuid)
+	t.mu.Unlock()
+	os.Remove(tunnelFile(_PID_FMT, uuid))
+	metrics.SetTunnelDesired(uuid.String(), false)
+	return nil
+}
+
+// running returns ids of running tunnels, the ones started by this process and the ones with a pid file
+func (t *TunnelSrv) running() []string {
+	t.mu.Lock()
+	ids := slices.Collect(maps.Keys(t.tunnelProc))
+	t.mu.Unlock()
+
+	pidFiles, _ := filepath.Glob(filepath.Join(app.CloudflaredConfigDir, fmt.Sprintf(_PID_FMT, "*")))
+	for _, pidFile := range pidFiles {
+		id, err := uuid.Parse(strings.TrimSuffix(filepath.Base(pidFile), filepath.Ext(pidFile)))
+		if err == nil && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+
+	var rez []string
+	for _, id := range ids {
+		if _, _, ok := t.process(id); ok {
+			rez = append(rez, id.String())
+		}
+	}
+	return rez
+}
+
+// process returns the process running tunnel uuid and whether it was started by this process,
+// a tunnel started by another process is found by its pid file
+func (t *TunnelSrv) process(uuid uuid.UUID) (*os.Process, bool, bool) {
+	t.mu.Lock()
+	proc, ok := t.tunnelProc[uuid]
+	t.mu.Unlock()
+	if ok {
+		return proc, true, true
+	}
+
+	pidFile := tunnelFile(_PID_FMT, uuid)
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		return nil, false, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.logger.Warnf("Invalid pid file %s, err = %v", pidFile, err)
+		return nil, false, false
+	}
+
+	// signal 0 only checks that the process exists
+	proc, err = os.FindProcess(pid)
+	if err != nil || proc.Signal(syscall.Signal(0)) != nil {
+		t.logger.Debugf("Removing stale pid file %s", pidFile)
+		os.Remove(pidFile)
+		return nil, false, false
+	}
+	return proc, false, true
+}
+
+// kill kills proc, only a child of this process can be waited for
+func (t *TunnelSrv) kill(proc *os.Process, child bool) error {
 	err := proc.Kill()
 	if err != nil {
-		t.logger.Errorf("Failed to kill process = %+v, err = %v", *proc, err)
+		t.logger.Errorf("Failed to kill process, pid = %d, err = %v", proc.Pid, err)
 		return err
 	}
+	if !child {
+		return nil
+	}
 
 	_, err = proc.Wait()
 	if err != nil {
-		t.logger.Errorf("Failed to Wait for process = %+v, err = %v", *proc, err)
+		t.logger.Errorf("Failed to Wait for process, pid = %d, err = %v", proc.Pid, err)
 		return err
 	}
-
-	delete(t.tunnelProc, uuid)
 	return nil
 }
 
+// writePid writes the pid file of tunnel uuid, without it only this process knows the tunnel is running
+func (t *TunnelSrv) writePid(uuid uuid.UUID, proc *os.Process) {
+	pidFile := tunnelFile(_PID_FMT, uuid)
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(proc.Pid)), 0o644); err != nil {
+		t.logger.Warnf("Failed to write pid file %s, err = %v", pidFile, err)
+	}
+}
+
 // Create implements ITunnelSrv.
 // runs and parses ❯ cloudflared tunnel create [name]
-func (t *TunnelSrv) Create(name string) (*model.Tunnel, error) {
+func (t *TunnelSrv) Create(ctx context.Context, name string) (*model.Tunnel, error) {
+	logger := logging.Logger(ctx, t.logger)
 	if name == "" {
 		return nil, cerror.ErrNameIsEmpty
 	}
 
-	cmd := exec.Command(_CLOUDFLARED, _TUNNEL, "create", _OUTPUT, name)
+	cmd, done := t.cloudflared(ctx, "create", "create", _OUTPUT, name)
 	data, err := cmd.Output()
+	done(err)
 	if err != nil {
 		checkErr(err)
-		t.logger.Errorf("Error running the command = %s, err = %w", cmd.String(), err)
+		logger.Errorf("Error running the command = %s, err = %w", cmd.String(), err)
 		return nil, err
 	}
 
 	var tunnel model.Tunnel
 	err = json.NewDecoder(bytes.NewReader(data)).Decode(&tunnel)
 	if err != nil {
-		t.logger.Errorf("Error decoding data, err = %w", err)
+		logger.Errorf("Error decoding data, err = %w", err)
 		return nil, err
 	}
 
@@ -222,12 +335,14 @@ func (t *TunnelSrv) Create(name string) (*model.Tunnel, error) {
 }
 
 // Delete implements ITunnelSrv.
-func (t *TunnelSrv) Delete(uuid uuid.UUID) error {
-	cmd := exec.Command(_CLOUDFLARED, _TUNNEL, "delete", uuid.String())
+func (t *TunnelSrv) Delete(ctx context.Context, uuid uuid.UUID) error {
+	logger := logging.Logger(ctx, t.logger)
+	cmd, done := t.cloudflared(ctx, "delete", "delete", uuid.String())
 	err := cmd.Run()
+	done(err)
 	if err != nil {
 		checkErr(err)
-		t.logger.Errorf("Error running the command = %s, err = %w", cmd.String(), err)
+		logger.Errorf("Error running the command = %s, err = %w", cmd.String(), err)
 		return err
 	}
 
@@ -236,29 +351,54 @@ func (t *TunnelSrv) Delete(uuid uuid.UUID) error {
 
 // List implements ITunnelSrv.
 // runs and parses ❯ cloudflared tunnel list
-func (t *TunnelSrv) List() ([]model.Tunnel, error) {
-	cmd := exec.Command(_CLOUDFLARED, _TUNNEL, "list", _OUTPUT)
+func (t *TunnelSrv) List(ctx context.Context) ([]model.Tunnel, error) {
+	logger := logging.Logger(ctx, t.logger)
+	cmd, done := t.cloudflared(ctx, "list", "list", _OUTPUT)
 	data, err := cmd.Output()
+	done(err)
 	if err != nil {
 		checkErr(err)
-		t.logger.Errorf("Error running the command = %s, err = %w", cmd.String(), err)
+		logger.Errorf("Error running the command = %s, err = %w", cmd.String(), err)
 		return nil, err
 	}
 
 	var list []model.Tunnel
 	err = json.NewDecoder(bytes.NewReader(data)).Decode(&list)
 	if err != nil {
-		t.logger.Errorf("Error decoding data, err = %w", err)
+		logger.Errorf("Error decoding data, err = %w", err)
 		return nil, err
 	}
 
 	for i := range list {
-		_, list[i].IsRunning = t.tunnelProc[list[i].Id]
+		_, _, list[i].IsRunning = t.process(list[i].Id)
 	}
 
 	return list, nil
 }
 
+// cloudflared returns the cloudflared tunnel command with args and starts a span covering it,
+// done must be called with the result, it ends the span and counts the subcommand in metrics.
+// A started tunnel outlives the request, so the command isn't bound to ctx
+func (t *TunnelSrv) cloudflared(ctx context.Context, subcommand string, args ...string) (*exec.Cmd, func(error)) {
+	cmd := exec.Command(_CLOUDFLARED, append([]string{_TUNNEL}, args...)...)
+	_, span := tracing.Start(ctx, _CLOUDFLARED+" "+_TUNNEL+" "+subcommand, trace.WithAttributes(
+		attribute.String("process.executable.name", _CLOUDFLARED),
+		attribute.StringSlice("process.command_args", cmd.Args),
+	))
+
+	return cmd, func(err error) {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			span.SetAttributes(attribute.Int("process.exit.code", exitErr.ExitCode()))
+		}
+		if cmd.Process != nil {
+			span.SetAttributes(attribute.Int("process.pid", cmd.Process.Pid))
+		}
+		metrics.CountCommand(subcommand, err)
+		tracing.End(span, err)
+	}
+}
+
 func checkErr(err error) {
 	nerr, ok := err.(*exec.Error)
 	if ok {
@@ -0,0 +1,302 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+	"github.com/killi1812/cloudflared-web-gui/util/logging"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ITunnelAccessSrv interface {
+	// Levels returns access levels of the user with its effective permissions on the tunnels,
+	// including access through its groups, tunnels without access are left out
+	Levels(ctx context.Context, user uuid.UUID, permissions []model.Permission, tunnels []uuid.UUID) (map[uuid.UUID]model.TunnelAccessLevel, error)
+	// Access returns the owner and grants of a tunnel, owner is nil for tunnels created outside of the app
+	Access(ctx context.Context, tunnel uuid.UUID) (*model.TunnelOwner, []model.TunnelGrant, error)
+	// SetOwner makes user the owner of the tunnel
+	SetOwner(ctx context.Context, tunnel, user uuid.UUID) error
+	// SetGroupOwner makes group the owner of the tunnel
+	SetGroupOwner(ctx context.Context, tunnel, group uuid.UUID) error
+	// Grant gives user level access to the tunnel, replacing an earlier grant
+	Grant(ctx context.Context, tunnel, user uuid.UUID, level model.TunnelAccessLevel) error
+	// GrantGroup gives members of group level access to the tunnel, replacing an earlier grant
+	GrantGroup(ctx context.Context, tunnel, group uuid.UUID, level model.TunnelAccessLevel) error
+	// Revoke removes the grant of user on the tunnel
+	Revoke(ctx context.Context, tunnel, user uuid.UUID) error
+	// RevokeGroup removes the grant of group on the tunnel
+	RevokeGroup(ctx context.Context, tunnel, group uuid.UUID) error
+	// Forget removes the owner and grants of a deleted tunnel
+	Forget(ctx context.Context, tunnel uuid.UUID) error
+}
+
+type TunnelAccessSrv struct {
+	db     *gorm.DB
+	logger *zap.SugaredLogger
+}
+
+func NewTunnelAccessSrv() ITunnelAccessSrv {
+	var service ITunnelAccessSrv
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger) {
+		service = &TunnelAccessSrv{
+			db:     db,
+			logger: logger,
+		}
+	})
+
+	return service
+}
+
+// Levels implements ITunnelAccessSrv.
+func (s *TunnelAccessSrv) Levels(ctx context.Context, userUuid uuid.UUID, permissions []model.Permission, tunnels []uuid.UUID) (map[uuid.UUID]model.TunnelAccessLevel, error) {
+	logger := logging.Logger(ctx, s.logger)
+	levels := make(map[uuid.UUID]model.TunnelAccessLevel, len(tunnels))
+	if len(tunnels) == 0 {
+		return levels, nil
+	}
+
+	if slices.Contains(permissions, model.PERM_TUNNEL_ALL) {
+		for _, id := range tunnels {
+			levels[id] = model.TUNNEL_ADMIN
+		}
+		return levels, nil
+	}
+
+	userId, err := s.userId(ctx, userUuid)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return levels, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var groupIds []uint
+	err = s.db.WithContext(ctx).Table("group_members").Where("user_id = ?", userId).Pluck("group_id", &groupIds).Error
+	if err != nil {
+		logger.Errorf("Failed to query groups of user = %s, err = %+v", userUuid, err)
+		return nil, err
+	}
+
+	var owned []model.TunnelOwner
+	err = s.db.WithContext(ctx).Where("tunnel_id IN ? AND (owner_user_id = ? OR owner_group_id IN ?)", tunnels, userId, groupIds).Find(&owned).Error
+	if err != nil {
+		logger.Errorf("Failed to query tunnel owners, err = %+v", err)
+		return nil, err
+	}
+	for _, owner := range owned {
+		levels[owner.TunnelId] = model.TUNNEL_ADMIN
+	}
+
+	var grants []model.TunnelGrant
+	err = s.db.WithContext(ctx).Where("tunnel_id IN ? AND (user_id = ? OR group_id IN ?)", tunnels, userId, groupIds).Find(&grants).Error
+	if err != nil {
+		logger.Errorf("Failed to query tunnel grants, err = %+v", err)
+		return nil, err
+	}
+	for _, grant := range grants {
+		levels[grant.TunnelId] = model.HigherTunnelAccess(levels[grant.TunnelId], grant.Level)
+	}
+
+	if slices.Contains(permissions, model.PERM_TUNNEL_READ_ALL) {
+		for _, id := range tunnels {
+			levels[id] = model.HigherTunnelAccess(levels[id], model.TUNNEL_VIEWER)
+		}
+	}
+
+	return levels, nil
+}
+
+// Access implements ITunnelAccessSrv.
+func (s *TunnelAccessSrv) Access(ctx context.Context, tunnel uuid.UUID) (*model.TunnelOwner, []model.TunnelGrant, error) {
+	var owner model.TunnelOwner
+	rez := s.db.WithContext(ctx).Preload("OwnerUser").Preload("OwnerGroup").Where("tunnel_id = ?", tunnel).Limit(1).Find(&owner)
+	if rez.Error != nil {
+		return nil, nil, rez.Error
+	}
+
+	var grants []model.TunnelGrant
+	if err := s.db.WithContext(ctx).Preload("User").Preload("Group").Where("tunnel_id = ?", tunnel).Find(&grants).Error; err != nil {
+		return nil, nil, err
+	}
+
+	if rez.RowsAffected == 0 {
+		return nil, grants, nil
+	}
+	return &owner, grants, nil
+}
+
+// SetOwner implements ITunnelAccessSrv.
+func (s *TunnelAccessSrv) SetOwner(ctx context.Context, tunnel, user uuid.UUID) error {
+	logger := logging.Logger(ctx, s.logger)
+	userId, err := s.userId(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	if err := s.setOwner(ctx, model.TunnelOwner{TunnelId: tunnel, OwnerUserId: &userId}); err != nil {
+		return err
+	}
+
+	logger.Infof("User = %s owns tunnel = %s", user, tunnel)
+	return nil
+}
+
+// SetGroupOwner implements ITunnelAccessSrv.
+func (s *TunnelAccessSrv) SetGroupOwner(ctx context.Context, tunnel, group uuid.UUID) error {
+	logger := logging.Logger(ctx, s.logger)
+	groupId, err := s.groupId(ctx, group)
+	if err != nil {
+		return err
+	}
+
+	if err := s.setOwner(ctx, model.TunnelOwner{TunnelId: tunnel, OwnerGroupId: &groupId}); err != nil {
+		return err
+	}
+
+	logger.Infof("Group = %s owns tunnel = %s", group, tunnel)
+	return nil
+}
+
+// setOwner stores owner replacing the previous owner of the tunnel
+func (s *TunnelAccessSrv) setOwner(ctx context.Context, owner model.TunnelOwner) error {
+	logger := logging.Logger(ctx, s.logger)
+	err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tunnel_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"owner_user_id", "owner_group_id", "updated_at"}),
+	}).Create(&owner).Error
+	if err != nil {
+		logger.Errorf("Failed to set owner of tunnel = %s, err = %+v", owner.TunnelId, err)
+		return err
+	}
+	return nil
+}
+
+// Grant implements ITunnelAccessSrv.
+func (s *TunnelAccessSrv) Grant(ctx context.Context, tunnel, user uuid.UUID, level model.TunnelAccessLevel) error {
+	logger := logging.Logger(ctx, s.logger)
+	if _, ok := model.StrToTunnelAccessLevel(string(level)); !ok {
+		return cerror.ErrUnknownAccessLevel
+	}
+
+	userId, err := s.userId(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	grant := model.TunnelGrant{TunnelId: tunnel, UserId: &userId, Level: level}
+	err = s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tunnel_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"level", "updated_at"}),
+	}).Create(&grant).Error
+	if err != nil {
+		logger.Errorf("Failed to grant %s on tunnel = %s to user = %s, err = %+v", level, tunnel, user, err)
+		return err
+	}
+
+	logger.Infof("Granted %s on tunnel = %s to user = %s", level, tunnel, user)
+	return nil
+}
+
+// GrantGroup implements ITunnelAccessSrv.
+func (s *TunnelAccessSrv) GrantGroup(ctx context.Context, tunnel, group uuid.UUID, level model.TunnelAccessLevel) error {
+	logger := logging.Logger(ctx, s.logger)
+	if _, ok := model.StrToTunnelAccessLevel(string(level)); !ok {
+		return cerror.ErrUnknownAccessLevel
+	}
+
+	groupId, err := s.groupId(ctx, group)
+	if err != nil {
+		return err
+	}
+
+	grant := model.TunnelGrant{TunnelId: tunnel, GroupId: &groupId, Level: level}
+	err = s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tunnel_id"}, {Name: "group_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"level", "updated_at"}),
+	}).Create(&grant).Error
+	if err != nil {
+		logger.Errorf("Failed to grant %s on tunnel = %s to group = %s, err = %+v", level, tunnel, group, err)
+		return err
+	}
+
+	logger.Infof("Granted %s on tunnel = %s to group = %s", level, tunnel, group)
+	return nil
+}
+
+// Revoke implements ITunnelAccessSrv.
+func (s *TunnelAccessSrv) Revoke(ctx context.Context, tunnel, user uuid.UUID) error {
+	logger := logging.Logger(ctx, s.logger)
+	userId, err := s.userId(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	rez := s.db.WithContext(ctx).Unscoped().Where("tunnel_id = ? AND user_id = ?", tunnel, userId).Delete(&model.TunnelGrant{})
+	if rez.Error != nil {
+		logger.Errorf("Failed to revoke access on tunnel = %s from user = %s, err = %+v", tunnel, user, rez.Error)
+		return rez.Error
+	}
+	if rez.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	logger.Infof("Revoked access on tunnel = %s from user = %s", tunnel, user)
+	return nil
+}
+
+// RevokeGroup implements ITunnelAccessSrv.
+func (s *TunnelAccessSrv) RevokeGroup(ctx context.Context, tunnel, group uuid.UUID) error {
+	logger := logging.Logger(ctx, s.logger)
+	groupId, err := s.groupId(ctx, group)
+	if err != nil {
+		return err
+	}
+
+	rez := s.db.WithContext(ctx).Unscoped().Where("tunnel_id = ? AND group_id = ?", tunnel, groupId).Delete(&model.TunnelGrant{})
+	if rez.Error != nil {
+		logger.Errorf("Failed to revoke access on tunnel = %s from group = %s, err = %+v", tunnel, group, rez.Error)
+		return rez.Error
+	}
+	if rez.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	logger.Infof("Revoked access on tunnel = %s from group = %s", tunnel, group)
+	return nil
+}
+
+// Forget implements ITunnelAccessSrv.
+func (s *TunnelAccessSrv) Forget(ctx context.Context, tunnel uuid.UUID) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("tunnel_id = ?", tunnel).Delete(&model.TunnelGrant{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("tunnel_id = ?", tunnel).Delete(&model.TunnelOwner{}).Error
+	})
+}
+
+// userId returns the database id of the user with uuid
+func (s *TunnelAccessSrv) userId(ctx context.Context, user uuid.UUID) (uint, error) {
+	var found model.User
+	if err := s.db.WithContext(ctx).Select("id").Where("uuid = ?", user).First(&found).Error; err != nil {
+		return 0, err
+	}
+	return found.ID, nil
+}
+
+// groupId returns the database id of the group with uuid
+func (s *TunnelAccessSrv) groupId(ctx context.Context, group uuid.UUID) (uint, error) {
+	var found model.Group
+	if err := s.db.WithContext(ctx).Select("id").Where("uuid = ?", group).First(&found).Error; err != nil {
+		return 0, err
+	}
+	return found.ID, nil
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// --- Tunnel Access Service Test Suite ---
+type tunnelAccessTestSuite struct {
+	suite.Suite
+	db            *gorm.DB
+	accessService *TunnelAccessSrv
+}
+
+func (suite *tunnelAccessTestSuite) SetupSuite() {
+	db, err := gorm.Open(sqlite.Open("file:tunnel_access_test.db?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(db.AutoMigrate(model.GetAllModels()...))
+	suite.db = db
+
+	suite.accessService = &TunnelAccessSrv{db: db, logger: zap.NewNop().Sugar()}
+}
+
+func (suite *tunnelAccessTestSuite) TearDownSuite() {
+	auth.ResetRoles()
+	sqlDB, _ := suite.db.DB()
+	suite.Require().NoError(sqlDB.Close())
+}
+
+func TestTunnelAccessTestSuite(t *testing.T) {
+	suite.Run(t, new(tunnelAccessTestSuite))
+}
+
+// permissions returns effective permissions of user with its loaded groups
+func (suite *tunnelAccessTestSuite) permissions(user *model.User) []model.Permission {
+	return (&auth.Claims{Role: user.Role, Groups: user.GroupNames()}).Permissions()
+}
+
+// levelOf returns the level of user on tunnel and whether the user has any access
+func (suite *tunnelAccessTestSuite) levelOf(user *model.User, tunnel uuid.UUID) (model.TunnelAccessLevel, bool) {
+	levels, err := suite.accessService.Levels(context.Background(), user.Uuid, suite.permissions(user), []uuid.UUID{tunnel})
+	suite.Require().NoError(err)
+	level, ok := levels[tunnel]
+	return level, ok
+}
+
+// --- Test Cases ---
+
+func (suite *tunnelAccessTestSuite) TestLevels_OwnerIsAdmin() {
+	owner := createUser(suite.T(), suite.db, "")
+	tunnel := uuid.New()
+	suite.Require().NoError(suite.accessService.SetOwner(context.Background(), tunnel, owner.Uuid))
+
+	level, ok := suite.levelOf(owner, tunnel)
+	suite.True(ok)
+	suite.Equal(model.TUNNEL_ADMIN, level)
+}
+
+func (suite *tunnelAccessTestSuite) TestLevels_NoAccessIsLeftOut() {
+	owner, other := createUser(suite.T(), suite.db, ""), createUser(suite.T(), suite.db, "")
+	tunnel := uuid.New()
+	suite.Require().NoError(suite.accessService.SetOwner(context.Background(), tunnel, owner.Uuid))
+
+	_, ok := suite.levelOf(other, tunnel)
+	suite.False(ok)
+
+	// tunnels created outside of the app have no owner
+	_, ok = suite.levelOf(other, uuid.New())
+	suite.False(ok)
+}
+
+func (suite *tunnelAccessTestSuite) TestLevels_TunnelAllSeesEverything() {
+	admin := createUser(suite.T(), suite.db, "")
+	admin.Role = model.ROLE_ADMIN
+
+	tunnels := []uuid.UUID{uuid.New(), uuid.New()}
+	levels, err := suite.accessService.Levels(context.Background(), admin.Uuid, suite.permissions(admin), tunnels)
+	suite.Require().NoError(err)
+	suite.Len(levels, 2)
+	for _, id := range tunnels {
+		suite.Equal(model.TUNNEL_ADMIN, levels[id])
+	}
+}
+
+func (suite *tunnelAccessTestSuite) TestLevels_TunnelReadAllSeesEverythingAsViewer() {
+	viewer := createUser(suite.T(), suite.db, "")
+	viewer.Role = model.ROLE_VIEWER
+	owned := uuid.New()
+	suite.Require().NoError(suite.accessService.SetOwner(context.Background(), owned, viewer.Uuid))
+
+	tunnels := []uuid.UUID{owned, uuid.New()}
+	levels, err := suite.accessService.Levels(context.Background(), viewer.Uuid, suite.permissions(viewer), tunnels)
+	suite.Require().NoError(err)
+	suite.Equal(model.TUNNEL_ADMIN, levels[owned], "higher access is kept")
+	suite.Equal(model.TUNNEL_VIEWER, levels[tunnels[1]])
+}
+
+func (suite *tunnelAccessTestSuite) TestGrant_ReplacesEarlierGrant() {
+	user := createUser(suite.T(), suite.db, "")
+	tunnel := uuid.New()
+
+	suite.Require().NoError(suite.accessService.Grant(context.Background(), tunnel, user.Uuid, model.TUNNEL_VIEWER))
+	level, _ := suite.levelOf(user, tunnel)
+	suite.Equal(model.TUNNEL_VIEWER, level)
+
+	suite.Require().NoError(suite.accessService.Grant(context.Background(), tunnel, user.Uuid, model.TUNNEL_OPERATOR))
+	level, _ = suite.levelOf(user, tunnel)
+	suite.Equal(model.TUNNEL_OPERATOR, level)
+
+	_, grants, err := suite.accessService.Access(context.Background(), tunnel)
+	suite.Require().NoError(err)
+	suite.Len(grants, 1)
+}
+
+func (suite *tunnelAccessTestSuite) TestGrant_OwnerKeepsHigherLevel() {
+	owner := createUser(suite.T(), suite.db, "")
+	tunnel := uuid.New()
+	suite.Require().NoError(suite.accessService.SetOwner(context.Background(), tunnel, owner.Uuid))
+	suite.Require().NoError(suite.accessService.Grant(context.Background(), tunnel, owner.Uuid, model.TUNNEL_VIEWER))
+
+	level, _ := suite.levelOf(owner, tunnel)
+	suite.Equal(model.TUNNEL_ADMIN, level)
+}
+
+func (suite *tunnelAccessTestSuite) TestGrant_UnknownLevel() {
+	user := createUser(suite.T(), suite.db, "")
+	err := suite.accessService.Grant(context.Background(), uuid.New(), user.Uuid, "owner")
+	suite.True(errors.Is(err, cerror.ErrUnknownAccessLevel))
+}
+
+func (suite *tunnelAccessTestSuite) TestGrant_UnknownUser() {
+	err := suite.accessService.Grant(context.Background(), uuid.New(), uuid.New(), model.TUNNEL_VIEWER)
+	suite.True(errors.Is(err, gorm.ErrRecordNotFound))
+}
+
+func (suite *tunnelAccessTestSuite) TestSetOwner_TransfersOwnership() {
+	first, second := createUser(suite.T(), suite.db, ""), createUser(suite.T(), suite.db, "")
+	tunnel := uuid.New()
+	suite.Require().NoError(suite.accessService.SetOwner(context.Background(), tunnel, first.Uuid))
+	suite.Require().NoError(suite.accessService.SetOwner(context.Background(), tunnel, second.Uuid))
+
+	owner, _, err := suite.accessService.Access(context.Background(), tunnel)
+	suite.Require().NoError(err)
+	suite.Require().NotNil(owner.OwnerUser)
+	suite.Equal(second.Uuid, owner.OwnerUser.Uuid)
+
+	_, ok := suite.levelOf(first, tunnel)
+	suite.False(ok)
+}
+
+func (suite *tunnelAccessTestSuite) TestRevoke() {
+	user := createUser(suite.T(), suite.db, "")
+	tunnel := uuid.New()
+	suite.Require().NoError(suite.accessService.Grant(context.Background(), tunnel, user.Uuid, model.TUNNEL_OPERATOR))
+
+	suite.Require().NoError(suite.accessService.Revoke(context.Background(), tunnel, user.Uuid))
+	_, ok := suite.levelOf(user, tunnel)
+	suite.False(ok)
+
+	err := suite.accessService.Revoke(context.Background(), tunnel, user.Uuid)
+	suite.True(errors.Is(err, gorm.ErrRecordNotFound))
+}
+
+func (suite *tunnelAccessTestSuite) TestForget_RemovesOwnerAndGrants() {
+	owner, user := createUser(suite.T(), suite.db, ""), createUser(suite.T(), suite.db, "")
+	tunnel := uuid.New()
+	suite.Require().NoError(suite.accessService.SetOwner(context.Background(), tunnel, owner.Uuid))
+	suite.Require().NoError(suite.accessService.Grant(context.Background(), tunnel, user.Uuid, model.TUNNEL_VIEWER))
+
+	suite.Require().NoError(suite.accessService.Forget(context.Background(), tunnel))
+
+	found, grants, err := suite.accessService.Access(context.Background(), tunnel)
+	suite.Require().NoError(err)
+	suite.Nil(found)
+	suite.Empty(grants)
+}
+
+func (suite *tunnelAccessTestSuite) TestLevels_GroupOwnerAndGrants() {
+	member, other := createUser(suite.T(), suite.db, ""), createUser(suite.T(), suite.db, "")
+	group := model.Group{Uuid: uuid.New(), Name: uuid.NewString(), Members: []model.User{*member}}
+	suite.Require().NoError(suite.db.Create(&group).Error)
+
+	owned, shared := uuid.New(), uuid.New()
+	suite.Require().NoError(suite.accessService.SetGroupOwner(context.Background(), owned, group.Uuid))
+	suite.Require().NoError(suite.accessService.GrantGroup(context.Background(), shared, group.Uuid, model.TUNNEL_VIEWER))
+	// a personal grant wins over a lower group grant
+	suite.Require().NoError(suite.accessService.Grant(context.Background(), shared, member.Uuid, model.TUNNEL_OPERATOR))
+
+	level, _ := suite.levelOf(member, owned)
+	suite.Equal(model.TUNNEL_ADMIN, level)
+	level, _ = suite.levelOf(member, shared)
+	suite.Equal(model.TUNNEL_OPERATOR, level)
+
+	_, ok := suite.levelOf(other, owned)
+	suite.False(ok)
+
+	access, grants, err := suite.accessService.Access(context.Background(), owned)
+	suite.Require().NoError(err)
+	suite.Nil(access.OwnerUser)
+	suite.Require().NotNil(access.OwnerGroup)
+	suite.Equal(group.Name, access.OwnerGroup.Name)
+	suite.Empty(grants)
+
+	suite.Require().NoError(suite.accessService.RevokeGroup(context.Background(), shared, group.Uuid))
+	suite.True(errors.Is(suite.accessService.RevokeGroup(context.Background(), shared, group.Uuid), gorm.ErrRecordNotFound))
+}
@@ -1,47 +1,97 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"sort"
 	"strings"
 
 	"github.com/killi1812/cloudflared-web-gui/app"
 	"github.com/killi1812/cloudflared-web-gui/model"
 	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+	"github.com/killi1812/cloudflared-web-gui/util/logging"
 
 	"github.com/google/uuid"
-	"github.com/xrash/smetrics"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type IUserCrudService interface {
-	Create(user *model.User, password string) (*model.User, error)
-	Read(uuid uuid.UUID) (*model.User, error)
-	ReadAll() ([]model.User, error)
-	Update(uuid uuid.UUID, user *model.User) (*model.User, error)
-	Delete(uuid uuid.UUID) error
-	GetAllUsers() ([]model.User, error)
-	SearchUsersByName(query string) ([]model.User, error)
-	GetUserByOIB(oib string) (*model.User, error)
+	Create(ctx context.Context, user *model.User, password string) (*model.User, error)
+	Read(ctx context.Context, uuid uuid.UUID) (*model.User, error)
+	// ReadDeleted returns the deleted user with uuid
+	ReadDeleted(ctx context.Context, uuid uuid.UUID) (*model.User, error)
+	// ReadByUsername returns the active user with username
+	ReadByUsername(ctx context.Context, username string) (*model.User, error)
+	ReadAll(ctx context.Context) ([]model.User, error)
+	// List returns a page of users matching filter and the total count of matching users
+	List(ctx context.Context, filter UserFilter) ([]model.User, int64, error)
+	Update(ctx context.Context, uuid uuid.UUID, user *model.User) (*model.User, error)
+	// Delete anonymizes and soft deletes the user and ends its session
+	Delete(ctx context.Context, uuid uuid.UUID) error
+	// Restore undeletes a user under a new username, the anonymized user has no password until it is reset
+	Restore(ctx context.Context, uuid uuid.UUID, username string) (*model.User, error)
+	// ChangePassword changes the password of a user after verifying the current one,
+	// failed verifications count towards the login rate limits and lockout
+	ChangePassword(ctx context.Context, uuid uuid.UUID, currentPassword, newPassword string, client ClientInfo) (*model.User, error)
+	// ResetPassword sets a new password, unlocks the account and ends the users session,
+	// forceChange makes the user change it after login
+	ResetPassword(ctx context.Context, uuid uuid.UUID, password string, forceChange bool) error
+	// FindOrCreateByEmail returns the user with email, creating it with role if it doesn't exist
+	FindOrCreateByEmail(ctx context.Context, email string, role model.UserRole) (*model.User, error)
+}
+
+// UserFilter selects and orders users returned by List
+type UserFilter struct {
+	Page     int // Page starts at 1
+	PageSize int
+	Sort     string // Sort is a key of _USER_SORT_COLUMNS, username when empty
+	Desc     bool
+	Role     model.UserRole
+	Search   string // Search matches part of the username or email, case insensitive
+	Deleted  bool   // Deleted lists deleted users instead of active ones
+}
+
+// Normalized returns the filter with defaults for a missing or out of range page and page size
+func (f UserFilter) Normalized() UserFilter {
+	if f.Page < 1 {
+		f.Page = 1
+	}
+	if f.PageSize < 1 || f.PageSize > _MAX_PAGE_SIZE {
+		f.PageSize = _DEFAULT_PAGE_SIZE
+	}
+	return f
+}
+
+const (
+	_DEFAULT_PAGE_SIZE = 20
+	_MAX_PAGE_SIZE     = 100
+	_USERNAME_ATTEMPTS = 100 // _USERNAME_ATTEMPTS is how many suffixed usernames are tried before provisioning fails
+)
+
+// _USER_SORT_COLUMNS maps sort keys accepted by List to columns
+var _USER_SORT_COLUMNS = map[string]string{
+	"username":  "username",
+	"email":     "email",
+	"role":      "role",
+	"createdAt": "created_at",
 }
 
 type UserCrudService struct {
 	db     *gorm.DB
 	logger *zap.SugaredLogger
-}
-
-type UserWithScore struct {
-	User  model.User
-	Score float64
+	auth   IAuthService
 }
 
 func NewUserCrudService() IUserCrudService {
 	var service IUserCrudService
-	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger) {
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger, authSrv IAuthService) {
 		service = &UserCrudService{
 			db:     db,
 			logger: logger,
+			auth:   authSrv,
 		}
 	})
 
@@ -49,51 +99,165 @@ func NewUserCrudService() IUserCrudService {
 }
 
 // ReadAll implements IUserCrudService.
-func (u *UserCrudService) ReadAll() ([]model.User, error) {
+func (u *UserCrudService) ReadAll(ctx context.Context) ([]model.User, error) {
 	var users []model.User
-	rez := u.db.Find(&users)
+	rez := u.db.WithContext(ctx).Find(&users)
 	if rez.Error != nil {
 		return nil, rez.Error
 	}
 	return users, nil
 }
 
+// List implements IUserCrudService.
+func (u *UserCrudService) List(ctx context.Context, filter UserFilter) ([]model.User, int64, error) {
+	logger := logging.Logger(ctx, u.logger)
+	column, ok := _USER_SORT_COLUMNS[filter.Sort]
+	if filter.Sort == "" {
+		column, ok = "username", true
+	}
+	if !ok {
+		return nil, 0, cerror.ErrUnknownSortField
+	}
+	filter = filter.Normalized()
+
+	query := u.db.WithContext(ctx).Model(&model.User{})
+	if filter.Deleted {
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	if filter.Role != "" {
+		query = query.Where("role = ?", filter.Role)
+	}
+	if search := strings.TrimSpace(filter.Search); search != "" {
+		pattern := "%" + escapeLike(strings.ToLower(search)) + "%"
+		query = query.Where("(LOWER(username) LIKE ? ESCAPE '!' OR LOWER(email) LIKE ? ESCAPE '!')", pattern, pattern)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		logger.Errorf("Error counting users, err = %v", err)
+		return nil, 0, err
+	}
+
+	var users []model.User
+	err := query.
+		Preload("Groups").
+		Order(clause.OrderByColumn{Column: clause.Column{Name: column}, Desc: filter.Desc}).
+		Order("id").
+		Limit(filter.PageSize).
+		Offset((filter.Page - 1) * filter.PageSize).
+		Find(&users).Error
+	if err != nil {
+		logger.Errorf("Error listing users, err = %v", err)
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+// escapeLike escapes LIKE wildcards with ! so they match literally, backslash isn't portable across databases
+func escapeLike(text string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(text)
+}
+
 // Delete implements IUserCrudService.
-func (u *UserCrudService) Delete(_uuid uuid.UUID) error {
+func (u *UserCrudService) Delete(ctx context.Context, _uuid uuid.UUID) error {
+	logger := logging.Logger(ctx, u.logger)
 	var user model.User
-	rez := u.db.Where("uuid = ?", _uuid).First(&user)
+	rez := u.db.WithContext(ctx).Where("uuid = ?", _uuid).First(&user)
 	if rez.Error != nil {
-		if rez.RowsAffected == 0 {
-			u.logger.Debugf("User with UUID %s not found", _uuid)
+		if errors.Is(rez.Error, gorm.ErrRecordNotFound) {
+			logger.Debugf("User with UUID %s not found", _uuid)
 			return gorm.ErrRecordNotFound
 		}
-		u.logger.Errorf("Error finding user with UUID %s: %v", _uuid, rez.Error)
+		logger.Errorf("Error finding user with UUID %s: %v", _uuid, rez.Error)
 		return rez.Error
 	}
 
 	user.Username = fmt.Sprintf("deleted_user_%s", _uuid.String())
 	user.PasswordHash = ""
 
-	saveRez := u.db.Save(&user)
-	if saveRez.Error != nil {
-		u.logger.Errorf("Error saving anonymized user with UUID %s: %v", _uuid, saveRez.Error)
-		return saveRez.Error
-	}
+	return u.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			logger.Errorf("Error saving anonymized user with UUID %s: %v", _uuid, err)
+			return err
+		}
 
-	u.logger.Debugf("User with UUID %s anonymized successfully", _uuid)
+		logger.Debugf("User with UUID %s anonymized successfully", _uuid)
 
-	if delRez := u.db.Delete(&user); delRez.Error != nil {
-		u.logger.Errorf("Error deleting anonymized user with UUID %s: %v", _uuid, saveRez.Error)
-		return saveRez.Error
+		if err := tx.Where("user_uuid = ?", _uuid).Delete(&model.Session{}).Error; err != nil {
+			logger.Errorf("Error deleting session of user with UUID %s: %v", _uuid, err)
+			return err
+		}
+		if err := tx.Delete(&user).Error; err != nil {
+			logger.Errorf("Error deleting anonymized user with UUID %s: %v", _uuid, err)
+			return err
+		}
+
+		logger.Infof("User with UUID %s deleted", _uuid)
+		return nil
+	})
+}
+
+// Restore implements IUserCrudService.
+func (u *UserCrudService) Restore(ctx context.Context, _uuid uuid.UUID, username string) (*model.User, error) {
+	logger := logging.Logger(ctx, u.logger)
+	var user model.User
+	rez := u.db.WithContext(ctx).Unscoped().Where("uuid = ? AND deleted_at IS NOT NULL", _uuid).First(&user)
+	if rez.Error != nil {
+		return nil, rez.Error
+	}
+	if err := u.checkUsername(ctx, username); err != nil {
+		return nil, err
+	}
+
+	user.Username = username
+	user.DeletedAt = gorm.DeletedAt{}
+	if err := u.db.WithContext(ctx).Unscoped().Save(&user).Error; err != nil {
+		logger.Errorf("Error restoring user with UUID %s: %v", _uuid, err)
+		return nil, err
 	}
 
+	logger.Infof("User with UUID %s restored as %s", _uuid, username)
+	return &user, nil
+}
+
+// checkUsername returns cerror.ErrUserExists if an active user has username
+func (u *UserCrudService) checkUsername(ctx context.Context, username string) error {
+	var count int64
+	if err := u.db.WithContext(ctx).Model(&model.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return cerror.ErrUserExists
+	}
 	return nil
 }
 
+// uniqueUsername returns username, or username with the first free numeric suffix when a user already has it,
+// usernames of users provisioned from an identity provider must not shadow local or ldap users
+func uniqueUsername(ctx context.Context, db *gorm.DB, username string) (string, error) {
+	for i := 1; i <= _USERNAME_ATTEMPTS; i++ {
+		candidate := username
+		if i > 1 {
+			candidate = fmt.Sprintf("%s-%d", username, i)
+		}
+
+		var count int64
+		if err := db.WithContext(ctx).Model(&model.User{}).Where("username = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+	}
+	return "", cerror.ErrUserExists
+}
+
 // Read implements IUserCrudService.
-func (u *UserCrudService) Read(_uuid uuid.UUID) (*model.User, error) {
+func (u *UserCrudService) Read(ctx context.Context, _uuid uuid.UUID) (*model.User, error) {
 	var user model.User
-	rez := u.db.
+	rez := u.db.WithContext(ctx).
+		Preload("Groups").
 		Where("uuid = ?", _uuid).
 		First(&user)
 	if rez.Error != nil {
@@ -103,17 +267,47 @@ func (u *UserCrudService) Read(_uuid uuid.UUID) (*model.User, error) {
 	return &user, nil
 }
 
+// ReadDeleted implements IUserCrudService.
+func (u *UserCrudService) ReadDeleted(ctx context.Context, _uuid uuid.UUID) (*model.User, error) {
+	var user model.User
+	rez := u.db.WithContext(ctx).
+		Unscoped().
+		Preload("Groups").
+		Where("uuid = ? AND deleted_at IS NOT NULL", _uuid).
+		First(&user)
+	if rez.Error != nil {
+		return nil, rez.Error
+	}
+
+	return &user, nil
+}
+
+// ReadByUsername implements IUserCrudService.
+func (u *UserCrudService) ReadByUsername(ctx context.Context, username string) (*model.User, error) {
+	var user model.User
+	rez := u.db.WithContext(ctx).
+		Preload("Groups").
+		Where("username = ?", username).
+		First(&user)
+	if rez.Error != nil {
+		return nil, rez.Error
+	}
+
+	return &user, nil
+}
+
 // Update implements IUserCrudService.
-func (u *UserCrudService) Update(_uuid uuid.UUID, user *model.User) (*model.User, error) {
-	userOld, err := u.Read(_uuid)
+func (u *UserCrudService) Update(ctx context.Context, _uuid uuid.UUID, user *model.User) (*model.User, error) {
+	logger := logging.Logger(ctx, u.logger)
+	userOld, err := u.Read(ctx, _uuid)
 	if err != nil {
 		return nil, err
 	}
 
-	u.logger.Debugf("Updating user %+v", userOld)
+	logger.Debugf("Updating user %+v", userOld)
 	userOld = userOld.Update(user)
 
-	rez := u.db.
+	rez := u.db.WithContext(ctx).
 		Where("uuid = ?", _uuid).
 		Save(userOld)
 
@@ -123,7 +317,11 @@ func (u *UserCrudService) Update(_uuid uuid.UUID, user *model.User) (*model.User
 	return userOld, nil
 }
 
-func (u *UserCrudService) Create(user *model.User, password string) (*model.User, error) {
+func (u *UserCrudService) Create(ctx context.Context, user *model.User, password string) (*model.User, error) {
+	if err := auth.ValidatePassword(password); err != nil {
+		return nil, err
+	}
+
 	hash, err := auth.HashPassword(password)
 	if err != nil {
 		return nil, err
@@ -131,8 +329,12 @@ func (u *UserCrudService) Create(user *model.User, password string) (*model.User
 
 	user.PasswordHash = hash
 
+	if err := u.checkUsername(ctx, user.Username); err != nil {
+		return nil, err
+	}
+
 	// Create the user
-	rez := u.db.Create(&user)
+	rez := u.db.WithContext(ctx).Create(user)
 	if rez.Error != nil {
 		return nil, rez.Error
 	}
@@ -140,54 +342,119 @@ func (u *UserCrudService) Create(user *model.User, password string) (*model.User
 	return user, nil
 }
 
-func (u *UserCrudService) GetAllUsers() ([]model.User, error) {
-	var users []model.User
-	rez := u.db.
-		Where("role != ?", model.ROLE_SUPER_ADMIN).
-		Find(&users)
-	if rez.Error != nil {
-		return nil, rez.Error
+// ChangePassword implements IUserCrudService.
+func (u *UserCrudService) ChangePassword(ctx context.Context, _uuid uuid.UUID, currentPassword, newPassword string, client ClientInfo) (*model.User, error) {
+	logger := logging.Logger(ctx, u.logger)
+	user, err := u.Read(ctx, _uuid)
+	if err != nil {
+		return nil, err
 	}
-	return users, nil
-}
 
-// SearchUsersByName searches for users by name and surname
-func (u *UserCrudService) SearchUsersByName(query string) ([]model.User, error) {
-	normalizedQuery := strings.ToLower(strings.TrimSpace(query))
+	if err := u.auth.VerifyPassword(ctx, user, currentPassword, client); err != nil {
+		logger.Infof("Current password of user uuid = %s not verified, err = %v", _uuid, err)
+		return nil, err
+	}
+	if currentPassword == newPassword {
+		return nil, fmt.Errorf("%w: must differ from the current password", cerror.ErrWeakPassword)
+	}
 
-	var users []model.User
-	err := u.db.Find(&users).Error
+	if err := u.setPassword(ctx, user, newPassword); err != nil {
+		return nil, err
+	}
+	user.MustChangePassword = false
+
+	// only the password columns are written so a concurrent role or lockout change isn't undone
+	err = u.db.WithContext(ctx).Model(&model.User{}).Where("id = ?", user.ID).Updates(map[string]any{
+		"password_hash":        user.PasswordHash,
+		"must_change_password": false,
+	}).Error
 	if err != nil {
+		logger.Errorf("Error saving password of user uuid = %s: %v", _uuid, err)
 		return nil, err
 	}
 
-	var scoredUsers []UserWithScore
-	for _, user := range users {
-		username := strings.ToLower(user.Username)
-		score := smetrics.JaroWinkler(normalizedQuery, username, 0.7, 4)
-		scoredUsers = append(scoredUsers, UserWithScore{User: user, Score: score})
+	logger.Infof("User uuid = %s changed password", _uuid)
+	return user, nil
+}
+
+// ResetPassword implements IUserCrudService.
+func (u *UserCrudService) ResetPassword(ctx context.Context, _uuid uuid.UUID, password string, forceChange bool) error {
+	logger := logging.Logger(ctx, u.logger)
+	user, err := u.Read(ctx, _uuid)
+	if err != nil {
+		return err
 	}
 
-	sort.Slice(scoredUsers, func(i, j int) bool {
-		return scoredUsers[i].Score > scoredUsers[j].Score
-	})
+	if err := u.setPassword(ctx, user, password); err != nil {
+		return err
+	}
+	user.MustChangePassword = forceChange
+	user.ResetFailedLogins()
 
-	var filteredUsers []model.User
-	for _, scoredUser := range scoredUsers {
-		if scoredUser.Score >= 0.8 {
-			filteredUsers = append(filteredUsers, scoredUser.User)
+	return u.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(user).Error; err != nil {
+			logger.Errorf("Error saving password of user uuid = %s: %v", _uuid, err)
+			return err
 		}
+		// the old password might be known to someone else, end the current session
+		if err := tx.Where("user_uuid = ?", _uuid).Delete(&model.Session{}).Error; err != nil {
+			logger.Errorf("Error deleting session of user uuid = %s: %v", _uuid, err)
+			return err
+		}
+
+		logger.Infof("Password of user uuid = %s was reset, forceChange = %t", _uuid, forceChange)
+		return nil
+	})
+}
+
+// setPassword validates the password against the policy and sets its hash
+func (u *UserCrudService) setPassword(ctx context.Context, user *model.User, password string) error {
+	if err := auth.ValidatePassword(password); err != nil {
+		return err
 	}
 
-	return filteredUsers, nil
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = hash
+	return nil
 }
 
-// GetUserByOIB implements IUserCrudService.
-func (u *UserCrudService) GetUserByOIB(oib string) (*model.User, error) {
+// FindOrCreateByEmail implements IUserCrudService.
+func (u *UserCrudService) FindOrCreateByEmail(ctx context.Context, email string, role model.UserRole) (*model.User, error) {
+	logger := logging.Logger(ctx, u.logger)
 	var user model.User
-	rez := u.db.Where("oib = ?", oib).First(&user)
-	if rez.Error != nil {
+	rez := u.db.WithContext(ctx).Unscoped().Preload("Groups").Where("email = ?", email).First(&user)
+	if rez.Error == nil {
+		if user.DeletedAt.Valid {
+			logger.Infof("Rejecting login of deleted user, email = %s", email)
+			return nil, cerror.ErrUserDeleted
+		}
+		return &user, nil
+	}
+	if !errors.Is(rez.Error, gorm.ErrRecordNotFound) {
+		logger.Errorf("Error finding user with email %s: %v", email, rez.Error)
 		return nil, rez.Error
 	}
+
+	username, err := uniqueUsername(ctx, u.db, email)
+	if err != nil {
+		logger.Errorf("Failed to pick a username for email %s, err = %+v", email, err)
+		return nil, err
+	}
+
+	user = model.User{
+		Uuid:     uuid.New(),
+		Username: username,
+		Email:    email,
+		Role:     role,
+	}
+	if rez := u.db.WithContext(ctx).Create(&user); rez.Error != nil {
+		logger.Errorf("Error creating user with email %s: %v", email, rez.Error)
+		return nil, rez.Error
+	}
+
+	logger.Infof("Created user on first login, email = %s, uuid = %s, role = %s", email, user.Uuid, role)
 	return &user, nil
 }
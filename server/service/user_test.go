@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// --- User Crud Service Test Suite ---
+type userTestSuite struct {
+	suite.Suite
+	db          *gorm.DB
+	userService IUserCrudService
+	rawPass     string
+}
+
+func (suite *userTestSuite) SetupSuite() {
+	db, err := gorm.Open(sqlite.Open("file:user_test.db?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(db.AutoMigrate(model.GetAllModels()...))
+	suite.db = db
+
+	suite.userService = &UserCrudService{
+		db:     db,
+		logger: zap.NewNop().Sugar(),
+		auth:   &AuthService{db: db, logger: zap.NewNop().Sugar()},
+	}
+	suite.rawPass = "password123"
+}
+
+func (suite *userTestSuite) TearDownSuite() {
+	sqlDB, _ := suite.db.DB()
+	suite.Require().NoError(sqlDB.Close())
+}
+
+func TestUserTestSuite(t *testing.T) {
+	suite.Run(t, new(userTestSuite))
+}
+
+// --- Test Cases ---
+
+func (suite *userTestSuite) TestCreate_WeakPassword() {
+	_, err := suite.userService.Create(context.Background(), &model.User{
+		Uuid:     uuid.New(),
+		Username: "weak",
+		Role:     model.ROLE_USER,
+	}, "short")
+
+	suite.ErrorIs(err, cerror.ErrWeakPassword)
+}
+
+func (suite *userTestSuite) TestChangePassword_Success() {
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+	suite.Require().NoError(suite.db.Model(user).Update("must_change_password", true).Error)
+
+	changed, err := suite.userService.ChangePassword(context.Background(), user.Uuid, suite.rawPass, "new-password-456", ClientInfo{})
+	suite.Require().NoError(err)
+
+	suite.False(changed.MustChangePassword)
+	suite.True(auth.VerifyPassword(changed.PasswordHash, "new-password-456"))
+}
+
+func (suite *userTestSuite) TestChangePassword_WrongCurrentPassword() {
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+
+	_, err := suite.userService.ChangePassword(context.Background(), user.Uuid, "wrong-password", "new-password-456", ClientInfo{})
+
+	suite.ErrorIs(err, cerror.ErrInvalidCredentials)
+}
+
+func (suite *userTestSuite) TestChangePassword_PolicyIsEnforced() {
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+
+	_, err := suite.userService.ChangePassword(context.Background(), user.Uuid, suite.rawPass, "short", ClientInfo{})
+	suite.ErrorIs(err, cerror.ErrWeakPassword)
+
+	_, err = suite.userService.ChangePassword(context.Background(), user.Uuid, suite.rawPass, suite.rawPass, ClientInfo{})
+	suite.ErrorIs(err, cerror.ErrWeakPassword)
+}
+
+func (suite *userTestSuite) TestResetPassword_ForcesChangeAndEndsSession() {
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+	suite.Require().NoError(suite.db.Create(&model.Session{
+		UserId:       user.ID,
+		UserUuid:     user.Uuid,
+		RefreshToken: "refresh",
+	}).Error)
+
+	err := suite.userService.ResetPassword(context.Background(), user.Uuid, "reset-password-789", true)
+	suite.Require().NoError(err)
+
+	saved, err := suite.userService.Read(context.Background(), user.Uuid)
+	suite.Require().NoError(err)
+	suite.True(saved.MustChangePassword)
+	suite.True(auth.VerifyPassword(saved.PasswordHash, "reset-password-789"))
+
+	var sessions int64
+	suite.db.Model(&model.Session{}).Where("user_uuid = ?", user.Uuid).Count(&sessions)
+	suite.Zero(sessions)
+}
+
+func (suite *userTestSuite) TestResetPassword_UnlocksAccount() {
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+	lockedUntil := time.Now().Add(time.Hour)
+	suite.Require().NoError(suite.db.Model(user).Updates(map[string]any{"failed_logins": 10, "locked_until": lockedUntil}).Error)
+
+	suite.Require().NoError(suite.userService.ResetPassword(context.Background(), user.Uuid, "reset-password-789", false))
+
+	saved, err := suite.userService.Read(context.Background(), user.Uuid)
+	suite.Require().NoError(err)
+	suite.False(saved.IsLocked(time.Now()))
+	suite.Zero(saved.FailedLogins)
+}
+
+func (suite *userTestSuite) TestResetPassword_UnknownUser() {
+	err := suite.userService.ResetPassword(context.Background(), uuid.New(), "reset-password-789", false)
+
+	suite.ErrorIs(err, gorm.ErrRecordNotFound)
+}
+
+func (suite *userTestSuite) TestReadByUsername() {
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+
+	found, err := suite.userService.ReadByUsername(context.Background(), user.Username)
+	suite.Require().NoError(err)
+	suite.Equal(user.Uuid, found.Uuid)
+
+	_, err = suite.userService.ReadByUsername(context.Background(), "missing-"+user.Username)
+	suite.ErrorIs(err, gorm.ErrRecordNotFound)
+}
+
+func (suite *userTestSuite) TestCreate_DuplicateUsername() {
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+	_, err := suite.userService.Create(context.Background(), &model.User{
+		Uuid:     uuid.New(),
+		Username: user.Username,
+		Role:     model.ROLE_USER,
+	}, suite.rawPass)
+
+	suite.ErrorIs(err, cerror.ErrUserExists)
+}
+
+func (suite *userTestSuite) TestList_PaginatesSortsAndFilters() {
+	prefix := "list-" + uuid.NewString()[:8]
+	for _, name := range []string{"c", "a", "b"} {
+		_, err := suite.userService.Create(context.Background(), &model.User{Uuid: uuid.New(), Username: prefix + "-" + name, Role: model.ROLE_VIEWER}, suite.rawPass)
+		suite.Require().NoError(err)
+	}
+
+	users, total, err := suite.userService.List(context.Background(), UserFilter{Search: strings.ToUpper(prefix), PageSize: 2, Desc: true})
+	suite.Require().NoError(err)
+	suite.EqualValues(3, total)
+	suite.Require().Len(users, 2)
+	suite.Equal(prefix+"-c", users[0].Username)
+	suite.Equal(prefix+"-b", users[1].Username)
+
+	users, _, err = suite.userService.List(context.Background(), UserFilter{Search: prefix, PageSize: 2, Page: 2, Desc: true})
+	suite.Require().NoError(err)
+	suite.Require().Len(users, 1)
+	suite.Equal(prefix+"-a", users[0].Username)
+
+	_, total, err = suite.userService.List(context.Background(), UserFilter{Search: prefix, Role: model.ROLE_ADMIN})
+	suite.Require().NoError(err)
+	suite.Zero(total)
+
+	_, _, err = suite.userService.List(context.Background(), UserFilter{Sort: "password_hash"})
+	suite.ErrorIs(err, cerror.ErrUnknownSortField)
+}
+
+func (suite *userTestSuite) TestList_SearchEscapesWildcards() {
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+
+	_, total, err := suite.userService.List(context.Background(), UserFilter{Search: "%"})
+	suite.Require().NoError(err)
+	suite.Zero(total)
+
+	users, total, err := suite.userService.List(context.Background(), UserFilter{Search: user.Username[4:12]})
+	suite.Require().NoError(err)
+	suite.EqualValues(1, total)
+	suite.Equal(user.Uuid, users[0].Uuid)
+}
+
+func (suite *userTestSuite) TestDeleteAndRestore() {
+	user := createUser(suite.T(), suite.db, suite.rawPass)
+	suite.Require().NoError(suite.userService.Delete(context.Background(), user.Uuid))
+
+	_, err := suite.userService.Read(context.Background(), user.Uuid)
+	suite.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	deleted, _, err := suite.userService.List(context.Background(), UserFilter{Deleted: true, Search: user.Uuid.String()})
+	suite.Require().NoError(err)
+	suite.Require().Len(deleted, 1)
+	suite.Empty(deleted[0].PasswordHash)
+	read, err := suite.userService.ReadDeleted(context.Background(), user.Uuid)
+	suite.Require().NoError(err)
+	suite.Equal(user.Role, read.Role)
+
+	restored, err := suite.userService.Restore(context.Background(), user.Uuid, "restored-"+user.Uuid.String()[:8])
+	suite.Require().NoError(err)
+	suite.False(restored.DeletedAt.Valid)
+
+	_, err = suite.userService.Read(context.Background(), user.Uuid)
+	suite.NoError(err)
+	_, err = suite.userService.ReadDeleted(context.Background(), user.Uuid)
+	suite.ErrorIs(err, gorm.ErrRecordNotFound)
+	_, err = suite.userService.Restore(context.Background(), user.Uuid, "again")
+	suite.ErrorIs(err, gorm.ErrRecordNotFound)
+}
+
+func (suite *userTestSuite) TestFindOrCreateByEmail_DeletedUserIsRejected() {
+	email := uuid.NewString() + "@example.com"
+	user, err := suite.userService.FindOrCreateByEmail(context.Background(), email, model.ROLE_USER)
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.userService.Delete(context.Background(), user.Uuid))
+
+	_, err = suite.userService.FindOrCreateByEmail(context.Background(), email, model.ROLE_USER)
+	suite.ErrorIs(err, cerror.ErrUserDeleted)
+
+	var count int64
+	suite.Require().NoError(suite.db.Unscoped().Model(&model.User{}).Where("email = ?", email).Count(&count).Error)
+	suite.EqualValues(1, count)
+}
+
+func (suite *userTestSuite) TestFindOrCreateByEmail_UsernameCollisionIsSuffixed() {
+	email := uuid.NewString() + "@example.com"
+	local := createUser(suite.T(), suite.db, suite.rawPass)
+	suite.Require().NoError(suite.db.Model(local).Update("username", email).Error)
+
+	user, err := suite.userService.FindOrCreateByEmail(context.Background(), email, model.ROLE_USER)
+	suite.Require().NoError(err)
+	suite.Equal(email+"-2", user.Username)
+	suite.NotEqual(local.Uuid, user.Uuid)
+
+	found, err := suite.userService.FindOrCreateByEmail(context.Background(), email, model.ROLE_USER)
+	suite.Require().NoError(err)
+	suite.Equal(user.Uuid, found.Uuid, "the user is found by email on the next login")
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// AccessHeader is the header Cloudflare Access adds to every request it lets through
+const AccessHeader = "Cf-Access-Jwt-Assertion"
+
+// AccessUserResolver maps the email of a verified Cloudflare Access identity to a user with its groups loaded
+type AccessUserResolver func(ctx context.Context, email string) (*model.User, error)
+
+// AccessLoginHook is called with the claims of the first assertion of every Cloudflare Access login
+type AccessLoginHook func(ctx context.Context, claims *Claims, ip, userAgent string)
+
+// AccessVerifier validates Cloudflare Access JWT assertions
+type AccessVerifier struct {
+	verifier *oidc.IDTokenVerifier
+	resolve  AccessUserResolver
+	onLogin  AccessLoginHook
+
+	mu     sync.Mutex
+	logins map[string]time.Time // logins is a map with [Key] user uuid and [Value] issue time of its newest assertion
+}
+
+// access is set when the app runs in Cloudflare Access auth mode, see UseAccess
+var access *AccessVerifier
+
+// NewAccessVerifier creates a verifier for assertions issued by issuer (https://<team>.cloudflareaccess.com)
+// for the application audience tag. keySet is usually NewAccessKeySet, tests can pass any oidc.KeySet.
+func NewAccessVerifier(issuer, audience string, keySet oidc.KeySet, resolve AccessUserResolver) *AccessVerifier {
+	return &AccessVerifier{
+		verifier: oidc.NewVerifier(issuer, keySet, &oidc.Config{ClientID: audience}),
+		resolve:  resolve,
+		logins:   make(map[string]time.Time),
+	}
+}
+
+// OnLogin sets the hook called once per login. Access sends the same assertion with every request
+// of a session, so an assertion issued after the last one seen of the user starts a new login
+func (v *AccessVerifier) OnLogin(hook AccessLoginHook) {
+	v.onLogin = hook
+}
+
+// noticeLogin calls the login hook when claims come from a new login, there is one entry per user
+// in logins so requests can't grow it
+func (v *AccessVerifier) noticeLogin(ctx context.Context, claims *Claims, ip, userAgent string) {
+	if v.onLogin == nil {
+		return
+	}
+
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+	v.mu.Lock()
+	last, seen := v.logins[claims.ID]
+	isNew := !seen || issuedAt.After(last)
+	if isNew {
+		v.logins[claims.ID] = issuedAt
+	}
+	v.mu.Unlock()
+
+	if isNew {
+		v.onLogin(ctx, claims, ip, userAgent)
+	}
+}
+
+// NewAccessKeySet returns a key set that fetches the team JWKS from url,
+// keys are cached and only refetched when an assertion is signed with an unknown key
+func NewAccessKeySet(url string) oidc.KeySet {
+	return oidc.NewRemoteKeySet(context.Background(), url)
+}
+
+// UseAccess makes Protect authenticate with Cloudflare Access assertions instead of bearer tokens,
+// passing nil restores bearer token authentication
+func UseAccess(verifier *AccessVerifier) {
+	access = verifier
+}
+
+// Verify validates the assertion and returns claims of the user it belongs to
+func (v *AccessVerifier) Verify(ctx context.Context, assertion string) (*Claims, error) {
+	if assertion == "" {
+		return nil, cerror.ErrMissingAccessAssertion
+	}
+
+	idToken, err := v.verifier.Verify(ctx, assertion)
+	if err != nil {
+		return nil, err
+	}
+
+	var accessClaims struct {
+		Email string `json:"email"`
+	}
+	if err := idToken.Claims(&accessClaims); err != nil {
+		return nil, err
+	}
+	// service tokens don't carry an identity
+	if accessClaims.Email == "" {
+		return nil, cerror.ErrAccessIdentityMissing
+	}
+
+	user, err := v.resolve(ctx, accessClaims.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Claims{
+		Email:    user.Email,
+		Username: user.Username,
+		Role:     user.Role,
+		Groups:   user.GroupNames(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        user.Uuid.String(),
+			Subject:   idToken.Subject,
+			ExpiresAt: jwt.NewNumericDate(idToken.Expiry),
+			IssuedAt:  jwt.NewNumericDate(idToken.IssuedAt),
+		},
+	}, nil
+}
@@ -0,0 +1,233 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	_TEST_ACCESS_ISSUER   = "https://team.cloudflareaccess.com"
+	_TEST_ACCESS_AUDIENCE = "test-audience-tag"
+)
+
+// --- Test Suite Definition ---
+type AccessTestSuite struct {
+	suite.Suite
+	router     *gin.Engine
+	keyServer  *httptest.Server
+	keyFetches atomic.Int32
+	key        *rsa.PrivateKey
+	users      map[string]*model.User
+	logins     []string
+}
+
+func (suite *AccessTestSuite) SetupSuite() {
+	var err error
+	suite.key, err = rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+
+	// local key server standing in for https://<team>.cloudflareaccess.com/cdn-cgi/access/certs
+	suite.keyServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.keyFetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"kid": "access-key",
+				"n":   base64.RawURLEncoding.EncodeToString(suite.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(suite.key.E)).Bytes()),
+			}},
+		})
+	}))
+
+	suite.users = make(map[string]*model.User)
+	resolve := func(ctx context.Context, email string) (*model.User, error) {
+		if user, ok := suite.users[email]; ok {
+			return user, nil
+		}
+		user := &model.User{Uuid: uuid.New(), Username: email, Email: email, Role: model.ROLE_USER}
+		suite.users[email] = user
+		return user, nil
+	}
+
+	keySet := auth.NewAccessKeySet(suite.keyServer.URL)
+	verifier := auth.NewAccessVerifier(_TEST_ACCESS_ISSUER, _TEST_ACCESS_AUDIENCE, keySet, resolve)
+	verifier.OnLogin(func(ctx context.Context, claims *auth.Claims, ip, userAgent string) {
+		suite.logins = append(suite.logins, claims.Email)
+	})
+	auth.UseAccess(verifier)
+
+	gin.SetMode(gin.TestMode)
+	suite.router = gin.New()
+	suite.router.GET("/protected/general", auth.Protect(), func(c *gin.Context) {
+		claims, _ := auth.GetClaims(c)
+		c.String(http.StatusOK, claims.Email)
+	})
+	suite.router.GET("/protected/admin", auth.Protect(model.ROLE_SUPER_ADMIN), func(c *gin.Context) {
+		c.String(http.StatusOK, "admin_access_granted")
+	})
+}
+
+func (suite *AccessTestSuite) TearDownSuite() {
+	auth.UseAccess(nil)
+	suite.keyServer.Close()
+}
+
+// Helper to sign an assertion like Cloudflare Access does
+func (suite *AccessTestSuite) assertion(claims jwt.MapClaims) string {
+	base := jwt.MapClaims{
+		"iss": _TEST_ACCESS_ISSUER,
+		"aud": []string{_TEST_ACCESS_AUDIENCE},
+		"sub": uuid.NewString(),
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(5 * time.Minute).Unix(),
+	}
+	for k, v := range claims {
+		base[k] = v
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, base)
+	token.Header["kid"] = "access-key"
+	signed, err := token.SignedString(suite.key)
+	suite.Require().NoError(err)
+	return signed
+}
+
+func (suite *AccessTestSuite) performRequest(path, assertion string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(http.MethodGet, path, nil)
+	if assertion != "" {
+		req.Header.Set(auth.AccessHeader, assertion)
+	}
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, req)
+	return w
+}
+
+// --- Test Cases ---
+
+func (suite *AccessTestSuite) TestProtect_ValidAssertion() {
+	w := suite.performRequest("/protected/general", suite.assertion(jwt.MapClaims{"email": "jane@example.com"}))
+
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Equal("jane@example.com", w.Body.String())
+	suite.Contains(suite.users, "jane@example.com")
+}
+
+func (suite *AccessTestSuite) TestProtect_BearerTokenIsIgnored() {
+	req, _ := http.NewRequest(http.MethodGet, "/protected/general", nil)
+	req.Header.Set("Authorization", "Bearer something")
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, req)
+
+	suite.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func (suite *AccessTestSuite) TestProtect_MissingAssertion() {
+	w := suite.performRequest("/protected/general", "")
+
+	suite.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func (suite *AccessTestSuite) TestProtect_WrongAudience() {
+	w := suite.performRequest("/protected/general", suite.assertion(jwt.MapClaims{
+		"email": "jane@example.com",
+		"aud":   []string{"another-application"},
+	}))
+
+	suite.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func (suite *AccessTestSuite) TestProtect_ExpiredAssertion() {
+	w := suite.performRequest("/protected/general", suite.assertion(jwt.MapClaims{
+		"email": "jane@example.com",
+		"exp":   time.Now().Add(-time.Minute).Unix(),
+	}))
+
+	suite.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func (suite *AccessTestSuite) TestProtect_UnknownSigningKey() {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   _TEST_ACCESS_ISSUER,
+		"aud":   []string{_TEST_ACCESS_AUDIENCE},
+		"email": "jane@example.com",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	})
+	signed, err := token.SignedString(otherKey)
+	suite.Require().NoError(err)
+
+	w := suite.performRequest("/protected/general", signed)
+
+	suite.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func (suite *AccessTestSuite) TestProtect_ServiceTokenWithoutEmail() {
+	w := suite.performRequest("/protected/general", suite.assertion(jwt.MapClaims{"common_name": "service.access"}))
+
+	suite.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func (suite *AccessTestSuite) TestProtect_RoleOfMappedUser() {
+	suite.users["root@example.com"] = &model.User{Uuid: uuid.New(), Email: "root@example.com", Role: model.ROLE_SUPER_ADMIN}
+
+	w := suite.performRequest("/protected/admin", suite.assertion(jwt.MapClaims{"email": "jane@example.com"}))
+	suite.Equal(http.StatusForbidden, w.Code)
+
+	w = suite.performRequest("/protected/admin", suite.assertion(jwt.MapClaims{"email": "root@example.com"}))
+	suite.Equal(http.StatusOK, w.Code)
+}
+
+func (suite *AccessTestSuite) TestKeySet_IsCached() {
+	w := suite.performRequest("/protected/general", suite.assertion(jwt.MapClaims{"email": "cache@example.com"}))
+	suite.Require().Equal(http.StatusOK, w.Code)
+	fetches := suite.keyFetches.Load()
+
+	for range 3 {
+		w := suite.performRequest("/protected/general", suite.assertion(jwt.MapClaims{"email": "cache@example.com"}))
+		suite.Require().Equal(http.StatusOK, w.Code)
+	}
+
+	suite.Equal(fetches, suite.keyFetches.Load())
+}
+
+func (suite *AccessTestSuite) TestOnLogin_OncePerAssertion() {
+	suite.logins = nil
+	first := suite.assertion(jwt.MapClaims{"email": "session@example.com"})
+	for range 3 {
+		w := suite.performRequest("/protected/general", first)
+		suite.Require().Equal(http.StatusOK, w.Code)
+	}
+	suite.Equal([]string{"session@example.com"}, suite.logins)
+
+	relogin := suite.assertion(jwt.MapClaims{"email": "session@example.com", "iat": time.Now().Add(time.Minute).Unix()})
+	w := suite.performRequest("/protected/general", relogin)
+	suite.Require().Equal(http.StatusOK, w.Code)
+	w = suite.performRequest("/protected/general", first)
+	suite.Require().Equal(http.StatusOK, w.Code)
+
+	suite.Equal([]string{"session@example.com", "session@example.com"}, suite.logins, "an older assertion isn't a new login")
+}
+
+// --- Run Test Suite ---
+func TestAccessSuite(t *testing.T) {
+	suite.Run(t, new(AccessTestSuite))
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// _INVITE_AUDIENCE is the aud claim of invite tokens, it keeps them from being accepted as any other token
+const _INVITE_AUDIENCE = "invite"
+
+// GenerateInviteToken returns a token identifying the invitation, signed with the refresh key set.
+// The token is only a proof of the link, whether the invitation can still be accepted is kept in the database
+func GenerateInviteToken(invitation uuid.UUID, expiresAt time.Time) (string, error) {
+	now := time.Now()
+	claims := &jwt.RegisteredClaims{
+		Issuer:    app.JwtIssuer,
+		Subject:   invitation.String(),
+		Audience:  jwt.ClaimStrings{_INVITE_AUDIENCE},
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		NotBefore: jwt.NewNumericDate(now),
+		IssuedAt:  jwt.NewNumericDate(now),
+	}
+	return sign(claims, currentRefreshKeys().Active)
+}
+
+// ParseInviteToken verifies an invite token and returns the uuid of its invitation
+func ParseInviteToken(tokenString string) (uuid.UUID, error) {
+	var claims jwt.RegisteredClaims
+	if _, err := jwt.ParseWithClaims(tokenString, &claims, keyFunc(currentRefreshKeys(), app.RefreshKey)); err != nil {
+		return uuid.Nil, err
+	}
+	if !claims.VerifyAudience(_INVITE_AUDIENCE, true) {
+		return uuid.Nil, cerror.ErrInvalidTokenAudience
+	}
+	if !claims.VerifyIssuer(app.JwtIssuer, false) {
+		return uuid.Nil, cerror.ErrInvalidTokenIssuer
+	}
+
+	return uuid.Parse(claims.Subject)
+}
@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// SigningKey is a key identified by the kid header of tokens it signed,
+// HS256 keys hold Secret and EdDSA keys hold Private
+type SigningKey struct {
+	Kid       string
+	Algorithm string // Algorithm is app.JwtAlgHS256 when empty
+	Secret    []byte
+	Private   ed25519.PrivateKey
+	ExpiresAt time.Time // ExpiresAt is zero for keys that don't expire
+}
+
+// KeySet holds the key new tokens are signed with and the keys older tokens are still verified with
+type KeySet struct {
+	Active   SigningKey
+	Previous []SigningKey
+}
+
+// Jwk is a public key of the JSON Web Key Set published at /.well-known/jwks.json
+type Jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+}
+
+// Jwks is a JSON Web Key Set
+type Jwks struct {
+	Keys []Jwk `json:"keys"`
+}
+
+var (
+	keySetsMu   sync.RWMutex
+	accessKeys  *KeySet
+	refreshKeys *KeySet
+)
+
+// SetKeySets replaces the access and refresh token key sets,
+// until it is called keys are derived from app.AccessKey and app.RefreshKey
+func SetKeySets(access, refresh KeySet) {
+	keySetsMu.Lock()
+	defer keySetsMu.Unlock()
+	accessKeys, refreshKeys = &access, &refresh
+}
+
+// ResetKeySets drops key sets set by SetKeySets, keys are derived from config again
+func ResetKeySets() {
+	keySetsMu.Lock()
+	defer keySetsMu.Unlock()
+	accessKeys, refreshKeys = nil, nil
+}
+
+// ConfigKid returns the kid of a secret loaded from config, it is derived from
+// the secret so tokens stay valid across restarts without storing anything
+func ConfigKid(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return "cfg-" + hex.EncodeToString(sum[:8])
+}
+
+// ConfigKey creates a key that never expires from a secret loaded from config
+func ConfigKey(secret string) SigningKey {
+	return SigningKey{Kid: ConfigKid(secret), Algorithm: app.JwtAlgHS256, Secret: []byte(secret)}
+}
+
+// GenerateKey creates a random key for algorithm with a new kid
+func GenerateKey(algorithm string) (SigningKey, error) {
+	key := SigningKey{Kid: uuid.NewString(), Algorithm: algorithm}
+
+	if algorithm == app.JwtAlgEdDSA {
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return key, err
+		}
+		key.Private = private
+		return key, nil
+	}
+
+	key.Algorithm = app.JwtAlgHS256
+	key.Secret = make([]byte, 32)
+	_, err := rand.Read(key.Secret)
+	return key, err
+}
+
+// LoadKeyFile reads a PEM encoded PKCS8 Ed25519 private key, the kid is derived from the public key
+func LoadKeyFile(path string) (SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return SigningKey{}, cerror.ErrInvalidSigningKey
+	}
+
+	key, err := UnmarshalKey("", app.JwtAlgEdDSA, block.Bytes)
+	if err != nil {
+		return key, err
+	}
+	key.Kid = ConfigKid(string(key.Private.Public().(ed25519.PublicKey)))
+	return key, nil
+}
+
+// MarshalKey returns the secret of HS256 keys and PKCS8 encoded private key of EdDSA keys
+func MarshalKey(key SigningKey) ([]byte, error) {
+	if key.Algorithm == app.JwtAlgEdDSA {
+		return x509.MarshalPKCS8PrivateKey(key.Private)
+	}
+	return key.Secret, nil
+}
+
+// UnmarshalKey is the inverse of MarshalKey
+func UnmarshalKey(kid, algorithm string, data []byte) (SigningKey, error) {
+	key := SigningKey{Kid: kid, Algorithm: algorithm}
+
+	switch algorithm {
+	case app.JwtAlgEdDSA:
+		parsed, err := x509.ParsePKCS8PrivateKey(data)
+		if err != nil {
+			return key, err
+		}
+		private, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return key, cerror.ErrInvalidSigningKey
+		}
+		key.Private = private
+	case app.JwtAlgHS256, "":
+		key.Algorithm = app.JwtAlgHS256
+		key.Secret = data
+	default:
+		return key, cerror.ErrInvalidSigningKey
+	}
+
+	return key, nil
+}
+
+// PublicJwks returns the public access token keys that are not expired
+func PublicJwks() Jwks {
+	keys := currentAccessKeys()
+	now := time.Now()
+
+	jwks := Jwks{Keys: []Jwk{}}
+	for _, key := range append([]SigningKey{keys.Active}, keys.Previous...) {
+		if key.Algorithm != app.JwtAlgEdDSA || (!key.ExpiresAt.IsZero() && now.After(key.ExpiresAt)) {
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, Jwk{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(key.Private.Public().(ed25519.PublicKey)),
+			Kid: key.Kid,
+			Alg: app.JwtAlgEdDSA,
+			Use: "sig",
+		})
+	}
+
+	return jwks
+}
+
+func currentAccessKeys() KeySet {
+	keySetsMu.RLock()
+	defer keySetsMu.RUnlock()
+	if accessKeys == nil {
+		return KeySet{Active: ConfigKey(app.AccessKey)}
+	}
+	return *accessKeys
+}
+
+func currentRefreshKeys() KeySet {
+	keySetsMu.RLock()
+	defer keySetsMu.RUnlock()
+	if refreshKeys == nil {
+		return KeySet{Active: ConfigKey(app.RefreshKey)}
+	}
+	return *refreshKeys
+}
+
+// method returns the jwt signing method of the key
+func (k SigningKey) method() jwt.SigningMethod {
+	if k.Algorithm == app.JwtAlgEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodHS256
+}
+
+// signingKey returns the key passed to jwt for signing
+func (k SigningKey) signingKey() any {
+	if k.Algorithm == app.JwtAlgEdDSA {
+		return k.Private
+	}
+	return k.Secret
+}
+
+// verifyKey returns the key passed to jwt for verification
+func (k SigningKey) verifyKey() any {
+	if k.Algorithm == app.JwtAlgEdDSA {
+		return k.Private.Public()
+	}
+	return k.Secret
+}
+
+// verificationKey returns the verification key of a non expired key with kid,
+// the token has to be signed with the algorithm of the key
+func (k KeySet) verificationKey(kid string, method jwt.SigningMethod, now time.Time) (any, error) {
+	var key *SigningKey
+	if k.Active.Kid == kid {
+		key = &k.Active
+	}
+	for i := range k.Previous {
+		if key == nil && k.Previous[i].Kid == kid {
+			key = &k.Previous[i]
+		}
+	}
+
+	if key == nil {
+		return nil, cerror.ErrUnknownSigningKey
+	}
+	if key.method().Alg() != method.Alg() {
+		return nil, cerror.ErrUnexpectedSigningMethod
+	}
+	if !key.ExpiresAt.IsZero() && now.After(key.ExpiresAt) {
+		return nil, cerror.ErrSigningKeyExpired
+	}
+
+	return key.verifyKey(), nil
+}
@@ -0,0 +1,234 @@
+package auth_test
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+// --- Test Suite Definition ---
+type KeySetTestSuite struct {
+	suite.Suite
+	user *model.User
+}
+
+func (suite *KeySetTestSuite) SetupTest() {
+	app.AccessKey = "test-keyset-access"
+	app.RefreshKey = "test-keyset-refresh"
+	app.JwtIssuer = "test-issuer"
+	app.JwtAudience = []string{"test-audience"}
+	suite.user = &model.User{Uuid: uuid.New(), Username: "keyset", Role: model.ROLE_USER}
+	auth.ResetKeySets()
+}
+
+func (suite *KeySetTestSuite) TearDownTest() {
+	auth.ResetKeySets()
+	app.JwtIssuer = ""
+	app.JwtAudience = nil
+	app.JwtRequireClaims = false
+}
+
+// useEdDSA makes a generated EdDSA key the active access key
+func (suite *KeySetTestSuite) useEdDSA() auth.SigningKey {
+	key, err := auth.GenerateKey(app.JwtAlgEdDSA)
+	suite.Require().NoError(err)
+	auth.SetKeySets(auth.KeySet{Active: key}, auth.KeySet{Active: auth.ConfigKey(app.RefreshKey)})
+	return key
+}
+
+// accessToken signs a token for the suite user with secret and kid, an empty kid omits the header
+func (suite *KeySetTestSuite) accessToken(secret, kid string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{
+		Username: suite.user.Username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        suite.user.Uuid.String(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString([]byte(secret))
+	suite.Require().NoError(err)
+	return "Bearer " + signed
+}
+
+// --- Test Cases ---
+
+func (suite *KeySetTestSuite) TestGenerateTokens_SetsKid() {
+	active := auth.SigningKey{Kid: "active-kid", Secret: []byte("active-secret")}
+	auth.SetKeySets(auth.KeySet{Active: active}, auth.KeySet{Active: auth.ConfigKey(app.RefreshKey)})
+
+	accessToken, refreshToken, err := auth.GenerateTokens(suite.user)
+	suite.Require().NoError(err)
+
+	token, _, err := auth.ParseToken("Bearer " + accessToken)
+	suite.Require().NoError(err)
+	suite.Equal("active-kid", token.Header["kid"])
+
+	token, _, err = auth.ParseRefreshToken(refreshToken)
+	suite.Require().NoError(err)
+	suite.Equal(auth.ConfigKid(app.RefreshKey), token.Header["kid"])
+}
+
+func (suite *KeySetTestSuite) TestParseToken_PreviousKey() {
+	auth.SetKeySets(auth.KeySet{
+		Active:   auth.SigningKey{Kid: "new", Secret: []byte("new-secret")},
+		Previous: []auth.SigningKey{{Kid: "old", Secret: []byte("old-secret"), ExpiresAt: time.Now().Add(time.Hour)}},
+	}, auth.KeySet{})
+
+	_, claims, err := auth.ParseToken(suite.accessToken("old-secret", "old"))
+	suite.Require().NoError(err)
+	suite.Equal(suite.user.Username, claims.Username)
+}
+
+func (suite *KeySetTestSuite) TestParseToken_ExpiredPreviousKey() {
+	auth.SetKeySets(auth.KeySet{
+		Active:   auth.SigningKey{Kid: "new", Secret: []byte("new-secret")},
+		Previous: []auth.SigningKey{{Kid: "old", Secret: []byte("old-secret"), ExpiresAt: time.Now().Add(-time.Minute)}},
+	}, auth.KeySet{})
+
+	_, _, err := auth.ParseToken(suite.accessToken("old-secret", "old"))
+	suite.ErrorIs(err, cerror.ErrSigningKeyExpired)
+}
+
+func (suite *KeySetTestSuite) TestParseToken_UnknownKid() {
+	_, _, err := auth.ParseToken(suite.accessToken(app.AccessKey, "unknown"))
+	suite.ErrorIs(err, cerror.ErrUnknownSigningKey)
+}
+
+func (suite *KeySetTestSuite) TestParseToken_MissingKidUsesConfigKey() {
+	_, _, err := auth.ParseToken(suite.accessToken(app.AccessKey, ""))
+	suite.NoError(err)
+}
+
+func (suite *KeySetTestSuite) TestParseToken_KidOfOtherPurpose() {
+	_, _, err := auth.ParseToken(suite.accessToken(app.RefreshKey, auth.ConfigKid(app.RefreshKey)))
+	suite.ErrorIs(err, cerror.ErrUnknownSigningKey)
+}
+
+func (suite *KeySetTestSuite) TestGenerateTokens_StandardClaims() {
+	accessToken, _, err := auth.GenerateTokens(suite.user)
+	suite.Require().NoError(err)
+
+	_, claims, err := auth.ParseToken("Bearer " + accessToken)
+	suite.Require().NoError(err)
+	suite.Equal("test-issuer", claims.Issuer)
+	suite.Equal(jwt.ClaimStrings{"test-audience"}, claims.Audience)
+	suite.Equal(suite.user.Uuid.String(), claims.Subject)
+	suite.NotNil(claims.IssuedAt)
+	suite.NotNil(claims.NotBefore)
+}
+
+func (suite *KeySetTestSuite) TestParseToken_WrongIssuerAndAudience() {
+	accessToken, _, err := auth.GenerateTokens(suite.user)
+	suite.Require().NoError(err)
+
+	app.JwtAudience = []string{"other-audience"}
+	_, _, err = auth.ParseToken("Bearer " + accessToken)
+	suite.ErrorIs(err, cerror.ErrInvalidTokenAudience)
+
+	app.JwtIssuer = "other-issuer"
+	_, _, err = auth.ParseToken("Bearer " + accessToken)
+	suite.ErrorIs(err, cerror.ErrInvalidTokenIssuer)
+}
+
+func (suite *KeySetTestSuite) TestParseToken_RequireClaims() {
+	// tokens of older versions carry neither iss nor aud
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{
+		Username:         suite.user.Username,
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))},
+	})
+	legacyToken, err := legacy.SignedString([]byte(app.AccessKey))
+	suite.Require().NoError(err)
+	accessToken, _, err := auth.GenerateTokens(suite.user)
+	suite.Require().NoError(err)
+
+	_, _, err = auth.ParseToken("Bearer " + legacyToken)
+	suite.NoError(err, "tokens without iss and aud are accepted by default")
+
+	app.JwtRequireClaims = true
+	_, _, err = auth.ParseToken("Bearer " + legacyToken)
+	suite.ErrorIs(err, cerror.ErrInvalidTokenIssuer)
+	_, _, err = auth.ParseToken("Bearer " + accessToken)
+	suite.NoError(err)
+}
+
+func (suite *KeySetTestSuite) TestEdDSA_VerifiableWithJwks() {
+	key := suite.useEdDSA()
+
+	accessToken, _, err := auth.GenerateTokens(suite.user)
+	suite.Require().NoError(err)
+
+	jwks := auth.PublicJwks()
+	suite.Require().Len(jwks.Keys, 1)
+	suite.Equal(key.Kid, jwks.Keys[0].Kid)
+	suite.Equal("OKP", jwks.Keys[0].Kty)
+
+	// verify like another service would, only with the published key
+	x, err := base64.RawURLEncoding.DecodeString(jwks.Keys[0].X)
+	suite.Require().NoError(err)
+	token, err := jwt.Parse(accessToken, func(token *jwt.Token) (any, error) {
+		return ed25519.PublicKey(x), nil
+	})
+	suite.Require().NoError(err)
+	suite.Equal(jwt.SigningMethodEdDSA, token.Method)
+	suite.Equal(key.Kid, token.Header["kid"])
+}
+
+func (suite *KeySetTestSuite) TestEdDSA_RejectsAlgorithmConfusion() {
+	key := suite.useEdDSA()
+
+	// a HS256 token using the public key as secret must not verify
+	public := key.Private.Public().(ed25519.PublicKey)
+	_, _, err := auth.ParseToken(suite.accessToken(string(public), key.Kid))
+	suite.ErrorIs(err, cerror.ErrUnexpectedSigningMethod)
+}
+
+func (suite *KeySetTestSuite) TestPublicJwks_HidesSecretsAndExpiredKeys() {
+	expired, err := auth.GenerateKey(app.JwtAlgEdDSA)
+	suite.Require().NoError(err)
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	auth.SetKeySets(auth.KeySet{
+		Active:   auth.ConfigKey(app.AccessKey),
+		Previous: []auth.SigningKey{expired},
+	}, auth.KeySet{})
+
+	suite.Empty(auth.PublicJwks().Keys)
+}
+
+func (suite *KeySetTestSuite) TestLoadKeyFile() {
+	generated, err := auth.GenerateKey(app.JwtAlgEdDSA)
+	suite.Require().NoError(err)
+	der, err := x509.MarshalPKCS8PrivateKey(generated.Private)
+	suite.Require().NoError(err)
+
+	path := filepath.Join(suite.T().TempDir(), "jwt.pem")
+	suite.Require().NoError(os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+
+	first, err := auth.LoadKeyFile(path)
+	suite.Require().NoError(err)
+	second, err := auth.LoadKeyFile(path)
+	suite.Require().NoError(err)
+	suite.Equal(first.Kid, second.Kid, "kid should be stable across restarts")
+	suite.True(generated.Private.Equal(first.Private))
+}
+
+// --- Run Test Suite ---
+func TestKeySetSuite(t *testing.T) {
+	suite.Run(t, new(KeySetTestSuite))
+}
@@ -1,21 +1,120 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
 	"go.uber.org/zap"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// _ARGON2_PREFIX starts every Argon2id hash in PHC string format, hashes without it are bcrypt
+const _ARGON2_PREFIX = "$argon2id$"
+
+const (
+	_ARGON2_SALT_LENGTH = 16
+	_ARGON2_KEY_LENGTH  = 32
+)
+
+// Argon2Params are cost parameters of new password hashes, hashes with
+// other parameters still verify and are upgraded by NeedsRehash callers
+type Argon2Params struct {
+	Memory  uint32 // Memory is in KiB
+	Time    uint32
+	Threads uint8
+}
+
+// argon2Params is used by HashPassword, replaced with SetArgon2Params
+var argon2Params = Argon2Params{Memory: 64 * 1024, Time: 3, Threads: 2}
+
+// SetArgon2Params replaces the parameters used by HashPassword
+func SetArgon2Params(params Argon2Params) error {
+	if params.Time < 1 || params.Threads < 1 || params.Memory < 8*uint32(params.Threads) {
+		return fmt.Errorf("invalid argon2 parameters %+v, time and threads must be at least 1 and memory at least 8 KiB per thread", params)
+	}
+	argon2Params = params
+	return nil
+}
+
 func VerifyPassword(hashedPassword, plainPassword string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(plainPassword))
-	return err == nil
+	if !strings.HasPrefix(hashedPassword, _ARGON2_PREFIX) {
+		err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(plainPassword))
+		return err == nil
+	}
+
+	params, salt, key, err := decodeArgon2(hashedPassword)
+	if err != nil {
+		zap.S().Debugf("Failed to decode password hash err = %+v", err)
+		return false
+	}
+	other := argon2.IDKey([]byte(plainPassword), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1
 }
 
+// HashPassword returns an Argon2id hash of password in PHC string format
 func HashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
+	salt := make([]byte, _ARGON2_SALT_LENGTH)
+	if _, err := rand.Read(salt); err != nil {
 		zap.S().Debugf("Failed to hash password err = %+v", err)
 		return "", err
 	}
 
-	return string(hash), nil
+	params := argon2Params
+	key := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, _ARGON2_KEY_LENGTH)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		_ARGON2_PREFIX, argon2.Version, params.Memory, params.Time, params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// NeedsRehash reports whether hashedPassword was made by bcrypt or with parameters
+// other than the current ones, it should be replaced after the password is verified
+func NeedsRehash(hashedPassword string) bool {
+	if !strings.HasPrefix(hashedPassword, _ARGON2_PREFIX) {
+		return true
+	}
+	params, _, key, err := decodeArgon2(hashedPassword)
+	return err != nil || params != argon2Params || len(key) != _ARGON2_KEY_LENGTH
+}
+
+// decodeArgon2 parses $argon2id$v=19$m=65536,t=3,p=2$salt$key
+func decodeArgon2(hash string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, fmt.Errorf("argon2 hash has %d parts, want 6", len(parts))
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, err
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	// argon2.IDKey panics on zero time or threads and an empty key compares equal to any derived one
+	if params.Time == 0 || params.Memory == 0 || params.Threads == 0 {
+		return params, nil, nil, fmt.Errorf("invalid argon2 parameters m=%d,t=%d,p=%d", params.Memory, params.Time, params.Threads)
+	}
+	if len(salt) == 0 || len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("argon2 hash has an empty salt or key")
+	}
+	return params, salt, key, nil
 }
@@ -1,6 +1,7 @@
 package auth_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/killi1812/cloudflared-web-gui/util/auth"
@@ -12,6 +13,7 @@ func TestVerifyPassword(t *testing.T) {
 	hashedCorrect, _ := bcrypt.GenerateFromPassword([]byte("securePassword"), bcrypt.DefaultCost)
 	hashedWrong, _ := bcrypt.GenerateFromPassword([]byte("anotherPassword"), bcrypt.DefaultCost)
 	hashedEmpty, _ := bcrypt.GenerateFromPassword([]byte(""), bcrypt.DefaultCost)
+	argon2Hash, _ := auth.HashPassword("securePassword")
 
 	tests := []struct {
 		name           string
@@ -49,6 +51,42 @@ func TestVerifyPassword(t *testing.T) {
 			plainPassword:  "",
 			want:           true,
 		},
+		{
+			name:           "Argon2id hash",
+			hashedPassword: argon2Hash,
+			plainPassword:  "securePassword",
+			want:           true,
+		},
+		{
+			name:           "Argon2id hash, incorrect password",
+			hashedPassword: argon2Hash,
+			plainPassword:  "wrongPassword",
+			want:           false,
+		},
+		{
+			name:           "Malformed argon2id hash",
+			hashedPassword: "$argon2id$v=19$m=65536,t=3$c2FsdA$a2V5",
+			plainPassword:  "securePassword",
+			want:           false,
+		},
+		{
+			name:           "Argon2id hash with an empty key",
+			hashedPassword: "$argon2id$v=19$m=65536,t=3,p=2$c2FsdHNhbHRzYWx0c2FsdA$",
+			plainPassword:  "anyPassword",
+			want:           false,
+		},
+		{
+			name:           "Argon2id hash with zero time",
+			hashedPassword: "$argon2id$v=19$m=65536,t=0,p=2$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U",
+			plainPassword:  "securePassword",
+			want:           false,
+		},
+		{
+			name:           "Argon2id hash with zero threads",
+			hashedPassword: "$argon2id$v=19$m=65536,t=3,p=0$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U",
+			plainPassword:  "securePassword",
+			want:           false,
+		},
 		{
 			name:           "Different correct passwords",
 			hashedPassword: string(hashedWrong),
@@ -82,7 +120,7 @@ func TestHashPassword(t *testing.T) {
 		{
 			name:         "Empty password",
 			password:     "",
-			wantNonEmpty: true, // bcrypt will hash an empty string
+			wantNonEmpty: true, // an empty string is hashed like any other
 			wantErr:      false,
 		},
 	}
@@ -101,11 +139,39 @@ func TestHashPassword(t *testing.T) {
 			}
 			if got != "" && err == nil {
 				// Basic verification that the hash works (not exhaustive)
-				err := bcrypt.CompareHashAndPassword([]byte(got), []byte(tt.password))
-				if err != nil {
-					t.Errorf("HashPassword() generated an invalid hash for password: %s, error: %v", tt.password, err)
+				if !strings.HasPrefix(got, "$argon2id$v=19$") || !auth.VerifyPassword(got, tt.password) {
+					t.Errorf("HashPassword() generated an invalid hash = %s for password: %s", got, tt.password)
 				}
 			}
 		})
 	}
 }
+
+func TestNeedsRehash(t *testing.T) {
+	defaults := auth.Argon2Params{Memory: 64 * 1024, Time: 3, Threads: 2}
+	t.Cleanup(func() { _ = auth.SetArgon2Params(defaults) })
+
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if !auth.NeedsRehash(string(bcryptHash)) {
+		t.Errorf("NeedsRehash() = false for a bcrypt hash")
+	}
+
+	current, _ := auth.HashPassword("password")
+	if auth.NeedsRehash(current) {
+		t.Errorf("NeedsRehash() = true for a hash with current parameters")
+	}
+
+	if err := auth.SetArgon2Params(auth.Argon2Params{Memory: 32 * 1024, Time: 2, Threads: 1}); err != nil {
+		t.Fatalf("SetArgon2Params() error = %v", err)
+	}
+	if !auth.NeedsRehash(current) {
+		t.Errorf("NeedsRehash() = false after the parameters changed")
+	}
+	if !auth.VerifyPassword(current, "password") {
+		t.Errorf("VerifyPassword() rejected a hash with old parameters")
+	}
+
+	if err := auth.SetArgon2Params(auth.Argon2Params{Memory: 8, Time: 1, Threads: 2}); err == nil {
+		t.Errorf("SetArgon2Params() accepted less than 8 KiB memory per thread")
+	}
+}
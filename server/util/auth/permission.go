@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+var (
+	rolesMu    sync.RWMutex
+	roles      map[model.UserRole][]model.Permission
+	groupRoles map[string][]model.UserRole
+)
+
+// SetRoles replaces the known roles and their permissions,
+// until it is called the builtin roles of model.DefaultRoles are used
+func SetRoles(r map[model.UserRole][]model.Permission) {
+	rolesMu.Lock()
+	defer rolesMu.Unlock()
+	roles = r
+}
+
+// ResetRoles drops roles set by SetRoles, the builtin roles are used again
+func ResetRoles() {
+	SetRoles(nil)
+}
+
+// SetGroups replaces the known groups and the roles they grant their members
+func SetGroups(g map[string][]model.UserRole) {
+	rolesMu.Lock()
+	defer rolesMu.Unlock()
+	groupRoles = g
+}
+
+// ResetGroups drops groups set by SetGroups
+func ResetGroups() {
+	SetGroups(nil)
+}
+
+// GroupRoles returns roles granted to members of group
+func GroupRoles(group string) []model.UserRole {
+	rolesMu.RLock()
+	defer rolesMu.RUnlock()
+	return groupRoles[group]
+}
+
+// RolePermissions returns permissions of role and whether the role exists
+func RolePermissions(role model.UserRole) ([]model.Permission, bool) {
+	rolesMu.RLock()
+	defer rolesMu.RUnlock()
+	if roles == nil {
+		permissions, ok := model.DefaultRoles()[role]
+		return permissions, ok
+	}
+	permissions, ok := roles[role]
+	return permissions, ok
+}
+
+// ParseRole converts text to a known role
+func ParseRole(text string) (model.UserRole, error) {
+	role := model.UserRole(text)
+	if _, ok := RolePermissions(role); !ok {
+		return "", cerror.ErrUnknownRole
+	}
+	return role, nil
+}
+
+// HasPermission reports whether role grants all of the permissions
+func HasPermission(role model.UserRole, permissions ...model.Permission) bool {
+	granted, ok := RolePermissions(role)
+	if !ok {
+		return false
+	}
+	for _, p := range permissions {
+		if !slices.Contains(granted, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// CanGrant reports whether a user with role may assign target to someone,
+// which requires holding every permission of target
+func CanGrant(role, target model.UserRole) bool {
+	permissions, ok := RolePermissions(target)
+	return ok && HasPermission(role, permissions...)
+}
+
+// Roles returns the role of the caller followed by roles granted by its groups
+func (c *Claims) Roles() []model.UserRole {
+	roles := []model.UserRole{c.Role}
+	for _, group := range c.Groups {
+		for _, role := range GroupRoles(group) {
+			if !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
+
+// HasRole reports whether the caller has role directly or through one of its groups
+func (c *Claims) HasRole(role model.UserRole) bool {
+	return slices.Contains(c.Roles(), role)
+}
+
+// Permissions returns the effective permissions of the caller, the union of permissions of all its roles
+func (c *Claims) Permissions() []model.Permission {
+	var permissions []model.Permission
+	for _, role := range c.Roles() {
+		granted, _ := RolePermissions(role)
+		for _, p := range granted {
+			if !slices.Contains(permissions, p) {
+				permissions = append(permissions, p)
+			}
+		}
+	}
+	return permissions
+}
+
+// HasPermission reports whether the effective permissions of the caller include all of the permissions
+func (c *Claims) HasPermission(permissions ...model.Permission) bool {
+	granted := c.Permissions()
+	for _, p := range permissions {
+		if !slices.Contains(granted, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// CanGrant reports whether the caller may assign target to someone
+func (c *Claims) CanGrant(target model.UserRole) bool {
+	permissions, ok := RolePermissions(target)
+	return ok && c.HasPermission(permissions...)
+}
+
+// RequirePermission allows access only to users whose role or groups grant all of the permissions,
+// it must be placed after Protect
+func RequirePermission(permissions ...model.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := GetClaims(c)
+		if !ok {
+			zap.S().Errorf("RequirePermission used without Protect on route = %s", c.FullPath())
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		if !claims.HasPermission(permissions...) {
+			zap.S().Debugf("Role = %s with groups = %v is missing one of permissions = %v", claims.Role, claims.Groups, permissions)
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/killi1812/cloudflared-web-gui/app"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"go.uber.org/zap"
+)
+
+// PasswordPolicy describes requirements every new password has to meet
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// Breached contains lower cased passwords known from breaches, they are always rejected
+	Breached map[string]struct{}
+}
+
+// passwordPolicy is used by ValidatePassword, replaced with SetPasswordPolicy
+var passwordPolicy = PasswordPolicy{MinLength: 8}
+
+// SetPasswordPolicy replaces the policy used by ValidatePassword
+func SetPasswordPolicy(policy PasswordPolicy) {
+	passwordPolicy = policy
+}
+
+// LoadPasswordPolicy sets the policy and hashing parameters from app.Password* variables,
+// reading the breached password list if configured
+func LoadPasswordPolicy() error {
+	err := SetArgon2Params(Argon2Params{
+		Memory:  uint32(max(app.PasswordArgon2Memory, 0)),
+		Time:    uint32(max(app.PasswordArgon2Time, 0)),
+		Threads: uint8(min(max(app.PasswordArgon2Threads, 0), 255)),
+	})
+	if err != nil {
+		return err
+	}
+
+	policy := PasswordPolicy{
+		MinLength:     app.PasswordMinLength,
+		RequireUpper:  app.PasswordRequireUpper,
+		RequireLower:  app.PasswordRequireLower,
+		RequireDigit:  app.PasswordRequireDigit,
+		RequireSymbol: app.PasswordRequireSymbol,
+	}
+
+	if app.PasswordBreachedList != "" {
+		breached, err := LoadBreachedPasswords(app.PasswordBreachedList)
+		if err != nil {
+			return err
+		}
+		policy.Breached = breached
+		zap.S().Infof("Loaded %d breached passwords from %s", len(breached), app.PasswordBreachedList)
+	}
+
+	SetPasswordPolicy(policy)
+	return nil
+}
+
+// LoadBreachedPasswords reads a file with one password per line, empty lines and lines starting with # are skipped
+func LoadBreachedPasswords(path string) (map[string]struct{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	breached := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		breached[strings.ToLower(line)] = struct{}{}
+	}
+
+	return breached, scanner.Err()
+}
+
+// ValidatePassword checks the password against the current policy, the returned error wraps cerror.ErrWeakPassword
+func ValidatePassword(password string) error {
+	return passwordPolicy.Validate(password)
+}
+
+// Validate checks the password against the policy, the returned error wraps cerror.ErrWeakPassword
+func (p PasswordPolicy) Validate(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("%w: must be at least %d characters long", cerror.ErrWeakPassword, p.MinLength)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+
+	switch {
+	case p.RequireUpper && !upper:
+		return fmt.Errorf("%w: must contain an upper case letter", cerror.ErrWeakPassword)
+	case p.RequireLower && !lower:
+		return fmt.Errorf("%w: must contain a lower case letter", cerror.ErrWeakPassword)
+	case p.RequireDigit && !digit:
+		return fmt.Errorf("%w: must contain a digit", cerror.ErrWeakPassword)
+	case p.RequireSymbol && !symbol:
+		return fmt.Errorf("%w: must contain a symbol", cerror.ErrWeakPassword)
+	}
+
+	if _, ok := p.Breached[strings.ToLower(password)]; ok {
+		return fmt.Errorf("%w: password is known from a data breach", cerror.ErrWeakPassword)
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package auth_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/killi1812/cloudflared-web-gui/util/auth"
+	"github.com/killi1812/cloudflared-web-gui/util/cerror"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordPolicy_Validate(t *testing.T) {
+	policy := auth.PasswordPolicy{
+		MinLength:     10,
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+		Breached:      map[string]struct{}{"correct-horse-1a": {}},
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  bool
+	}{
+		{name: "Valid password", password: "Tr0ub4dor&3x", wantErr: false},
+		{name: "Too short", password: "Sh0rt!", wantErr: true},
+		{name: "Missing upper case", password: "tr0ub4dor&3x", wantErr: true},
+		{name: "Missing lower case", password: "TR0UB4DOR&3X", wantErr: true},
+		{name: "Missing digit", password: "Troubadour&xx", wantErr: true},
+		{name: "Missing symbol", password: "Tr0ub4dor33x", wantErr: true},
+		{name: "Breached password, case insensitive", password: "Correct-Horse-1A", wantErr: true},
+		{name: "Multibyte characters count as one", password: "Žžžžžžžž1!", wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Validate(tt.password)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, cerror.ErrWeakPassword)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestLoadBreachedPasswords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	err := os.WriteFile(path, []byte("# top passwords\nPassword1\n\n  qwerty123  \n"), 0o600)
+	require.NoError(t, err)
+
+	breached, err := auth.LoadBreachedPasswords(path)
+	require.NoError(t, err)
+
+	assert.Len(t, breached, 2)
+	assert.Contains(t, breached, "password1")
+	assert.Contains(t, breached, "qwerty123")
+
+	_, err = auth.LoadBreachedPasswords(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
@@ -5,38 +5,110 @@ import (
 	"slices"
 
 	"github.com/killi1812/cloudflared-web-gui/model"
+	"github.com/killi1812/cloudflared-web-gui/util/logging"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
-// Protect protects routes allowing access only to given roles (model.UserRole)
-// if roles are empty they it only checks for the validity of tokens
+const (
+	// _CLAIMS_KEY is the gin context key under which Protect stores the callers claims
+	_CLAIMS_KEY = "auth.claims"
+	// _ALLOW_PENDING_KEY marks routes usable by users that must change their password
+	_ALLOW_PENDING_KEY = "auth.allowPendingPasswordChange"
+	// _ALLOW_READ_ONLY_KEY marks mutating routes usable by read only roles
+	_ALLOW_READ_ONLY_KEY = "auth.allowReadOnly"
+)
+
+// Protect protects routes allowing access only to given roles (model.UserRole), held directly
+// or through a group, if roles are empty they it only checks for the validity of tokens
+//
+// When UseAccess was called the caller is authenticated with the Cloudflare Access assertion instead.
+// Requests other than GET, HEAD and OPTIONS are denied to roles without model.PERM_API_WRITE
+// unless AllowReadOnly was placed before Protect
 func Protect(roles ...model.UserRole) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, "Missing token")
-			return
+		var claims *Claims
+		if access != nil {
+			var err error
+			claims, err = access.Verify(c.Request.Context(), c.GetHeader(AccessHeader))
+			if err != nil {
+				logging.Logger(c.Request.Context(), zap.S()).Infof("Cloudflare Access auth failed with err = %+v", err)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, "Invalid access assertion")
+				return
+			}
+			access.noticeLogin(c.Request.Context(), claims, c.ClientIP(), c.Request.UserAgent())
+		} else {
+			authHeader := c.GetHeader("Authorization")
+			if authHeader == "" {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, "Missing token")
+				return
+			}
+
+			token, tokenClaims, err := ParseToken(authHeader)
+			if err != nil {
+				logging.Logger(c.Request.Context(), zap.S()).Infof("Auth failed with err = %+v", err)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, "Invalid token format")
+				return
+			}
+
+			if !token.Valid {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, "Invalid token")
+				return
+			}
+			claims = tokenClaims
 		}
 
-		token, claims, err := ParseToken(authHeader)
-		if err != nil {
-			zap.S().Infof("Auth failed with err = %+v", err)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, "Invalid token format")
+		if claims.MustChangePassword && !c.GetBool(_ALLOW_PENDING_KEY) {
+			c.AbortWithStatusJSON(http.StatusForbidden, "Password change required")
 			return
 		}
 
-		if !token.Valid {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, "Invalid token")
+		if !isReadMethod(c.Request.Method) && !c.GetBool(_ALLOW_READ_ONLY_KEY) && !claims.HasPermission(model.PERM_API_WRITE) {
+			logging.Logger(c.Request.Context(), zap.S()).Debugf("Read only role = %s denied %s %s", claims.Role, c.Request.Method, c.FullPath())
+			c.AbortWithStatus(http.StatusForbidden)
 			return
 		}
 
-		if len(roles) != 0 && !slices.Contains(roles, claims.Role) {
+		if len(roles) != 0 && !slices.ContainsFunc(roles, claims.HasRole) {
 			c.AbortWithStatus(http.StatusForbidden)
 			return
 		}
 
+		c.Set(_CLAIMS_KEY, claims)
+		logging.With(c, "user", claims.ID)
+		c.Next()
+	}
+}
+
+// AllowPendingPasswordChange must be placed before Protect on routes that users
+// who must change their password are allowed to use, like the password change itself
+func AllowPendingPasswordChange() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(_ALLOW_PENDING_KEY, true)
 		c.Next()
 	}
 }
+
+// AllowReadOnly must be placed before Protect on mutating routes that read only roles
+// are allowed to use, like refreshing tokens or changing their own password
+func AllowReadOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(_ALLOW_READ_ONLY_KEY, true)
+		c.Next()
+	}
+}
+
+func isReadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// GetClaims returns claims of the caller stored by Protect
+func GetClaims(c *gin.Context) (*Claims, bool) {
+	value, ok := c.Get(_CLAIMS_KEY)
+	if !ok {
+		return nil, false
+	}
+	claims, ok := value.(*Claims)
+	return claims, ok
+}
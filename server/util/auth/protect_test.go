@@ -39,6 +39,31 @@ func (suite *MiddlewareTestSuite) SetupSuite() {
 	suite.router.GET("/protected/admin", auth.Protect("admin"), func(c *gin.Context) {
 		c.String(http.StatusOK, "admin_access_granted")
 	})
+
+	suite.router.POST("/protected/general", auth.Protect(), func(c *gin.Context) {
+		c.String(http.StatusOK, "general_write_granted")
+	})
+
+	suite.router.PUT("/protected/read-only", auth.AllowReadOnly(), auth.Protect(), func(c *gin.Context) {
+		c.String(http.StatusOK, "read_only_allowed")
+	})
+
+	suite.router.PUT("/protected/password", auth.AllowPendingPasswordChange(), auth.Protect(), func(c *gin.Context) {
+		c.String(http.StatusOK, "password_change_allowed")
+	})
+
+	suite.router.DELETE("/protected/tunnel", auth.Protect(), auth.RequirePermission(model.PERM_TUNNEL_DELETE), func(c *gin.Context) {
+		c.String(http.StatusOK, "tunnel_deleted")
+	})
+
+	suite.router.GET("/protected/unprotected-permission", auth.RequirePermission(model.PERM_TUNNEL_READ), func(c *gin.Context) {
+		c.String(http.StatusOK, "should_not_be_reached")
+	})
+}
+
+// TearDownTest restores the builtin roles after tests that set custom ones
+func (suite *MiddlewareTestSuite) TearDownTest() {
+	auth.ResetRoles()
 }
 
 // Helper to make HTTP requests
@@ -83,6 +108,86 @@ func (suite *MiddlewareTestSuite) generateToken(userID, username string, userRol
 	return tokenString
 }
 
+// --- Test Cases for RequirePermission Middleware ---
+
+func (suite *MiddlewareTestSuite) TestRequirePermission_BuiltinRoles() {
+	userToken := suite.generateToken("123", "user", model.ROLE_USER, time.Now().Add(5*time.Minute))
+	w := suite.performRequest(http.MethodDelete, "/protected/tunnel", userToken)
+	suite.Equal(http.StatusForbidden, w.Code)
+
+	adminToken := suite.generateToken("456", "admin", model.ROLE_ADMIN, time.Now().Add(5*time.Minute))
+	w = suite.performRequest(http.MethodDelete, "/protected/tunnel", adminToken)
+	suite.Equal(http.StatusOK, w.Code)
+}
+
+func (suite *MiddlewareTestSuite) TestRequirePermission_CustomRole() {
+	auth.SetRoles(map[model.UserRole][]model.Permission{
+		"operator": {model.PERM_API_WRITE, model.PERM_TUNNEL_READ, model.PERM_TUNNEL_DELETE},
+	})
+
+	token := suite.generateToken("789", "operator", "operator", time.Now().Add(5*time.Minute))
+	w := suite.performRequest(http.MethodDelete, "/protected/tunnel", token)
+	suite.Equal(http.StatusOK, w.Code)
+
+	// roles not in the set lose their permissions
+	adminToken := suite.generateToken("456", "admin", model.ROLE_ADMIN, time.Now().Add(5*time.Minute))
+	w = suite.performRequest(http.MethodDelete, "/protected/tunnel", adminToken)
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func (suite *MiddlewareTestSuite) TestProtect_ReadOnlyRole() {
+	token := suite.generateToken("321", "viewer", model.ROLE_VIEWER, time.Now().Add(5*time.Minute))
+
+	w := suite.performRequest(http.MethodGet, "/protected/general", token)
+	suite.Equal(http.StatusOK, w.Code)
+
+	// mutating routes are denied even without RequirePermission
+	w = suite.performRequest(http.MethodPost, "/protected/general", token)
+	suite.Equal(http.StatusForbidden, w.Code)
+	w = suite.performRequest(http.MethodDelete, "/protected/tunnel", token)
+	suite.Equal(http.StatusForbidden, w.Code)
+
+	w = suite.performRequest(http.MethodPut, "/protected/read-only", token)
+	suite.Equal(http.StatusOK, w.Code)
+
+	userToken := suite.generateToken("123", "user", model.ROLE_USER, time.Now().Add(5*time.Minute))
+	w = suite.performRequest(http.MethodPost, "/protected/general", userToken)
+	suite.Equal(http.StatusOK, w.Code)
+}
+
+func (suite *MiddlewareTestSuite) TestRequirePermission_GroupRoles() {
+	auth.SetGroups(map[string][]model.UserRole{"tunnel-admins": {model.ROLE_ADMIN}})
+	defer auth.ResetGroups()
+
+	claims := &auth.Claims{
+		Role:   model.ROLE_USER,
+		Groups: []string{"tunnel-admins"},
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "123",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(5 * time.Minute)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(app.AccessKey))
+	suite.Require().NoError(err)
+
+	w := suite.performRequest(http.MethodDelete, "/protected/tunnel", token)
+	suite.Equal(http.StatusOK, w.Code)
+	w = suite.performRequest(http.MethodGet, "/protected/admin", token)
+	suite.Equal(http.StatusOK, w.Code)
+}
+
+func (suite *MiddlewareTestSuite) TestRequirePermission_WithoutProtect() {
+	w := suite.performRequest(http.MethodGet, "/protected/unprotected-permission", "")
+	suite.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func (suite *MiddlewareTestSuite) TestCanGrant() {
+	suite.True(auth.CanGrant(model.ROLE_SUPER_ADMIN, model.ROLE_ADMIN))
+	suite.True(auth.CanGrant(model.ROLE_ADMIN, model.ROLE_USER))
+	suite.False(auth.CanGrant(model.ROLE_ADMIN, model.ROLE_SUPER_ADMIN))
+	suite.False(auth.CanGrant(model.ROLE_SUPER_ADMIN, "unknown"))
+}
+
 // --- Test Cases for Protect Middleware ---
 
 func (suite *MiddlewareTestSuite) TestProtect_ValidToken_GeneralAccess() {
@@ -189,6 +294,27 @@ func (suite *MiddlewareTestSuite) TestProtect_ValidToken_InsufficientRole() {
 	// No body expected for 403 from this middleware implementation
 }
 
+func (suite *MiddlewareTestSuite) TestProtect_PasswordChangeRequired() {
+	claims := &auth.Claims{
+		Username:           "reset@example.com",
+		Role:               model.ROLE_USER,
+		MustChangePassword: true,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "12345678",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(5 * time.Minute)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(app.AccessKey))
+	suite.Require().NoError(err)
+
+	w := suite.performRequest(http.MethodGet, "/protected/general", token)
+	assert.Equal(suite.T(), http.StatusForbidden, w.Code)
+	assert.Contains(suite.T(), w.Body.String(), "Password change required")
+
+	w = suite.performRequest(http.MethodPut, "/protected/password", token)
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+}
+
 // --- Run Test Suite ---
 func TestMiddlewareSuite(t *testing.T) {
 	suite.Run(t, new(MiddlewareTestSuite))
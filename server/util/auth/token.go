@@ -17,7 +17,10 @@ type Claims struct {
 	Email     string         `json:"email"`
 	Username  string         `json:"username"`
 	Role      model.UserRole `json:"role"`
+	Groups    []string       `json:"groups,omitempty"` // Groups are names of the users groups, their roles are resolved with GroupRoles
 	TokenUuid uuid.UUID      `json:"uuid"`
+	// MustChangePassword restricts the token to routes allowed by AllowPendingPasswordChange
+	MustChangePassword bool `json:"mcp,omitempty"`
 }
 
 const (
@@ -33,49 +36,96 @@ func ParseToken(authHeader string) (*jwt.Token, *Claims, error) {
 	}
 	tokenString := authHeader[len("Bearer "):]
 	var claims Claims
-	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (any, error) {
-		return []byte(app.AccessKey), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &claims, keyFunc(currentAccessKeys(), app.AccessKey))
 	if err != nil {
 		return nil, nil, err
 	}
+	if err := claims.verifyIssuer(); err != nil {
+		return nil, nil, err
+	}
 
 	return token, &claims, nil
 }
 
-// GenerateTokens return a jwt access token and refresh token or an error
+// ParseRefreshToken parses and verifies a refresh token stored in a session
+func ParseRefreshToken(tokenString string) (*jwt.Token, *Claims, error) {
+	var claims Claims
+	token, err := jwt.ParseWithClaims(tokenString, &claims, keyFunc(currentRefreshKeys(), app.RefreshKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := claims.verifyIssuer(); err != nil {
+		return nil, nil, err
+	}
+
+	return token, &claims, nil
+}
+
+// verifyIssuer checks iss and aud against config, tokens issued before the claims were added
+// don't carry them and are accepted until they expire unless app.JwtRequireClaims is set
+func (c *Claims) verifyIssuer() error {
+	if !c.VerifyIssuer(app.JwtIssuer, app.JwtRequireClaims) {
+		return cerror.ErrInvalidTokenIssuer
+	}
+	if len(app.JwtAudience) == 0 {
+		return nil
+	}
+	if len(c.Audience) == 0 {
+		if app.JwtRequireClaims {
+			return cerror.ErrInvalidTokenAudience
+		}
+		return nil
+	}
+	for _, aud := range app.JwtAudience {
+		if c.VerifyAudience(aud, true) {
+			return nil
+		}
+	}
+	return cerror.ErrInvalidTokenAudience
+}
+
+// keyFunc selects the verification key by the kid header, tokens without kid were issued
+// before key rotation with configSecret and are accepted while its key is still in the set
+func keyFunc(keys KeySet, configSecret string) jwt.Keyfunc {
+	return func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			kid = ConfigKid(configSecret)
+		}
+
+		return keys.verificationKey(kid, token.Method, time.Now())
+	}
+}
+
+// GenerateTokens return a jwt access token and refresh token or an error,
+// user.Groups have to be loaded for the groups to be included in the access token
 func GenerateTokens(user *model.User) (string, string, error) {
 	if user == nil {
 		return "", "", cerror.ErrUserIsNil
 	}
 	uuidPair := uuid.New()
+	now := time.Now()
 	accessTokenClaims := &Claims{
-		Username:  user.Username,
-		Role:      user.Role,
-		TokenUuid: uuidPair,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(_ACCESS_TOKEN_DURATION)),
-			ID:        user.Uuid.String(),
-		},
-	}
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessTokenClaims)
-	accessTokenString, err := accessToken.SignedString([]byte(app.AccessKey))
+		Username:           user.Username,
+		Role:               user.Role,
+		Groups:             user.GroupNames(),
+		TokenUuid:          uuidPair,
+		MustChangePassword: user.MustChangePassword,
+		RegisteredClaims:   registeredClaims(user, now, _ACCESS_TOKEN_DURATION),
+	}
+	accessTokenString, err := sign(accessTokenClaims, currentAccessKeys().Active)
 	if err != nil {
 		zap.S().Errorf("Failed to generate access token err = %w", err)
 		return "", "", err
 	}
 
 	refreshTokenClaims := &Claims{
-		Username:  user.Username,
-		Role:      user.Role,
-		TokenUuid: uuidPair,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(_REFRESH_TOKEN_DURATION)),
-			ID:        user.Uuid.String(),
-		},
-	}
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshTokenClaims)
-	refreshTokenString, err := refreshToken.SignedString([]byte(app.RefreshKey))
+		Username:         user.Username,
+		Role:             user.Role,
+		TokenUuid:        uuidPair,
+		RegisteredClaims: registeredClaims(user, now, _REFRESH_TOKEN_DURATION),
+	}
+	refreshTokenString, err := sign(refreshTokenClaims, currentRefreshKeys().Active)
 	if err != nil {
 		zap.S().Errorf("Failed to generate refresh token err = %w", err)
 		return "", "", err
@@ -83,3 +133,23 @@ func GenerateTokens(user *model.User) (string, string, error) {
 
 	return accessTokenString, refreshTokenString, nil
 }
+
+// registeredClaims returns standard claims of a token for user valid for duration from now
+func registeredClaims(user *model.User, now time.Time, duration time.Duration) jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Issuer:    app.JwtIssuer,
+		Subject:   user.Uuid.String(),
+		Audience:  app.JwtAudience,
+		ExpiresAt: jwt.NewNumericDate(now.Add(duration)),
+		NotBefore: jwt.NewNumericDate(now),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ID:        user.Uuid.String(),
+	}
+}
+
+// sign signs claims with key and sets the kid header
+func sign(claims jwt.Claims, key SigningKey) (string, error) {
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.Kid
+	return token.SignedString(key.signingKey())
+}
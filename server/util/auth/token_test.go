@@ -221,3 +221,36 @@ func TestGenerateTokens(t *testing.T) {
 		})
 	}
 }
+
+func TestInviteToken(t *testing.T) {
+	app.AccessKey = "test-jwt-key"
+	app.RefreshKey = "test-refresh-key"
+
+	invitation := uuid.New()
+	token, err := auth.GenerateInviteToken(invitation, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GenerateInviteToken() error = %v", err)
+	}
+
+	got, err := auth.ParseInviteToken(token)
+	if err != nil || got != invitation {
+		t.Errorf("ParseInviteToken() = %v, %v, want %v", got, err, invitation)
+	}
+
+	if _, _, err := auth.ParseToken("Bearer " + token); err == nil {
+		t.Errorf("ParseToken() accepted an invite token")
+	}
+
+	_, refresh, err := auth.GenerateTokens(&model.User{Uuid: uuid.New(), Role: model.ROLE_USER})
+	if err != nil {
+		t.Fatalf("GenerateTokens() error = %v", err)
+	}
+	if _, err := auth.ParseInviteToken(refresh); !errors.Is(err, cerror.ErrInvalidTokenAudience) {
+		t.Errorf("ParseInviteToken() of a refresh token error = %v, want %v", err, cerror.ErrInvalidTokenAudience)
+	}
+
+	expired, _ := auth.GenerateInviteToken(invitation, time.Now().Add(-time.Minute))
+	if _, err := auth.ParseInviteToken(expired); err == nil {
+		t.Errorf("ParseInviteToken() accepted an expired token")
+	}
+}
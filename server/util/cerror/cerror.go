@@ -3,6 +3,7 @@ package cerror
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/killi1812/cloudflared-web-gui/util/format"
 )
@@ -23,4 +24,59 @@ var (
 	ErrNameIsEmpty             = errors.New("name is empty")
 	ErrTunnelNotRunning        = errors.New("tunnel not running")
 	ErrTunnelAlreadyRunning    = errors.New("tunnel already running")
+	ErrOidcNotConfigured       = errors.New("openid connect is not configured")
+	ErrOidcInvalidState        = errors.New("invalid or expired openid connect state")
+	ErrOidcMissingIdToken      = errors.New("id token missing from token response")
+	ErrMissingAccessAssertion  = errors.New("cloudflare access assertion missing")
+	ErrAccessIdentityMissing   = errors.New("cloudflare access assertion has no email")
+	ErrLocalLoginDisabled      = errors.New("local login is disabled")
+	ErrTooManyRequests         = errors.New("too many requests")
+	ErrAccountLocked           = errors.New("account is temporarily locked")
+	ErrWeakPassword            = errors.New("password does not meet the password policy")
+	ErrUserExists              = errors.New("user with this username already exists")
+	ErrDeleteSelf              = errors.New("users can't delete themselves")
+	ErrUserDeleted             = errors.New("user was deleted by an administrator")
+	ErrUnknownSortField        = errors.New("unknown sort field")
+	ErrPasswordChangeRequired  = errors.New("password change required")
+	ErrUnknownSigningKey       = errors.New("token signed with an unknown key")
+	ErrSigningKeyExpired       = errors.New("token signed with an expired key")
+	ErrUnexpectedSigningMethod = errors.New("unexpected token signing method")
+	ErrInvalidSigningKey       = errors.New("invalid signing key")
+	ErrInvalidTokenIssuer      = errors.New("token issued by another issuer")
+	ErrInvalidTokenAudience    = errors.New("token issued for another audience")
+	ErrInvalidRoleName         = errors.New("role name must be 2-20 lowercase letters, digits, - or _")
+	ErrRoleExists              = errors.New("role already exists")
+	ErrBuiltinRole             = errors.New("builtin role can't be changed")
+	ErrRoleInUse               = errors.New("role is assigned to users or groups")
+	ErrUnknownPermission       = errors.New("unknown permission")
+	ErrUnknownAccessLevel      = errors.New("unknown tunnel access level")
+	ErrInvalidGroupName        = errors.New("group name must be 2 to 50 letters, digits, spaces or . _ -")
+	ErrGroupExists             = errors.New("group already exists")
+	ErrInvalidInvitation       = errors.New("invalid invitation link")
+	ErrInvitationNotPending    = errors.New("invitation was already accepted, revoked or has expired")
+	ErrInvalidInviteExpiry     = errors.New("invitation expiry must be in the future and at most 7 days away")
+	ErrSchemaTooNew            = errors.New("database schema is newer than this version supports")
+	ErrUnknownMigration        = errors.New("database has an applied migration unknown to this version")
+	ErrIrreversibleMigration   = errors.New("migration can't be rolled back")
+	ErrPendingMigrations       = errors.New("database has pending migrations")
+	ErrBackupUnsupported       = errors.New("backup is only supported with the sqlite driver, use the database tools of postgres or mysql")
+	ErrInvalidBackup           = errors.New("invalid backup archive")
+	ErrBackupEncrypted         = errors.New("backup archive is encrypted, set the backup passphrase")
+	ErrBackupPassphrase        = errors.New("wrong backup passphrase")
+	ErrCloudflareApi           = errors.New("cloudflare api request failed")
+	ErrCloudflaredNotFound     = errors.New("cloudflared binary not found")
 )
+
+// RetryError is returned when the request can be retried after RetryAfter
+type RetryError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", e.Err, e.RetryAfter.Round(time.Second))
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
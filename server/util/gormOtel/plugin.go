@@ -0,0 +1,74 @@
+package gormotel
+
+import (
+	"errors"
+
+	"github.com/killi1812/cloudflared-web-gui/util/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// _SPAN_KEY holds the span of a statement between its before and after callbacks
+const _SPAN_KEY = "gormotel:span"
+
+type gormOtelPlugin struct{}
+
+// NewGormOtelPlugin returns a plugin starting a span for every statement as a child of the span in its context,
+// spans hold the SQL without variables since they hold password hashes and tokens
+func NewGormOtelPlugin() gorm.Plugin {
+	return gormOtelPlugin{}
+}
+
+func (gormOtelPlugin) Name() string {
+	return "gormotel"
+}
+
+func (p gormOtelPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("gorm:create").Register("gormotel:before_create", p.before("create")),
+		callbacks.Create().After("gorm:create").Register("gormotel:after_create", p.after),
+		callbacks.Query().Before("gorm:query").Register("gormotel:before_query", p.before("query")),
+		callbacks.Query().After("gorm:query").Register("gormotel:after_query", p.after),
+		callbacks.Update().Before("gorm:update").Register("gormotel:before_update", p.before("update")),
+		callbacks.Update().After("gorm:update").Register("gormotel:after_update", p.after),
+		callbacks.Delete().Before("gorm:delete").Register("gormotel:before_delete", p.before("delete")),
+		callbacks.Delete().After("gorm:delete").Register("gormotel:after_delete", p.after),
+		callbacks.Row().Before("gorm:row").Register("gormotel:before_row", p.before("row")),
+		callbacks.Row().After("gorm:row").Register("gormotel:after_row", p.after),
+		callbacks.Raw().Before("gorm:raw").Register("gormotel:before_raw", p.before("raw")),
+		callbacks.Raw().After("gorm:raw").Register("gormotel:after_raw", p.after),
+	)
+}
+
+func (gormOtelPlugin) before(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx, span := tracing.Start(db.Statement.Context, "gorm."+operation, trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attribute.String("db.system.name", db.Dialector.Name())))
+		db.Statement.Context = ctx
+		db.InstanceSet(_SPAN_KEY, span)
+	}
+}
+
+func (gormOtelPlugin) after(db *gorm.DB) {
+	value, ok := db.InstanceGet(_SPAN_KEY)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+
+	span.SetAttributes(
+		attribute.String("db.query.text", db.Statement.SQL.String()),
+		attribute.String("db.collection.name", db.Statement.Table),
+		attribute.Int64("db.response.returned_rows", db.Statement.RowsAffected),
+	)
+
+	// a missing record is an expected result, not a failed statement
+	err := db.Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil
+	}
+	tracing.End(span, err)
+}
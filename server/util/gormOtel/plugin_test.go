@@ -0,0 +1,53 @@
+package gormotel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/killi1812/cloudflared-web-gui/util/tracing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type secret struct {
+	Id    uint
+	Value string
+}
+
+func TestPlugin(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	db, err := gorm.Open(sqlite.Open("file:gormotel_test.db?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&secret{}))
+	require.NoError(t, db.Use(NewGormOtelPlugin()))
+
+	ctx, parent := tracing.Start(context.Background(), "request")
+	require.NoError(t, db.WithContext(ctx).Create(&secret{Value: "hunter2"}).Error)
+	assert.ErrorIs(t, db.WithContext(ctx).First(&secret{}, "value = ?", "missing").Error, gorm.ErrRecordNotFound)
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+
+	create, query := spans[0], spans[1]
+	assert.Equal(t, "gorm.create", create.Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), create.Parent().SpanID())
+	for _, attr := range create.Attributes() {
+		assert.NotContains(t, attr.Value.Emit(), "hunter2", "variables aren't in spans")
+	}
+
+	assert.Equal(t, "gorm.query", query.Name())
+	assert.Equal(t, codes.Unset, query.Status().Code, "a missing record isn't an error")
+}
@@ -6,11 +6,30 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/killi1812/cloudflared-web-gui/util/logging"
+	"github.com/killi1812/cloudflared-web-gui/util/metrics"
+
 	"go.uber.org/zap"
 	"gorm.io/gorm/logger"
 	"gorm.io/gorm/utils"
 )
 
+// ParseLevel parses a gorm log level name, silent, error, warn or info
+func ParseLevel(level string) (logger.LogLevel, error) {
+	switch level {
+	case "silent":
+		return logger.Silent, nil
+	case "error":
+		return logger.Error, nil
+	case "warn":
+		return logger.Warn, nil
+	case "info":
+		return logger.Info, nil
+	default:
+		return 0, fmt.Errorf("unknown gorm log level %s", level)
+	}
+}
+
 type gormZapLogger struct {
 	logger.Config
 	infoStr, warnStr, errStr            string
@@ -51,50 +70,53 @@ func (l *gormZapLogger) LogMode(level logger.LogLevel) logger.Interface {
 
 func (l *gormZapLogger) Info(c context.Context, msg string, args ...any) {
 	if l.LogLevel >= logger.Info {
-		zap.S().Infof(l.infoStr+msg, args...)
+		logging.Logger(c, zap.S()).Infof(l.infoStr+msg, args...)
 	}
 }
 
 func (l *gormZapLogger) Warn(c context.Context, msg string, args ...any) {
 	if l.LogLevel >= logger.Warn {
-		zap.S().Warnf(l.warnStr+msg, args...)
+		logging.Logger(c, zap.S()).Warnf(l.warnStr+msg, args...)
 	}
 }
 
 func (l *gormZapLogger) Error(c context.Context, msg string, args ...any) {
 	if l.LogLevel >= logger.Error {
-		zap.S().Errorf(l.errStr+msg, args...)
+		logging.Logger(c, zap.S()).Errorf(l.errStr+msg, args...)
 	}
 }
 
 func (l *gormZapLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	elapsed := time.Since(begin)
+	metrics.ObserveQuery(elapsed, err)
+
 	if l.LogLevel <= logger.Silent {
 		return
 	}
-	elapsed := time.Since(begin)
+	log := logging.Logger(ctx, zap.S())
 
 	switch {
 	case err != nil && l.LogLevel >= logger.Error && (!errors.Is(err, logger.ErrRecordNotFound) || !l.IgnoreRecordNotFoundError):
 		sql, rows := fc()
 		if rows == -1 {
-			zap.S().Errorf(l.traceErrStr, utils.FileWithLineNum(), err, float64(elapsed.Nanoseconds())/1e6, "-", sql)
+			log.Errorf(l.traceErrStr, utils.FileWithLineNum(), err, float64(elapsed.Nanoseconds())/1e6, "-", sql)
 		} else {
-			zap.S().Errorf(l.traceErrStr, utils.FileWithLineNum(), err, float64(elapsed.Nanoseconds())/1e6, rows, sql)
+			log.Errorf(l.traceErrStr, utils.FileWithLineNum(), err, float64(elapsed.Nanoseconds())/1e6, rows, sql)
 		}
 	case elapsed > l.SlowThreshold && l.SlowThreshold != 0 && l.LogLevel >= logger.Warn:
 		sql, rows := fc()
 		slowLog := fmt.Sprintf("SLOW SQL >= %v", l.SlowThreshold)
 		if rows == -1 {
-			zap.S().Warnf(l.traceWarnStr, utils.FileWithLineNum(), slowLog, float64(elapsed.Nanoseconds())/1e6, "-", sql)
+			log.Warnf(l.traceWarnStr, utils.FileWithLineNum(), slowLog, float64(elapsed.Nanoseconds())/1e6, "-", sql)
 		} else {
-			zap.S().Warnf(l.traceWarnStr, utils.FileWithLineNum(), slowLog, float64(elapsed.Nanoseconds())/1e6, rows, sql)
+			log.Warnf(l.traceWarnStr, utils.FileWithLineNum(), slowLog, float64(elapsed.Nanoseconds())/1e6, rows, sql)
 		}
 	case l.LogLevel == logger.Info:
 		sql, rows := fc()
 		if rows == -1 {
-			zap.S().Infof(l.traceStr, utils.FileWithLineNum(), float64(elapsed.Nanoseconds())/1e6, "-", sql)
+			log.Infof(l.traceStr, utils.FileWithLineNum(), float64(elapsed.Nanoseconds())/1e6, "-", sql)
 		} else {
-			zap.S().Infof(l.traceStr, utils.FileWithLineNum(), float64(elapsed.Nanoseconds())/1e6, rows, sql)
+			log.Infof(l.traceStr, utils.FileWithLineNum(), float64(elapsed.Nanoseconds())/1e6, rows, sql)
 		}
 	}
 }
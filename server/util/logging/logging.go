@@ -0,0 +1,98 @@
+// Package logging carries a request scoped logger in the context, so log lines of services, queries and the
+// access log of a request can be found by its request id
+package logging
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/killi1812/cloudflared-web-gui/util/tracing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// HeaderRequestId is the header a request id is read from and returned in
+const HeaderRequestId = "X-Request-ID"
+
+// _REQUEST_ID_REGEX limits request ids sent by clients, so they can't inject into log lines
+var _REQUEST_ID_REGEX = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type loggerKey struct{}
+
+// WithLogger returns ctx carrying logger
+func WithLogger(ctx context.Context, logger *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// Logger returns the request scoped logger of ctx, outside of requests it returns fallback
+// with the trace and span id of ctx
+func Logger(ctx context.Context, fallback *zap.SugaredLogger) *zap.SugaredLogger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.SugaredLogger); ok {
+		return logger
+	}
+	return tracing.Logger(ctx, fallback)
+}
+
+// With adds key value pairs to the request scoped logger of c, later log lines of the request carry them
+func With(c *gin.Context, args ...any) {
+	ctx := c.Request.Context()
+	c.Request = c.Request.WithContext(WithLogger(ctx, Logger(ctx, zap.S()).With(args...)))
+}
+
+// Middleware assigns every request an id, a valid X-Request-ID of the client is kept, puts a logger with the
+// id and route into the request context and writes an access log line when the request is done.
+// Requests to quiet paths, like probes, are logged at debug level
+func Middleware(quiet ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		id := c.GetHeader(HeaderRequestId)
+		if !_REQUEST_ID_REGEX.MatchString(id) {
+			id = uuid.NewString()
+		}
+		c.Header(HeaderRequestId, id)
+
+		route := c.FullPath()
+		ctx := c.Request.Context()
+		logger := tracing.Logger(ctx, zap.S()).With("request_id", id, "method", c.Request.Method, "route", route)
+		c.Request = c.Request.WithContext(WithLogger(ctx, logger))
+
+		c.Next()
+
+		logger = Logger(c.Request.Context(), zap.S())
+		fields := []any{
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"latency", time.Since(start),
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+			"user_agent", c.Request.UserAgent(),
+		}
+		if len(c.Errors) > 0 {
+			fields = append(fields, "errors", c.Errors.String())
+		}
+
+		switch {
+		case c.Writer.Status() >= http.StatusInternalServerError:
+			logger.Errorw("Request failed", fields...)
+		case slices.Contains(quiet, c.Request.URL.Path):
+			logger.Debugw("Request", fields...)
+		default:
+			logger.Infow("Request", fields...)
+		}
+	}
+}
+
+// Recovery responds with 500 to requests that panicked and logs the panic with the request scoped logger
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err any) {
+		Logger(c.Request.Context(), zap.S()).Errorf("Recovered from panic, err = %+v\n%s", err, debug.Stack())
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
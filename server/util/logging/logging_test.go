@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newRouter(t *testing.T) (*gin.Engine, *observer.ObservedLogs) {
+	core, logs := observer.New(zap.DebugLevel)
+	t.Cleanup(zap.ReplaceGlobals(zap.New(core)))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware("/healthz"), Recovery())
+	router.GET("/tunnel/:id", func(c *gin.Context) {
+		With(c, "user", "user-uuid")
+		Logger(c.Request.Context(), zap.S()).Info("Handled")
+		c.Status(http.StatusNoContent)
+	})
+	router.GET("/healthz", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/panic", func(c *gin.Context) { panic("boom") })
+	return router, logs
+}
+
+func get(router *gin.Engine, path, requestId string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if requestId != "" {
+		req.Header.Set(HeaderRequestId, requestId)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware_RequestId(t *testing.T) {
+	router, logs := newRouter(t)
+
+	w := get(router, "/tunnel/1", "client-id.1")
+	assert.Equal(t, "client-id.1", w.Header().Get(HeaderRequestId))
+
+	entries := logs.All()
+	require.Len(t, entries, 2)
+	for _, entry := range entries {
+		fields := entry.ContextMap()
+		assert.Equal(t, "client-id.1", fields["request_id"])
+		assert.Equal(t, "/tunnel/:id", fields["route"])
+		assert.Equal(t, "user-uuid", fields["user"], "fields added by With are on later lines")
+	}
+	assert.Equal(t, "Request", entries[1].Message)
+	assert.EqualValues(t, http.StatusNoContent, entries[1].ContextMap()["status"])
+}
+
+func TestMiddleware_InvalidRequestIdReplaced(t *testing.T) {
+	router, _ := newRouter(t)
+
+	w := get(router, "/tunnel/1", "bad id\nwith newline")
+	_, err := uuid.Parse(w.Header().Get(HeaderRequestId))
+	assert.NoError(t, err)
+
+	w = get(router, "/tunnel/1", "")
+	_, err = uuid.Parse(w.Header().Get(HeaderRequestId))
+	assert.NoError(t, err)
+}
+
+func TestMiddleware_Levels(t *testing.T) {
+	router, logs := newRouter(t)
+
+	get(router, "/healthz", "")
+	assert.Equal(t, zapcore.DebugLevel, logs.TakeAll()[0].Level, "quiet paths are logged at debug")
+
+	w := get(router, "/panic", "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	entries := logs.TakeAll()
+	require.Len(t, entries, 2)
+	assert.Contains(t, entries[0].Message, "boom")
+	assert.Equal(t, zapcore.ErrorLevel, entries[1].Level)
+}
+
+func TestLogger_Fallback(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	fallback := zap.New(core).Sugar()
+
+	Logger(context.Background(), fallback).Info("outside a request")
+	Logger(WithLogger(context.Background(), fallback.With("request_id", "1")), zap.S()).Info("in a request")
+
+	entries := logs.All()
+	require.Len(t, entries, 2)
+	assert.Empty(t, entries[0].ContextMap())
+	assert.Equal(t, "1", entries[1].ContextMap()["request_id"])
+}
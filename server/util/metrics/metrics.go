@@ -0,0 +1,238 @@
+// Package metrics holds the Prometheus collectors of the app and serves them in the text exposition format
+package metrics
+
+import (
+	"crypto/subtle"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm/logger"
+)
+
+const _NAMESPACE = "cloudflared_web_gui"
+
+// _UNMATCHED labels requests that didn't match a route, so unknown paths can't grow the label set
+const _UNMATCHED = "unmatched"
+
+// _OTHER_METHOD labels requests with a method outside _METHODS, clients can send any method name
+const _OTHER_METHOD = "other"
+
+var _METHODS = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodHead, http.MethodOptions,
+}
+
+// Registry holds every collector of the app, the Go runtime and process collectors included
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: _NAMESPACE,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status.",
+	}, []string{"method", "route", "status"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: _NAMESPACE,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latencies by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	dbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: _NAMESPACE,
+		Name:      "db_query_duration_seconds",
+		Help:      "Database query durations by result, ok, not_found or error.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"result"})
+
+	cloudflaredCommands = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: _NAMESPACE,
+		Name:      "cloudflared_commands_total",
+		Help:      "cloudflared invocations by tunnel subcommand.",
+	}, []string{"command"})
+	cloudflaredFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: _NAMESPACE,
+		Name:      "cloudflared_command_failures_total",
+		Help:      "cloudflared invocations that failed to start or exited with an error, by tunnel subcommand.",
+	}, []string{"command"})
+
+	cloudflareApiCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: _NAMESPACE,
+		Name:      "cloudflare_api_requests_total",
+		Help:      "Cloudflare API requests by endpoint and status, status is error when no response was received.",
+	}, []string{"endpoint", "status"})
+	cloudflareApiRateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: _NAMESPACE,
+		Name:      "cloudflare_api_rate_limited_total",
+		Help:      "Cloudflare API requests rejected with 429 Too Many Requests, by endpoint.",
+	}, []string{"endpoint"})
+
+	tunnelRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: _NAMESPACE,
+		Name:      "tunnel_restarts_total",
+		Help:      "Tunnel restarts by tunnel.",
+	}, []string{"tunnel"})
+	tunnelRunning = prometheus.NewDesc(
+		prometheus.BuildFQName(_NAMESPACE, "", "tunnel_running"),
+		"1 when a cloudflared process is running the tunnel.",
+		[]string{"tunnel"}, nil,
+	)
+	tunnelDesired = prometheus.NewDesc(
+		prometheus.BuildFQName(_NAMESPACE, "", "tunnel_desired_running"),
+		"1 when the tunnel was last started or restarted by this server, 0 when it was last stopped.",
+		[]string{"tunnel"}, nil,
+	)
+)
+
+var (
+	tunnelsMu sync.Mutex
+	tunnels   func() []string     // tunnels returns running tunnels on every scrape, set with ObserveTunnels
+	desired   = map[string]bool{} // desired is the state tunnels were last put in
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration,
+		dbQueryDuration,
+		cloudflaredCommands, cloudflaredFailures,
+		cloudflareApiCalls, cloudflareApiRateLimited,
+		tunnelRestarts,
+		tunnelCollector{},
+	)
+}
+
+// Handler serves the metrics, requests must send token as a bearer token
+func Handler(token string) gin.HandlerFunc {
+	handler := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+	want := []byte("Bearer " + token)
+
+	return func(c *gin.Context) {
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), want) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// Middleware counts requests and observes their latency by method and route template,
+// so path parameters and made up methods don't create new series
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = _UNMATCHED
+		}
+		method := c.Request.Method
+		if !slices.Contains(_METHODS, method) {
+			method = _OTHER_METHOD
+		}
+		httpRequests.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveQuery records the duration of a database query and its result
+func ObserveQuery(elapsed time.Duration, err error) {
+	result := "ok"
+	switch {
+	case errors.Is(err, logger.ErrRecordNotFound):
+		result = "not_found"
+	case err != nil:
+		result = "error"
+	}
+	dbQueryDuration.WithLabelValues(result).Observe(elapsed.Seconds())
+}
+
+// CountCommand records a cloudflared tunnel subcommand invocation, failed when err isn't nil
+func CountCommand(command string, err error) {
+	cloudflaredCommands.WithLabelValues(command).Inc()
+	if err != nil {
+		cloudflaredFailures.WithLabelValues(command).Inc()
+	}
+}
+
+// CountApiCall records a Cloudflare API request to endpoint, status is zero when no response was received
+func CountApiCall(endpoint string, status int) {
+	label := "error"
+	if status != 0 {
+		label = strconv.Itoa(status)
+	}
+	cloudflareApiCalls.WithLabelValues(endpoint, label).Inc()
+	if status == http.StatusTooManyRequests {
+		cloudflareApiRateLimited.WithLabelValues(endpoint).Inc()
+	}
+}
+
+// SetTunnelDesired records whether tunnel should be running
+func SetTunnelDesired(tunnel string, running bool) {
+	tunnelsMu.Lock()
+	defer tunnelsMu.Unlock()
+	desired[tunnel] = running
+}
+
+// CountTunnelRestart records a restart of tunnel
+func CountTunnelRestart(tunnel string) {
+	tunnelRestarts.WithLabelValues(tunnel).Inc()
+}
+
+// ObserveTunnels sets the function returning ids of running tunnels, it is called on every scrape
+func ObserveTunnels(running func() []string) {
+	tunnelsMu.Lock()
+	defer tunnelsMu.Unlock()
+	tunnels = running
+}
+
+// tunnelCollector reports the running and desired state of tunnels that are running or have a desired state
+type tunnelCollector struct{}
+
+func (tunnelCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- tunnelRunning
+	ch <- tunnelDesired
+}
+
+func (tunnelCollector) Collect(ch chan<- prometheus.Metric) {
+	tunnelsMu.Lock()
+	running := tunnels
+	states := maps.Clone(desired)
+	tunnelsMu.Unlock()
+
+	isRunning := make(map[string]bool)
+	if running != nil {
+		for _, tunnel := range running() {
+			isRunning[tunnel] = true
+		}
+	}
+
+	for tunnel := range isRunning {
+		if _, ok := states[tunnel]; !ok {
+			ch <- prometheus.MustNewConstMetric(tunnelRunning, prometheus.GaugeValue, 1, tunnel)
+		}
+	}
+	for tunnel, want := range states {
+		ch <- prometheus.MustNewConstMetric(tunnelRunning, prometheus.GaugeValue, gaugeValue(isRunning[tunnel]), tunnel)
+		ch <- prometheus.MustNewConstMetric(tunnelDesired, prometheus.GaugeValue, gaugeValue(want), tunnel)
+	}
+}
+
+func gaugeValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm/logger"
+)
+
+func newRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/metrics", Handler("token"))
+	router.GET("/tunnel/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	return router
+}
+
+func get(router *gin.Engine, path, authorization string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandler_Token(t *testing.T) {
+	router := newRouter()
+
+	assert.Equal(t, http.StatusUnauthorized, get(router, "/metrics", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, get(router, "/metrics", "Bearer wrong").Code)
+
+	w := get(router, "/metrics", "Bearer token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "go_goroutines")
+}
+
+func TestMiddleware_Route(t *testing.T) {
+	router := newRouter()
+	before := testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, "/tunnel/:id", "204"))
+	unmatched := testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, _UNMATCHED, "404"))
+
+	get(router, "/tunnel/1", "")
+	get(router, "/tunnel/2", "")
+	get(router, "/unknown", "")
+
+	assert.Equal(t, before+2, testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, "/tunnel/:id", "204")),
+		"requests are labeled by the route template")
+	assert.Equal(t, unmatched+1, testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, _UNMATCHED, "404")))
+}
+
+func TestMiddleware_Method(t *testing.T) {
+	router := newRouter()
+	other := testutil.ToFloat64(httpRequests.WithLabelValues(_OTHER_METHOD, _UNMATCHED, "404"))
+
+	for _, method := range []string{"FOO", "BAR", "get"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/unknown", nil))
+	}
+
+	assert.Equal(t, other+3, testutil.ToFloat64(httpRequests.WithLabelValues(_OTHER_METHOD, _UNMATCHED, "404")),
+		"unknown methods share one label")
+}
+
+func TestCounters(t *testing.T) {
+	CountCommand("list", nil)
+	CountCommand("list", errors.New("exit status 1"))
+	assert.Equal(t, 2.0, testutil.ToFloat64(cloudflaredCommands.WithLabelValues("list")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(cloudflaredFailures.WithLabelValues("list")))
+
+	CountApiCall("dns_records", http.StatusOK)
+	CountApiCall("dns_records", http.StatusTooManyRequests)
+	CountApiCall("dns_records", 0)
+	assert.Equal(t, 1.0, testutil.ToFloat64(cloudflareApiCalls.WithLabelValues("dns_records", "error")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(cloudflareApiRateLimited.WithLabelValues("dns_records")))
+
+	ObserveQuery(time.Millisecond, logger.ErrRecordNotFound)
+	assert.Equal(t, 1, testutil.CollectAndCount(dbQueryDuration, _NAMESPACE+"_db_query_duration_seconds"))
+}
+
+func TestTunnelCollector(t *testing.T) {
+	t.Cleanup(func() {
+		ObserveTunnels(nil)
+		desired = map[string]bool{}
+	})
+
+	ObserveTunnels(func() []string { return []string{"running-started", "running-elsewhere"} })
+	SetTunnelDesired("running-started", true)
+	SetTunnelDesired("crashed", true)
+	SetTunnelDesired("stopped", false)
+
+	expected := `
+# HELP cloudflared_web_gui_tunnel_desired_running 1 when the tunnel was last started or restarted by this server, 0 when it was last stopped.
+# TYPE cloudflared_web_gui_tunnel_desired_running gauge
+cloudflared_web_gui_tunnel_desired_running{tunnel="crashed"} 1
+cloudflared_web_gui_tunnel_desired_running{tunnel="running-started"} 1
+cloudflared_web_gui_tunnel_desired_running{tunnel="stopped"} 0
+# HELP cloudflared_web_gui_tunnel_running 1 when a cloudflared process is running the tunnel.
+# TYPE cloudflared_web_gui_tunnel_running gauge
+cloudflared_web_gui_tunnel_running{tunnel="crashed"} 0
+cloudflared_web_gui_tunnel_running{tunnel="running-elsewhere"} 1
+cloudflared_web_gui_tunnel_running{tunnel="running-started"} 1
+cloudflared_web_gui_tunnel_running{tunnel="stopped"} 0
+`
+	require.NoError(t, testutil.CollectAndCompare(tunnelCollector{}, strings.NewReader(expected)))
+}
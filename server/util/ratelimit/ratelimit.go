@@ -0,0 +1,87 @@
+// Package ratelimit implements in memory sliding window rate limiting keyed by a string (ip, username, ...)
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter allows at most limit hits per key in any window
+type Limiter struct {
+	limit  int
+	window time.Duration
+
+	mu        sync.Mutex
+	hits      map[string][]time.Time
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// New creates a limiter, a limit <= 0 disables limiting
+func New(limit int, window time.Duration) *Limiter {
+	return &Limiter{
+		limit:  limit,
+		window: window,
+		hits:   make(map[string][]time.Time),
+		now:    time.Now,
+	}
+}
+
+// Allow records a hit for key and reports if it is within the limit,
+// when it isn't the hit is not recorded and retryAfter is the time until the next hit is allowed
+func (l *Limiter) Allow(key string) (ok bool, retryAfter time.Duration) {
+	if l == nil || l.limit <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.prune(now)
+
+	hits := l.recent(key, now)
+	if len(hits) >= l.limit {
+		l.hits[key] = hits
+		return false, hits[0].Add(l.window).Sub(now)
+	}
+
+	l.hits[key] = append(hits, now)
+	return true, 0
+}
+
+// Reset forgets all hits of key
+func (l *Limiter) Reset(key string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.hits, key)
+}
+
+// recent returns hits of key inside the window ending at now, must be called with mu held
+func (l *Limiter) recent(key string, now time.Time) []time.Time {
+	hits := l.hits[key]
+	start := now.Add(-l.window)
+	i := 0
+	for i < len(hits) && !hits[i].After(start) {
+		i++
+	}
+	return hits[i:]
+}
+
+// prune removes keys without recent hits at most once per window, must be called with mu held
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < l.window {
+		return
+	}
+	l.lastPrune = now
+
+	for key := range l.hits {
+		if len(l.recent(key, now)) == 0 {
+			delete(l.hits, key)
+		}
+	}
+}
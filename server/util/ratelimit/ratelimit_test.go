@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTestLimiter(limit int, window time.Duration) (*Limiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := New(limit, window)
+	l.now = clock.Now
+	return l, clock
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	l, clock := newTestLimiter(3, time.Minute)
+
+	for i := range 3 {
+		if ok, _ := l.Allow("ip"); !ok {
+			t.Fatalf("hit %d should be allowed", i+1)
+		}
+		clock.Advance(10 * time.Second)
+	}
+
+	ok, retryAfter := l.Allow("ip")
+	if ok {
+		t.Fatalf("hit over the limit should not be allowed")
+	}
+	if retryAfter != 30*time.Second {
+		t.Errorf("retryAfter = %v, want %v", retryAfter, 30*time.Second)
+	}
+
+	if ok, _ := l.Allow("another-ip"); !ok {
+		t.Errorf("other keys should not be limited")
+	}
+
+	clock.Advance(retryAfter)
+	if ok, _ := l.Allow("ip"); !ok {
+		t.Errorf("hit should be allowed after the oldest hit left the window")
+	}
+}
+
+func TestLimiter_Reset(t *testing.T) {
+	l, _ := newTestLimiter(1, time.Minute)
+
+	l.Allow("user")
+	if ok, _ := l.Allow("user"); ok {
+		t.Fatalf("second hit should not be allowed")
+	}
+
+	l.Reset("user")
+	if ok, _ := l.Allow("user"); !ok {
+		t.Errorf("hit should be allowed after reset")
+	}
+}
+
+func TestLimiter_Disabled(t *testing.T) {
+	var nilLimiter *Limiter
+	for _, l := range []*Limiter{New(0, time.Minute), nilLimiter} {
+		for range 100 {
+			if ok, _ := l.Allow("key"); !ok {
+				t.Fatalf("disabled limiter should allow every hit")
+			}
+		}
+	}
+}
+
+func TestLimiter_Prune(t *testing.T) {
+	l, clock := newTestLimiter(5, time.Minute)
+
+	l.Allow("a")
+	l.Allow("b")
+	clock.Advance(2 * time.Minute)
+	l.Allow("c")
+
+	if len(l.hits) != 1 {
+		t.Errorf("stale keys should be pruned, got %d keys", len(l.hits))
+	}
+}
@@ -1,25 +1,21 @@
 package seed
 
 import (
+	"context"
 	"errors"
-	"os"
 
+	"github.com/killi1812/cloudflared-web-gui/app"
 	"github.com/killi1812/cloudflared-web-gui/dto"
 	"github.com/killi1812/cloudflared-web-gui/model"
 	"github.com/killi1812/cloudflared-web-gui/service"
 
 	"go.uber.org/zap"
-	"gorm.io/gorm"
-)
-
-const (
-	_PASSWORD_ENV = "SUPERADMIN_PASSWORD"
 )
 
 var suadmin *model.User
 
 // CreateSuperAdmin creates a SuperAdmin user if one doesn't already exist.
-// It reads the password from the SUPERADMIN_PASSWORD environment variable.
+// It reads the password from app.SuperadminPassword (SUPERADMIN_PASSWORD).
 // The function will panic if required environment variables are missing or
 // if user creation fails, as this is critical for application bootstrap.
 func createSuperAdmin() error {
@@ -27,24 +23,22 @@ func createSuperAdmin() error {
 
 	// Check if SuperAdmin exists
 	{
-		_, err := userCrud.SearchUsersByName("superadmin")
+		_, total, err := userCrud.List(context.Background(), service.UserFilter{Role: model.ROLE_SUPER_ADMIN, PageSize: 1})
 		if err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				zap.S().Infof("SuperAdmin not found, err %+v", err)
-			} else {
-				return err
-			}
-		} else {
+			return err
+		}
+		if total > 0 {
 			zap.S().Infoln("SuperAdmin found")
 			zap.S().Infoln("Skipping superadmin creation")
 			return nil
 		}
+		zap.S().Infoln("SuperAdmin not found")
 	}
 
 	zap.S().Infoln("Crating superadmin creation")
-	password := os.Getenv(_PASSWORD_ENV)
+	password := app.SuperadminPassword
 	if password == "" {
-		return errors.New("env variable is empty")
+		return errors.New("superadmin password is empty")
 	}
 	if len(password) < 8 {
 		return errors.New("password must be at least 8 characters long")
@@ -60,7 +54,7 @@ func createSuperAdmin() error {
 		return err
 	}
 
-	user, err := userCrud.Create(newUser, dto.Password)
+	user, err := userCrud.Create(context.Background(), newUser, dto.Password)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,37 @@
+// Package tracing holds OpenTelemetry helpers shared by services, the tracer provider is set up by app.SetupTracing
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// _SCOPE is the instrumentation scope of spans started by the app
+const _SCOPE = "github.com/killi1812/cloudflared-web-gui"
+
+// Start starts a span named name as a child of the span in ctx
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(_SCOPE).Start(ctx, name, opts...)
+}
+
+// End records err on span, marks the span failed when err isn't nil and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Logger returns logger with the trace and span id of the span in ctx, so log lines can be found from a trace
+func Logger(ctx context.Context, logger *zap.SugaredLogger) *zap.SugaredLogger {
+	spanCtx := trace.SpanContextFromContext(ctx)
+	if !spanCtx.IsValid() {
+		return logger
+	}
+	return logger.With("trace_id", spanCtx.TraceID().String(), "span_id", spanCtx.SpanID().String())
+}